@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// imageAge is when a referenced image last changed, as far as can be told.
+type imageAge struct {
+	image string
+	// changed is zero if the age is unknown; from says where it came from:
+	// "git", "mtime" or "last-modified".
+	changed time.Time
+	from    string
+	err     error
+}
+
+// imageAges finds the age of every image the input files reference: for a
+// local image the time of the last commit that touched it, or its
+// modification time outside git, and for a remote image its Last-Modified
+// header.
+func (o *cliOptions) imageAges(ctx context.Context, client *http.Client) ([]imageAge, error) {
+	index, err := o.imageIndex()
+	if err != nil {
+		return nil, err
+	}
+	var ages []imageAge
+	for image := range index {
+		age := imageAge{image: image}
+		age.changed, age.from, age.err = localImageAge(image)
+		ages = append(ages, age)
+	}
+	seen := map[string]bool{}
+	for _, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, url := range markdown.RemoteImageURLs(content) {
+			if seen[url] {
+				continue
+			}
+			seen[url] = true
+			age := imageAge{image: url, from: "last-modified"}
+			age.changed, age.err = remoteImageAge(ctx, client, url)
+			ages = append(ages, age)
+		}
+	}
+	// Oldest first; unknown ages last.
+	sort.SliceStable(ages, func(i, j int) bool {
+		a, b := ages[i].changed, ages[j].changed
+		if a.IsZero() != b.IsZero() {
+			return b.IsZero()
+		}
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return ages[i].image < ages[j].image
+	})
+	return ages, nil
+}
+
+// localImageAge returns when the local file at path was last committed, or
+// last modified if git doesn't track it.
+func localImageAge(path string) (time.Time, string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+	cmd := exec.Command("git", "log", "-1", "--format=%ct", "--", filepath.Base(path))
+	cmd.Dir = filepath.Dir(path)
+	if out, err := cmd.Output(); err == nil {
+		if epoch, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64); err == nil {
+			return time.Unix(epoch, 0), "git", nil
+		}
+	}
+	return info.ModTime(), "mtime", nil
+}
+
+// remoteImageAge returns the Last-Modified time of the image at url, zero if
+// the server doesn't send one.
+func remoteImageAge(ctx context.Context, client *http.Client, url string) (time.Time, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return time.Time{}, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, &markdown.HTTPError{Status: resp.StatusCode}
+	}
+	changed, err := http.ParseTime(resp.Header.Get("Last-Modified"))
+	if err != nil {
+		return time.Time{}, nil
+	}
+	return changed, nil
+}
+
+// writeImageAges lists ages, marking images older than olderThan if it is
+// set, and returns how many are.
+func writeImageAges(w io.Writer, ages []imageAge, now time.Time, olderThan time.Duration) int {
+	stale := 0
+	for _, age := range ages {
+		switch {
+		case age.err != nil:
+			fprintf(w, "%s: %v\n", age.image, age.err)
+		case age.changed.IsZero():
+			fprintf(w, "%s: unknown age\n", age.image)
+		default:
+			days := int(now.Sub(age.changed) / day)
+			fprintf(w, "%s: %s, %d days ago (%s)", age.image, age.changed.UTC().Format(time.DateOnly), days, age.from)
+			if olderThan > 0 && now.Sub(age.changed) > olderThan {
+				stale++
+				fprintf(w, " STALE")
+			}
+			fprintf(w, "\n")
+		}
+	}
+	if olderThan > 0 {
+		older := ageValue(olderThan)
+		fprintf(w, "%d of %d images are older than %s\n", stale, len(ages), older.String())
+	}
+	return stale
+}
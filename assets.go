@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// sharedAssetsFile is written next to the pages by --shared-assets.
+const sharedAssetsFile = "assets.css"
+
+// renderedPage is an HTML output held back by --shared-assets.
+type renderedPage struct {
+	path    string
+	content string
+}
+
+// writeSharedAssets moves the images the held-back pages have in common into
+// assets.css, in the deepest directory containing every page, and writes the
+// pages linking to it.
+func (o *cliOptions) writeSharedAssets() error {
+	contents := make([]string, len(o.pages))
+	paths := make([]string, len(o.pages))
+	for i, page := range o.pages {
+		contents[i], paths[i] = page.content, page.path
+	}
+	rewritten, linked, css := markdown.ShareAssets(contents, 2)
+
+	cssPath := filepath.Join(commonDir(paths), sharedAssetsFile)
+	if css != "" {
+		if err := writeFileAtomic(cssPath, []byte(css)); err != nil {
+			return &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: cssPath, Err: err}
+		}
+		printf("Wrote shared images to %s\n", cssPath)
+	}
+	for i, page := range rewritten {
+		if linked[i] {
+			href, err := filepath.Rel(filepath.Dir(paths[i]), cssPath)
+			if err != nil {
+				return err
+			}
+			link := fmt.Sprintf("<link rel=\"stylesheet\" href=\"%s\">\n</head>", filepath.ToSlash(href))
+			page = strings.Replace(page, "</head>", link, 1)
+		}
+		if err := writeFileAtomic(paths[i], []byte(page)); err != nil {
+			return &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: paths[i], Err: err}
+		}
+	}
+	return nil
+}
+
+// commonDir returns the deepest directory containing every path.
+func commonDir(paths []string) string {
+	if len(paths) == 0 {
+		return "."
+	}
+	dir := filepath.Dir(paths[0])
+	for _, path := range paths[1:] {
+		for !isWithin(filepath.Dir(path), dir) {
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				break
+			}
+			dir = parent
+		}
+	}
+	return dir
+}
+
+// isWithin reports whether path is dir or below it.
+func isWithin(path, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"markdown-images/markdown"
+)
+
+// writeFileAtomic writes data to path through a temporary file in the same
+// directory, synced and renamed into place once complete. A crash, a full
+// disk or an interrupted run never leaves a truncated file, and the previous
+// contents survive until the new ones are complete. An existing file keeps
+// its permissions and a new one gets 0666 less the umask, as os.WriteFile
+// gives. A symlink is followed rather than replaced, even one whose target
+// doesn't exist yet.
+//
+// Outputs with embedded images can be gigabytes, so the free space is
+// checked first, failing before anything is written.
+func writeFileAtomic(path string, data []byte) error {
+	mode := 0666 &^ umask
+	if target, err := filepath.EvalSymlinks(path); err == nil {
+		path = target
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	} else if path, err = resolveLink(path); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err := checkDiskSpace(dir, int64(len(data))); err != nil {
+		return err
+	}
+	tmp, err := createTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer removeTemp(tmp.Name())
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if errors.Is(err, syscall.ENOSPC) {
+		return errorf("disk full writing %s to %s: %w", markdown.FormatSize(int64(len(data))), dir, err)
+	} else if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// maxLinks is how many symlinks resolveLink follows before giving up on a
+// loop, as the kernel does.
+const maxLinks = 40
+
+// resolveLink returns the path a chain of symlinks at path ends at, which
+// may not exist, or path itself if it is not a symlink.
+func resolveLink(path string) (string, error) {
+	for range maxLinks {
+		info, err := os.Lstat(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			return path, nil
+		} else if err != nil {
+			return "", err
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			return path, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(filepath.Dir(path), target)
+		}
+		path = target
+	}
+	return "", &fs.PathError{Op: "readlink", Path: path, Err: syscall.ELOOP}
+}
+
+// checkDiskSpace fails with an error wrapping syscall.ENOSPC if the
+// filesystem holding dir has less than size bytes available.
+func checkDiskSpace(dir string, size int64) error {
+	available, ok := availableSpace(dir)
+	if !ok || available >= size {
+		return nil
+	}
+	return errorf("%s needed but only %s available in %s: %w",
+		markdown.FormatSize(size), markdown.FormatSize(available), dir, syscall.ENOSPC)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// attributionsVersion is bumped when the attributions file format changes
+// incompatibly.
+const attributionsVersion = 1
+
+// attributionsSuffix names the file --attributions file writes beside an
+// output.
+const attributionsSuffix = ".attributions.json"
+
+// attributionsHeading titles the section --attributions section appends to
+// each output.
+const attributionsHeading = "## Image attributions"
+
+// attributionRecord lists the remote images embedded in an output and what
+// is known of their origin and license, for legal review of the
+// third-party content an embedded document carries.
+type attributionRecord struct {
+	Version int                `json:"version"`
+	Images  []attributionImage `json:"images"`
+}
+
+// attributionImage is one remote image of an attributionRecord.
+type attributionImage struct {
+	URL       string            `json:"url"`
+	AltText   string            `json:"alt_text,omitempty"`
+	Retrieved time.Time         `json:"retrieved"`
+	SHA256    string            `json:"sha256"`
+	License   string            `json:"license,omitempty"`
+	Creator   string            `json:"creator,omitempty"`
+	Copyright string            `json:"copyright,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+}
+
+// attributions returns the embedded remote images of result, each URL once.
+func attributions(result *markdown.Result) []attributionImage {
+	var images []attributionImage
+	seen := map[string]bool{}
+	for _, img := range result.Images {
+		a := img.Attribution
+		if !img.Embedded || a == nil || seen[a.URL] {
+			continue
+		}
+		seen[a.URL] = true
+		images = append(images, attributionImage{
+			URL:       a.URL,
+			AltText:   img.Reference.AltText,
+			Retrieved: a.Retrieved,
+			SHA256:    img.SourceSHA256,
+			License:   a.License,
+			Creator:   a.Creator,
+			Copyright: a.Copyright,
+			Headers:   a.Headers,
+		})
+	}
+	return images
+}
+
+// writeAttributions records the attributions of the remote images embedded
+// in outputFile.
+func writeAttributions(outputFile string, result *markdown.Result) error {
+	record := attributionRecord{Version: attributionsVersion, Images: attributions(result)}
+	if record.Images == nil {
+		record.Images = []attributionImage{}
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outputFile+attributionsSuffix, append(data, '\n'))
+}
+
+// linkTextEscaper keeps alt text from closing the link it is written in.
+var linkTextEscaper = strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`)
+
+// appendAttributions ends content with a section listing the attributions
+// of its embedded remote images, if it has any.
+func appendAttributions(content string, result *markdown.Result) string {
+	images := attributions(result)
+	if len(images) == 0 {
+		return content
+	}
+	newline := markdown.DetectNewline(content)
+	var b strings.Builder
+	b.WriteString(strings.TrimRight(content, "\r\n"))
+	b.WriteString(newline + newline + attributionsHeading + newline + newline)
+	for _, img := range images {
+		name := img.AltText
+		if name == "" {
+			name = img.URL
+		}
+		details := []string{}
+		if img.Creator != "" {
+			details = append(details, "by "+img.Creator)
+		}
+		if img.Copyright != "" {
+			details = append(details, img.Copyright)
+		}
+		if img.License != "" {
+			details = append(details, "license: "+img.License)
+		} else {
+			details = append(details, "no license information found")
+		}
+		details = append(details, "retrieved "+img.Retrieved.Format(time.DateOnly))
+		fmt.Fprintf(&b, "- [%s](<%s>): %s%s", linkTextEscaper.Replace(name), img.URL, strings.Join(details, ", "), newline)
+	}
+	return b.String()
+}
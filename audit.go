@@ -0,0 +1,29 @@
+package main
+
+import (
+	"io"
+
+	"markdown-images/markdown"
+)
+
+// auditPaths writes a report of the images each input file references
+// through inconsistent relative paths, and returns how many were found.
+// Nothing is embedded.
+func (o *cliOptions) auditPaths(w io.Writer) (int, error) {
+	found := 0
+	for _, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return found, err
+		}
+		for _, group := range markdown.AuditPaths(content, o.documentDir(file)) {
+			found++
+			fprintf(w, "%s: %s is referenced as:\n", file, group.Suggested)
+			for _, occ := range group.Occurrences {
+				fprintf(w, "  line %d: %s\n", occ.Line, occ.Path)
+			}
+			fprintf(w, "  suggested: %s\n", group.Suggested)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// buildStateFile holds the dependency graph of --incremental runs, in the
+// directory the inputs have in common.
+const buildStateFile = ".mdimages-deps.json"
+
+// buildStateVersion is bumped when the state format changes; older state is
+// discarded, so everything is rebuilt once.
+const buildStateVersion = 1
+
+// buildState records, for every output of earlier --incremental runs, the
+// local files it was built from. An output is rebuilt when any of them
+// changed, or when the options did. Remote images are not tracked, and
+// paths are as given on the command line, so runs must start from the same
+// directory.
+type buildState struct {
+	Version int `json:"version"`
+	// Options fingerprints the command line and MARKDOWN_IMAGES_ and
+	// MDIMAGES_ environment.
+	Options   string                  `json:"options"`
+	Documents map[string]*buildRecord `json:"documents"`
+	path      string
+}
+
+// buildRecord is the dependency graph of one output, keyed by its name in
+// buildState.Documents.
+type buildRecord struct {
+	Inputs []string `json:"inputs"`
+	// Outputs are the files written, several with --split-by-heading.
+	Outputs      []string    `json:"outputs"`
+	Dependencies []fileStamp `json:"dependencies"`
+}
+
+// fileStamp identifies the version of a file by size and modification time.
+type fileStamp struct {
+	Path string `json:"path"`
+	// Size is -1 for a file that did not exist, such as a missing image.
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+func stampFile(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{Path: path, Size: -1}
+	}
+	return fileStamp{Path: path, Size: info.Size(), ModTime: info.ModTime().UTC()}
+}
+
+// buildOptions fingerprints the settings of a run: the command line, the
+// environment and the config file, if any.
+func buildOptions(args []string, configFile string) string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if isEnvSetting(kv) {
+			env = append(env, kv)
+		}
+	}
+	sort.Strings(env)
+	if configFile != "" {
+		config, _ := os.ReadFile(configFile)
+		env = append(env, configFile, string(config))
+	}
+	return contentHash(strings.Join(append(slices.Clone(args), env...), "\x00"))
+}
+
+// loadBuildState reads the state at path. State that is missing, unreadable
+// or from a run with other options starts out empty.
+func loadBuildState(path, options string) *buildState {
+	fresh := &buildState{Version: buildStateVersion, Options: options, Documents: map[string]*buildRecord{}, path: path}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fresh
+	}
+	var s buildState
+	if json.Unmarshal(data, &s) != nil || s.Version != buildStateVersion || s.Options != options || s.Documents == nil {
+		return fresh
+	}
+	s.path = path
+	return &s
+}
+
+// upToDate reports whether output was built from inputs and none of its
+// dependencies changed since.
+func (s *buildState) upToDate(output string, inputs []string) bool {
+	rec := s.Documents[output]
+	if rec == nil || !slices.Equal(rec.Inputs, inputs) {
+		return false
+	}
+	for _, out := range rec.Outputs {
+		if _, err := os.Stat(out); err != nil {
+			return false
+		}
+	}
+	for _, dep := range rec.Dependencies {
+		now := stampFile(dep.Path)
+		if now.Size != dep.Size || !now.ModTime.Equal(dep.ModTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// record stores the dependencies output was just built from.
+func (s *buildState) record(output string, inputs, outputs, dependencies []string) {
+	rec := &buildRecord{Inputs: inputs, Outputs: outputs}
+	seen := map[string]bool{}
+	for _, dep := range dependencies {
+		if !seen[dep] {
+			seen[dep] = true
+			rec.Dependencies = append(rec.Dependencies, stampFile(dep))
+		}
+	}
+	s.Documents[output] = rec
+}
+
+// write saves the state as indented JSON.
+func (s *buildState) write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.path, append(data, '\n'))
+}
+
+// dependencies lists the local files that went into the output of files:
+// the documents, their includes, the images and media they reference and a
+// custom --theme stylesheet. complete is false if an image failed or an
+// include could not be read, since the output may then depend on more.
+func (o *cliOptions) dependencies(files []string, baseDir string, results []*markdown.Result) (deps []string, complete bool) {
+	deps, complete = slices.Clone(files), true
+	if o.includes {
+		for _, file := range files {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				complete = false
+				continue
+			}
+			included, err := markdown.IncludedFiles(string(data), o.documentDir(file))
+			deps = append(deps, included...)
+			complete = complete && err == nil
+		}
+	}
+	for _, result := range results {
+		for _, img := range result.Images {
+			complete = complete && img.Err == nil
+			if path, ok := markdown.LocalPath(baseDir, img.Reference.ImagePath); ok {
+				deps = append(deps, path)
+			}
+		}
+		for _, media := range result.Media {
+			if path, ok := markdown.LocalPath(baseDir, media.Path); ok {
+				deps = append(deps, path)
+			}
+		}
+	}
+	if _, builtin := markdown.Theme(o.theme); o.format == "html" && !builtin && o.theme != "none" && !strings.Contains(o.theme, "://") {
+		deps = append(deps, o.theme)
+	}
+	return deps, complete
+}
+
+// writeDepfile writes a Makefile rule stating that outputs depend on deps,
+// as compilers do with -MD, for make, Ninja (depfile =) and Bazel.
+func writeDepfile(path string, outputs, deps []string) error {
+	var b strings.Builder
+	for i, out := range outputs {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(escapeMakePath(out))
+	}
+	b.WriteByte(':')
+	seen := map[string]bool{}
+	for _, dep := range deps {
+		if !seen[dep] {
+			seen[dep] = true
+			b.WriteString(" \\\n  " + escapeMakePath(dep))
+		}
+	}
+	b.WriteByte('\n')
+	return writeFileAtomic(path, []byte(b.String()))
+}
+
+// escapeMakePath escapes the characters make treats specially in a rule.
+func escapeMakePath(path string) string {
+	path = filepath.ToSlash(path)
+	path = strings.ReplaceAll(path, "$", "$$")
+	path = strings.ReplaceAll(path, "#", `\#`)
+	return strings.ReplaceAll(path, " ", `\ `)
+}
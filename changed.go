@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// changedFiles returns the absolute paths of the files of the git
+// repository containing dir that differ from ref in the working tree,
+// committed or not, and of the untracked files git doesn't ignore.
+func changedFiles(dir, ref string) (map[string]bool, error) {
+	// The ref may come from the environment or a config file: don't let
+	// git read it as an option.
+	if strings.HasPrefix(ref, "-") {
+		return nil, errorf("invalid git ref %q", ref)
+	}
+	top, err := gitOutput(dir, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, err
+	}
+	root := strings.TrimSpace(string(top))
+	commit, err := gitOutput(root, "rev-parse", "--verify", "--end-of-options", ref+"^{commit}")
+	if err != nil {
+		return nil, err
+	}
+	changed := map[string]bool{}
+	for _, args := range [][]string{
+		{"diff", "--name-only", "-z", strings.TrimSpace(string(commit)), "--"},
+		{"ls-files", "--others", "--exclude-standard", "-z", "--full-name"},
+	} {
+		out, err := gitOutput(root, args...)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range bytes.Split(out, []byte{0}) {
+			if len(name) > 0 {
+				changed[filepath.Join(root, filepath.FromSlash(string(name)))] = true
+			}
+		}
+	}
+	return changed, nil
+}
+
+// gitOutput runs git in dir and returns its standard output, or an error
+// with what it wrote to standard error.
+func gitOutput(dir string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, errorf("git %s: %s", args[0], msg)
+		}
+		return nil, errorf("git %s: %v", args[0], err)
+	}
+	return out, nil
+}
+
+// keepChanged drops the input files that are unchanged since --changed-since:
+// a document is kept if it changed, or one of its includes or the local
+// images it references, found with the image index, did.
+func (o *cliOptions) keepChanged() error {
+	dir := commonDir(o.inputFiles)
+	changed, err := changedFiles(dir, o.changedSince)
+	if err != nil {
+		return err
+	}
+	index, err := o.imageIndex()
+	if err != nil {
+		return err
+	}
+	keep := map[string]bool{}
+	for image, uses := range index {
+		if changed[realPath(filepath.FromSlash(image))] {
+			for _, use := range uses {
+				keep[filepath.FromSlash(use.Document)] = true
+			}
+		}
+	}
+	var files []string
+	for _, file := range o.inputFiles {
+		if keep[file] || o.fileChanged(file, changed) {
+			files = append(files, file)
+		}
+	}
+	o.inputFiles = files
+	return nil
+}
+
+// fileChanged reports whether file or, with --resolve-includes, one of its
+// includes is among changed.
+func (o *cliOptions) fileChanged(file string, changed map[string]bool) bool {
+	if changed[realPath(file)] {
+		return true
+	}
+	if !o.includes {
+		return false
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return true
+	}
+	included, _ := markdown.IncludedFiles(string(data), o.documentDir(file))
+	for _, path := range included {
+		if changed[realPath(path)] {
+			return true
+		}
+	}
+	return false
+}
+
+// realPath is the absolute path of path with symbolic links resolved, as
+// git reports the repository's location.
+func realPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		return real
+	}
+	return path
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// checkImages writes a report of the problems the input files' images would
+// cause when embedding, without embedding anything: local images that don't
+// exist, and images referenced through inconsistent relative paths (see
+// auditPaths), and images breaking the rules of the policy. It returns how
+// many problems were found; violations of warn rules are reported but not
+// counted.
+func (o *cliOptions) checkImages(w io.Writer) (int, error) {
+	found := 0
+	for _, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return found, err
+		}
+		baseDir := o.documentDir(file)
+		for _, use := range markdown.LocalImageUses(content, baseDir) {
+			if _, err := os.Stat(use.File); err != nil {
+				found++
+				fprintf(w, "%s:%d: %s: image not found\n", file, use.Line, use.Path)
+			}
+		}
+		if o.policy == nil {
+			continue
+		}
+		for _, ref := range markdown.ImageReferences(content) {
+			line := strings.Count(content[:ref.StartPos], "\n") + 1
+			for _, v := range o.policy.violations(referencedImage(ref, baseDir)) {
+				if v.rule.level == "error" {
+					found++
+					fprintf(w, "%s:%d: %s: %s (%s)\n", file, line, imageName(ref.ImagePath), v.message, v.rule.name)
+				} else {
+					fprintf(w, "%s:%d: warning: %s: %s (%s)\n", file, line, imageName(ref.ImagePath), v.message, v.rule.name)
+				}
+			}
+		}
+	}
+	inconsistent, err := o.auditPaths(w)
+	if err != nil {
+		return found, err
+	}
+	found += inconsistent
+	fprintf(w, "%d problems found in %d documents\n", found, len(o.inputFiles))
+	return found, nil
+}
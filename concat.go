@@ -0,0 +1,83 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// stdinName is the input file name that reads the document from standard
+// input and writes the output to standard output.
+const stdinName = "-"
+
+// loadDocument reads a markdown file, or standard input for "-", resolving
+// include directives relative to its directory when requested.
+func (o *cliOptions) loadDocument(file string) (string, error) {
+	var data []byte
+	var err error
+	if file == stdinName {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return "", &markdown.Error{Code: markdown.CodeInputUnreadable, Path: file, Err: err}
+	}
+	if !o.includes {
+		return string(data), nil
+	}
+	return markdown.ResolveIncludes(string(data), o.documentDir(file))
+}
+
+// documentDir returns the directory relative paths in file are resolved
+// against: --base-dir if given, else its own, or the working directory for
+// standard input.
+func (o *cliOptions) documentDir(file string) string {
+	switch {
+	case o.baseDir != "":
+		return o.baseDir
+	case file == stdinName:
+		return "."
+	}
+	return filepath.Dir(file)
+}
+
+// concatDocuments loads files in order and joins them into a single document.
+// Each file's relative image paths are rebased onto baseDir, the directory
+// the combined document is processed from. The first file decides the
+// byte-order mark and line endings, the last whether the result ends with a
+// line ending. Links between the files are pointed at the headings of the
+// combined document: for HTML output by RenderHTML, which reads the file
+// markers left in place, for markdown output here.
+func (o *cliOptions) concatDocuments(files []string, baseDir string) (string, error) {
+	var b strings.Builder
+	newline := "\n"
+	for i, file := range files {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return "", err
+		}
+		content, bom := markdown.StripBOM(content)
+		if i == 0 {
+			newline = markdown.DetectNewline(content)
+			if bom {
+				b.WriteString("\ufeff")
+			}
+		} else {
+			content = markdown.NormalizeNewlines(content, newline)
+			b.WriteString(newline)
+		}
+		b.WriteString(markdown.FileMarker(file) + newline)
+		b.WriteString(markdown.RebaseImagePaths(content, o.documentDir(file), baseDir))
+		if i < len(files)-1 && content != "" && !strings.HasSuffix(content, "\n") {
+			b.WriteString(newline)
+		}
+	}
+	if o.format == "html" {
+		return b.String(), nil
+	}
+	return markdown.LinkFiles(b.String()), nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are looked for in the directory of the first input and
+// its parents, the nearest one being used.
+var configFileNames = []string{".markdown-images.yaml", ".markdown-images.yml"}
+
+// configPathFlags are the flags whose relative values in a config file are
+// relative to the file's directory rather than the working directory.
+var configPathFlags = map[string]bool{
+	"base-dir":    true,
+	"output-dir":  true,
+	"cache-dir":   true,
+	"assets-dir":  true,
+	"mirror-map":  true,
+	"stats-file":  true,
+	"messages":    true,
+	"junit":       true,
+	"report":      true,
+	"encrypt-key": true,
+	"policy":      true,
+	"template":    true,
+}
+
+// explicitConfigFlags are the flags a config file found next to the inputs
+// may not set, since it comes with the documents and may not be trusted:
+// those that run a command or write a file anywhere. They can be set on
+// the command line, in the environment or in a file given with --config.
+var explicitConfigFlags = map[string]bool{
+	"pdf-command": true,
+	"report":      true,
+	"junit":       true,
+	"stats-file":  true,
+}
+
+// findConfig returns the nearest config file in dir or above it, or "" if
+// there is none.
+func findConfig(dir string) string {
+	return findNearest(dir, configFileNames)
+}
+
+// findNearest returns the nearest file with one of names in dir or above
+// it, or "" if there is none.
+func findNearest(dir string, names []string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		for _, name := range names {
+			path := filepath.Join(dir, name)
+			if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+				return path
+			}
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// configStart is the directory the config file search starts in: that of
+// the first input, or the working directory for standard input.
+func configStart(positional []string) string {
+	if len(positional) == 0 || positional[0] == stdinName {
+		return "."
+	}
+	if info, err := os.Stat(positional[0]); err == nil && info.IsDir() {
+		return positional[0]
+	}
+	return filepath.Dir(positional[0])
+}
+
+// applyConfig sets every flag that was given neither on the command line
+// nor in the environment from the config file at path, a YAML mapping of
+// flag names to values: a list for repeatable flags, and a mapping for
+// --var. Its profiles mapping holds named sets of settings; those of the
+// profile selected with --profile, or by the file's own profile setting,
+// take precedence over the others. A discovered file, found next to the
+// inputs rather than given with --config, may not set explicitConfigFlags.
+func applyConfig(fs *flag.FlagSet, path string, discovered bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return errorf("invalid config file %s: %v", path, err)
+	}
+	settings := &yaml.Node{Kind: yaml.MappingNode}
+	if len(doc.Content) > 0 {
+		settings = doc.Content[0]
+	}
+	if settings.Kind != yaml.MappingNode {
+		return errorf("invalid config file %s: expected a mapping of flag names to values", path)
+	}
+
+	profiles := &yaml.Node{Kind: yaml.MappingNode}
+	selected := fs.Lookup("profile").Value.String()
+	for i := 0; i+1 < len(settings.Content); i += 2 {
+		switch key, node := settings.Content[i].Value, settings.Content[i+1]; key {
+		case "profiles":
+			if node.Kind != yaml.MappingNode {
+				return errorf("invalid profiles in %s (line %d): expected a mapping of profile names to settings", path, node.Line)
+			}
+			profiles = node
+		case "profile":
+			if selected == "" {
+				selected = node.Value
+			}
+		}
+	}
+	if selected != "" {
+		var names []string
+		var profile *yaml.Node
+		for i := 0; i+1 < len(profiles.Content); i += 2 {
+			names = append(names, profiles.Content[i].Value)
+			if profiles.Content[i].Value == selected {
+				profile = profiles.Content[i+1]
+			}
+		}
+		if profile == nil {
+			return errorf("unknown profile %q in %s (expected one of: %s)", selected, path, strings.Join(names, ", "))
+		}
+		if profile.Kind != yaml.MappingNode {
+			return errorf("invalid profile %q in %s (line %d): expected a mapping of flag names to values", selected, path, profile.Line)
+		}
+		if err := applySettings(fs, path, profile, false, discovered); err != nil {
+			return err
+		}
+		if err := fs.Set("profile", selected); err != nil {
+			return err
+		}
+	}
+	return applySettings(fs, path, settings, true, discovered)
+}
+
+// applySettings sets the flags named in settings that are not set yet.
+// Profiles may only be chosen and defined at the top level of the file.
+func applySettings(fs *flag.FlagSet, path string, settings *yaml.Node, top, discovered bool) error {
+	set := setFlags(fs)
+	for i := 0; i+1 < len(settings.Content); i += 2 {
+		name, node := settings.Content[i].Value, settings.Content[i+1]
+		switch {
+		case (name == "profiles" || name == "profile") && top:
+			// applyConfig has read them already.
+			continue
+		case fs.Lookup(name) == nil || name == "config" || name == "profile":
+			return errorf("unknown setting %q in %s (line %d)", name, path, settings.Content[i].Line)
+		case discovered && explicitConfigFlags[name]:
+			return errorf("setting %q in %s (line %d) is only read from a config file given with --config", name, path, settings.Content[i].Line)
+		case set(fs.Lookup(name)):
+			continue
+		}
+		values, err := configValues(node)
+		if err != nil {
+			return errorf("invalid value for %s in %s (line %d): %v", name, path, node.Line, err)
+		}
+		for _, value := range values {
+			if configPathFlags[name] || (name == "theme" && strings.HasSuffix(strings.ToLower(value), ".css")) {
+				if value != "" && !filepath.IsAbs(value) {
+					value = filepath.Join(filepath.Dir(path), value)
+				}
+			}
+			if err := fs.Set(name, value); err != nil {
+				return errorf("invalid value %q for %s in %s (line %d): %v", value, name, path, node.Line, err)
+			}
+		}
+	}
+	return nil
+}
+
+// configValues returns the flag values a setting stands for.
+func configValues(node *yaml.Node) ([]string, error) {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		if node.Tag == "!!null" {
+			return nil, errors.New("missing value")
+		}
+		return []string{node.Value}, nil
+	case yaml.SequenceNode:
+		var values []string
+		for _, item := range node.Content {
+			if item.Kind != yaml.ScalarNode {
+				return nil, errors.New("expected a list of values")
+			}
+			values = append(values, item.Value)
+		}
+		return values, nil
+	case yaml.MappingNode:
+		var values []string
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if node.Content[i+1].Kind != yaml.ScalarNode {
+				return nil, errors.New("expected name: value pairs")
+			}
+			values = append(values, node.Content[i].Value+"="+node.Content[i+1].Value)
+		}
+		return values, nil
+	}
+	return nil, errors.New("unsupported value")
+}
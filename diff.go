@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// diffContext is how many unchanged lines surround each change of a
+// unifiedDiff, as in diff -u.
+const diffContext = 3
+
+// diffLine is a line of an edit script: op is ' ' for a line both sides
+// share, '-' for one only the old side has and '+' for one only the new
+// side has.
+type diffLine struct {
+	op   byte
+	text string
+}
+
+// unifiedDiff returns the changes that turn oldText, labelled oldName, into
+// newText, labelled newName, in the unified format of diff -u, or "" if
+// there are none.
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	script := diffLines(splitLines(oldText), splitLines(newText))
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+	for start := 0; start < len(script); {
+		if script[start].op == ' ' {
+			start++
+			continue
+		}
+		// Grow the hunk while the next change is close enough to share
+		// its context.
+		end := start
+		for i := start; i < len(script) && i <= end+2*diffContext+1; i++ {
+			if script[i].op != ' ' {
+				end = i
+			}
+		}
+		first, last := max(start-diffContext, 0), min(end+diffContext+1, len(script))
+		oldLine, newLine := 1, 1
+		for _, l := range script[:first] {
+			if l.op != '+' {
+				oldLine++
+			}
+			if l.op != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, l := range script[first:last] {
+			if l.op != '+' {
+				oldCount++
+			}
+			if l.op != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldLine, oldCount), hunkRange(newLine, newCount))
+		for _, l := range script[first:last] {
+			b.WriteByte(l.op)
+			b.WriteString(l.text)
+			if !strings.HasSuffix(l.text, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		start = last
+	}
+	return b.String()
+}
+
+// hunkRange formats the lines of one side of a hunk, starting at line
+// start, as diff -u does: an empty range names the line before it.
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start-1)
+	case 1:
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+// splitLines splits text after each line break.
+func splitLines(text string) []string {
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns the shortest edit script that turns a into b, found
+// with Myers' algorithm.
+func diffLines(a, b []string) []diffLine {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	// v[offset+k] is the furthest x reached on diagonal k = x-y; trace keeps
+	// v as it was before each round, to walk the path back.
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	var script []diffLine
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x, y = x-1, y-1
+			script = append(script, diffLine{' ', a[x]})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			script = append(script, diffLine{'+', b[prevY]})
+		} else {
+			script = append(script, diffLine{'-', a[prevX]})
+		}
+		x, y = prevX, prevY
+	}
+	slices.Reverse(script)
+	return script
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package main
+
+// availableSpace can't determine the free space on this platform; writes
+// still report a full disk when they fail.
+func availableSpace(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"markdown-images/markdown"
+)
+
+// documentStats is what the stats subcommand reports for a document, to
+// help decide between embedding its images and bundling them alongside.
+type documentStats struct {
+	markdown.TextStats
+	Local, Remote, Failed int
+	// Types counts the images by embedded MIME type; images that could not
+	// be loaded count as "unknown".
+	Types map[string]int
+	// ReferencedBytes is the size of the source images, EmbeddedBytes that
+	// of their base64 payloads after resizing and re-encoding.
+	ReferencedBytes, EmbeddedBytes int64
+	InputBytes, OutputBytes        int64
+}
+
+// collectDocumentStats measures content and the result of processing it.
+func collectDocumentStats(content, baseDir string, result *markdown.Result) documentStats {
+	stats := documentStats{
+		TextStats:   markdown.CountText(content),
+		Types:       map[string]int{},
+		InputBytes:  int64(len(content)),
+		OutputBytes: int64(len(result.Content)),
+	}
+	for _, img := range result.Images {
+		if _, local := markdown.LocalPath(baseDir, img.Reference.ImagePath); local {
+			stats.Local++
+		} else {
+			stats.Remote++
+		}
+		mimeType := img.MIMEType
+		if mimeType == "" {
+			mimeType = "unknown"
+		}
+		stats.Types[mimeType]++
+		stats.ReferencedBytes += int64(img.OriginalSize)
+		if img.Err != nil {
+			stats.Failed++
+		} else if img.Embedded {
+			stats.EmbeddedBytes += int64((img.EncodedSize + 2) / 3 * 4)
+		}
+	}
+	return stats
+}
+
+// add accumulates other into s.
+func (s *documentStats) add(other documentStats) {
+	s.Words += other.Words
+	s.Headings += other.Headings
+	s.Local += other.Local
+	s.Remote += other.Remote
+	s.Failed += other.Failed
+	for mimeType, n := range other.Types {
+		s.Types[mimeType] += n
+	}
+	s.ReferencedBytes += other.ReferencedBytes
+	s.EmbeddedBytes += other.EmbeddedBytes
+	s.InputBytes += other.InputBytes
+	s.OutputBytes += other.OutputBytes
+}
+
+// writeDocumentStats writes the report for one document, or the total.
+func writeDocumentStats(w io.Writer, name string, s documentStats) {
+	fprintf(w, "%s\n", name)
+	fprintf(w, "  words:           %d\n", s.Words)
+	fprintf(w, "  headings:        %d\n", s.Headings)
+	fprintf(w, "  images:          %d (%d local, %d remote, %d failed)\n", s.Local+s.Remote, s.Local, s.Remote, s.Failed)
+	types := make([]string, 0, len(s.Types))
+	for mimeType := range s.Types {
+		types = append(types, mimeType)
+	}
+	sort.Strings(types)
+	for _, mimeType := range types {
+		fprintf(w, "    %-15s%d\n", mimeType, s.Types[mimeType])
+	}
+	fprintf(w, "  referenced size: %s\n", markdown.FormatSize(s.ReferencedBytes))
+	fprintf(w, "  embedded size:   %s of base64\n", markdown.FormatSize(s.EmbeddedBytes))
+	fprintf(w, "  output size:     %s (from %s)\n", markdown.FormatSize(s.OutputBytes), markdown.FormatSize(s.InputBytes))
+}
+
+// documentStats processes every input file with the current settings, so
+// that sizes reflect --max-width, --quality and the like, and writes their
+// statistics to w instead of any output. Several files are followed by a
+// total.
+func (o *cliOptions) documentStats(ctx context.Context, processor *markdown.Processor, w io.Writer) error {
+	total := documentStats{Types: map[string]int{}}
+	for i, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return err
+		}
+		baseDir := o.documentDir(file)
+		result, err := processor.ProcessContext(ctx, content, baseDir)
+		if err != nil {
+			return err
+		}
+		stats := collectDocumentStats(content, baseDir, result)
+		if i > 0 {
+			fprintf(w, "\n")
+		}
+		writeDocumentStats(w, file, stats)
+		total.add(stats)
+	}
+	if len(o.inputFiles) > 1 {
+		fprintf(w, "\n")
+		writeDocumentStats(w, tr("Total"), total)
+	}
+	return nil
+}
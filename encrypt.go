@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/base64"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// loadEncryptionKey reads the --encrypt-key file: a base64-encoded 256-bit
+// key, such as `openssl rand -base64 32` prints.
+func loadEncryptionKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	for _, encoding := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.RawURLEncoding} {
+		if key, err := encoding.DecodeString(text); err == nil && len(key) == markdown.EncryptionKeySize {
+			return key, nil
+		}
+	}
+	// The key itself is never repeated in messages.
+	return nil, errorf("%s does not hold a base64-encoded %d-byte key (make one with: openssl rand -base64 %d)", path, markdown.EncryptionKeySize, markdown.EncryptionKeySize)
+}
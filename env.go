@@ -0,0 +1,139 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// envPrefixes are prepended to the upper-cased flag name to form the
+// environment variables for a flag, e.g. --max-width is read from
+// MARKDOWN_IMAGES_MAX_WIDTH, or else from the shorter MDIMAGES_MAX_WIDTH.
+var envPrefixes = []string{"MARKDOWN_IMAGES_", "MDIMAGES_"}
+
+// envName returns the environment variable consulted for a flag with
+// prefix.
+func envName(prefix, flagName string) string {
+	return prefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// envAliases maps the shorter names some flags are also read from, after
+// either prefix, to their flags: MARKDOWN_IMAGES_TIMEOUT sets
+// --image-timeout unless MARKDOWN_IMAGES_IMAGE_TIMEOUT is set.
+var envAliases = map[string]string{
+	"TIMEOUT":  "image-timeout",
+	"MAX_SIZE": "max-image-size",
+}
+
+// lookupEnv returns the value of the first environment variable set for a
+// flag, and its name.
+func lookupEnv(flagName string) (value, name string, ok bool) {
+	for _, prefix := range envPrefixes {
+		name = envName(prefix, flagName)
+		if value, ok = os.LookupEnv(name); ok {
+			return value, name, true
+		}
+		for alias, aliased := range envAliases {
+			if aliased != flagName {
+				continue
+			}
+			if value, ok = os.LookupEnv(prefix + alias); ok {
+				return value, prefix + alias, true
+			}
+		}
+	}
+	return "", "", false
+}
+
+// checkEnv rejects the environment variables with one of envPrefixes that
+// name no flag, such as a misspelled MARKDOWN_IMAGES_QUALTY, which would
+// otherwise be ignored.
+func checkEnv(fs *flag.FlagSet) error {
+	var unknown []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		for _, prefix := range envPrefixes {
+			suffix, ok := strings.CutPrefix(name, prefix)
+			if !ok {
+				continue
+			}
+			if _, alias := envAliases[suffix]; !alias && fs.Lookup(strings.ToLower(strings.ReplaceAll(suffix, "_", "-"))) == nil {
+				unknown = append(unknown, name)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return errorf("unknown environment variable %s: no flag is named after it", unknown[0])
+	}
+	return nil
+}
+
+// isEnvSetting reports whether kv, an entry of os.Environ, may set a flag.
+func isEnvSetting(kv string) bool {
+	for _, prefix := range envPrefixes {
+		if strings.HasPrefix(kv, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// verbosityFlags together choose how much is printed, so setting one of
+// them overrides the others in the environment and config file: -q on the
+// command line is not undone by verbose: true in the config file.
+var verbosityFlags = []string{"verbose", "quiet", "log-level"}
+
+// flagDest identifies where a flag stores its value, which its aliases,
+// such as -o and --output, share.
+func flagDest(f *flag.Flag) any {
+	if v := reflect.ValueOf(f.Value); v.Kind() == reflect.Pointer {
+		return struct {
+			typ reflect.Type
+			ptr uintptr
+		}{v.Type(), v.Pointer()}
+	}
+	return f.Name
+}
+
+// setFlags returns whether a flag was already set in fs, under its own
+// name or an alias, or is a verbosity flag while another one was.
+func setFlags(fs *flag.FlagSet) func(*flag.Flag) bool {
+	set := map[any]bool{}
+	fs.Visit(func(f *flag.Flag) { set[flagDest(f)] = true })
+	for _, name := range verbosityFlags {
+		if set[flagDest(fs.Lookup(name))] {
+			for _, name := range verbosityFlags {
+				set[flagDest(fs.Lookup(name))] = true
+			}
+			break
+		}
+	}
+	return func(f *flag.Flag) bool { return set[flagDest(f)] }
+}
+
+// applyEnv sets every flag that was not given on the command line from its
+// environment variable, so flags take precedence over the environment.
+func applyEnv(fs *flag.FlagSet) error {
+	if err := checkEnv(fs); err != nil {
+		return err
+	}
+	setOnCommandLine := setFlags(fs)
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || setOnCommandLine(f) {
+			return
+		}
+		value, name, ok := lookupEnv(f.Name)
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = errorf("invalid value %q for %s: %v", value, name, setErr)
+		}
+	})
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"markdown-images/markdown"
+)
+
+// Exit statuses of an embedding run, so that CI can tell a broken command
+// line from a document with a few broken images. Report commands such as
+// check and age exit with exitFailure when they find something.
+const (
+	// exitFailure means nothing could be done: every document failed, or
+	// with --strict no image could be embedded.
+	exitFailure = 1
+	// exitInputError means the command line is invalid or an input can't
+	// be read.
+	exitInputError = 2
+	// exitPartial means some documents failed, or with --strict some
+	// images could not be embedded, but the rest were processed.
+	exitPartial = 3
+	// exitInterrupted is the exit status after SIGINT or SIGTERM,
+	// following the shell convention of 128 + SIGINT.
+	exitInterrupted = 130
+)
+
+// exitf is fatalf with an exit status.
+func exitf(status int, format string, args ...any) {
+	log.Printf(tr(format), args...)
+	exit(status)
+}
+
+// exit is os.Exit after removing the temporary files, which deferred
+// removals would leave behind.
+func exit(status int) {
+	removeTempFiles()
+	os.Exit(status)
+}
+
+// errorStatus is the exit status for a document that could not be processed
+// at all.
+func errorStatus(err error) int {
+	if markdown.CodeOf(err) == markdown.CodeInputUnreadable {
+		return exitInputError
+	}
+	return exitFailure
+}
+
+// tally counts the images of a processed document for --strict. A
+// placeholder stands for an image that could not be embedded, and so does
+// an image breaking an error rule of the policy.
+func (o *cliOptions) tally(result *markdown.Result) {
+	for _, img := range result.Images {
+		switch {
+		case img.Err != nil, img.Placeholder:
+			o.imagesFailed++
+		case img.Embedded && o.policy.rejects(embeddedImage(img)):
+			o.imagesFailed++
+		case img.Embedded:
+			o.imagesEmbedded++
+		}
+	}
+}
+
+// exitStatus is the exit status of a run over docs documents of which
+// failed could not be processed.
+func (o *cliOptions) exitStatus(failed, docs int) int {
+	switch {
+	case failed > 0 && failed == docs:
+		return exitFailure
+	case failed > 0:
+		return exitPartial
+	case !o.strict || o.imagesFailed == 0:
+		return 0
+	case o.imagesEmbedded == 0:
+		return exitFailure
+	}
+	return exitPartial
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"net/url"
+	"os"
+	"path/filepath"
+
+	"markdown-images/markdown"
+)
+
+// extractImages is the inverse of embedding: it writes the images embedded
+// as data URIs in the input files to o.assetsDir and points the documents at
+// the files. An image is named after its alt text and a hash of its content,
+// so the same image is written once however often it is embedded, or after
+// the file its source comment names (see --source-comments), unless another
+// image has that name.
+func (o *cliOptions) extractImages() error {
+	total := 0
+	for _, file := range o.inputFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return &markdown.Error{Code: markdown.CodeInputUnreadable, Path: file, Err: err}
+		}
+		dir := o.documentDir(file)
+		content, n, err := markdown.ExtractDataURIs(string(data), func(ref markdown.ImageReference, image []byte) (string, error) {
+			path := filepath.Join(o.assetsDir, markdown.ExtractedName(ref.AltText, image))
+			if name := markdown.RestoredName(ref.EmbeddedSource, image); name != "" {
+				// Keep the original name unless another image has it.
+				restored := filepath.Join(o.assetsDir, name)
+				if existing, err := os.ReadFile(restored); err != nil || bytes.Equal(existing, image) {
+					path = restored
+				}
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return "", err
+			}
+			// Restored names may have spaces, which end a markdown link.
+			link := (&url.URL{Path: filepath.ToSlash(rel)}).EscapedPath()
+			if o.dryRun {
+				return link, nil
+			}
+			if _, err := os.Stat(path); err != nil {
+				if err := os.MkdirAll(o.assetsDir, 0755); err != nil {
+					return "", err
+				}
+				if err := writeFileAtomic(path, image); err != nil {
+					return "", &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: path, Err: err}
+				}
+			}
+			return link, nil
+		})
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			continue
+		}
+		total += n
+		if o.dryRun {
+			printf("Dry run: would extract %d images from %s\n", n, file)
+			continue
+		}
+		if err := writeFileAtomic(file, []byte(content)); err != nil {
+			return &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: file, Err: err}
+		}
+		printf("Extracted %d images from %s\n", n, file)
+	}
+	if !o.dryRun {
+		printf("Extracted %d images into %s\n", total, o.assetsDir)
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// sizeValue is a flag.Value accepting human-readable sizes such as "500K".
+type sizeValue int64
+
+func (s *sizeValue) String() string {
+	if s == nil || *s == 0 {
+		return ""
+	}
+	return markdown.FormatSize(int64(*s))
+}
+
+func (s *sizeValue) Set(value string) error {
+	n, err := markdown.ParseSize(value)
+	if err != nil {
+		return err
+	}
+	*s = sizeValue(n)
+	return nil
+}
+
+// attrStyleValue is a flag.Value accepting the --attr-style dialects.
+type attrStyleValue markdown.AttrStyle
+
+func (a *attrStyleValue) String() string {
+	return string(*a)
+}
+
+func (a *attrStyleValue) Set(value string) error {
+	style, err := markdown.ParseAttrStyle(value)
+	if err != nil {
+		return err
+	}
+	*a = attrStyleValue(style)
+	return nil
+}
+
+// pipelinesValue is a repeatable flag.Value collecting --transform pipelines.
+type pipelinesValue []markdown.Pipeline
+
+func (p *pipelinesValue) String() string {
+	if p == nil {
+		return ""
+	}
+	var specs []string
+	for _, pipeline := range *p {
+		specs = append(specs, pipeline.Spec)
+	}
+	return strings.Join(specs, "; ")
+}
+
+func (p *pipelinesValue) Set(value string) error {
+	pipeline, err := markdown.ParsePipeline(value)
+	if err != nil {
+		return err
+	}
+	*p = append(*p, pipeline)
+	return nil
+}
+
+// fallbacksValue is a repeatable flag.Value collecting --fallback mappings.
+type fallbacksValue []markdown.Fallback
+
+func (f *fallbacksValue) String() string {
+	if f == nil {
+		return ""
+	}
+	var specs []string
+	for _, fallback := range *f {
+		specs = append(specs, fallback.Prefix+"="+strings.Join(fallback.Alternatives, ","))
+	}
+	return strings.Join(specs, "; ")
+}
+
+func (f *fallbacksValue) Set(value string) error {
+	fallback, err := markdown.ParseFallback(value)
+	if err != nil {
+		return err
+	}
+	*f = append(*f, fallback)
+	return nil
+}
+
+// tagProfilesValue is a repeatable flag.Value collecting --tag-profile
+// definitions by name.
+type tagProfilesValue map[string]markdown.TagProfile
+
+func (t *tagProfilesValue) String() string {
+	if t == nil {
+		return ""
+	}
+	var specs []string
+	for name, profile := range *t {
+		specs = append(specs, name+": "+profile.Spec)
+	}
+	sort.Strings(specs)
+	return strings.Join(specs, "; ")
+}
+
+func (t *tagProfilesValue) Set(value string) error {
+	name, profile, err := markdown.ParseTagProfile(value)
+	if err != nil {
+		return err
+	}
+	if *t == nil {
+		*t = tagProfilesValue{}
+	}
+	(*t)[name] = profile
+	return nil
+}
+
+// fontEmbeddingValue is a flag.Value accepting the --embed-fonts modes.
+type fontEmbeddingValue markdown.FontEmbedding
+
+func (f *fontEmbeddingValue) String() string {
+	return string(*f)
+}
+
+func (f *fontEmbeddingValue) Set(value string) error {
+	fonts, err := markdown.ParseFontEmbedding(value)
+	if err != nil {
+		return err
+	}
+	*f = fontEmbeddingValue(fonts)
+	return nil
+}
+
+// tocValue is the --toc flag: a heading depth that may be omitted, as in
+// --toc or --toc=2.
+type tocValue int
+
+// defaultTOCDepth is the depth of a bare --toc.
+const defaultTOCDepth = 3
+
+func (t *tocValue) String() string {
+	if t == nil || *t == 0 {
+		return ""
+	}
+	return strconv.Itoa(int(*t))
+}
+
+func (t *tocValue) Set(value string) error {
+	switch value {
+	case "true":
+		*t = defaultTOCDepth
+		return nil
+	case "false":
+		*t = 0
+		return nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 || n > 6 {
+		return errorf("expected a heading depth between 1 and 6")
+	}
+	*t = tocValue(n)
+	return nil
+}
+
+// IsBoolFlag lets --toc be given without a value.
+func (t *tocValue) IsBoolFlag() bool { return true }
+
+// figureLabelValue is the --number-figures flag: a caption label that may be
+// omitted, as in --number-figures or --number-figures=Abbildung.
+type figureLabelValue string
+
+// defaultFigureLabel is the label of a bare --number-figures.
+const defaultFigureLabel = "Figure"
+
+func (f *figureLabelValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return string(*f)
+}
+
+func (f *figureLabelValue) Set(value string) error {
+	switch value {
+	case "true":
+		*f = defaultFigureLabel
+	case "false":
+		*f = ""
+	default:
+		*f = figureLabelValue(strings.TrimSpace(value))
+	}
+	return nil
+}
+
+// IsBoolFlag lets --number-figures be given without a value.
+func (f *figureLabelValue) IsBoolFlag() bool { return true }
+
+// varsValue is the repeatable --var flag, collecting name=value pairs.
+type varsValue map[string]string
+
+func (v *varsValue) String() string {
+	if v == nil {
+		return ""
+	}
+	var pairs []string
+	for name, value := range *v {
+		pairs = append(pairs, name+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func (v *varsValue) Set(value string) error {
+	name, val, ok := strings.Cut(value, "=")
+	if !ok || !variableNameRegex.MatchString(name) {
+		return errorf("expected name=value with a lower-case name, e.g. version=1.2.0")
+	}
+	if *v == nil {
+		*v = varsValue{}
+	}
+	(*v)[name] = val
+	return nil
+}
+
+// variableNameRegex matches the names {{name}} placeholders may use.
+var variableNameRegex = regexp.MustCompile(`^[a-z][a-z0-9_-]*$`)
+
+// ageValue is a flag.Value accepting an age in days, weeks or years, such as
+// "90d", "6w" or "1y", or a Go duration such as "36h".
+type ageValue time.Duration
+
+// day is the unit of ageValue.
+const day = 24 * time.Hour
+
+func (a *ageValue) String() string {
+	if a == nil || *a == 0 {
+		return ""
+	}
+	if d := time.Duration(*a); d%day == 0 {
+		return strconv.FormatInt(int64(d/day), 10) + "d"
+	}
+	return time.Duration(*a).String()
+}
+
+func (a *ageValue) Set(value string) error {
+	units := map[string]time.Duration{"d": day, "w": 7 * day, "y": 365 * day}
+	number, unit := value, time.Duration(0)
+	if len(value) > 1 {
+		number, unit = value[:len(value)-1], units[value[len(value)-1:]]
+	}
+	if unit != 0 {
+		n, err := strconv.Atoi(number)
+		if err != nil || n < 0 {
+			return errorf("expected an age such as 90d, 6w or 1y")
+		}
+		*a = ageValue(time.Duration(n) * unit)
+		return nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return errorf("expected an age such as 90d, 6w or 1y")
+	}
+	*a = ageValue(d)
+	return nil
+}
+
+// globsValue is the repeatable --glob flag, collecting slash-separated
+// patterns in which "**" matches any number of directories.
+type globsValue []string
+
+func (g *globsValue) String() string {
+	if g == nil {
+		return ""
+	}
+	return strings.Join(*g, ", ")
+}
+
+func (g *globsValue) Set(value string) error {
+	pattern := strings.TrimPrefix(filepath.ToSlash(value), "./")
+	for _, segment := range strings.Split(pattern, "/") {
+		if _, err := path.Match(segment, ""); err != nil || segment == "" {
+			return errorf("invalid pattern %q", value)
+		}
+	}
+	*g = append(*g, pattern)
+	return nil
+}
+
+// listValue is a repeatable flag.Value collecting its values in order.
+type listValue []string
+
+func (l *listValue) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ", ")
+}
+
+func (l *listValue) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
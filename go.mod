@@ -4,4 +4,15 @@ go 1.23.0
 
 toolchain go1.24.5
 
-require golang.org/x/image v0.29.0
+require (
+	github.com/alecthomas/chroma v0.10.0
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/yuin/goldmark v1.7.13
+	golang.org/x/image v0.29.0
+	golang.org/x/net v0.42.0
+	golang.org/x/sys v0.34.0
+	golang.org/x/text v0.27.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require github.com/dlclark/regexp2 v1.4.0 // indirect
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"markdown-images/markdown"
+)
+
+// imageUse is where the index subcommand found a reference to an image.
+type imageUse struct {
+	Document string `json:"document"`
+	Line     int    `json:"line"`
+	// Path is the reference as written in the document.
+	Path string `json:"path"`
+}
+
+// imageIndex maps each local image, relative to the working directory and
+// with forward slashes, to the references to it in every input file. It
+// answers "who uses this file?" before an asset is renamed or deleted: an
+// image that is not in the index is not referenced by any document.
+func (o *cliOptions) imageIndex() (map[string][]imageUse, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	index := map[string][]imageUse{}
+	for _, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return nil, err
+		}
+		for _, use := range markdown.LocalImageUses(content, o.documentDir(file)) {
+			image := use.File
+			if rel, err := filepath.Rel(wd, image); err == nil {
+				image = rel
+			}
+			image = filepath.ToSlash(image)
+			index[image] = append(index[image], imageUse{
+				Document: filepath.ToSlash(file),
+				Line:     use.Line,
+				Path:     use.Path,
+			})
+		}
+	}
+	return index, nil
+}
+
+// writeImageIndex writes index as a JSON object, or with format "csv" as
+// image,document,line,path rows sorted by image.
+func writeImageIndex(w io.Writer, index map[string][]imageUse, format string) error {
+	if format == "json" {
+		data, err := json.MarshalIndent(index, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(append(data, '\n'))
+		return err
+	}
+	images := make([]string, 0, len(index))
+	for image := range index {
+		images = append(images, image)
+	}
+	sort.Strings(images)
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"image", "document", "line", "path"})
+	for _, image := range images {
+		for _, use := range index[image] {
+			cw.Write([]string{image, use.Document, strconv.Itoa(use.Line), use.Path})
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"markdown-images/markdown"
+)
+
+// templateFileNames are looked for like configFileNames by init, starting
+// in the directory of the new document, when --template is not given.
+var templateFileNames = []string{".markdown-images-template.md"}
+
+// defaultTemplate is the document init writes without a template.
+const defaultTemplate = "# {{title}}\n\n_{{date}}_\n\n"
+
+// initDocument creates the document named by the only input file from the
+// --template, replacing its {{title}}, {{date}} and --var placeholders and
+// embedding its images, such as an organization's logo and header, so that
+// the new document is self-contained from the start. It fails without
+// writing anything if the document exists or an image can't be embedded.
+func (o *cliOptions) initDocument(ctx context.Context, processor *markdown.Processor) error {
+	file := o.inputFiles[0]
+	if _, err := os.Stat(file); err == nil {
+		return errorf("%s already exists", file)
+	}
+	dir := filepath.Dir(file)
+	templateFile := o.templateFile
+	if templateFile == "" {
+		templateFile = findNearest(dir, templateFileNames)
+	}
+	content, source, templateDir := defaultTemplate, "the default template", dir
+	if templateFile != "" {
+		data, err := os.ReadFile(templateFile)
+		if err != nil {
+			return err
+		}
+		content, source, templateDir = string(data), templateFile, filepath.Dir(templateFile)
+	}
+	vars := o.variables(dir)
+	if _, ok := vars["title"]; !ok {
+		vars["title"] = documentTitle(file)
+	}
+	content = markdown.SubstituteVariables(content, vars)
+
+	result, err := processor.ProcessContext(ctx, content, templateDir)
+	if err != nil {
+		return err
+	}
+	embedded, failed := 0, 0
+	for _, img := range result.Images {
+		switch {
+		case img.Err != nil, img.Placeholder:
+			failed++
+		case img.Embedded:
+			embedded++
+		}
+	}
+	if failed > 0 {
+		printFailureSummary(os.Stderr, result)
+		return errorf("could not embed %d images of %s", failed, source)
+	}
+	if o.dryRun {
+		printf("Dry run: would create %s from %s with %d embedded images\n", file, source, embedded)
+		return nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	// Images the template leaves as references, such as excluded ones,
+	// must still resolve from the new document.
+	content = markdown.RebaseImagePaths(result.Content, templateDir, dir)
+	if err := writeFileAtomic(file, []byte(content)); err != nil {
+		return &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: file, Err: err}
+	}
+	printf("Created %s from %s with %d embedded images\n", file, source, embedded)
+	return nil
+}
+
+// documentTitle is the {{title}} of a new document without a --var title:
+// its file name in words, e.g. "Quarterly report" for quarterly-report.md.
+func documentTitle(file string) string {
+	name := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' || r == '_' }), " ")
+	if name == "" {
+		return name
+	}
+	first, size := utf8.DecodeRuneInString(name)
+	return string(unicode.ToUpper(first)) + name[size:]
+}
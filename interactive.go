@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// interactiveSkip is the skip reason of the images --interactive declined.
+const interactiveSkip = "skipped interactively"
+
+// imagePrompt asks, for --interactive, whether to embed each image before it
+// is embedded. Answering always or never answers every later prompt of the
+// run the same way.
+type imagePrompt struct {
+	in     *bufio.Reader
+	out    io.Writer
+	client *http.Client
+	// answer is "always" or "never" once given.
+	answer string
+}
+
+// beforeEmbed returns the Options.BeforeEmbed of a document: content, read
+// from files, whose relative image paths resolve against baseDir.
+func (p *imagePrompt) beforeEmbed(ctx context.Context, files []string, content, baseDir string) func(int, *markdown.ImageReference) string {
+	return func(_ int, ref *markdown.ImageReference) string {
+		switch p.answer {
+		case "always":
+			return ""
+		case "never":
+			return interactiveSkip
+		}
+		line := strings.Count(content[:min(ref.StartPos, len(content))], "\n") + 1
+		fprintf(p.out, "%s:%d: %s (%s)\n", strings.Join(files, ", "), line, ref.ImagePath, p.describe(ctx, ref.ImagePath, baseDir))
+		for {
+			fprintf(p.out, "Embed this image? [e]mbed, [s]kip, [a]lways, [n]ever: ")
+			answer, err := p.in.ReadString('\n')
+			if err != nil && answer == "" {
+				// Nobody is left to ask: leave the rest alone.
+				io.WriteString(p.out, "\n")
+				p.answer = "never"
+				return interactiveSkip
+			}
+			switch strings.ToLower(strings.TrimSpace(answer)) {
+			case "e", "embed", "y", "yes":
+				return ""
+			case "s", "skip", "no":
+				return interactiveSkip
+			case "a", "always":
+				p.answer = "always"
+				return ""
+			case "n", "never":
+				p.answer = "never"
+				return interactiveSkip
+			}
+		}
+	}
+}
+
+// describe tells what the image at path is and how large: a local file, a
+// remote image as large as its Content-Length says, or a data URI.
+func (p *imagePrompt) describe(ctx context.Context, path, baseDir string) string {
+	if mimeType, data, ok := markdown.DecodeDataURI(path); ok {
+		return mimeType + ", " + markdown.FormatSize(int64(len(data)))
+	}
+	if local, ok := markdown.LocalPath(baseDir, path); ok {
+		info, err := os.Stat(local)
+		if err != nil {
+			return tr("local, not found")
+		}
+		return tr("local") + ", " + markdown.FormatSize(info.Size())
+	}
+	if size, ok := remoteImageSize(ctx, p.client, path); ok {
+		return tr("remote") + ", " + markdown.FormatSize(size)
+	}
+	return tr("remote, size unknown")
+}
+
+// remoteImageSize returns the Content-Length of the image at url, if the
+// server answers a HEAD request with one.
+func remoteImageSize(ctx context.Context, client *http.Client, url string) (int64, bool) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		return 0, false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, false
+	}
+	return resp.ContentLength, resp.ContentLength >= 0
+}
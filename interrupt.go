@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// tempFiles holds the temporary files in use, so that an interrupted run
+// can remove them: os.Exit skips the deferred removals. Files that are not
+// written beside an output, such as the videos ffmpeg reads and the files
+// external renderers write, go in one directory per run, made on first use
+// and removed at exit unless --keep-temp is given.
+var tempFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+	dir   string
+	keep  bool
+}{paths: make(map[string]bool)}
+
+// tempDir returns the run's temporary directory, making it if needed.
+func tempDir() (string, error) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	if tempFiles.dir == "" {
+		dir, err := os.MkdirTemp("", "markdown-images-*")
+		if err != nil {
+			return "", err
+		}
+		tempFiles.dir = dir
+	}
+	return tempFiles.dir, nil
+}
+
+// createTemp is os.CreateTemp for files that removeTemp deletes. An empty
+// dir means the run's temporary directory.
+func createTemp(dir, pattern string) (*os.File, error) {
+	if dir == "" {
+		var err error
+		if dir, err = tempDir(); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.CreateTemp(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	tempFiles.Lock()
+	tempFiles.paths[f.Name()] = true
+	tempFiles.Unlock()
+	return f, nil
+}
+
+// removeTemp deletes a file made by createTemp, unless it is in the run's
+// temporary directory and --keep-temp is given.
+func removeTemp(path string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	delete(tempFiles.paths, path)
+	if !tempFiles.keep || filepath.Dir(path) != tempFiles.dir {
+		os.Remove(path)
+	}
+}
+
+// removeTempFiles deletes every temporary file still in use and the run's
+// temporary directory, or with --keep-temp tells where it is.
+func removeTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for path := range tempFiles.paths {
+		if !tempFiles.keep || filepath.Dir(path) != tempFiles.dir {
+			os.Remove(path)
+		}
+		delete(tempFiles.paths, path)
+	}
+	if tempFiles.dir == "" {
+		return
+	}
+	if tempFiles.keep {
+		logf("Kept temporary files in %s", tempFiles.dir)
+	} else {
+		os.RemoveAll(tempFiles.dir)
+	}
+	tempFiles.dir = ""
+}
+
+// tempCommand is exec.CommandContext for external renderers, run with
+// $TMPDIR set to the run's temporary directory, so that the files they
+// leave behind are removed with it.
+func tempCommand(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	dir, err := tempDir()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Env = append(os.Environ(), "TMPDIR="+dir)
+	return cmd, nil
+}
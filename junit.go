@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// junitReport is the --junit report: a test suite per document and a test
+// case per image reference, so that CI servers show images that could not
+// be embedded as failing tests of their document.
+type junitReport struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Name     string       `xml:"name,attr"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name     string      `xml:"name,attr"`
+	Tests    int         `xml:"tests,attr"`
+	Failures int         `xml:"failures,attr"`
+	Errors   int         `xml:"errors,attr"`
+	Skipped  int         `xml:"skipped,attr"`
+	Time     string      `xml:"time,attr"`
+	Cases    []junitCase `xml:"testcase"`
+}
+
+type junitCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Failure   *junitProblem `xml:"failure,omitempty"`
+	Error     *junitProblem `xml:"error,omitempty"`
+	Skipped   *junitProblem `xml:"skipped,omitempty"`
+}
+
+// junitProblem is the body of a failure, error or skipped element.
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr,omitempty"`
+	Text    string `xml:",chardata"`
+}
+
+// addDocument adds the suite of a processed document: files are its inputs,
+// content what was processed and took elapsed.
+func (r *junitReport) addDocument(files []string, content string, result *markdown.Result, elapsed time.Duration) {
+	name := strings.Join(files, ", ")
+	suite := junitSuite{Name: name, Time: junitTime(elapsed)}
+	for _, img := range result.Images {
+		ref := img.Reference
+		line := strings.Count(content[:min(ref.StartPos, len(content))], "\n") + 1
+		c := junitCase{Name: fmt.Sprintf("line %d: %s", line, ref.ImagePath), Classname: name}
+		switch {
+		case img.Err != nil:
+			code := markdown.CodeOf(img.Err)
+			c.Failure = &junitProblem{Message: img.Err.Error(), Type: code.Name(), Text: fmt.Sprintf("%s %s %s\n", code, code.Name(), ref.ImagePath)}
+			suite.Failures++
+		case img.Placeholder:
+			c.Skipped = &junitProblem{Message: "unreachable, embedded a placeholder"}
+			suite.Skipped++
+		case !img.Embedded && img.SkipReason != "":
+			c.Skipped = &junitProblem{Message: img.SkipReason}
+			suite.Skipped++
+		}
+		suite.Cases = append(suite.Cases, c)
+	}
+	suite.Tests = len(suite.Cases)
+	r.add(suite)
+}
+
+// addError adds the suite of a document that could not be processed at all,
+// with a single erroring test case.
+func (r *junitReport) addError(files []string, err error) {
+	name := strings.Join(files, ", ")
+	r.add(junitSuite{Name: name, Tests: 1, Errors: 1, Time: junitTime(0), Cases: []junitCase{{
+		Name:      "process",
+		Classname: name,
+		Error:     &junitProblem{Message: err.Error(), Type: markdown.CodeOf(err).Name()},
+	}}})
+}
+
+func (r *junitReport) add(suite junitSuite) {
+	r.Suites = append(r.Suites, suite)
+	r.Tests += suite.Tests
+	r.Failures += suite.Failures
+	r.Errors += suite.Errors
+	r.Skipped += suite.Skipped
+}
+
+// write writes the report to path, with the total time of the run.
+func (r *junitReport) write(path string, elapsed time.Duration) error {
+	r.Name, r.Time = "markdown-images", junitTime(elapsed)
+	data, err := xml.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append([]byte(xml.Header), append(data, '\n')...))
+}
+
+// junitTime formats a duration as JUnit's seconds.
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// linkOutputs points the relative links of content, read from file, to
+// other input files at the outputs written for them, so that a converted
+// tree (such as one from --recursive) links to itself rather than to the
+// sources. Documents split with --split-by-heading have no single output,
+// and with --in-place the outputs are the sources, so links are left alone
+// then.
+func (o *cliOptions) linkOutputs(content, file string) string {
+	if o.splitLevel > 0 || o.inPlace {
+		return content
+	}
+	inputs := make(map[string]bool, len(o.inputFiles))
+	for _, input := range o.inputFiles {
+		inputs[filepath.Clean(input)] = true
+	}
+	dir := o.documentDir(file)
+	return markdown.RewriteLinks(content, func(destination string) (string, bool) {
+		target, fragment, hasFragment := strings.Cut(destination, "#")
+		u, err := url.Parse(target)
+		if target == "" || err != nil || u.Scheme != "" || u.Host != "" || u.RawQuery != "" || path.IsAbs(u.Path) {
+			return "", false
+		}
+		if !inputs[filepath.Join(dir, filepath.FromSlash(u.Path))] {
+			return "", false
+		}
+		output := strings.TrimSuffix(target, path.Ext(target)) + o.outputExt(target)
+		if hasFragment {
+			output += "#" + fragment
+		}
+		return output, true
+	})
+}
+
+// rebaseOutput rewrites the relative image paths and links of content, a
+// document in baseDir, to resolve from the directory of the --output file
+// it is written to, if that is another one. Outputs named after their
+// input stay beside it, and --output-dir mirrors the tree links are
+// written for.
+func (o *cliOptions) rebaseOutput(content, baseDir, outputFile string) string {
+	if o.output == "" || outputFile == stdinName {
+		return content
+	}
+	outputDir := filepath.Dir(outputFile)
+	if from, err := filepath.Abs(baseDir); err == nil {
+		if to, err := filepath.Abs(outputDir); err == nil && from == to {
+			return content
+		}
+	}
+	return markdown.RebaseLinks(markdown.RebaseImagePaths(content, baseDir, outputDir), baseDir, outputDir)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"io"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// listImages writes the image references of each input file, one per line
+// with its line number and what it is: a local file (or a missing one), a
+// remote URL or an image already embedded as a data URI.
+func (o *cliOptions) listImages(w io.Writer) error {
+	for _, file := range o.inputFiles {
+		content, err := o.loadDocument(file)
+		if err != nil {
+			return err
+		}
+		dir := o.documentDir(file)
+		for _, ref := range markdown.ImageReferences(content) {
+			line := strings.Count(content[:ref.StartPos], "\n") + 1
+			if mimeType, data, ok := markdown.DecodeDataURI(ref.ImagePath); ok {
+				fprintf(w, "%s:%d: embedded %s, %s\n", file, line, mimeType, markdown.FormatSize(int64(len(data))))
+				continue
+			}
+			kind := "remote"
+			if path, local := markdown.LocalPath(dir, ref.ImagePath); local {
+				kind = "local"
+				if _, err := os.Stat(path); err != nil {
+					kind = "missing"
+				}
+			}
+			fprintf(w, "%s:%d: %s %s\n", file, line, kind, ref.ImagePath)
+		}
+	}
+	return nil
+}
@@ -1,39 +1,1672 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"markdown-images/markdown"
 )
 
+// cliOptions holds the parsed command line.
+type cliOptions struct {
+	inputFiles []string
+	// strict makes images that could not be embedded fail the run, which
+	// tally counts.
+	strict                       bool
+	policyFile                   string
+	policy                       *policy
+	imagesEmbedded, imagesFailed int
+	// showVersion prints the version and build information and exits.
+	showVersion bool
+	// verbose and quiet are shorthands for a logLevel of debug and warn.
+	verbose    bool
+	quiet      bool
+	logLevel   string
+	level      slog.Level
+	progress   string
+	jobs       int
+	statsFile  string
+	attrStyle  attrStyleValue
+	figcaption bool
+	// collapseOver is the size above which embedded images are collapsed.
+	collapseOver sizeValue
+	wrapWidth    int
+	refStyle     bool
+	quality      int
+	maxWidth     int
+	maxHeight    int
+	// preserveBitDepth keeps 16-bit images at 16 bits per channel.
+	preserveBitDepth bool
+	concat           bool
+	includes         bool
+	audit            bool
+	// sourceComments writes a comment naming its source before every
+	// embedded image.
+	sourceComments bool
+	// changedSince keeps only the input files that changed since this git
+	// ref, or whose includes or images did.
+	changedSince string
+	// recursive walks directory arguments for the markdown files, or the
+	// files matching globs, below them; inputRoots maps each file found to
+	// the directory argument it was found in.
+	recursive   bool
+	globs       globsValue
+	inputRoots  map[string]string
+	githubToken string
+	// baseURL is where relative image paths are fetched from instead of
+	// the local disk, if set.
+	baseURL string
+	// manifest is a CSV file listing the documents to embed, and the
+	// output, profile and base URL of each.
+	manifest string
+	target   string
+	// maxEmbedSize and allowedFormats keep images that are too large, or of
+	// a format the target can't show, as references, and so does
+	// maxImageSize for images whose source is too large.
+	maxEmbedSize   sizeValue
+	maxImageSize   sizeValue
+	allowedFormats []string
+	// profile is the --target profile, zero if none was selected.
+	profile targetProfile
+	// args is the raw command line, recorded in plans.
+	args      []string
+	dryRun    bool
+	diff      bool
+	planFile  string
+	applyFile string
+	// planned collects the dry run for --plan; applied is the plan being
+	// executed by --apply.
+	planned *plan
+	applied *plan
+	// usage collects the results of the run for the --stats-file.
+	usage []*markdown.Result
+	// interactive asks before embedding each image, through prompt.
+	interactive bool
+	prompt      *imagePrompt
+	// transforms are the --transform pipelines, in command line order.
+	transforms pipelinesValue
+	cacheDir   string
+	noCache    bool
+	// splitLevel splits outputs at headings of this level or higher; zero
+	// keeps documents whole.
+	splitLevel int
+	// format is the output format, "markdown" or "html".
+	format string
+	// sharedAssets defers writing HTML pages until the end of the run, when
+	// images they have in common are moved to a shared stylesheet.
+	sharedAssets bool
+	pages        []renderedPage
+	// encryptKeyFile holds the key the images of HTML pages are encrypted
+	// with, loaded into encryptionKey.
+	encryptKeyFile string
+	encryptionKey  []byte
+	// pdfCommand rasterizes the first page of referenced PDFs.
+	pdfCommand string
+	// pdfThumbnails is the width of previews inserted above PDF links.
+	pdfThumbnails int
+	// videoPosters is the width of poster frames for videos, taken
+	// posterTime into the video.
+	videoPosters int
+	posterTime   time.Duration
+	// embedMediaUnder inlines smaller local audio and video files.
+	embedMediaUnder sizeValue
+	fonts           fontEmbeddingValue
+	// theme is a built-in theme name, "none", or a CSS file or URL.
+	theme string
+	toc   tocValue
+	// highlight is the code highlighting style; empty picks one matching
+	// the theme.
+	highlight string
+	// incremental skips documents whose dependencies are unchanged, as
+	// recorded in build.
+	incremental bool
+	build       *buildState
+	// depfile writes a Makefile rule listing each output's dependencies.
+	depfile bool
+	// substitute replaces {{name}} placeholders with vars, {{date}} (in
+	// dateFormat) and {{git-sha}}.
+	substitute bool
+	vars       varsValue
+	dateFormat string
+	// variant selects the conditional sections kept in the output:
+	// markdown.VariantEmbedded, or markdown.VariantLinked to write the
+	// document with its images left as links instead.
+	variant string
+	// figureLabel numbers embedded images as figures when set.
+	figureLabel figureLabelValue
+	// imageTemplate is the parsed --image-template, or nil.
+	imageTemplate *template.Template
+	// stamp marks outputs with a hash of their inputs and leaves outputs
+	// whose stamp is current untouched.
+	stamp bool
+	// imageTimeout and docTimeout bound the time spent on one image and on
+	// one document; zero means no limit.
+	imageTimeout time.Duration
+	docTimeout   time.Duration
+	// allowPartial writes the output of a document interrupted by SIGINT or
+	// SIGTERM, with the images processed so far embedded.
+	allowPartial bool
+	// messagesFile is a catalog translating the command's messages.
+	messagesFile string
+	// output replaces the <input>_embedded.md output path, and
+	// overwriteInput allows it to be one of the inputs.
+	output         string
+	overwriteInput bool
+	// outputDir receives the outputs under the input names, in the same
+	// tree as the inputs below their directory argument.
+	outputDir string
+	// readOnly records which input directories are read-only; relocated
+	// maps the outputs moved from them to the working directory to their
+	// inputs.
+	readOnly  map[string]bool
+	relocated map[string]string
+	// inPlace replaces each input with its output, first copying it to the
+	// input name plus backupSuffix if that is set.
+	inPlace      bool
+	backupSuffix string
+	// docStats reports statistics about the documents instead of writing
+	// any output; it is set by the stats subcommand.
+	docStats bool
+	// index writes which documents reference each image instead of
+	// embedding, in indexFormat; it is set by the index subcommand.
+	index       bool
+	indexFormat string
+	// moveFrom and moveTo are the image to rename and its new path, set by
+	// the mv subcommand; inputFiles are then the documents to update.
+	moveFrom, moveTo string
+	// mirror downloads remote images into assetsDir and points the
+	// documents at the copies, recording them in mirrorMap; it is set by
+	// the mirror subcommand.
+	mirror    bool
+	assetsDir string
+	mirrorMap string
+	// recordSources writes where each embedded image came from beside the
+	// output, and refresh, set by the refresh subcommand, uses that to
+	// update the outputs given as inputs.
+	recordSources bool
+	refresh       bool
+	// keepTemp keeps the run's temporary directory for debugging.
+	keepTemp bool
+	// attributions records the origin and license of embedded remote
+	// images in a "file" beside the output or a "section" at its end.
+	attributions string
+	// fallbackPlaceholder embeds placeholders for remote images whose host
+	// can't be reached; it implies recordSources so they can be refreshed.
+	fallbackPlaceholder bool
+	// age reports when each referenced image last changed, flagging those
+	// older than olderThan; it is set by the age subcommand.
+	age       bool
+	olderThan ageValue
+	// extract writes embedded images to files in assetsDir, check reports
+	// missing and inconsistently referenced images and list lists the image
+	// references; they are set by the subcommands of the same names.
+	extract bool
+	check   bool
+	list    bool
+	// init creates the input file from templateFile; it is set by the init
+	// subcommand.
+	init         bool
+	templateFile string
+	// junitFile receives a JUnit XML report of the run, collected in junit.
+	junitFile string
+	junit     *junitReport
+	// reportFile receives a JSON report of every image of the run,
+	// collected in report.
+	reportFile string
+	report     *runReport
+	// watch embeds the inputs again whenever they change, or with
+	// watchImages the local images they reference; watching holds what
+	// depends on what.
+	watch       bool
+	watchImages bool
+	watching    *watchState
+	// configFile supplies defaults for the flags set neither on the command
+	// line nor in the environment; "none" disables the search for one.
+	configFile string
+	// profileName selects a profile of the config file.
+	profileName string
+	// exclude leaves the images whose path or URL matches one of these
+	// patterns untouched, and include those that match none of its.
+	exclude listValue
+	include listValue
+	// fallbacks are the alternative sources tried for the images under
+	// their prefixes when an image's own can't be read.
+	fallbacks fallbacksValue
+	// tagProfiles are the profiles defined with --tag-profile, besides the
+	// built-in ones, that images select with |tag in their alt text.
+	tagProfiles tagProfilesValue
+	// baseDir, if set, is where relative image paths are resolved instead
+	// of the directory of each document.
+	baseDir string
+}
+
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: go run main.go <markdown-file> [--debug]")
-		os.Exit(1)
+	opts, err := parseArgs(os.Args[1:])
+	if err == flag.ErrHelp {
+		printUsage(os.Stdout)
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		printUsage(os.Stdout)
+		exit(exitInputError)
+	}
+
+	if opts.showVersion {
+		fmt.Print(versionInfo())
+		return
+	}
+
+	if opts.applyFile != "" {
+		reviewed, err := loadPlan(opts.applyFile)
+		if err != nil {
+			exitf(exitInputError, "Error reading plan: %v", err)
+		}
+		if opts, err = parseArgs(reviewed.Args); err != nil {
+			exitf(exitInputError, "Error in plan arguments: %v", err)
+		}
+		opts.applied = reviewed
+	}
+	logLevel.Set(opts.level)
+	tempFiles.keep = opts.keepTemp
+	defer removeTempFiles()
+
+	if (slices.Contains(opts.inputFiles, stdinName) && opts.output == "") || opts.diff {
+		// Keep standard output for the document or its diff.
+		statusOutput = os.Stderr
+	}
+
+	if opts.recursive {
+		if err := opts.expandInputs(); err != nil {
+			exitf(exitInputError, "Error walking directory: %v", err)
+		}
+		if len(opts.inputFiles) == 0 {
+			exitf(exitInputError, "No markdown files found")
+		}
+	}
+	if opts.changedSince != "" {
+		if err := opts.keepChanged(); err != nil {
+			exitf(exitInputError, "Error: %v", err)
+		}
+		if len(opts.inputFiles) == 0 {
+			printf("No documents changed since %s\n", opts.changedSince)
+			return
+		}
+	}
+	if opts.outputDir != "" {
+		if err := opts.checkMirroredOutputs(); err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+
+	if opts.index {
+		index, err := opts.imageIndex()
+		if err != nil {
+			fatalf("Error reading file: %v", err)
+		}
+		var out bytes.Buffer
+		if err := writeImageIndex(&out, index, opts.indexFormat); err != nil {
+			fatalf("Error writing index: %v", err)
+		}
+		if opts.output == "" {
+			os.Stdout.Write(out.Bytes())
+		} else if err := writeFileAtomic(opts.output, out.Bytes()); err != nil {
+			fatalf("Error writing index: %v", err)
+		}
+		return
+	}
+
+	if opts.moveFrom != "" {
+		if err := opts.moveImage(); err != nil {
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	if opts.audit {
+		found, err := opts.auditPaths(os.Stdout)
+		if err != nil {
+			fatalf("Error reading file: %v", err)
+		}
+		if found > 0 {
+			exit(1)
+		}
+		return
+	}
+	if opts.check {
+		found, err := opts.checkImages(os.Stdout)
+		if err != nil {
+			fatalf("Error reading file: %v", err)
+		}
+		if found > 0 {
+			exit(1)
+		}
+		return
+	}
+	if opts.list {
+		if err := opts.listImages(os.Stdout); err != nil {
+			fatalf("Error reading file: %v", err)
+		}
+		return
+	}
+	if opts.extract {
+		if err := opts.extractImages(); err != nil {
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+
+	// The first SIGINT or SIGTERM stops processing; a second one kills the
+	// process at once.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if opts.pdfCommand != "" {
+		if err := registerPDFCommand(ctx, opts.pdfCommand); err != nil {
+			fatalf("Error: %v", err)
+		}
+	}
+	if opts.videoPosters > 0 && !registerFFmpeg(ctx, opts.posterTime) {
+		warnf("Warning: ffmpeg not found; videos are left without poster frames")
+	}
+	if opts.planFile != "" {
+		opts.planned = &plan{Version: planVersion, Args: planArgs(opts.args)}
+	}
+	if opts.junitFile != "" {
+		opts.junit = &junitReport{}
+	}
+	if opts.reportFile != "" {
+		opts.report = &runReport{Started: time.Now().UTC()}
+	}
+	if opts.interactive {
+		opts.prompt = &imagePrompt{in: bufio.NewReader(os.Stdin), out: os.Stderr, client: &http.Client{Timeout: 30 * time.Second}}
+	}
+	if opts.incremental {
+		opts.build = loadBuildState(filepath.Join(commonDir(opts.inputFiles), buildStateFile), buildOptions(opts.args, opts.configFile))
+	}
+	if opts.age {
+		ages, err := opts.imageAges(ctx, &http.Client{Timeout: 30 * time.Second})
+		if err != nil {
+			fatalf("Error reading file: %v", err)
+		}
+		if writeImageAges(os.Stdout, ages, time.Now(), time.Duration(opts.olderThan)) > 0 {
+			exit(1)
+		}
+		return
+	}
+	if opts.watch {
+		if err := opts.watchInputs(ctx); err != nil {
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+	if opts.refresh {
+		failed := opts.refreshOutputs(ctx)
+		if ctx.Err() != nil {
+			exit(exitInterrupted)
+		}
+		if failed > 0 {
+			exit(1)
+		}
+		return
+	}
+	if opts.manifest != "" {
+		failed, rows, err := opts.processManifest(ctx)
+		opts.recordStats()
+		if err != nil {
+			exitf(exitInputError, "Error reading manifest: %v", err)
+		}
+		if ctx.Err() != nil {
+			logf("Interrupted")
+			exit(exitInterrupted)
+		}
+		if opts.strict && opts.imagesFailed > 0 {
+			logf("Strict: %d of %d images could not be embedded", opts.imagesFailed, opts.imagesFailed+opts.imagesEmbedded)
+		}
+		if status := opts.exitStatus(failed, rows); status != 0 {
+			exit(status)
+		}
+		return
+	}
+	processor := markdown.NewProcessor(opts.processorOptions())
+	if opts.init {
+		if err := opts.initDocument(ctx, processor); err != nil {
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+	if opts.mirror {
+		if err := opts.mirrorImages(ctx, processor); err != nil {
+			if ctx.Err() != nil {
+				exit(exitInterrupted)
+			}
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+	if opts.docStats {
+		if err := opts.documentStats(ctx, processor, os.Stdout); err != nil {
+			if ctx.Err() != nil {
+				exit(exitInterrupted)
+			}
+			fatalf("Error: %v", err)
+		}
+		return
+	}
+	started := time.Now()
+	failed := 0
+	if opts.concat || len(opts.inputFiles) == 1 {
+		if err := opts.embed(ctx, processor, opts.inputFiles); err != nil {
+			opts.recordStats()
+			if opts.junit != nil {
+				opts.junit.addError(opts.inputFiles, err)
+				if err := opts.junit.write(opts.junitFile, time.Since(started)); err != nil {
+					warnf("Warning: Could not write JUnit report: %v", err)
+				}
+			}
+			if opts.report != nil {
+				opts.report.addError(opts.inputFiles, err)
+				if err := opts.report.write(opts.reportFile, time.Since(started)); err != nil {
+					warnf("Warning: Could not write report: %v", err)
+				}
+			}
+			exitf(errorStatus(err), "Error: %v", err)
+		}
+	} else {
+		failed = opts.embedEach(ctx, processor)
+	}
+	opts.recordStats()
+	interrupted := ctx.Err() != nil
+	if opts.sharedAssets && (!interrupted || opts.allowPartial) {
+		if err := opts.writeSharedAssets(); err != nil {
+			fatalf("Error writing output file: %v", err)
+		}
+	}
+	if opts.build != nil {
+		if err := opts.build.write(); err != nil {
+			warnf("Warning: Could not save %s: %v", opts.build.path, err)
+		}
+	}
+	if interrupted {
+		logf("Interrupted")
+		exit(exitInterrupted)
+	}
+	if opts.junit != nil {
+		if err := opts.junit.write(opts.junitFile, time.Since(started)); err != nil {
+			fatalf("Error writing JUnit report: %v", err)
+		}
+	}
+	if opts.report != nil {
+		if err := opts.report.write(opts.reportFile, time.Since(started)); err != nil {
+			fatalf("Error writing report: %v", err)
+		}
+	}
+	if opts.planned != nil {
+		if err := opts.planned.write(opts.planFile); err != nil {
+			fatalf("Error writing plan: %v", err)
+		}
+		printf("Wrote plan to %s\n", opts.planFile)
+	}
+	docs := len(opts.inputFiles)
+	if opts.concat || docs == 1 {
+		docs = 1
+	}
+	if opts.strict && opts.imagesFailed > 0 {
+		logf("Strict: %d of %d images could not be embedded", opts.imagesFailed, opts.imagesFailed+opts.imagesEmbedded)
+	}
+	if status := opts.exitStatus(failed, docs); status != 0 {
+		exit(status)
+	}
+}
+
+// fileOutcome records whether an input file could be processed.
+type fileOutcome struct {
+	file string
+	err  error
+}
+
+// embedEach embeds every input file on its own, sharing processor and so
+// its downloads and encoded images, and returns how many failed. A failing
+// file doesn't stop the others; all are listed once every file was tried.
+func (o *cliOptions) embedEach(ctx context.Context, processor *markdown.Processor) int {
+	var outcomes []fileOutcome
+	failed := 0
+	for _, file := range o.inputFiles {
+		if ctx.Err() != nil {
+			break
+		}
+		err := o.embed(ctx, processor, []string{file})
+		if err != nil {
+			logf("Error: %v", err)
+			failed++
+			if o.junit != nil {
+				o.junit.addError([]string{file}, err)
+			}
+			if o.report != nil {
+				o.report.addError([]string{file}, err)
+			}
+		}
+		outcomes = append(outcomes, fileOutcome{file: file, err: err})
+	}
+	printOutcomes(statusOutput, outcomes, failed)
+	return failed
+}
+
+// printOutcomes writes the summary of a run over several files.
+func printOutcomes(w io.Writer, outcomes []fileOutcome, failed int) {
+	fprintf(w, "\nProcessed %d files: %d succeeded, %d failed\n", len(outcomes), len(outcomes)-failed, failed)
+	for _, outcome := range outcomes {
+		if outcome.err != nil {
+			fprintf(w, "  failed  %s: %v\n", outcome.file, outcome.err)
+		} else {
+			fprintf(w, "  ok      %s\n", outcome.file)
+		}
+	}
+}
+
+// embed processes files, concatenated when there is more than one, into the
+// --output file or the _embedded.md output named after the first file, or
+// into one output per part with --split-by-heading. ctx is canceled when the
+// run is interrupted; an interrupted document is not an error.
+func (o *cliOptions) embed(ctx context.Context, processor *markdown.Processor, files []string) error {
+	inputFile := files[0]
+	baseDir := o.documentDir(inputFile)
+	stem, suffix := inputFile, o.outputSuffix()
+	switch {
+	case o.output != "":
+		stem, suffix = o.output, filepath.Ext(o.output)
+	case o.outputDir != "":
+		stem, suffix = o.mirroredOutput(inputFile), o.outputExt(inputFile)
+	case inputFile != stdinName:
+		var err error
+		if stem, err = o.writableStem(inputFile); err != nil {
+			return err
+		}
+	}
+	outputFile := strings.TrimSuffix(stem, filepath.Ext(stem)) + suffix
+	switch {
+	case inputFile == stdinName && o.output == "":
+		outputFile = stdinName
+	case o.inPlace:
+		outputFile = inputFile
+	}
+
+	if o.build != nil && o.build.upToDate(outputFile, files) {
+		printf("Up to date: %s\n", outputFile)
+		return nil
+	}
+
+	var content string
+	var err error
+	if o.concat {
+		content, err = o.concatDocuments(files, baseDir)
+	} else {
+		content, err = o.loadDocument(inputFile)
+	}
+	if err != nil {
+		return errorf("reading file: %w", err)
+	}
+	if !o.concat {
+		content = o.linkOutputs(content, inputFile)
+	}
+	if o.substitute {
+		content = markdown.SubstituteVariables(content, o.variables(baseDir))
+	}
+	if content, err = markdown.ResolveConditionals(content, o.variant); err != nil {
+		return errorf("resolving conditional sections: %v", err)
+	}
+
+	if o.docTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, o.docTimeout)
+		defer cancel()
+	}
+	outputs := []string{outputFile}
+	var results []*markdown.Result
+	if o.splitLevel <= 0 {
+		result, err := o.embedPart(ctx, processor, files, content, baseDir, outputFile)
+		if err != nil {
+			return err
+		}
+		results = append(results, result)
+	} else {
+		outputs = nil
+		for i, section := range markdown.SplitByHeading(content, o.splitLevel) {
+			part := partOutputName(stem, i, section.Title, suffix)
+			outputs = append(outputs, part)
+			result, err := o.embedPart(ctx, processor, files, section.Content, baseDir, part)
+			if err != nil {
+				return err
+			}
+			results = append(results, result)
+			if ctx.Err() != nil {
+				break
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		// Interrupted outputs are missing or partial, so never up to date.
+		if o.build != nil {
+			delete(o.build.Documents, outputFile)
+		}
+		return nil
+	}
+	if o.watching != nil {
+		watched := results
+		if !o.watchImages {
+			watched = nil
+		}
+		deps, _ := o.dependencies(files, baseDir, watched)
+		o.watching.track(files, deps)
+	}
+	if o.dryRun || (o.build == nil && !o.depfile) {
+		return nil
+	}
+	deps, complete := o.dependencies(files, baseDir, results)
+	if o.depfile {
+		if err := writeDepfile(outputFile+".d", outputs, deps); err != nil {
+			warnf("Warning: Could not write depfile: %v", err)
+		}
+	}
+	if o.build != nil {
+		if complete {
+			o.build.record(outputFile, files, outputs, deps)
+		} else {
+			delete(o.build.Documents, outputFile)
+		}
+	}
+	return nil
+}
+
+// outputSuffix replaces the input's extension in output file names, such as
+// _embedded.md.
+func (o *cliOptions) outputSuffix() string {
+	suffix := "_embedded"
+	if o.variant == markdown.VariantLinked {
+		suffix = "_linked"
+	}
+	if o.format == "html" {
+		return suffix + ".html"
+	}
+	return suffix + ".md"
+}
+
+// outputExt replaces the extension of file in output file names: the
+// _embedded suffix, or with --output-dir, which keeps the input names,
+// .html or the file's own extension.
+func (o *cliOptions) outputExt(file string) string {
+	switch {
+	case o.outputDir == "":
+		return o.outputSuffix()
+	case o.format == "html":
+		return ".html"
+	}
+	return filepath.Ext(file)
+}
+
+// mirroredOutput is where --output-dir puts the output of file, before its
+// extension is replaced: at the path of file relative to the directory
+// argument it was found in, or of a file given by itself at its base name.
+func (o *cliOptions) mirroredOutput(file string) string {
+	if root, ok := o.inputRoots[file]; ok {
+		if rel, err := filepath.Rel(root, file); err == nil {
+			return filepath.Join(o.outputDir, rel)
+		}
+	}
+	return filepath.Join(o.outputDir, filepath.Base(file))
+}
+
+// checkMirroredOutputs refuses an --output-dir run in which two inputs,
+// found under different directory arguments, would have the same output.
+func (o *cliOptions) checkMirroredOutputs() error {
+	if o.concat {
+		return nil
+	}
+	inputs := map[string]string{}
+	for _, file := range o.inputFiles {
+		stem := o.mirroredOutput(file)
+		output := strings.TrimSuffix(stem, filepath.Ext(stem)) + o.outputExt(file)
+		if other, ok := inputs[output]; ok {
+			return errorf("both %s and %s would be written to %s", other, file, output)
+		}
+		inputs[output] = file
+	}
+	return nil
+}
+
+// partOutputName names the output for part i (counting from zero) of a
+// document split with --split-by-heading, e.g. guide_02-installation_embedded.md
+// for guide.md, or build/guide_02-installation.md for --output build/guide.md.
+func partOutputName(stem string, i int, title, suffix string) string {
+	name := fmt.Sprintf("%s_%02d", strings.TrimSuffix(stem, filepath.Ext(stem)), i+1)
+	if slug := markdown.Slug(title); slug != "" {
+		name += "-" + slug
+	}
+	return name + suffix
+}
+
+// embedPart embeds the images of content, read from files, and writes the
+// result to outputFile. ctx carries the --doc-timeout deadline.
+func (o *cliOptions) embedPart(ctx context.Context, processor *markdown.Processor, files []string, content, baseDir, outputFile string) (*markdown.Result, error) {
+	if !o.overwriteInput && !o.inPlace {
+		if err := checkNotInput(outputFile, files); err != nil {
+			return nil, err
+		}
+	}
+	var reviewed *documentPlan
+	if o.applied != nil {
+		var err error
+		if reviewed, err = o.applied.document(files, outputFile, content); err != nil {
+			return nil, errorf("applying plan: %v", err)
+		}
+		popts := o.processorOptions()
+		popts.BeforeEmbed = reviewed.beforeEmbed
+		processor = markdown.NewProcessor(popts)
+	}
+	if o.prompt != nil {
+		popts := o.processorOptions()
+		popts.BeforeEmbed = o.prompt.beforeEmbed(ctx, files, content, baseDir)
+		processor = markdown.NewProcessor(popts)
+	}
+
+	started := time.Now()
+	result, err := processor.ProcessContext(ctx, content, baseDir)
+	partial := false
+	if markdown.CodeOf(err) == markdown.CodeInterrupted {
+		if !o.allowPartial {
+			logf("Interrupted: not writing %s (use --allow-partial to keep partial results)", outputFile)
+			return result, nil
+		}
+		logf("Interrupted: writing partial %s", outputFile)
+		partial, err = true, nil
+	}
+	if err != nil {
+		return nil, errorf("processing markdown: %v", err)
+	}
+	if o.junit != nil {
+		o.junit.addDocument(files, content, result, time.Since(started))
+	}
+	if o.report != nil {
+		o.report.addDocument(files, outputFile, content, result)
+	}
+	o.tally(result)
+	o.enforcePolicy(files[0], content, result)
+	if reviewed != nil {
+		if err := reviewed.verify(result); err != nil {
+			return nil, errorf("applying plan: %v", err)
+		}
+	}
+
+	if o.dryRun {
+		if o.planned != nil {
+			o.planned.add(files, outputFile, content, result)
+		}
+		if o.diff {
+			output := o.rebaseOutput(result.Content, baseDir, outputFile)
+			if o.attributions == "section" {
+				output = appendAttributions(output, result)
+			}
+			if _, err := io.WriteString(os.Stdout, unifiedDiff(strings.Join(files, ", "), outputFile, markdown.ElideDataURIs(content), markdown.ElideDataURIs(output))); err != nil {
+				return nil, errorf("writing diff: %v", err)
+			}
+		}
+		printf("Dry run: would process %s -> %s\n", strings.Join(files, ", "), outputFile)
+		printFailureSummary(os.Stderr, result)
+		printMediaSummary(os.Stderr, result)
+		o.warnLimits(os.Stderr, outputFile, result)
+		return result, nil
+	}
+	output := o.rebaseOutput(result.Content, baseDir, outputFile)
+	if o.attributions == "section" {
+		output = appendAttributions(output, result)
+	}
+	if o.format == "html" {
+		if output, err = markdown.RenderHTML(output, o.htmlOptions(baseDir)); err != nil {
+			return nil, errorf("rendering HTML: %v", err)
+		}
+		var errs []error
+		output, errs = processor.InlineStylesheets(output, baseDir)
+		for _, err := range errs {
+			warnf("Warning: Could not inline stylesheet asset: %v", err)
+		}
+		if o.encryptionKey != nil {
+			if output, _, err = markdown.EncryptImages(output, o.encryptionKey); err != nil {
+				return nil, errorf("encrypting images: %v", err)
+			}
+		}
+	}
+	unchanged := false
+	// A partial output must not look up to date to a later --stamp run.
+	if o.stamp && !partial {
+		hash := o.inputHash(content, result)
+		output = stampOutput(output, hash, markdown.DetectNewline(output))
+		unchanged = !o.sharedAssets && readStamp(outputFile) == hash
+	}
+	switch {
+	case unchanged:
+	case o.sharedAssets:
+		o.pages = append(o.pages, renderedPage{path: outputFile, content: output})
+	case outputFile == stdinName:
+		if _, err := io.WriteString(os.Stdout, output); err != nil {
+			return nil, errorf("writing output: %v", err)
+		}
+	default:
+		if o.output != "" || o.outputDir != "" {
+			err = os.MkdirAll(filepath.Dir(outputFile), 0755)
+		}
+		if err == nil && o.inPlace && o.backupSuffix != "" {
+			err = backupFile(outputFile, outputFile+o.backupSuffix)
+		}
+		if err == nil {
+			err = writeFileAtomic(outputFile, []byte(output))
+		}
+		if err != nil {
+			return nil, errorf("writing output file: %v", &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: outputFile, Err: err})
+		}
+		if o.recordSources && !partial {
+			if err := o.writeSources(outputFile, baseDir, result); err != nil {
+				warnf("Warning: Could not record image sources of %s: %v", outputFile, err)
+			}
+		}
+		if o.attributions == "file" && !partial {
+			if err := writeAttributions(outputFile, result); err != nil {
+				warnf("Warning: Could not record image attributions of %s: %v", outputFile, err)
+			}
+		}
+	}
+
+	if o.statsFile != "" {
+		o.usage = append(o.usage, result)
 	}
 
-	inputFile := os.Args[1]
-	debugMode := len(os.Args) > 2 && os.Args[2] == "--debug"
+	switch {
+	case unchanged:
+		printf("Unchanged: %s\n", outputFile)
+	case partial:
+		printf("Partially processed %s -> %s\n", strings.Join(files, ", "), outputFile)
+	default:
+		printf("Successfully processed %s -> %s\n", strings.Join(files, ", "), outputFile)
+	}
+	printFailureSummary(os.Stderr, result)
+	printMediaSummary(os.Stderr, result)
+	o.warnLimits(os.Stderr, outputFile, result)
+	return result, nil
+}
 
-	content, err := os.ReadFile(inputFile)
+// backupFile copies path to backup, keeping its permissions, before
+// --in-place replaces it.
+func backupFile(path, backup string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
 	if err != nil {
-		log.Fatalf("Error reading file %s: %v", inputFile, err)
+		return err
+	}
+	if err := writeFileAtomic(backup, data); err != nil {
+		return err
 	}
+	return os.Chmod(backup, info.Mode().Perm())
+}
 
-	processedContent, err := markdown.ProcessMarkdown(string(content), filepath.Dir(inputFile), debugMode)
+// checkNotInput refuses to write outputFile over one of the documents it is
+// made from, which would lose the original image references.
+func checkNotInput(outputFile string, files []string) error {
+	out, err := os.Stat(outputFile)
 	if err != nil {
-		log.Fatalf("Error processing markdown: %v", err)
+		return nil
+	}
+	for _, file := range files {
+		if in, err := os.Stat(file); err == nil && os.SameFile(in, out) {
+			return errorf("refusing to overwrite input %s (use --overwrite-input to allow it)", file)
+		}
 	}
+	return nil
+}
+
+// printFailureSummary lists every image that could not be embedded together
+// with its stable error code, so scripts can match on codes, every
+// placeholder embedded for an unreachable image, and every image left as a
+// reference for being too large.
+func printFailureSummary(w io.Writer, result *markdown.Result) {
+	var failed, placeholders, tooLarge []markdown.ImageResult
+	for _, img := range result.Images {
+		switch {
+		case img.Err != nil:
+			failed = append(failed, img)
+		case img.Placeholder:
+			placeholders = append(placeholders, img)
+		case img.TooLarge:
+			tooLarge = append(tooLarge, img)
+		}
+	}
+	if len(failed) > 0 {
+		fprintf(w, "%d of %d images could not be embedded:\n", len(failed), len(result.Images))
+		for _, img := range failed {
+			code := markdown.CodeOf(img.Err)
+			fprintf(w, "  %s %s %s\n", code, code.Name(), img.Reference.ImagePath)
+		}
+	}
+	if len(placeholders) > 0 {
+		fprintf(w, "%d images were unreachable and replaced by placeholders; run refresh on the output once they can be reached:\n", len(placeholders))
+		for _, img := range placeholders {
+			fprintf(w, "  %s\n", img.Reference.ImagePath)
+		}
+	}
+	if len(tooLarge) > 0 {
+		fprintf(w, "%d images were too large and left as references:\n", len(tooLarge))
+		for _, img := range tooLarge {
+			fprintf(w, "  %s: %s\n", img.Reference.ImagePath, img.SkipReason)
+		}
+	}
+}
 
-	outputFile := strings.TrimSuffix(inputFile, filepath.Ext(inputFile)) + "_embedded.md"
-	err = os.WriteFile(outputFile, []byte(processedContent), 0644)
+// themeHighlight is the code highlighting style that suits each theme.
+var themeHighlight = map[string]string{"github": "github", "dark": "monokai", "print": "bw"}
+
+// htmlOptions applies --theme, --highlight and --toc to the HTML page of a document in baseDir. A
+// custom stylesheet is linked relative to the document, from where
+// InlineStylesheets resolves it.
+func (o *cliOptions) htmlOptions(baseDir string) markdown.HTMLOptions {
+	opts := markdown.HTMLOptions{TOCDepth: int(o.toc), Highlight: o.highlight}
+	switch {
+	case o.highlight == "none":
+		opts.Highlight = ""
+	case o.highlight == "":
+		opts.Highlight = themeHighlight[o.theme]
+		if opts.Highlight == "" {
+			opts.Highlight = "github"
+		}
+	}
+	if css, ok := markdown.Theme(o.theme); ok {
+		opts.CSS = css
+		return opts
+	}
+	switch {
+	case o.theme == "none":
+		return opts
+	case strings.HasPrefix(o.theme, "http://"), strings.HasPrefix(o.theme, "https://"):
+		opts.Stylesheets = []string{o.theme}
+		return opts
+	}
+	href := o.theme
+	if abs, err := filepath.Abs(o.theme); err == nil {
+		if dir, err := filepath.Abs(baseDir); err == nil {
+			if rel, err := filepath.Rel(dir, abs); err == nil {
+				href = rel
+			}
+		}
+	}
+	opts.Stylesheets = []string{filepath.ToSlash(href)}
+	return opts
+}
+
+// printMediaSummary warns about the audio and video files left as
+// references, with their sizes, since the output is not self-contained
+// without them.
+func printMediaSummary(w io.Writer, result *markdown.Result) {
+	var kept []markdown.MediaResult
+	var total int64
+	for _, m := range result.Media {
+		if !m.Embedded {
+			kept = append(kept, m)
+			total += max(m.Size, 0)
+		}
+	}
+	if len(kept) == 0 {
+		return
+	}
+	fprintf(w, "%d audio/video references were not embedded (%s of local files; see --embed-media-under):\n", len(kept), markdown.FormatSize(total))
+	for _, m := range kept {
+		switch {
+		case m.Err != nil:
+			code := markdown.CodeOf(m.Err)
+			fprintf(w, "  %s line %d: %s %s\n", m.Path, m.Line, code, code.Name())
+		case m.Size < 0:
+			fprintf(w, "  %s line %d: remote\n", m.Path, m.Line)
+		default:
+			fprintf(w, "  %s line %d: %s\n", m.Path, m.Line, markdown.FormatSize(m.Size))
+		}
+	}
+}
+
+// processorOptions converts the command line into library options.
+func (o *cliOptions) processorOptions() markdown.Options {
+	return markdown.Options{
+		Logger:              logger(),
+		AttrStyle:           markdown.AttrStyle(o.attrStyle),
+		Figcaption:          o.figcaption,
+		CollapseOver:        int64(o.collapseOver),
+		SourceComments:      o.sourceComments,
+		WrapWidth:           o.wrapWidth,
+		ReferenceStyle:      o.refStyle,
+		Quality:             o.quality,
+		MaxWidth:            o.maxWidth,
+		MaxHeight:           o.maxHeight,
+		PreserveBitDepth:    o.preserveBitDepth,
+		BaseURL:             o.baseURL,
+		GitHubToken:         o.githubToken,
+		AllowedFormats:      o.allowedFormats,
+		MaxEmbedSize:        int64(o.maxEmbedSize),
+		MaxImageSize:        int64(o.maxImageSize),
+		Exclude:             o.exclude,
+		Include:             o.include,
+		Fallbacks:           o.fallbacks,
+		TagProfiles:         o.tagProfiles,
+		FallbackPlaceholder: o.fallbackPlaceholder,
+		Pipelines:           o.transforms,
+		CacheDir:            o.cacheDir,
+		PDFThumbnails:       o.pdfThumbnails,
+		VideoPosters:        o.videoPosters,
+		EmbedMediaUnder:     int64(o.embedMediaUnder),
+		Fonts:               markdown.FontEmbedding(o.fonts),
+		ImageTemplate:       o.imageTemplate,
+		FigureLabel:         string(o.figureLabel),
+		ImageTimeout:        o.imageTimeout,
+		Progress:            o.progressFunc(),
+		Jobs:                o.jobs,
+		Attribution:         o.attributions != "",
+		BeforeEmbed:         o.variantFilter(),
+	}
+}
+
+// variantFilter is the Options.BeforeEmbed of --variant: nil to embed the
+// images, or one leaving them all as links for the linked variant.
+func (o *cliOptions) variantFilter() func(int, *markdown.ImageReference) string {
+	if o.variant != markdown.VariantLinked {
+		return nil
+	}
+	return func(int, *markdown.ImageReference) string { return "linked variant" }
+}
+
+// newFlagSet declares every command line flag, binding them to opts.
+func newFlagSet(opts *cliOptions) *flag.FlagSet {
+	fs := flag.NewFlagSet("markdown-images", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	opts.attrStyle = attrStyleValue(markdown.AttrStyleNone)
+	opts.fonts = fontEmbeddingValue(markdown.FontsWOFF2)
+	fs.BoolVar(&opts.strict, "strict", false, "exit with status 3, or 1 if none could be embedded, when images could not be embedded")
+	fs.StringVar(&opts.templateFile, "template", "", "with init, the document to start from instead of the nearest "+templateFileNames[0]+" above the new document")
+	fs.StringVar(&opts.policyFile, "policy", "", "check images against the rules of this policy file, reported by check and enforced by --strict, instead of the nearest "+policyFileNames[0]+" above the first input (none = no policy)")
+	fs.BoolVar(&opts.showVersion, "version", false, "print the version, commit and Go version of this build and exit")
+	fs.BoolVar(&opts.verbose, "verbose", false, "log every image processed and downloaded, with sizes and durations (same as --log-level debug)")
+	fs.BoolVar(&opts.verbose, "v", false, "shorthand for --verbose")
+	fs.BoolVar(&opts.verbose, "debug", false, "same as --verbose")
+	fs.StringVar(&opts.progress, "progress", "auto", "show the images processed, bytes downloaded and current image on standard error: auto (documents with at least "+strconv.Itoa(progressThreshold)+" images), always or never; a bar on a terminal, a line per image otherwise")
+	fs.IntVar(&opts.jobs, "jobs", runtime.GOMAXPROCS(0), "how many images of a document to download and encode at once, by default one per CPU")
+	fs.BoolVar(&opts.quiet, "quiet", false, "print only warnings and errors (same as --log-level warn)")
+	fs.BoolVar(&opts.quiet, "q", false, "shorthand for --quiet")
+	fs.StringVar(&opts.logLevel, "log-level", "info", "least severe messages to print: debug, info, warn or error")
+	fs.StringVar(&opts.configFile, "config", "", "read default flag values from this YAML file instead of the nearest .markdown-images.yaml above the first input (none = don't read one)")
+	fs.StringVar(&opts.profileName, "profile", "", "apply the settings of this profile of the config file, e.g. publish or preview")
+	fs.StringVar(&opts.messagesFile, "messages", "", "translate the command's messages with this JSON catalog (see messages/template.json)")
+	fs.StringVar(&opts.statsFile, "stats-file", "", "accumulate local usage statistics in this JSON file")
+	fs.Var(&opts.attrStyle, "attr-style", "how to write image dimensions: none, kramdown, pandoc or html")
+	fs.Func("image-template", "write each embedded image with this Go text/template, or the template in @file (fields: .Src .Alt .Title .Width .Height .Path .MIMEType .Size .Markup .Newline .Number .Caption)", func(value string) error {
+		text := value
+		if file, ok := strings.CutPrefix(value, "@"); ok {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			text = string(data)
+		}
+		tmpl, err := markdown.ParseImageTemplate(text)
+		if err != nil {
+			return err
+		}
+		opts.imageTemplate = tmpl
+		return nil
+	})
+	fs.StringVar(&opts.variant, "variant", markdown.VariantEmbedded, "keep the <!-- if:embedded --> or <!-- if:linked --> sections of the document: embedded, or linked to write <input>_linked.md with the images left as links")
+	fs.BoolVar(&opts.substitute, "substitute", false, "replace {{date}}, {{git-sha}} and --var placeholders in the document (implied by --var)")
+	fs.Var(&opts.vars, "var", "replace {{name}} with value, e.g. --var version=1.2.0 (repeatable)")
+	fs.StringVar(&opts.dateFormat, "date-format", defaultDateFormat, "Go time layout of {{date}}; the date is $SOURCE_DATE_EPOCH when set")
+	fs.Var(&opts.figureLabel, "number-figures", "caption embedded images \"Figure N: alt text\" (--number-figures=Fig. for another label) and replace "+markdown.ListOfFiguresMarker+" with a list of figures")
+	fs.BoolVar(&opts.figcaption, "figcaption", false, "wrap titled images in <figure> with the title as <figcaption>")
+	fs.Var(&opts.collapseOver, "collapse-over", "wrap embedded images larger than this size (e.g. 500K) in <details>")
+	fs.BoolVar(&opts.sourceComments, "source-comments", false, "write a <!-- source: path sha256:... --> comment before every embedded image, which the extract subcommand uses to restore its file name")
+	fs.IntVar(&opts.wrapWidth, "wrap-base64", 0, "emit HTML img tags with the base64 payload wrapped at this many columns (e.g. 76)")
+	fs.BoolVar(&opts.refStyle, "reference-style", false, "write ![alt][imgN] in the body and the data URIs as definitions at the end")
+	fs.IntVar(&opts.quality, "quality", markdown.DefaultQuality, "JPEG quality (1-100)")
+	fs.IntVar(&opts.maxWidth, "max-width", markdown.DefaultMaxWidth, "scale images without explicit dimensions down to this width (0 = no limit)")
+	fs.IntVar(&opts.maxHeight, "max-height", 0, "scale images without explicit dimensions down to this height (0 = no limit)")
+	fs.BoolVar(&opts.preserveBitDepth, "preserve-bit-depth", false, "keep 16 bits per channel when re-encoding images that have them, instead of reducing them to 8")
+	fs.StringVar(&opts.format, "format", "markdown", "output format: markdown, or html for standalone pages")
+	fs.StringVar(&opts.theme, "theme", "github", "look of HTML output: "+strings.Join(markdown.ThemeNames(), ", ")+", none, or a CSS file or URL")
+	fs.StringVar(&opts.highlight, "highlight", "", "style for syntax highlighting of fenced code blocks in HTML output, or none (default: one matching --theme)")
+	fs.Var(&opts.toc, "toc", "start HTML output with a linked table of contents of headings down to this level (--toc=2; default 3)")
+	fs.Var(&opts.fonts, "embed-fonts", "webfonts to embed in HTML output: woff2 (only the WOFF2 source where one exists), all or none")
+	fs.StringVar(&opts.encryptKeyFile, "encrypt-key", "", "experimental: with --format html, encrypt the embedded images with the base64 AES-256 key in this file, decrypted in the browser with the key in the page's #key= fragment or typed in")
+	fs.BoolVar(&opts.sharedAssets, "shared-assets", false, "with --format html, move images used by several pages into a shared assets.css")
+	fs.StringVar(&opts.pdfCommand, "pdf-command", "", "embed referenced PDFs as an image of their first page, rendered by this command from stdin to stdout (e.g. 'pdftoppm -png -singlefile -f 1 -l 1 -')")
+	fs.IntVar(&opts.pdfThumbnails, "pdf-thumbnails", 0, "insert a preview of page 1, this many pixels wide, above links to local PDFs (requires --pdf-command)")
+	fs.IntVar(&opts.videoPosters, "video-posters", 0, "use a frame, at most this many pixels wide, as the poster of <video> tags and as a preview above links to local .mp4/.webm files (requires ffmpeg)")
+	fs.DurationVar(&opts.posterTime, "poster-time", 0, "take --video-posters frames this far into the video (e.g. 2s)")
+	fs.DurationVar(&opts.imageTimeout, "image-timeout", 0, "leave an image as a reference if downloading and encoding it takes longer than this (e.g. 30s; 0 = no limit)")
+	fs.BoolVar(&opts.keepTemp, "keep-temp", false, "keep the run's temporary directory, holding the files given to ffmpeg and --pdf-command and those they write, and print where it is")
+	fs.BoolVar(&opts.allowPartial, "allow-partial", false, "when interrupted, write the output of the document in progress with the images processed so far")
+	fs.DurationVar(&opts.docTimeout, "doc-timeout", 0, "fail if processing one document takes longer than this (e.g. 10m; 0 = no limit)")
+	fs.Var(&opts.embedMediaUnder, "embed-media-under", "inline local audio and video files smaller than this size (e.g. 5M) as data URIs; by default they stay references")
+	fs.StringVar(&opts.output, "output", "", "write the output to this file instead of <input>_embedded.md (creating its directory)")
+	fs.StringVar(&opts.output, "o", "", "shorthand for --output")
+	fs.StringVar(&opts.baseDir, "base-dir", "", "resolve relative image paths, /-rooted ones included, and includes against this directory instead of each document's (default: the current directory for -)")
+	fs.StringVar(&opts.outputDir, "output-dir", "", "write each output into this directory under the input's name, mirroring the tree below directory arguments")
+	fs.BoolVar(&opts.overwriteInput, "overwrite-input", false, "allow --output to replace the input document")
+	fs.BoolVar(&opts.inPlace, "in-place", false, "replace each input document with its embedded version instead of writing <input>_embedded.md")
+	fs.StringVar(&opts.backupSuffix, "backup", "", "with --in-place, first copy each document to its name plus this suffix (e.g. .bak)")
+	fs.BoolVar(&opts.concat, "concat", false, "merge several markdown files, in argument order, into one embedded output")
+	fs.Var(&opts.tagProfiles, "tag-profile", "define a profile that images ending their alt text with |name are encoded with, e.g. 'sketch: format=png | grayscale | quantize=16' (repeatable; lossless, photo and icon are built in)")
+	fs.Var(&opts.transforms, "transform", "apply a transform pipeline, e.g. '*.png: strip-metadata | max-width=1200 | quantize=128' (repeatable)")
+	fs.StringVar(&opts.cacheDir, "cache-dir", defaultCacheDir(), "keep re-encoded images here so repeat runs skip re-encoding")
+	fs.BoolVar(&opts.noCache, "no-cache", false, "don't read or write the --cache-dir")
+	fs.IntVar(&opts.splitLevel, "split-by-heading", 0, "write one output per heading of this level or higher (1 = every # heading), each with its own images")
+	fs.BoolVar(&opts.interactive, "interactive", false, "ask before embedding each image, showing its path or URL and size: embed, skip, always (embed the rest) or never (skip the rest)")
+	fs.BoolVar(&opts.dryRun, "dry-run", false, "process the images but write nothing")
+	fs.BoolVar(&opts.diff, "diff", false, "print a unified diff of the changes to each document, with data URIs shortened to their size, instead of writing it (implies --dry-run)")
+	fs.StringVar(&opts.reportFile, "report", "", "write a JSON report to this file of every image reference of every document: its source, MIME type, original and encoded size, whether it was embedded and any error")
+	fs.StringVar(&opts.junitFile, "junit", "", "write a JUnit XML report to this file: a test suite per document and a test case per image, failing those that could not be embedded")
+	fs.StringVar(&opts.planFile, "plan", "", "with --dry-run, write every intended action to this JSON file (implies --dry-run)")
+	fs.StringVar(&opts.applyFile, "apply", "", "execute the actions recorded in a --plan file (same as the apply subcommand)")
+	fs.StringVar(&opts.target, "target", "", "adapt the output to where it is viewed: "+targetNames())
+	fs.Var(&opts.exclude, "exclude", "leave images whose path or URL matches this pattern untouched, where * matches anything, e.g. 'https://img.shields.io/*', or a regular expression after re: (repeatable)")
+	fs.Var(&opts.include, "include", "only embed images whose path or URL matches this pattern, written as for --exclude, e.g. 'assets/*' (repeatable)")
+	fs.Var(&opts.fallbacks, "fallback", "try these sources in turn for the images whose path or URL starts with a prefix if theirs can't be read, e.g. 'https://cdn.example.com/img/=https://mirror.internal/img/,vendor/img/' (repeatable)")
+	fs.StringVar(&opts.changedSince, "changed-since", "", "only process the documents changed since this git ref, or whose includes or local images changed, e.g. origin/main")
+	fs.Var(&opts.maxImageSize, "max-image-size", "leave images larger than this size (e.g. 2MB) as references without downloading or decoding them, and report them")
+	fs.Var(&opts.maxEmbedSize, "max-embed-size", "leave images whose data URI would be larger than this size (e.g. 1M) as references")
+	fs.StringVar(&opts.baseURL, "base-url", "", "fetch images with relative paths from this URL instead of the local disk, e.g. https://example.com/docs/")
+	fs.StringVar(&opts.manifest, "manifest", "", "embed the documents listed in this CSV file, with columns input, output, profile and base-url, instead of those on the command line")
+	fs.StringVar(&opts.githubToken, "github-token", "", "token for GitHub-hosted images, used after rate limiting (default $GITHUB_TOKEN)")
+	fs.BoolVar(&opts.incremental, "incremental", false, "skip documents whose inputs, includes and local images are unchanged since the last --incremental run (tracked in "+buildStateFile+")")
+	fs.BoolVar(&opts.fallbackPlaceholder, "fallback-placeholder", false, "embed a placeholder showing the URL of a remote image whose host can't be reached, to be replaced by the refresh subcommand later (implies --record-sources)")
+	fs.StringVar(&opts.attributions, "attributions", "", "record the URL, response headers and any license, creator and copyright found of each embedded remote image: file (<output>"+attributionsSuffix+") or section (an \""+strings.TrimLeft(attributionsHeading, "# ")+"\" section at the end of the output)")
+	fs.BoolVar(&opts.recordSources, "record-sources", false, "write <output>"+sourcesSuffix+" recording the source of each embedded image, for the refresh subcommand")
+	fs.BoolVar(&opts.stamp, "stamp", false, "end outputs with a comment holding a hash of their inputs, and don't rewrite outputs whose hash is unchanged")
+	fs.BoolVar(&opts.depfile, "depfile", false, "write a make-style <output>.d file listing the documents, includes and local images each output depends on")
+	fs.BoolVar(&opts.watch, "watch", false, "keep running and embed the inputs again whenever they or their includes change")
+	fs.BoolVar(&opts.watchImages, "watch-images", false, "with --watch, also embed again when a referenced local image changes")
+	fs.BoolVar(&opts.recursive, "recursive", false, "accept directories and process every markdown file below them, honoring .gitignore and .mdimagesignore")
+	fs.Var(&opts.globs, "glob", "with --recursive, process the files matching this pattern relative to each directory, e.g. '**/*.md', instead of every markdown file (repeatable)")
+	fs.StringVar(&opts.assetsDir, "assets-dir", "assets", "directory the mirror subcommand downloads remote images into and the extract subcommand writes embedded images to")
+	fs.StringVar(&opts.mirrorMap, "mirror-map", "", "file mapping each mirrored URL to its local copy (default <assets-dir>/"+defaultMirrorMap+")")
+	fs.Var(&opts.olderThan, "older-than", "with the age subcommand, flag images last changed longer ago than this (e.g. 180d, 6w, 1y) and exit 1 if there are any")
+	fs.StringVar(&opts.indexFormat, "index-format", "json", "output format of the index subcommand: json or csv")
+	fs.BoolVar(&opts.audit, "audit-paths", false, "report images referenced through inconsistent relative paths instead of embedding; exits 1 if any are found")
+	fs.BoolVar(&opts.includes, "resolve-includes", false, "replace <!-- include: file.md --> and {{include file.md}} directives with the file contents")
+	return fs
+}
+
+// defaultCacheDir is the per-user cache location, or "" if there is none.
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
 	if err != nil {
-		log.Fatalf("Error writing output file %s: %v", outputFile, err)
+		return ""
+	}
+	return filepath.Join(dir, "markdown-images")
+}
+
+// printUsage writes the usage line and the flag reference to w.
+func printUsage(w io.Writer) {
+	fprintf(w, "Usage: go run main.go [embed] <markdown-file>... [flags]\n")
+	fprintf(w, "       go run main.go - [--base-dir <dir>] [flags] < in.md > out.md\n")
+	fprintf(w, "       go run main.go --concat <markdown-file>... [flags]\n")
+	fprintf(w, "       go run main.go --recursive <directory>... [--glob '**/*.md'] [--output-dir <dir>] [flags]\n")
+	fprintf(w, "       go run main.go --watch [--watch-images] <markdown-file>... [flags]\n")
+	fprintf(w, "       go run main.go --manifest <documents.csv> [flags]\n")
+	fprintf(w, "       go run main.go --audit-paths <markdown-file>...\n")
+	fprintf(w, "       go run main.go init <new-document.md> [--template <file.md>] [--var title=...]\n")
+	fprintf(w, "       go run main.go extract <markdown-file>... [--assets-dir <dir>] [--dry-run]\n")
+	fprintf(w, "       go run main.go check [<directory>...]\n")
+	fprintf(w, "       go run main.go list [<directory>...]\n")
+	fprintf(w, "       go run main.go stats <markdown-file>... [flags]\n")
+	fprintf(w, "       go run main.go index <directory>... [--index-format csv] [-o <file>]\n")
+	fprintf(w, "       go run main.go mv <image> <new-path> [<directory>...] [--dry-run]\n")
+	fprintf(w, "       go run main.go mirror [<directory>...] [--assets-dir <dir>] [--mirror-map <file>]\n")
+	fprintf(w, "       go run main.go refresh <embedded-file>... [--dry-run]\n")
+	fprintf(w, "       go run main.go age <directory>... [--older-than 180d]\n")
+	fprintf(w, "       go run main.go apply <plan.json>\n")
+	fprintf(w, "\nCommands:\n")
+	fprintf(w, "  embed    embed the referenced images into the documents (the default)\n")
+	fprintf(w, "  init     create a document from a template, with its images embedded\n")
+	fprintf(w, "  extract  write images embedded as data URIs to files and reference those\n")
+	fprintf(w, "  check    report missing images and inconsistent image paths; exits 1 if any\n")
+	fprintf(w, "  list     list the image references of each document\n")
+	fprintf(w, "  stats    report statistics about the documents and their images\n")
+	fprintf(w, "  index    report which documents reference each image\n")
+	fprintf(w, "  mv       rename an image and update the documents referencing it\n")
+	fprintf(w, "  mirror   download remote images and reference the local copies\n")
+	fprintf(w, "  refresh  embed again the images whose source changed since\n")
+	fprintf(w, "  age      report when each referenced image last changed\n")
+	fprintf(w, "  apply    execute the actions recorded in a --plan file\n")
+	fprintf(w, "\nFlags:\n")
+	fs := newFlagSet(&cliOptions{})
+	fs.VisitAll(func(f *flag.Flag) { f.Usage = tr(f.Usage) })
+	fs.SetOutput(w)
+	fs.PrintDefaults()
+}
+
+// subcommands are the names parseArgs accepts as the first argument: "embed
+// doc.md" is the same as "doc.md", "extract docs/..." writes embedded images
+// back to files, "check docs/..." reports images that would fail to embed,
+// "list docs/..." lists the images of the documents, "stats doc.md..." and
+// "index docs/..." report on the documents, "mv old.png new.png docs/..."
+// renames an image in them, "mirror docs/..." downloads their remote images,
+// "refresh out.md..." updates outputs from their recorded sources and "age
+// docs/..." reports how old their images are. "apply plan.json" is handled
+// on its own.
+var subcommands = []string{"embed", "init", "extract", "check", "list", "stats", "index", "mv", "mirror", "refresh", "age"}
+
+// sharedFlags apply to every subcommand: they set what is logged, where
+// settings come from, and how the input documents are found and read.
+var sharedFlags = []string{
+	"version", "verbose", "v", "debug", "quiet", "q", "log-level", "progress",
+	"config", "profile", "messages", "base-dir", "recursive", "glob", "resolve-includes",
+}
+
+// processingFlags set how images are read and encoded, for the subcommands
+// that process them like embedding does.
+var processingFlags = []string{
+	"attr-style", "image-template", "number-figures", "figcaption", "collapse-over", "source-comments",
+	"wrap-base64", "reference-style", "quality", "max-width", "max-height", "preserve-bit-depth",
+	"embed-fonts", "pdf-command", "pdf-thumbnails", "video-posters", "poster-time", "image-timeout",
+	"embed-media-under", "tag-profile", "transform", "cache-dir", "no-cache", "target", "exclude",
+	"include", "fallback", "max-image-size", "max-embed-size", "base-url", "github-token",
+	"fallback-placeholder", "attributions", "jobs", "keep-temp",
+}
+
+// subcommandFlags are the flags each subcommand takes besides sharedFlags;
+// embedding takes them all.
+var subcommandFlags = map[string][]string{
+	"init":    append([]string{"template", "var", "substitute", "date-format", "dry-run"}, processingFlags...),
+	"extract": {"assets-dir", "dry-run", "changed-since"},
+	"check":   {"policy", "changed-since"},
+	"list":    {"changed-since"},
+	"stats":   append([]string{"changed-since"}, processingFlags...),
+	"index":   {"index-format", "output", "o"},
+	"mv":      {"dry-run"},
+	"mirror":  {"assets-dir", "mirror-map", "dry-run", "image-timeout", "github-token", "max-image-size"},
+	"refresh": {"dry-run"},
+	"age":     {"older-than"},
+}
+
+// checkSubcommandFlags rejects the flags given on the command line that
+// subcommand doesn't take, rather than ignoring them. Settings from the
+// environment and config files are shared by every subcommand and not
+// checked.
+func checkSubcommandFlags(fs *flag.FlagSet, subcommand string) error {
+	allowed, ok := subcommandFlags[subcommand]
+	if !ok {
+		return nil
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if err == nil && !slices.Contains(sharedFlags, f.Name) && !slices.Contains(allowed, f.Name) {
+			err = errorf("-%s doesn't apply to the %s subcommand", f.Name, subcommand)
+		}
+	})
+	return err
+}
+
+// parseArgs parses the command line. Flags may appear before or after the
+// markdown file, so the historical "main.go file.md --debug" form keeps working.
+func parseArgs(args []string) (*cliOptions, error) {
+	if len(args) > 0 && args[0] == "apply" {
+		// "apply plan.json" is the subcommand form of --apply plan.json.
+		if len(args) != 2 {
+			return nil, errorf("usage: apply <plan.json>")
+		}
+		return &cliOptions{applyFile: args[1]}, nil
+	}
+
+	var subcommand string
+	if len(args) > 0 && slices.Contains(subcommands, args[0]) {
+		subcommand, args = args[0], args[1:]
+	}
+	if subcommand == "embed" {
+		// Embedding is what runs without a subcommand; embed just names it.
+		subcommand = ""
+	}
+	opts := &cliOptions{
+		args:     args,
+		init:     subcommand == "init",
+		extract:  subcommand == "extract",
+		check:    subcommand == "check",
+		list:     subcommand == "list",
+		docStats: subcommand == "stats",
+		index:    subcommand == "index",
+		mirror:   subcommand == "mirror",
+		refresh:  subcommand == "refresh",
+		age:      subcommand == "age",
+	}
+	fs := newFlagSet(opts)
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if opts.showVersion {
+		return opts, nil
+	}
+	if err := checkSubcommandFlags(fs, subcommand); err != nil {
+		return nil, err
 	}
 
-	fmt.Printf("Successfully processed %s -> %s\n", inputFile, outputFile)
+	if err := applyEnv(fs); err != nil {
+		return nil, err
+	}
+	discovered := false
+	switch opts.configFile {
+	case "none":
+		opts.configFile = ""
+	case "":
+		opts.configFile = findConfig(configStart(positional))
+		discovered = true
+	}
+	if opts.configFile != "" {
+		if err := applyConfig(fs, opts.configFile, discovered); err != nil {
+			return nil, err
+		}
+	} else if opts.profileName != "" {
+		return nil, errorf("--profile %s requires a config file (none found above %s)", opts.profileName, configStart(positional))
+	}
+	var messages map[string]string
+	if opts.messagesFile != "" {
+		var err error
+		if messages, err = loadMessages(opts.messagesFile); err != nil {
+			return nil, err
+		}
+	}
+	setMessages(messages)
+	set := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	if err := opts.applyTarget(set); err != nil {
+		return nil, err
+	}
+	if opts.githubToken == "" {
+		opts.githubToken = os.Getenv("GITHUB_TOKEN")
+	}
+	if err := opts.level.UnmarshalText([]byte(opts.logLevel)); err != nil {
+		return nil, errorf("invalid --log-level %q (expected debug, info, warn or error)", opts.logLevel)
+	}
+	if opts.progress != "auto" && opts.progress != "always" && opts.progress != "never" {
+		return nil, errorf("invalid --progress %q (expected auto, always or never)", opts.progress)
+	}
+	switch {
+	case opts.verbose && opts.quiet:
+		return nil, errorf("--verbose and --quiet can't be used together")
+	case opts.verbose:
+		opts.level = slog.LevelDebug
+	case opts.quiet:
+		opts.level = slog.LevelWarn
+	}
+	if opts.index || opts.age {
+		// The index and age reports cover whole documentation trees.
+		opts.recursive = true
+	}
+	if opts.index {
+		if opts.indexFormat != "json" && opts.indexFormat != "csv" {
+			return nil, errorf("invalid --index-format %q (expected json or csv)", opts.indexFormat)
+		}
+	}
+
+	if subcommand == "mv" {
+		if len(positional) < 2 {
+			return nil, errorf("usage: mv <image> <new-path> [<directory>...]")
+		}
+		opts.moveFrom, opts.moveTo, positional = positional[0], positional[1], positional[2:]
+		if len(positional) == 0 {
+			positional = []string{"."}
+		}
+		opts.recursive = true
+	}
+	if opts.init && len(positional) != 1 {
+		return nil, errorf("usage: init <new-document.md> [--template <file.md>]")
+	}
+	if opts.templateFile != "" && !opts.init {
+		return nil, errorf("--template only applies to init")
+	}
+	if opts.mirror || opts.check || opts.list {
+		if len(positional) == 0 {
+			positional = []string{"."}
+		}
+		opts.recursive = true
+	}
+
+	if opts.manifest != "" {
+		switch {
+		case len(positional) > 0:
+			return nil, errorf("--manifest lists the documents to embed and can't be combined with input files")
+		case subcommand != "", opts.audit:
+			return nil, errorf("--manifest only applies to embedding documents")
+		case opts.watch, opts.concat, opts.recursive, opts.output != "", opts.outputDir != "":
+			return nil, errorf("--manifest can't be used with --watch, --concat, --recursive, --output or --output-dir")
+		case opts.planFile != "", opts.junitFile != "", opts.reportFile != "", opts.incremental, opts.sharedAssets:
+			return nil, errorf("--manifest can't be used with --plan, --junit, --report, --incremental or --shared-assets")
+		}
+	}
+	if len(positional) == 0 && opts.applyFile == "" && opts.manifest == "" {
+		return nil, errorf("expected a markdown file")
+	}
+	switch opts.policyFile {
+	case "none":
+		opts.policyFile = ""
+	case "":
+		opts.policyFile = findNearest(configStart(positional), policyFileNames)
+	}
+	if opts.policyFile != "" {
+		var err error
+		if opts.policy, err = loadPolicy(opts.policyFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.inPlace {
+		switch {
+		case opts.output != "":
+			return nil, errorf("--in-place and --output can't be used together")
+		case opts.concat, opts.splitLevel > 0, opts.format != "markdown":
+			return nil, errorf("--in-place writes one markdown document per input and can't be used with --concat, --split-by-heading or --format html")
+		}
+	} else if opts.backupSuffix != "" {
+		return nil, errorf("--backup requires --in-place")
+	}
+	if slices.Contains(positional, stdinName) {
+		switch {
+		case len(positional) > 1, opts.recursive:
+			return nil, errorf("- reads a single document from standard input and can't be combined with other inputs")
+		case opts.splitLevel > 0, opts.incremental, opts.depfile, opts.sharedAssets:
+			return nil, errorf("- can't be used with --split-by-heading, --incremental, --depfile or --shared-assets")
+		}
+	}
+	if opts.outputDir != "" {
+		switch {
+		case opts.output != "", opts.inPlace:
+			return nil, errorf("--output-dir can't be used with --output or --in-place")
+		case slices.Contains(positional, stdinName):
+			return nil, errorf("- writes to standard output and can't be used with --output-dir")
+		}
+	}
+	if opts.changedSince != "" {
+		switch {
+		case opts.index, opts.moveFrom != "", opts.mirror, opts.refresh, opts.age, opts.init:
+			return nil, errorf("--changed-since only applies to embedding documents and the extract, check, list and stats subcommands")
+		case opts.watch, opts.manifest != "", slices.Contains(positional, stdinName):
+			return nil, errorf("--changed-since can't be used with --watch, --manifest or -")
+		case strings.HasPrefix(opts.changedSince, "-"):
+			return nil, errorf("invalid --changed-since %q: expected a git ref", opts.changedSince)
+		}
+	}
+	if opts.watchImages && !opts.watch {
+		return nil, errorf("--watch-images requires --watch")
+	}
+	if opts.watch {
+		switch {
+		case subcommand != "", opts.audit:
+			return nil, errorf("--watch only applies to embedding documents")
+		case slices.Contains(positional, stdinName):
+			return nil, errorf("- reads standard input once and can't be watched")
+		case opts.inPlace, opts.overwriteInput:
+			return nil, errorf("--watch can't be used with --in-place or --overwrite-input, whose outputs would trigger it again")
+		case opts.dryRun, opts.planFile != "", opts.incremental, opts.sharedAssets, opts.junitFile != "", opts.reportFile != "":
+			return nil, errorf("--watch can't be used with --dry-run, --plan, --incremental, --shared-assets, --junit or --report")
+		}
+	}
+	if opts.interactive {
+		switch {
+		case subcommand != "", opts.audit:
+			return nil, errorf("--interactive only applies to embedding documents")
+		case slices.Contains(positional, stdinName):
+			return nil, errorf("- reads the document from standard input, where --interactive reads its answers")
+		case opts.planFile != "", opts.applyFile != "":
+			return nil, errorf("--interactive can't be used with --plan or --apply")
+		}
+		// The prompts share standard error with the progress bar.
+		opts.progress = "never"
+	}
+	if len(opts.globs) > 0 && !opts.recursive {
+		return nil, errorf("--glob requires --recursive")
+	}
+	if opts.output != "" && (opts.recursive || (len(positional) > 1 && !opts.concat)) && !opts.audit && !opts.check && !opts.list && !opts.docStats && !opts.index && !opts.age {
+		return nil, errorf("--output takes a single document (use --concat to merge several)")
+	}
+	opts.inputFiles = positional
+	if opts.diff {
+		switch {
+		case subcommand != "", opts.audit:
+			return nil, errorf("--diff only applies to embedding documents")
+		case opts.watch, opts.applyFile != "":
+			return nil, errorf("--diff can't be used with --watch or --apply")
+		case opts.format != "markdown":
+			return nil, errorf("--diff compares markdown and can't be used with --format html")
+		}
+	}
+	if opts.planFile != "" || opts.diff {
+		opts.dryRun = true
+	}
+	if opts.fallbackPlaceholder {
+		opts.recordSources = true
+	}
+	if opts.noCache {
+		opts.cacheDir = ""
+	}
+	if len(opts.vars) > 0 {
+		opts.substitute = true
+	}
+	if opts.format != "markdown" && opts.format != "html" {
+		return nil, errorf("invalid --format %q (expected markdown or html)", opts.format)
+	}
+	switch opts.variant {
+	case markdown.VariantEmbedded:
+	case markdown.VariantLinked:
+		if opts.interactive || opts.planFile != "" || opts.applyFile != "" {
+			return nil, errorf("--variant linked embeds no images and can't be used with --interactive, --plan or --apply")
+		}
+	default:
+		return nil, errorf("invalid --variant %q (expected embedded or linked)", opts.variant)
+	}
+	if opts.incremental && (opts.sharedAssets || opts.dryRun || opts.applyFile != "") {
+		return nil, errorf("--incremental cannot be combined with --shared-assets, --dry-run, --plan or --apply")
+	}
+	if opts.encryptKeyFile != "" {
+		switch {
+		case opts.format != "html":
+			return nil, errorf("--encrypt-key requires --format html")
+		case opts.sharedAssets:
+			return nil, errorf("--encrypt-key can't be used with --shared-assets, which moves images out of the pages")
+		}
+		var err error
+		if opts.encryptionKey, err = loadEncryptionKey(opts.encryptKeyFile); err != nil {
+			return nil, err
+		}
+	}
+	if opts.sharedAssets && opts.format != "html" {
+		return nil, errorf("--shared-assets requires --format html")
+	}
+	for _, name := range []string{"theme", "toc", "highlight"} {
+		if set[name] && opts.format != "html" {
+			return nil, errorf("--%s requires --format html", name)
+		}
+	}
+	if _, builtin := markdown.Theme(opts.theme); !builtin && opts.theme != "none" && !strings.Contains(opts.theme, "://") {
+		if _, err := os.Stat(opts.theme); err != nil {
+			return nil, errorf("unknown --theme %q (expected %s, none, or a CSS file or URL)", opts.theme, strings.Join(markdown.ThemeNames(), ", "))
+		}
+	}
+	if opts.highlight != "" && opts.highlight != "none" && !slices.Contains(markdown.HighlightStyles(), opts.highlight) {
+		return nil, errorf("unknown --highlight style %q (expected none or one of %s)", opts.highlight, strings.Join(markdown.HighlightStyles(), ", "))
+	}
+	if opts.format == "html" && !set["attr-style"] && opts.attrStyle == attrStyleValue(markdown.AttrStyleNone) {
+		// Attribute blocks would show up as text in the rendered page.
+		opts.attrStyle = attrStyleValue(markdown.AttrStyleHTML)
+	}
+	if opts.videoPosters < 0 || opts.posterTime < 0 {
+		return nil, errorf("--video-posters and --poster-time must not be negative")
+	}
+	if opts.pdfThumbnails < 0 {
+		return nil, errorf("--pdf-thumbnails must not be negative")
+	}
+	if opts.imageTimeout < 0 || opts.docTimeout < 0 {
+		return nil, errorf("--image-timeout and --doc-timeout must not be negative")
+	}
+	if opts.pdfThumbnails > 0 && opts.pdfCommand == "" {
+		return nil, errorf("--pdf-thumbnails requires --pdf-command to render the pages")
+	}
+	if opts.splitLevel < 0 || opts.splitLevel > 6 {
+		return nil, errorf("--split-by-heading must be a heading level between 1 and 6")
+	}
+	if opts.wrapWidth < 0 {
+		return nil, errorf("--wrap-base64 must not be negative")
+	}
+	if opts.quality < 1 || opts.quality > 100 {
+		return nil, errorf("--quality must be between 1 and 100")
+	}
+	if opts.attributions != "" && opts.attributions != "file" && opts.attributions != "section" {
+		return nil, errorf("invalid --attributions %q (expected file or section)", opts.attributions)
+	}
+	if opts.jobs < 1 {
+		return nil, errorf("--jobs must be at least 1")
+	}
+	for _, pattern := range append(slices.Clone(opts.exclude), opts.include...) {
+		if err := markdown.CheckPattern(pattern); err != nil {
+			return nil, errorf("invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if opts.baseURL != "" {
+		if u, err := url.Parse(opts.baseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errorf("invalid --base-url %q (expected an http or https URL)", opts.baseURL)
+		}
+	}
+	if opts.maxWidth == 0 {
+		opts.maxWidth = -1 // The library treats zero as "use the default".
+	}
+	return opts, nil
 }
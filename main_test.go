@@ -0,0 +1,3241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+func TestParseArgs(t *testing.T) {
+	testCases := []struct {
+		name      string
+		args      []string
+		wantInput string
+		wantLevel slog.Level
+		wantStats string
+		wantErr   bool
+	}{
+		{
+			name:      "File only",
+			args:      []string{"doc.md"},
+			wantInput: "doc.md",
+		},
+		{
+			name:      "Debug after file",
+			args:      []string{"doc.md", "--debug"},
+			wantInput: "doc.md",
+			wantLevel: slog.LevelDebug,
+		},
+		{
+			name:      "Quiet",
+			args:      []string{"-q", "doc.md"},
+			wantInput: "doc.md",
+			wantLevel: slog.LevelWarn,
+		},
+		{
+			name:      "Log level",
+			args:      []string{"doc.md", "--log-level", "error"},
+			wantInput: "doc.md",
+			wantLevel: slog.LevelError,
+		},
+		{
+			name:    "Unknown log level",
+			args:    []string{"doc.md", "--log-level", "loud"},
+			wantErr: true,
+		},
+		{
+			name:    "Verbose and quiet",
+			args:    []string{"doc.md", "-v", "--quiet"},
+			wantErr: true,
+		},
+		{
+			name:      "Flags before file",
+			args:      []string{"--stats-file", "stats.json", "doc.md"},
+			wantInput: "doc.md",
+			wantStats: "stats.json",
+		},
+		{
+			name:    "Missing file",
+			args:    []string{"--debug"},
+			wantErr: true,
+		},
+		{
+			name:    "Output with recursive",
+			args:    []string{"--recursive", "docs", "-o", "out.md"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown attribute style",
+			args:    []string{"doc.md", "--attr-style", "textile"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid collapse size",
+			args:    []string{"doc.md", "--collapse-over", "big"},
+			wantErr: true,
+		},
+		{
+			name:    "Quality out of range",
+			args:    []string{"doc.md", "--quality", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid regular expression",
+			args:    []string{"doc.md", "--include", "re:assets/(.*"},
+			wantErr: true,
+		},
+		{
+			name:      "Several files",
+			args:      []string{"a.md", "b.md"},
+			wantInput: "a.md",
+		},
+		{
+			name:    "Several files with --output",
+			args:    []string{"a.md", "b.md", "-o", "out.md"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown target",
+			args:    []string{"doc.md", "--target", "word"},
+			wantErr: true,
+		},
+		{
+			name:    "Glob without recursive",
+			args:    []string{"doc.md", "--glob", "**/*.md"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid glob",
+			args:    []string{"docs", "--recursive", "--glob", "[a.md"},
+			wantErr: true,
+		},
+		{
+			name:    "Output dir with in-place",
+			args:    []string{"docs", "--recursive", "--output-dir", "out", "--in-place"},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid age",
+			args:    []string{"age", "docs", "--older-than", "3 months"},
+			wantErr: true,
+		},
+		{
+			name:    "Negative timeout",
+			args:    []string{"doc.md", "--image-timeout", "-1s"},
+			wantErr: true,
+		},
+		{
+			name:    "No jobs",
+			args:    []string{"doc.md", "--jobs", "0"},
+			wantErr: true,
+		},
+		{
+			name:    "Fallback without alternatives",
+			args:    []string{"doc.md", "--fallback", "https://cdn.example.com/img/"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"doc.md", "--bogus"},
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			opts, err := parseArgs(tc.args)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got options %+v", opts)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseArgs failed: %v", err)
+			}
+			if opts.inputFiles[0] != tc.wantInput || opts.level != tc.wantLevel || opts.statsFile != tc.wantStats {
+				t.Errorf("Unexpected options: %+v", opts)
+			}
+		})
+	}
+}
+
+func TestParseArgsEnvironment(t *testing.T) {
+	t.Setenv("MDIMAGES_DEBUG", "true")
+	t.Setenv("MDIMAGES_QUALITY", "40")
+	t.Setenv("MDIMAGES_ATTR_STYLE", "html")
+
+	opts, err := parseArgs([]string{"doc.md", "--quality", "70"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if opts.level != slog.LevelDebug || markdown.AttrStyle(opts.attrStyle) != markdown.AttrStyleHTML {
+		t.Errorf("Expected debug and attribute style from the environment, got %+v", opts)
+	}
+	if opts.quality != 70 {
+		t.Errorf("Expected the --quality flag to win over MDIMAGES_QUALITY, got %d", opts.quality)
+	}
+
+	t.Setenv("MDIMAGES_MAX_WIDTH", "wide")
+	if _, err := parseArgs([]string{"doc.md"}); err == nil || !strings.Contains(err.Error(), "MDIMAGES_MAX_WIDTH") {
+		t.Errorf("Expected an error naming MDIMAGES_MAX_WIDTH, got %v", err)
+	}
+
+	t.Setenv("MARKDOWN_IMAGES_MAX_WIDTH", "640")
+	t.Setenv("MARKDOWN_IMAGES_JOBS", "3")
+	opts, err = parseArgs([]string{"doc.md"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if opts.maxWidth != 640 || opts.jobs != 3 || opts.quality != 40 {
+		t.Errorf("Expected MARKDOWN_IMAGES_* to win over MDIMAGES_*, and both to be read, got max width %d, jobs %d, quality %d", opts.maxWidth, opts.jobs, opts.quality)
+	}
+
+	t.Setenv("MARKDOWN_IMAGES_TIMEOUT", "30s")
+	t.Setenv("MDIMAGES_MAX_SIZE", "2MB")
+	opts, err = parseArgs([]string{"doc.md"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if opts.imageTimeout != 30*time.Second || opts.maxImageSize != 2<<20 {
+		t.Errorf("Expected the TIMEOUT and MAX_SIZE aliases to be read, got timeout %v, max image size %d", opts.imageTimeout, opts.maxImageSize)
+	}
+	t.Setenv("MARKDOWN_IMAGES_IMAGE_TIMEOUT", "1m")
+	if opts, err = parseArgs([]string{"doc.md"}); err != nil || opts.imageTimeout != time.Minute {
+		t.Errorf("Expected MARKDOWN_IMAGES_IMAGE_TIMEOUT to win over its alias, got %v, %v", opts.imageTimeout, err)
+	}
+
+	t.Setenv("MDIMAGES_QUALTY", "50")
+	if _, err := parseArgs([]string{"doc.md"}); err == nil || !strings.Contains(err.Error(), "MDIMAGES_QUALTY") {
+		t.Errorf("Expected an error naming the unknown MDIMAGES_QUALTY, got %v", err)
+	}
+}
+
+func TestAliasesWinOverEnvAndConfig(t *testing.T) {
+	root := t.TempDir()
+	doc := filepath.Join(root, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, ".markdown-images.yaml"), []byte("output: config.md\nverbose: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{doc, "-o", "cli.md", "-q"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.output != "cli.md" || !opts.quiet || opts.verbose {
+		t.Errorf("Expected -o and -q to win over the config, got output %q, quiet %v, verbose %v", opts.output, opts.quiet, opts.verbose)
+	}
+
+	t.Setenv("MARKDOWN_IMAGES_OUTPUT", "env.md")
+	t.Setenv("MARKDOWN_IMAGES_DEBUG", "false")
+	opts, err = parseArgs([]string{doc, "-o", "cli.md", "-v"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.output != "cli.md" || !opts.verbose {
+		t.Errorf("Expected -o and -v to win over the environment, got output %q, verbose %v", opts.output, opts.verbose)
+	}
+	if opts, err = parseArgs([]string{doc}); err != nil || opts.output != "env.md" {
+		t.Errorf("Expected the environment to win over the config, got %q, %v", opts.output, err)
+	}
+}
+
+func TestParseArgsTarget(t *testing.T) {
+	opts, err := parseArgs([]string{"doc.md", "--target", "vscode"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	o := opts.processorOptions()
+	if o.AttrStyle != markdown.AttrStyleHTML || slices.Contains(o.AllowedFormats, "image/svg+xml") {
+		t.Errorf("Unexpected vscode options: %+v", o)
+	}
+
+	opts, err = parseArgs([]string{"--target", "GitHub", "--attr-style", "none", "--max-embed-size", "2M", "doc.md"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if o := opts.processorOptions(); o.AttrStyle != markdown.AttrStyleNone || o.MaxEmbedSize != 2<<20 {
+		t.Errorf("Expected explicit flags to win over the github profile, got %+v", o)
+	}
+}
+
+func TestWarnLimits(t *testing.T) {
+	opts, err := parseArgs([]string{"doc.md", "--target", "github"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	result := &markdown.Result{
+		Content: strings.Repeat("x", 6<<20),
+		Images: []markdown.ImageResult{
+			{Reference: markdown.ImageReference{ImagePath: "big.png"}, MIMEType: "image/png", EncodedSize: 3 << 20, Embedded: true},
+			{Reference: markdown.ImageReference{ImagePath: "small.png"}, MIMEType: "image/png", EncodedSize: 1 << 10, Embedded: true},
+			{Reference: markdown.ImageReference{ImagePath: "kept.png"}, EncodedSize: 3 << 20},
+		},
+	}
+
+	var out strings.Builder
+	opts.warnLimits(&out, "doc_embedded.md", result)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[0], "big.png is 4.0 MB, over the github limit of 2.0 MB") ||
+		!strings.Contains(lines[1], "doc_embedded.md is 6.0 MB, over the github limit of 5.0 MB") {
+		t.Errorf("Unexpected warnings:\n%s", out.String())
+	}
+
+	out.Reset()
+	opts.target, opts.profile = "", targetProfile{}
+	opts.warnLimits(&out, "doc_embedded.md", result)
+	if out.Len() != 0 {
+		t.Errorf("Expected no warnings without a target, got %s", out.String())
+	}
+}
+
+func TestConcatDocuments(t *testing.T) {
+	root := t.TempDir()
+	chapterDir := filepath.Join(root, "chapters", "two")
+	if err := os.MkdirAll(chapterDir, 0755); err != nil {
+		t.Fatalf("Failed to create chapter directory: %v", err)
+	}
+	first := filepath.Join(root, "intro.md")
+	second := filepath.Join(chapterDir, "two.md")
+	if err := os.WriteFile(first, []byte("# Intro\n![logo](img/logo.png)"), 0644); err != nil {
+		t.Fatalf("Failed to write intro: %v", err)
+	}
+	secondContent := "# Two\n![logo](../../img/logo.png)\n<img src=\"fig.png\" alt=\"fig\">\n![remote](https://example.com/x.png)\n"
+	if err := os.WriteFile(second, []byte(secondContent), 0644); err != nil {
+		t.Fatalf("Failed to write chapter: %v", err)
+	}
+
+	opts := &cliOptions{}
+	combined, err := opts.concatDocuments([]string{first, second}, root)
+	if err != nil {
+		t.Fatalf("concatDocuments failed: %v", err)
+	}
+	expected := "# Intro\n![logo](img/logo.png)\n\n# Two\n![logo](img/logo.png)\n" +
+		"<img src=\"chapters/two/fig.png\" alt=\"fig\">\n![remote](https://example.com/x.png)\n"
+	if combined != expected {
+		t.Errorf("Unexpected combined document:\n%s\nwant:\n%s", combined, expected)
+	}
+}
+
+func TestConcatDocumentsLineEndings(t *testing.T) {
+	root := t.TempDir()
+	first := filepath.Join(root, "a.md")
+	second := filepath.Join(root, "b.md")
+	if err := os.WriteFile(first, []byte("\uFEFF# A\r\ntext\r\n"), 0644); err != nil {
+		t.Fatalf("Failed to write a.md: %v", err)
+	}
+	if err := os.WriteFile(second, []byte("\uFEFF# B\nmore"), 0644); err != nil {
+		t.Fatalf("Failed to write b.md: %v", err)
+	}
+
+	combined, err := (&cliOptions{}).concatDocuments([]string{first, second}, root)
+	if err != nil {
+		t.Fatalf("concatDocuments failed: %v", err)
+	}
+	if expected := "\uFEFF# A\r\ntext\r\n\r\n# B\r\nmore"; combined != expected {
+		t.Errorf("Got %q, want %q", combined, expected)
+	}
+}
+
+func TestAuditPathsReport(t *testing.T) {
+	root := t.TempDir()
+	doc := filepath.Join(root, "doc.md")
+	content := "![a](./img/a.png)\n\n![a](img/a.png)\n![b](img/b.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write document: %v", err)
+	}
+
+	var out strings.Builder
+	opts := &cliOptions{inputFiles: []string{doc}}
+	found, err := opts.auditPaths(&out)
+	if err != nil {
+		t.Fatalf("auditPaths failed: %v", err)
+	}
+	if found != 1 {
+		t.Errorf("Expected 1 finding, got %d", found)
+	}
+	expected := doc + ": img/a.png is referenced as:\n  line 1: ./img/a.png\n  line 3: img/a.png\n  suggested: img/a.png\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected report:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestWalkMarkdownFiles(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		".gitignore":              "build/\n*.draft.md\n/top.md\n",
+		".mdimagesignore":         "vendor/**/*.md\n!vendor/keep/*.md\n",
+		"README.md":               "",
+		"README_embedded.md":      "",
+		"top.md":                  "",
+		"notes.draft.md":          "",
+		"image.png":               "",
+		"build/out.md":            "",
+		"docs/guide.markdown":     "",
+		"docs/top.md":             "",
+		"docs/.gitignore":         "secret.md\n",
+		"docs/secret.md":          "",
+		"other/secret.md":         "",
+		"vendor/lib/doc.md":       "",
+		"vendor/keep/doc.md":      "",
+		".git/info.md":            "",
+		"drafts/.mdimagesignore":  "*\n",
+		"drafts/wip.md":           "",
+		"docs/sub/notes.draft.md": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	found, err := walkMarkdownFiles(root, nil, "")
+	if err != nil {
+		t.Fatalf("walkMarkdownFiles failed: %v", err)
+	}
+	var got []string
+	for _, f := range found {
+		rel, _ := filepath.Rel(root, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	expected := []string{"README.md", "docs/guide.markdown", "docs/top.md", "other/secret.md", "vendor/keep/doc.md"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("walkMarkdownFiles() = %v; want %v", got, expected)
+	}
+
+	found, err = walkMarkdownFiles(root, []string{"docs/**/*.md", "*.png"}, filepath.Join(root, "other"))
+	if err != nil {
+		t.Fatalf("walkMarkdownFiles failed: %v", err)
+	}
+	got = nil
+	for _, f := range found {
+		rel, _ := filepath.Rel(root, f)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	expected = []string{"docs/top.md", "image.png"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("walkMarkdownFiles() with globs = %v; want %v", got, expected)
+	}
+}
+
+func TestRecursiveSkipsOutputs(t *testing.T) {
+	root := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "doc.md"), []byte("![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, variant := range []string{markdown.VariantEmbedded, markdown.VariantLinked, markdown.VariantEmbedded, markdown.VariantLinked} {
+		opts, err := parseArgs([]string{root, "--recursive", "--variant", variant, "--no-cache"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := opts.expandInputs(); err != nil {
+			t.Fatal(err)
+		}
+		if len(opts.inputFiles) != 1 || filepath.Base(opts.inputFiles[0]) != "doc.md" {
+			t.Fatalf("Expected only doc.md to be walked, got %v", opts.inputFiles)
+		}
+		if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	if want := []string{"a.png", "doc.md", "doc_embedded.md", "doc_linked.md"}; !slices.Equal(names, want) {
+		t.Errorf("Files after walking twice = %v; want %v", names, want)
+	}
+}
+
+func TestPlanArgs(t *testing.T) {
+	got := planArgs([]string{"--plan", "p.json", "doc.md", "--dry-run", "--quality=70", "-plan=x.json", "--debug"})
+	expected := []string{"doc.md", "--quality=70", "--debug"}
+	if strings.Join(got, " ") != strings.Join(expected, " ") {
+		t.Errorf("planArgs() = %v; want %v", got, expected)
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.svg", "b.svg"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(`<svg width="4" height="4"></svg>`), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	content := "![a](a.svg)\n![b](b.svg)\n"
+	processor := markdown.NewProcessor(markdown.Options{})
+	result, err := processor.Process(content, root)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	planFile := filepath.Join(root, "plan.json")
+	p := &plan{Version: planVersion, Args: []string{"doc.md"}}
+	p.add([]string{"doc.md"}, "doc_embedded.md", content, result)
+	p.Documents[0].Images[1].Action = "skip"
+	if err := p.write(planFile); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	reviewed, err := loadPlan(planFile)
+	if err != nil {
+		t.Fatalf("loadPlan failed: %v", err)
+	}
+	if _, err := reviewed.document([]string{"doc.md"}, "doc_embedded.md", content+"changed"); err == nil {
+		t.Errorf("Expected an error for a changed document")
+	}
+	doc, err := reviewed.document([]string{"doc.md"}, "doc_embedded.md", content)
+	if err != nil {
+		t.Fatalf("document failed: %v", err)
+	}
+	applied, err := markdown.NewProcessor(markdown.Options{BeforeEmbed: doc.beforeEmbed}).Process(content, root)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !applied.Images[0].Embedded || applied.Images[1].SkipReason != "skipped by plan" {
+		t.Errorf("Plan not followed: %+v", applied.Images)
+	}
+	if !strings.HasSuffix(applied.Content, "\n![b](b.svg)\n") {
+		t.Errorf("Expected the skipped image to be kept, got %q", applied.Content)
+	}
+	if err := doc.verify(applied); err != nil {
+		t.Errorf("verify failed: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "a.svg"), []byte(`<svg width="8" height="8"></svg>`), 0644); err != nil {
+		t.Fatalf("Failed to rewrite a.svg: %v", err)
+	}
+	changed, err := markdown.NewProcessor(markdown.Options{BeforeEmbed: doc.beforeEmbed}).Process(content, root)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if err := doc.verify(changed); err == nil || !strings.Contains(err.Error(), "a.svg (line 1) changed") {
+		t.Errorf("Expected a changed-source error, got %v", err)
+	}
+}
+
+func TestParseArgsTransforms(t *testing.T) {
+	opts, err := parseArgs([]string{"doc.md", "--transform", "*.png: grayscale", "--transform", "quantize=16"})
+	if err != nil {
+		t.Fatalf("parseArgs failed: %v", err)
+	}
+	if pipelines := opts.processorOptions().Pipelines; len(pipelines) != 2 || pipelines[0].Pattern != "*.png" {
+		t.Errorf("Unexpected pipelines %+v", pipelines)
+	}
+	if _, err := parseArgs([]string{"doc.md", "--transform", "*.png: blur"}); err == nil {
+		t.Errorf("Expected an error for an unknown transform")
+	}
+}
+
+func TestParseArgsCache(t *testing.T) {
+	opts, err := parseArgs([]string{"doc.md", "--cache-dir", "/tmp/c"})
+	if err != nil || opts.processorOptions().CacheDir != "/tmp/c" {
+		t.Errorf("Expected the cache directory to be passed on, got %+v, %v", opts, err)
+	}
+	opts, err = parseArgs([]string{"doc.md", "--cache-dir", "/tmp/c", "--no-cache"})
+	if err != nil || opts.processorOptions().CacheDir != "" {
+		t.Errorf("Expected --no-cache to disable the cache, got %+v, %v", opts, err)
+	}
+}
+
+func TestPartOutputName(t *testing.T) {
+	if got := partOutputName("docs/guide.md", 1, "Getting Started!", "_embedded.md"); got != "docs/guide_02-getting-started_embedded.md" {
+		t.Errorf("Unexpected part name %q", got)
+	}
+	if got := partOutputName("guide.md", 0, "", "_embedded.md"); got != "guide_01_embedded.md" {
+		t.Errorf("Unexpected part name %q", got)
+	}
+}
+
+func TestWriteSharedAssets(t *testing.T) {
+	dir := t.TempDir()
+	logo := `<img src="data:image/png;base64,TE9HTw==">`
+	opts := &cliOptions{pages: []renderedPage{
+		{path: filepath.Join(dir, "a_embedded.html"), content: "<head>\n</head>" + logo},
+		{path: filepath.Join(dir, "sub", "b_embedded.html"), content: "<head>\n</head>" + logo},
+	}}
+	if err := os.Mkdir(filepath.Join(dir, "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.writeSharedAssets(); err != nil {
+		t.Fatal(err)
+	}
+	css, err := os.ReadFile(filepath.Join(dir, "assets.css"))
+	if err != nil || !strings.Contains(string(css), "TE9HTw==") {
+		t.Fatalf("Expected the logo in assets.css, got %q, %v", css, err)
+	}
+	page, _ := os.ReadFile(filepath.Join(dir, "sub", "b_embedded.html"))
+	if !strings.Contains(string(page), `<link rel="stylesheet" href="../assets.css">`) || strings.Contains(string(page), "TE9HTw==") {
+		t.Errorf("Unexpected page %s", page)
+	}
+
+	if _, err := parseArgs([]string{"doc.md", "--shared-assets"}); err == nil {
+		t.Errorf("Expected --shared-assets to require --format html")
+	}
+	parsed, err := parseArgs([]string{"doc.md", "--format", "html"})
+	if err != nil || parsed.attrStyle != attrStyleValue(markdown.AttrStyleHTML) || parsed.outputSuffix() != "_embedded.html" {
+		t.Errorf("Expected HTML output to default to HTML attributes, got %+v, %v", parsed, err)
+	}
+}
+
+func TestRegisterPDFCommand(t *testing.T) {
+	if _, err := exec.LookPath("tail"); err != nil {
+		t.Skip("tail not available")
+	}
+	var page bytes.Buffer
+	if err := png.Encode(&page, image.NewGray(image.Rect(0, 0, 5, 7))); err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	// "tail -c +6" strips the %PDF- header, standing in for a rasterizer.
+	if err := os.WriteFile(filepath.Join(dir, "figure.pdf"), append([]byte("%PDF-"), page.Bytes()...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := registerPDFCommand(context.Background(), "tail -c +6"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(`<embed src="figure.pdf">`, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := result.Images[0]; !img.Embedded || img.MIMEType != "image/png" || img.Width != 5 {
+		t.Errorf("Expected the rendered page as PNG, got %+v", img)
+	}
+	if err := registerPDFCommand(context.Background(), "  "); err == nil {
+		t.Errorf("Expected an error for an empty command")
+	}
+	if _, err := parseArgs([]string{"doc.md", "--pdf-thumbnails", "200"}); err == nil {
+		t.Errorf("Expected --pdf-thumbnails to require --pdf-command")
+	}
+}
+
+func TestPrintMediaSummary(t *testing.T) {
+	var out strings.Builder
+	printMediaSummary(&out, &markdown.Result{Media: []markdown.MediaResult{
+		{Path: "demo.mp4", Line: 3, Size: 12 << 20},
+		{Path: "https://example.com/talk.ogg", Line: 5, Size: -1},
+		{Path: "click.mp3", Line: 1, Size: 900, Embedded: true},
+	}})
+	want := "2 audio/video references were not embedded (12.0 MB of local files; see --embed-media-under):\n" +
+		"  demo.mp4 line 3: 12.0 MB\n  https://example.com/talk.ogg line 5: remote\n"
+	if out.String() != want {
+		t.Errorf("Unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestHTMLOptionsTheme(t *testing.T) {
+	dir := t.TempDir()
+	custom := filepath.Join(dir, "brand.css")
+	if err := os.WriteFile(custom, []byte("body {}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{"doc.md", "--format", "html"})
+	if err != nil || !strings.Contains(opts.htmlOptions(".").CSS, "/* github:") {
+		t.Errorf("Expected the github theme by default, got %+v, %v", opts, err)
+	}
+	opts, err = parseArgs([]string{"doc.md", "--format", "html", "--theme", custom})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := opts.htmlOptions(filepath.Join(dir, "docs")); got.CSS != "" || !slices.Equal(got.Stylesheets, []string{"../brand.css"}) {
+		t.Errorf("Expected the custom theme linked relative to the document, got %+v", got)
+	}
+	if _, err := parseArgs([]string{"doc.md", "--format", "html", "--theme", "solarized"}); err == nil {
+		t.Errorf("Expected an error for an unknown theme")
+	}
+	if _, err := parseArgs([]string{"doc.md", "--theme", "dark"}); err == nil {
+		t.Errorf("Expected --theme to require --format html")
+	}
+	opts, err = parseArgs([]string{"doc.md", "--format", "html", "--theme", "dark"})
+	if err != nil || opts.htmlOptions(".").Highlight != "monokai" {
+		t.Errorf("Expected the dark theme to highlight with monokai, got %+v, %v", opts, err)
+	}
+	opts, err = parseArgs([]string{"doc.md", "--format", "html", "--highlight", "none"})
+	if err != nil || opts.htmlOptions(".").Highlight != "" {
+		t.Errorf("Expected --highlight none to disable highlighting, got %+v, %v", opts, err)
+	}
+	if _, err := parseArgs([]string{"doc.md", "--format", "html", "--highlight", "rainbow"}); err == nil {
+		t.Errorf("Expected an error for an unknown highlight style")
+	}
+}
+
+func TestParseArgsTOC(t *testing.T) {
+	for args, want := range map[string]int{
+		"doc.md --format html":                    0,
+		"doc.md --format html --toc":              defaultTOCDepth,
+		"--toc=2 doc.md --format html":            2,
+		"doc.md --toc --format html --theme none": defaultTOCDepth,
+	} {
+		opts, err := parseArgs(strings.Fields(args))
+		if err != nil || opts.htmlOptions(".").TOCDepth != want {
+			t.Errorf("%s: expected depth %d, got %+v, %v", args, want, opts, err)
+		}
+	}
+	for _, args := range []string{"doc.md --toc", "doc.md --format html --toc=7"} {
+		if _, err := parseArgs(strings.Fields(args)); err == nil {
+			t.Errorf("%s: expected an error", args)
+		}
+	}
+}
+
+func TestParseArgsApplySubcommand(t *testing.T) {
+	opts, err := parseArgs([]string{"apply", "plan.json"})
+	if err != nil || opts.applyFile != "plan.json" {
+		t.Errorf("Expected the apply subcommand to set the plan file, got %+v, %v", opts, err)
+	}
+	if _, err := parseArgs([]string{"apply"}); err == nil {
+		t.Errorf("Expected an error without a plan file")
+	}
+}
+
+func TestUsageStatsOneRun(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var docs []string
+	for _, name := range []string{"one.md", "two.md"} {
+		doc := filepath.Join(dir, name)
+		if err := os.WriteFile(doc, []byte("![a](a.png)\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, doc)
+	}
+	statsPath := filepath.Join(dir, "stats.json")
+	opts, err := parseArgs(append(docs, "--stats-file", statsPath, "--no-cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStatus := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = savedStatus }()
+	if failed := opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions())); failed != 0 {
+		t.Fatalf("Expected both documents to be embedded, %d failed", failed)
+	}
+	opts.recordStats()
+
+	data, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stats usageStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Runs != 1 || stats.DocumentsProcessed != 2 || stats.ImagesEmbedded != 2 {
+		t.Errorf("Expected 1 run of 2 documents and 2 images, got %d runs, %d documents, %d images", stats.Runs, stats.DocumentsProcessed, stats.ImagesEmbedded)
+	}
+}
+
+func TestRecordUsageStats(t *testing.T) {
+	statsPath := filepath.Join(t.TempDir(), "stats.json")
+	result := &markdown.Result{Images: []markdown.ImageResult{
+		{Embedded: true, OriginalSize: 1000, EncodedSize: 400},
+		{Embedded: false, Err: errors.New("not found")},
+	}}
+
+	for i := 0; i < 2; i++ {
+		if err := recordUsageStats(statsPath, result); err != nil {
+			t.Fatalf("recordUsageStats failed: %v", err)
+		}
+	}
+
+	data, err := os.ReadFile(statsPath)
+	if err != nil {
+		t.Fatalf("Failed to read stats file: %v", err)
+	}
+	var stats usageStats
+	if err := json.Unmarshal(data, &stats); err != nil {
+		t.Fatalf("Stats file is not valid JSON: %v", err)
+	}
+
+	if stats.Runs != 2 || stats.DocumentsProcessed != 2 {
+		t.Errorf("Expected 2 runs and 2 documents, got %d and %d", stats.Runs, stats.DocumentsProcessed)
+	}
+	if stats.ImagesEmbedded != 2 || stats.ImagesFailed != 2 {
+		t.Errorf("Expected 2 embedded and 2 failed images, got %d and %d", stats.ImagesEmbedded, stats.ImagesFailed)
+	}
+	if stats.BytesSaved != 1200 {
+		t.Errorf("Expected 1200 bytes saved, got %d", stats.BytesSaved)
+	}
+}
+
+func TestConcatDocumentsHTMLMarksFiles(t *testing.T) {
+	root := t.TempDir()
+	first := filepath.Join(root, "a.md")
+	second := filepath.Join(root, "b.md")
+	if err := os.WriteFile(first, []byte("\uFEFF# A\n[b](b.md)\n"), 0644); err != nil {
+		t.Fatalf("Failed to write a.md: %v", err)
+	}
+	if err := os.WriteFile(second, []byte("# B\n"), 0644); err != nil {
+		t.Fatalf("Failed to write b.md: %v", err)
+	}
+
+	combined, err := (&cliOptions{format: "html"}).concatDocuments([]string{first, second}, root)
+	if err != nil {
+		t.Fatalf("concatDocuments failed: %v", err)
+	}
+	expected := "\uFEFF" + markdown.FileMarker(first) + "\n# A\n[b](b.md)\n\n" + markdown.FileMarker(second) + "\n# B\n"
+	if combined != expected {
+		t.Errorf("Got %q, want %q", combined, expected)
+	}
+	page, err := markdown.RenderHTML(combined, markdown.HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, `<a href="#b">b</a>`) {
+		t.Errorf("Expected the link to b.md to point at its heading:\n%s", page)
+	}
+}
+
+func TestLinkOutputs(t *testing.T) {
+	root := t.TempDir()
+	opts := &cliOptions{inputFiles: []string{filepath.Join(root, "README.md"), filepath.Join(root, "docs", "install.md")}}
+	content := "[install](docs/install.md#linux), [notes](notes.md), [site](https://example.com/docs/install.md) and [top](#top).\n"
+	want := "[install](docs/install_embedded.md#linux), [notes](notes.md), [site](https://example.com/docs/install.md) and [top](#top).\n"
+	if got := opts.linkOutputs(content, opts.inputFiles[0]); got != want {
+		t.Errorf("Got %q, want %q", got, want)
+	}
+
+	opts.format = "html"
+	if got := opts.linkOutputs("[back](../README.md)", opts.inputFiles[1]); got != "[back](../README_embedded.html)" {
+		t.Errorf("Got %q for HTML output", got)
+	}
+	opts.splitLevel = 2
+	if got := opts.linkOutputs("[back](../README.md)", opts.inputFiles[1]); got != "[back](../README.md)" {
+		t.Errorf("Expected links to split documents to be left alone, got %q", got)
+	}
+}
+
+func TestIncrementalBuild(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.md")
+	img := filepath.Join(dir, "a.png")
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(img, pixel.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(doc, []byte("![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc, "--incremental", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := filepath.Join(dir, buildStateFile)
+	run := func() {
+		opts.build = loadBuildState(state, buildOptions(opts.args, opts.configFile))
+		opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), []string{doc})
+		if err := opts.build.write(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+	run()
+	if data, _ := os.ReadFile(output); !strings.Contains(string(data), "data:image/png") {
+		t.Fatalf("Expected the image to be embedded, got %q", data)
+	}
+
+	if err := os.WriteFile(output, []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	if data, _ := os.ReadFile(output); string(data) != "stale" {
+		t.Errorf("Expected an unchanged document to be skipped")
+	}
+
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(img, later, later); err != nil {
+		t.Fatal(err)
+	}
+	run()
+	if data, _ := os.ReadFile(output); string(data) == "stale" {
+		t.Errorf("Expected a changed image to rebuild the document")
+	}
+
+	if loadBuildState(state, "other options").Documents[output] != nil {
+		t.Errorf("Expected state from other options to be discarded")
+	}
+	if _, err := parseArgs([]string{doc, "--incremental", "--dry-run"}); err == nil {
+		t.Errorf("Expected --incremental to reject --dry-run")
+	}
+}
+
+func TestWriteDepfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc_embedded.md.d")
+	err := writeDepfile(path, []string{"doc_01_embedded.md", "doc_02_embedded.md"},
+		[]string{"doc.md", "img/my logo.png", "doc.md", "price$#1.png"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	want := "doc_01_embedded.md doc_02_embedded.md: \\\n  doc.md \\\n  img/my\\ logo.png \\\n  price$$\\#1.png\n"
+	if string(data) != want {
+		t.Errorf("Got %q, want %q", data, want)
+	}
+}
+
+func TestStampOutput(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\r\nNo images."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc, "--stamp", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), []string{doc})
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := readStamp(output)
+	if want := "# Doc\r\nNo images.\r\n" + stampPrefix + hash + " -->\r\n"; len(hash) != 64 || string(data) != want {
+		t.Fatalf("Got %q, want %q", data, want)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(output, old, old); err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), []string{doc})
+	if info, _ := os.Stat(output); !info.ModTime().Equal(old) {
+		t.Errorf("Expected an output with a current stamp not to be rewritten")
+	}
+
+	if err := os.WriteFile(doc, []byte("# Doc\nChanged."), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), []string{doc})
+	if readStamp(output) == hash {
+		t.Errorf("Expected a changed input to change the stamp")
+	}
+
+	// The same failure, worded differently from run to run, hashes the same.
+	ref := markdown.ImageReference{ImagePath: "https://example.com/a.png"}
+	failed := func(err error) *markdown.Result {
+		return &markdown.Result{Images: []markdown.ImageResult{{Reference: ref, Err: err}}}
+	}
+	if opts.inputHash("doc", failed(errors.New("timed out after 30.1s"))) != opts.inputHash("doc", failed(errors.New("timed out after 30.4s"))) {
+		t.Errorf("Expected error messages to be left out of the stamp")
+	}
+	if opts.inputHash("doc", failed(errors.New("timed out"))) == opts.inputHash("doc", &markdown.Result{Images: []markdown.ImageResult{{Reference: ref, Embedded: true, SourceSHA256: "ab"}}}) {
+		t.Errorf("Expected an embedded image to change the stamp")
+	}
+}
+
+func TestInterruptedEmbed(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.md")
+	content := "# Doc\n![logo](logo.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	opts, err := parseArgs([]string{doc, "--no-cache", "--stamp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(ctx, markdown.NewProcessor(opts.processorOptions()), []string{doc})
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected no output without --allow-partial, got %v", err)
+	}
+
+	if opts, err = parseArgs([]string{doc, "--no-cache", "--stamp", "--allow-partial"}); err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(ctx, markdown.NewProcessor(opts.processorOptions()), []string{doc})
+	data, err := os.ReadFile(output)
+	if err != nil || string(data) != content {
+		t.Errorf("Expected the unprocessed document without a stamp, got %q (%v)", data, err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left, got %v", entries)
+	}
+}
+
+func TestDocumentStats(t *testing.T) {
+	dir := t.TempDir()
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), pixel.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	first := filepath.Join(dir, "first.md")
+	second := filepath.Join(dir, "second.md")
+	if err := os.WriteFile(first, []byte("# Title\n\nSome words here.\n\n![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(second, []byte("## Other\n\n![missing](missing.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"stats", first, second, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.docStats || len(opts.inputFiles) != 2 {
+		t.Fatalf("Expected the stats subcommand with two files, got %+v", opts)
+	}
+	var out strings.Builder
+	if err := opts.documentStats(context.Background(), markdown.NewProcessor(opts.processorOptions()), &out); err != nil {
+		t.Fatalf("documentStats failed: %v", err)
+	}
+	report := out.String()
+	for _, want := range []string{
+		first + "\n  words:           4\n  headings:        1\n  images:          1 (1 local, 0 remote, 0 failed)\n    image/png      1\n",
+		second + "\n  words:           1\n  headings:        1\n  images:          1 (1 local, 0 remote, 1 failed)\n    unknown        1\n",
+		"Total\n  words:           5\n  headings:        2\n  images:          2 (2 local, 0 remote, 1 failed)\n",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("Expected the report to contain:\n%s\ngot:\n%s", want, report)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "first_embedded.md")); !os.IsNotExist(err) {
+		t.Errorf("Expected stats not to write any output, got %v", err)
+	}
+}
+
+func TestOutputFlag(t *testing.T) {
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "build", "doc.md")
+	opts, err := parseArgs([]string{doc, "-o", output, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles)
+	if data, err := os.ReadFile(output); err != nil || string(data) != "# Doc\n" {
+		t.Errorf("Expected the output in %s, got %q, %v", output, data, err)
+	}
+
+	// References left in an output in another directory still resolve.
+	content := "# Doc\n![gone](img/gone.png) [setup](setup.md#install) [up](#doc)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles)
+	if data, _ := os.ReadFile(output); string(data) != "# Doc\n![gone](../img/gone.png) [setup](../setup.md#install) [up](#doc)\n" {
+		t.Errorf("Expected the references rebased on %s, got %q", output, data)
+	}
+	sibling := filepath.Join(dir, "copy.md")
+	if opts, err = parseArgs([]string{doc, "-o", sibling, "--no-cache"}); err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles)
+	if data, _ := os.ReadFile(sibling); string(data) != content {
+		t.Errorf("Expected an output beside the input to keep the references, got %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc_embedded.md")); !os.IsNotExist(err) {
+		t.Errorf("Expected no _embedded.md output with --output, got %v", err)
+	}
+
+	if err := checkNotInput(output, []string{doc}); err != nil {
+		t.Errorf("Expected a separate output to be allowed, got %v", err)
+	}
+	if err := checkNotInput(filepath.Join(dir, ".", "doc.md"), []string{doc}); err == nil {
+		t.Errorf("Expected an error when the output is the input")
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	root := t.TempDir()
+	docs := filepath.Join(root, "docs")
+	out := filepath.Join(root, "out")
+	files := map[string]string{
+		"docs/index.md":            "[Setup](guide/setup.md)\n",
+		"docs/guide/setup.md":      "# Setup\n",
+		"docs/guide/notes.txt":     "",
+		"docs/drafts/wip.markdown": "# Draft\n",
+		"out/stale/from-before.md": "",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseArgs([]string{docs, "--recursive", "--glob", "**/*.md", "--output-dir", out, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.checkMirroredOutputs(); err != nil {
+		t.Fatal(err)
+	}
+	if failed := opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions())); failed != 0 {
+		t.Fatalf("Expected every file to be processed, %d failed", failed)
+	}
+	for name, want := range map[string]string{"index.md": "[Setup](guide/setup.md)\n", "guide/setup.md": "# Setup\n"} {
+		if data, err := os.ReadFile(filepath.Join(out, filepath.FromSlash(name))); err != nil || string(data) != want {
+			t.Errorf("Expected %q in out/%s, got %q, %v", want, name, data, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(out, "drafts")); !os.IsNotExist(err) {
+		t.Errorf("Expected files not matching --glob to be skipped, got %v", err)
+	}
+
+	// The output directory is never walked, even inside the input tree.
+	opts, err = parseArgs([]string{root, "--recursive", "--output-dir", out, "--format", "html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range opts.inputFiles {
+		if strings.HasPrefix(file, out) {
+			t.Errorf("Expected %s not to be walked", file)
+		}
+	}
+	if got, want := opts.mirroredOutput(filepath.Join(docs, "guide", "setup.md")), filepath.Join(out, "docs", "guide", "setup.md"); got != want {
+		t.Errorf("mirroredOutput() = %s; want %s", got, want)
+	}
+	if got := opts.outputExt("setup.md"); got != ".html" {
+		t.Errorf("outputExt() = %s; want .html", got)
+	}
+
+	// Two inputs with the same relative path can't share an output.
+	opts, err = parseArgs([]string{filepath.Join(docs, "index.md"), filepath.Join(root, "index.md"), "--output-dir", out})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.checkMirroredOutputs(); err == nil {
+		t.Errorf("Expected an error for inputs with the same output")
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	root := t.TempDir()
+	config := `# Project defaults
+quality: 70
+image-timeout: 30s
+date-format: 2006-01-02
+cache-dir: .cache/images
+exclude:
+  - https://img.shields.io/*
+  - "*.gif"
+var:
+  version: 1.2.0
+`
+	if err := os.WriteFile(filepath.Join(root, ".markdown-images.yaml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "docs", "guide", "doc.md")
+	if err := os.MkdirAll(filepath.Dir(doc), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(doc, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.configFile != filepath.Join(root, ".markdown-images.yaml") {
+		t.Errorf("Expected the config file above the input to be found, got %q", opts.configFile)
+	}
+	if opts.quality != 70 || opts.imageTimeout != 30*time.Second || opts.dateFormat != "2006-01-02" {
+		t.Errorf("Expected the config values, got quality %d, timeout %v, date format %q", opts.quality, opts.imageTimeout, opts.dateFormat)
+	}
+	if want := filepath.Join(root, ".cache", "images"); opts.cacheDir != want {
+		t.Errorf("Expected cache-dir relative to the config file, got %q; want %q", opts.cacheDir, want)
+	}
+	if len(opts.exclude) != 2 || opts.exclude[1] != "*.gif" || opts.vars["version"] != "1.2.0" {
+		t.Errorf("Expected the lists and mappings of the config, got %v and %v", opts.exclude, opts.vars)
+	}
+
+	// Flags override the environment, which overrides the config file.
+	t.Setenv("MDIMAGES_QUALITY", "80")
+	if opts, err = parseArgs([]string{doc}); err != nil || opts.quality != 80 {
+		t.Errorf("Expected the environment to override the config, got %d, %v", opts.quality, err)
+	}
+	if opts, err = parseArgs([]string{doc, "--quality", "90"}); err != nil || opts.quality != 90 {
+		t.Errorf("Expected the flag to override the config, got %d, %v", opts.quality, err)
+	}
+	t.Setenv("MDIMAGES_QUALITY", "")
+	os.Unsetenv("MDIMAGES_QUALITY")
+	if opts, err = parseArgs([]string{doc, "--config", "none"}); err != nil || opts.quality != markdown.DefaultQuality || opts.configFile != "" {
+		t.Errorf("Expected --config none to skip the config file, got %d, %v", opts.quality, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(root, "docs", ".markdown-images.yml"), []byte("qualty: 70\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseArgs([]string{doc}); err == nil || !strings.Contains(err.Error(), `unknown setting "qualty"`) {
+		t.Errorf("Expected the nearest config file to be read and rejected, got %v", err)
+	}
+}
+
+func TestDiscoveredConfigCannotRunCommands(t *testing.T) {
+	root := t.TempDir()
+	doc := filepath.Join(root, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config := filepath.Join(root, ".markdown-images.yaml")
+	for _, setting := range []string{"pdf-command: touch pwned", "report: /tmp/report.json", "junit: junit.xml", "stats-file: stats.json", "profile: p\nprofiles:\n  p:\n    pdf-command: touch pwned"} {
+		if err := os.WriteFile(config, []byte(setting+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseArgs([]string{doc}); err == nil || !strings.Contains(err.Error(), "--config") {
+			t.Errorf("Expected a discovered config setting %q to be rejected, got %v", setting, err)
+		}
+		opts, err := parseArgs([]string{doc, "--config", config})
+		if err != nil {
+			t.Errorf("Expected an explicit config to set %q, got %v", setting, err)
+		} else if opts.pdfCommand == "" && opts.reportFile == "" && opts.junitFile == "" && opts.statsFile == "" {
+			t.Errorf("Expected an explicit config to set %q", setting)
+		}
+	}
+}
+
+func TestConfigProfiles(t *testing.T) {
+	root := t.TempDir()
+	config := `quality: 70
+max-width: 1000
+profile: preview
+profiles:
+  preview:
+    max-width: 600
+  publish:
+    format: html
+    quality: 90
+    max-embed-size: 2M
+  email:
+    target: confluence
+    max-embed-size: 100K
+`
+	if err := os.WriteFile(filepath.Join(root, ".markdown-images.yaml"), []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "doc.md")
+
+	opts, err := parseArgs([]string{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.profileName != "preview" || opts.maxWidth != 600 || opts.quality != 70 {
+		t.Errorf("Expected the file's default profile over its top level, got %q, max width %d, quality %d", opts.profileName, opts.maxWidth, opts.quality)
+	}
+	if opts, err = parseArgs([]string{doc, "--profile", "publish", "--quality", "95"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.format != "html" || opts.quality != 95 || opts.maxEmbedSize != 2<<20 || opts.maxWidth != 1000 {
+		t.Errorf("Expected the publish profile under the flags, got format %s, quality %d, max embed size %d, max width %d", opts.format, opts.quality, opts.maxEmbedSize, opts.maxWidth)
+	}
+	if opts, err = parseArgs([]string{doc, "--profile", "email"}); err != nil || opts.target != "confluence" || opts.maxEmbedSize != 100<<10 {
+		t.Errorf("Expected the email profile's target and size limit, got %v", err)
+	}
+	if _, err := parseArgs([]string{doc, "--profile", "print"}); err == nil || !strings.Contains(err.Error(), "expected one of: preview, publish, email") {
+		t.Errorf("Expected an unknown profile to list the profiles, got %v", err)
+	}
+	if _, err := parseArgs([]string{doc, "--config", "none", "--profile", "publish"}); err == nil {
+		t.Errorf("Expected --profile without a config file to fail")
+	}
+}
+
+func TestImageIndex(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "guide"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"intro.md":       "# Intro\n\n![logo](img/logo.png)\n",
+		"guide/setup.md": "![logo](../img/logo.png)\n<img src=\"shot.png\">\n![remote](https://example.com/a.png)\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	opts, err := parseArgs([]string{"index", root, "--index-format", "csv"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.index || !opts.recursive {
+		t.Fatalf("Expected the index subcommand to walk directories, got %+v", opts)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	index, err := opts.imageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := func(name string) string {
+		path, err := filepath.Rel(wd, filepath.Join(root, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(path)
+	}
+	setup := filepath.ToSlash(filepath.Join(root, "guide", "setup.md"))
+	intro := filepath.ToSlash(filepath.Join(root, "intro.md"))
+
+	var out strings.Builder
+	if err := writeImageIndex(&out, index, opts.indexFormat); err != nil {
+		t.Fatal(err)
+	}
+	expected := "image,document,line,path\n" +
+		rel("guide/shot.png") + "," + setup + ",2,shot.png\n" +
+		rel("img/logo.png") + "," + setup + ",1,../img/logo.png\n" +
+		rel("img/logo.png") + "," + intro + ",3,img/logo.png\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected CSV index:\n%s\nwant:\n%s", out.String(), expected)
+	}
+
+	out.Reset()
+	if err := writeImageIndex(&out, index, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string][]imageUse
+	if err := json.Unmarshal([]byte(out.String()), &decoded); err != nil {
+		t.Fatalf("Invalid JSON index: %v", err)
+	}
+	if uses := decoded[rel("img/logo.png")]; len(uses) != 2 || uses[1] != (imageUse{Document: intro, Line: 3, Path: "img/logo.png"}) {
+		t.Errorf("Unexpected JSON entry for the logo: %+v", uses)
+	}
+
+	if _, err := parseArgs([]string{"index", root, "--index-format", "xml"}); err == nil {
+		t.Errorf("Expected an error for an unknown --index-format")
+	}
+}
+
+func TestMoveImage(t *testing.T) {
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "guide"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"intro.md":       "![logo](img/logo.png)\n",
+		"guide/setup.md": "<img src=\"../img/logo.png\">\n![other](shot.png)\n",
+		"img/logo.png":   "png",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(root, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	from := filepath.Join(root, "img", "logo.png")
+	to := filepath.Join(root, "assets", "brand.png")
+
+	opts, err := parseArgs([]string{"mv", from, to, root, "--dry-run"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.moveFrom != from || opts.moveTo != to || !opts.recursive {
+		t.Fatalf("Unexpected mv options %+v", opts)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.moveImage(); err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if _, err := os.Stat(from); err != nil {
+		t.Errorf("Expected --dry-run to leave the image in place, got %v", err)
+	}
+
+	opts.dryRun = false
+	if err := opts.moveImage(); err != nil {
+		t.Fatalf("moveImage failed: %v", err)
+	}
+	if _, err := os.Stat(to); err != nil {
+		t.Errorf("Expected the image at %s, got %v", to, err)
+	}
+	for name, want := range map[string]string{
+		"intro.md":       "![logo](assets/brand.png)\n",
+		"guide/setup.md": "<img src=\"../assets/brand.png\">\n![other](shot.png)\n",
+	} {
+		if got, _ := os.ReadFile(filepath.Join(root, name)); string(got) != want {
+			t.Errorf("%s = %q; want %q", name, got, want)
+		}
+	}
+
+	if err := opts.moveImage(); err == nil {
+		t.Errorf("Expected an error moving a missing image")
+	}
+	opts.moveFrom, opts.moveTo = to, filepath.Join(root, "intro.md")
+	if err := opts.moveImage(); err == nil {
+		t.Errorf("Expected an error overwriting an existing file")
+	}
+	if _, err := parseArgs([]string{"mv", from}); err == nil {
+		t.Errorf("Expected an error without a destination")
+	}
+}
+
+func TestStdinPipe(t *testing.T) {
+	dir := t.TempDir()
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), pixel.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	in := filepath.Join(dir, "in.md")
+	if err := os.WriteFile(in, []byte("![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	stdout, err := os.Create(filepath.Join(dir, "out.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	savedStdin, savedStdout, savedStatus := os.Stdin, os.Stdout, statusOutput
+	os.Stdin, os.Stdout, statusOutput = stdin, stdout, io.Discard
+	defer func() { os.Stdin, os.Stdout, statusOutput = savedStdin, savedStdout, savedStatus }()
+
+	opts, err := parseArgs([]string{"-", "--base-dir", dir, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles)
+	output, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(output), "![a](data:image/png;base64,") {
+		t.Errorf("Expected the embedded document on standard output, got %q", output)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "-")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file named -, got %v", err)
+	}
+
+	for _, args := range [][]string{{"-", "doc.md", "--concat"}, {"-", "--split-by-heading", "2"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestInPlace(t *testing.T) {
+	dir := t.TempDir()
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), pixel.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("![a](a.png)\n"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{doc, "--in-place", "--backup", ".bak", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles)
+	if data, err := os.ReadFile(doc); err != nil || !strings.HasPrefix(string(data), "![a](data:image/png;base64,") {
+		t.Errorf("Expected the document to be embedded in place, got %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(doc + ".bak"); err != nil || string(data) != "![a](a.png)\n" {
+		t.Errorf("Expected the original in the backup, got %q, %v", data, err)
+	}
+	if info, err := os.Stat(doc + ".bak"); err != nil || info.Mode().Perm() != 0640 {
+		t.Errorf("Expected the backup to keep the permissions, got %v, %v", info, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc_embedded.md")); !os.IsNotExist(err) {
+		t.Errorf("Expected no _embedded.md output with --in-place, got %v", err)
+	}
+
+	for _, args := range [][]string{
+		{doc, "--backup", ".bak"},
+		{doc, "--in-place", "-o", "out.md"},
+		{doc, "--in-place", "--format", "html"},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestMirrorImages(t *testing.T) {
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/img/logo.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(pixel.Bytes())
+	}))
+	defer server.Close()
+	logo, missing := server.URL+"/img/logo.png", server.URL+"/missing.png"
+
+	root := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(root, "guide"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	intro := filepath.Join(root, "intro.md")
+	setup := filepath.Join(root, "guide", "setup.md")
+	if err := os.WriteFile(intro, []byte("![logo]("+logo+")\n![gone]("+missing+")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(setup, []byte("<img src=\""+logo+"\">\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	assets := filepath.Join(root, "assets")
+	opts, err := parseArgs([]string{"mirror", root, "--assets-dir", assets})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	processor := markdown.NewProcessor(opts.processorOptions())
+	if err := opts.mirrorImages(context.Background(), processor); err != nil {
+		t.Fatalf("mirrorImages failed: %v", err)
+	}
+
+	mapping, err := loadMirrorMap(filepath.Join(assets, defaultMirrorMap))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := markdown.MirrorName(logo, pixel.Bytes())
+	if len(mapping) != 1 || mapping[logo] != name {
+		t.Fatalf("Unexpected mapping %v", mapping)
+	}
+	if data, err := os.ReadFile(filepath.Join(assets, name)); err != nil || !bytes.Equal(data, pixel.Bytes()) {
+		t.Errorf("Expected the downloaded image in %s, got %v", assets, err)
+	}
+	for file, want := range map[string]string{
+		intro: "![logo](assets/" + name + ")\n![gone](" + missing + ")\n",
+		setup: "<img src=\"../assets/" + name + "\">\n",
+	} {
+		if got, _ := os.ReadFile(file); string(got) != want {
+			t.Errorf("%s = %q; want %q", file, got, want)
+		}
+	}
+
+	// A later run re-syncs the recorded copy in place.
+	if err := os.WriteFile(filepath.Join(assets, name), []byte("stale"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.mirrorImages(context.Background(), processor); err != nil {
+		t.Fatalf("Second mirrorImages failed: %v", err)
+	}
+	if data, err := os.ReadFile(filepath.Join(assets, name)); err != nil || !bytes.Equal(data, pixel.Bytes()) {
+		t.Errorf("Expected the copy to be refreshed, got %q, %v", data, err)
+	}
+}
+
+func TestEmbedEach(t *testing.T) {
+	dir := t.TempDir()
+	a, missing, c := filepath.Join(dir, "a.md"), filepath.Join(dir, "missing.md"), filepath.Join(dir, "c.md")
+	for _, file := range []string{a, c} {
+		if err := os.WriteFile(file, []byte("# Doc\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	opts, err := parseArgs([]string{a, missing, c, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var summary strings.Builder
+	savedStatus := statusOutput
+	statusOutput = &summary
+	defer func() { statusOutput = savedStatus }()
+
+	if failed := opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions())); failed != 1 {
+		t.Errorf("Expected 1 failure, got %d", failed)
+	}
+	for _, output := range []string{"a_embedded.md", "c_embedded.md"} {
+		if _, err := os.Stat(filepath.Join(dir, output)); err != nil {
+			t.Errorf("Expected %s despite the failure, got %v", output, err)
+		}
+	}
+	for _, want := range []string{
+		"Processed 3 files: 2 succeeded, 1 failed\n",
+		"  ok      " + a + "\n",
+		"  failed  " + missing + ": reading file: ",
+		"  ok      " + c + "\n",
+	} {
+		if !strings.Contains(summary.String(), want) {
+			t.Errorf("Expected the summary to contain %q, got:\n%s", want, summary.String())
+		}
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ok.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, missing := filepath.Join(dir, "doc.md"), filepath.Join(dir, "missing.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n\n![ok](ok.png)\n\n![broken](gone.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.xml")
+	opts, err := parseArgs([]string{doc, missing, "--junit", report, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.junit = &junitReport{}
+	savedStatus := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = savedStatus }()
+	opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions()))
+	if err := opts.junit.write(report, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got junitReport
+	if err := xml.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Invalid XML: %v\n%s", err, data)
+	}
+	if got.Tests != 3 || got.Failures != 1 || got.Errors != 1 || got.Time != "1.000" || len(got.Suites) != 2 {
+		t.Fatalf("Unexpected totals in:\n%s", data)
+	}
+	suite := got.Suites[0]
+	if suite.Name != doc || suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("Unexpected suite for %s: %+v", doc, suite)
+	}
+	if c := suite.Cases[0]; c.Name != "line 3: ok.png" || c.Failure != nil {
+		t.Errorf("Expected the embedded image to pass, got %+v", c)
+	}
+	if c := suite.Cases[1]; c.Name != "line 5: gone.png" || c.Failure == nil || c.Failure.Type != "file-not-found" {
+		t.Errorf("Expected the missing image to fail, got %+v", c)
+	}
+	if suite := got.Suites[1]; suite.Name != missing || suite.Errors != 1 || suite.Cases[0].Error == nil {
+		t.Errorf("Expected an error for the unreadable document, got %+v", suite)
+	}
+}
+
+func TestRunReport(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ok.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc, missing := filepath.Join(dir, "doc.md"), filepath.Join(dir, "missing.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n\n![ok](ok.png)\n\n![broken](gone.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report := filepath.Join(dir, "report.json")
+	opts, err := parseArgs([]string{doc, missing, "--report", report, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.report = &runReport{}
+	savedStatus := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = savedStatus }()
+	opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions()))
+	if err := opts.report.write(report, time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got runReport
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatalf("Invalid JSON: %v\n%s", err, data)
+	}
+	if got.Version != reportVersion || got.Duration != 1 || len(got.Documents) != 2 {
+		t.Fatalf("Unexpected report:\n%s", data)
+	}
+	d := got.Documents[0]
+	if len(d.Files) != 1 || d.Files[0] != doc || d.Output != filepath.Join(dir, "doc_embedded.md") || len(d.Images) != 2 {
+		t.Fatalf("Unexpected document %s in:\n%s", doc, data)
+	}
+	want := reportImage{Reference: "ok.png", Line: 3, Source: filepath.Join(dir, "ok.png"), MIMEType: "image/png",
+		OriginalBytes: buf.Len(), EncodedBytes: d.Images[0].EncodedBytes, Embedded: true}
+	if d.Images[0] != want || want.EncodedBytes == 0 {
+		t.Errorf("Expected %+v, got %+v", want, d.Images[0])
+	}
+	if img := d.Images[1]; img.Reference != "gone.png" || img.Line != 5 || img.Embedded || img.Code != "MI1001" || img.Error == "" {
+		t.Errorf("Expected the missing image to fail, got %+v", img)
+	}
+	if d := got.Documents[1]; d.Files[0] != missing || d.Error == "" || len(d.Images) != 0 {
+		t.Errorf("Expected an error for the unreadable document, got %+v", d)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	img, doc, output := filepath.Join(dir, "a.png"), filepath.Join(dir, "doc.md"), filepath.Join(dir, "doc_embedded.md")
+	writePNG := func(size int) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(img, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePNG(2)
+	if err := os.WriteFile(doc, []byte("![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc, "--watch", "--watch-images", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedStatus := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = savedStatus }()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- opts.watchInputs(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("watchInputs() = %v", err)
+		}
+	}()
+
+	// waitFor keeps changing the inputs until the output satisfies ok, since
+	// changes made before the watch starts are missed.
+	waitFor := func(change func(), ok func(string) bool) string {
+		t.Helper()
+		var data []byte
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); {
+			if data, _ = os.ReadFile(output); ok(string(data)) {
+				return string(data)
+			}
+			change()
+			time.Sleep(3 * watchDelay)
+		}
+		t.Fatalf("Timed out waiting for the output, last got %q", data)
+		return ""
+	}
+	first := waitFor(func() {}, func(s string) bool { return strings.Contains(s, "data:image/png") })
+	waitFor(func() { writePNG(3) }, func(s string) bool { return s != first })
+	waitFor(func() {
+		if err := os.WriteFile(doc, []byte("# Title\n\n![a](a.png)\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}, func(s string) bool { return strings.HasPrefix(s, "# Title\n") })
+
+	if _, err := parseArgs([]string{doc, "--watch", "--in-place"}); err == nil {
+		t.Errorf("Expected --watch with --in-place to be refused")
+	}
+}
+
+func TestFallbackPlaceholderRefresh(t *testing.T) {
+	// The image server is down while embedding and back up for the refresh,
+	// at the same address.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	url := "http://" + addr + "/intranet/chart.png"
+
+	dir := t.TempDir()
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("![chart]("+url+")\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc, "--fallback-placeholder", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.recordSources {
+		t.Errorf("Expected --fallback-placeholder to record sources")
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+	if data, _ := os.ReadFile(output); !strings.Contains(string(data), "data:image/svg+xml;base64,") {
+		t.Fatalf("Expected a placeholder in the output, got %q", data)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if l, err = net.Listen("tcp", addr); err != nil {
+		t.Skipf("Could not listen on %s again: %v", addr, err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	server.Listener.Close()
+	server.Listener = l
+	server.Start()
+	defer server.Close()
+
+	refresh, err := parseArgs([]string{"refresh", output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := refresh.refreshOutputs(context.Background()); failed != 0 {
+		t.Fatalf("Expected no failures, got %d", failed)
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "image/svg+xml") || !strings.Contains(string(data), "data:image/png;base64,") {
+		t.Errorf("Expected the refresh to restore the image, got %q", data)
+	}
+}
+
+func TestRefreshOutputs(t *testing.T) {
+	dir := t.TempDir()
+	img := filepath.Join(dir, "a.png")
+	writePNG := func(size int) {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(img, buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writePNG(2)
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("![a](a.png)\n\nText.\n\n![again](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc, "--record-sources", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+	before, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	refresh, err := parseArgs([]string{"refresh", output})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := refresh.refreshOutputs(context.Background()); failed != 0 {
+		t.Fatalf("Expected no failures, got %d", failed)
+	}
+	if after, _ := os.ReadFile(output); !bytes.Equal(after, before) {
+		t.Errorf("Expected an unchanged source to leave the output alone")
+	}
+
+	writePNG(3)
+	if failed := refresh.refreshOutputs(context.Background()); failed != 0 {
+		t.Fatalf("Expected no failures, got %d", failed)
+	}
+	after, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uris := regexp.MustCompile(`data:image/png;base64,[A-Za-z0-9+/=]+`).FindAllString(string(after), -1)
+	if len(uris) != 2 || uris[0] != uris[1] || strings.Contains(string(before), uris[0]) {
+		t.Errorf("Expected both references to get the new image, got %q", after)
+	}
+	if !strings.Contains(string(after), "\n\nText.\n\n") {
+		t.Errorf("Expected the text to be kept, got %q", after)
+	}
+	record, err := loadSources(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range record.Images {
+		if img.DataSHA256 != contentHash(uris[0]) {
+			t.Errorf("Expected the record to follow the refreshed image, got %+v", img)
+		}
+	}
+
+	if failed := refresh.refreshOutputs(context.Background()); failed != 0 {
+		t.Errorf("Expected a second refresh to find nothing to do, got %d failures", failed)
+	}
+	if err := os.Remove(output + sourcesSuffix); err != nil {
+		t.Fatal(err)
+	}
+	if failed := refresh.refreshOutputs(context.Background()); failed != 1 {
+		t.Errorf("Expected a failure without recorded sources, got %d", failed)
+	}
+}
+
+func TestImageAges(t *testing.T) {
+	now := time.Date(2026, 6, 1, 12, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/old.png" {
+			w.Header().Set("Last-Modified", now.AddDate(0, 0, -300).Format(http.TimeFormat))
+		}
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	for name, changed := range map[string]time.Time{"old.png": now.AddDate(-2, 0, 0), "new.png": now.AddDate(0, 0, -3)} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte("png"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, changed, changed); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := filepath.Join(dir, "doc.md")
+	content := "![new](new.png) ![old](old.png) ![remote](" + server.URL + "/old.png) ![plain](" + server.URL + "/plain.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"age", dir, "--older-than", "180d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.age || !opts.recursive || time.Duration(opts.olderThan) != 180*day {
+		t.Fatalf("Unexpected age options %+v", opts)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	ages, err := opts.imageAges(context.Background(), server.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if stale := writeImageAges(&out, ages, now, time.Duration(opts.olderThan)); stale != 2 {
+		t.Errorf("Expected 2 stale images, got %d", stale)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel := func(name string) string {
+		path, err := filepath.Rel(wd, filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return filepath.ToSlash(path)
+	}
+	expected := rel("old.png") + ": 2024-06-01, 730 days ago (mtime) STALE\n" +
+		server.URL + "/old.png: 2025-08-05, 300 days ago (last-modified) STALE\n" +
+		rel("new.png") + ": 2026-05-29, 3 days ago (mtime)\n" +
+		server.URL + "/plain.png: unknown age\n" +
+		"2 of 4 images are older than 180d\n"
+	if out.String() != expected {
+		t.Errorf("Unexpected report:\n%s\nwant:\n%s", out.String(), expected)
+	}
+}
+
+func TestParseArgsImageTemplate(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "figure.tmpl")
+	if err := os.WriteFile(file, []byte("<figure>{{.Markup}}</figure>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{"doc.md", "--image-template", "@" + file})
+	if err != nil || opts.processorOptions().ImageTemplate == nil {
+		t.Fatalf("Expected the template file to be loaded, got %v", err)
+	}
+	if _, err := parseArgs([]string{"doc.md", "--image-template", "{{.Src"}); err == nil {
+		t.Errorf("Expected an error for a malformed template")
+	}
+	if _, err := parseArgs([]string{"doc.md", "--image-template", "@" + file + ".missing"}); err == nil {
+		t.Errorf("Expected an error for a missing template file")
+	}
+}
+
+func TestParseArgsNumberFigures(t *testing.T) {
+	for args, want := range map[string]string{
+		"":                       "",
+		"--number-figures":       "Figure",
+		"--number-figures=Fig.":  "Fig.",
+		"--number-figures=false": "",
+		"--number-figures=Abb. ": "Abb.",
+	} {
+		argv := []string{"doc.md"}
+		if args != "" {
+			argv = append(argv, args)
+		}
+		opts, err := parseArgs(argv)
+		if err != nil {
+			t.Fatalf("%s: %v", args, err)
+		}
+		if got := opts.processorOptions().FigureLabel; got != want {
+			t.Errorf("%s: got label %q, want %q", args, got, want)
+		}
+	}
+}
+
+func TestVariables(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	opts, err := parseArgs([]string{"doc.md", "--var", "version=2.0", "--var", "git-sha=abc1234", "--date-format", "January 2, 2006"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.substitute {
+		t.Errorf("Expected --var to imply --substitute")
+	}
+	vars := opts.variables(t.TempDir())
+	if vars["date"] != "November 14, 2023" || vars["version"] != "2.0" || vars["git-sha"] != "abc1234" {
+		t.Errorf("Unexpected variables %v", vars)
+	}
+	if _, err := parseArgs([]string{"doc.md", "--var", "Version"}); err == nil {
+		t.Errorf("Expected an error for a --var without a value")
+	}
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "doc_embedded.md")
+	if err := os.WriteFile(output, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "link.md")
+	if err := os.Symlink(output, link); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(link, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(output); string(data) != "new" {
+		t.Errorf("Expected the symlink target to be rewritten, got %q", data)
+	}
+	if info, _ := os.Lstat(link); info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("Expected the symlink to be kept")
+	}
+	if info, _ := os.Stat(output); info.Mode().Perm() != 0600 {
+		t.Errorf("Expected the permissions to be kept, got %v", info.Mode())
+	}
+
+	// A failed write leaves the previous output and no temporary file.
+	if err := writeFileAtomic(filepath.Join(dir, "missing", "out.md"), []byte("x")); err == nil {
+		t.Errorf("Expected an error writing into a missing directory")
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 2 {
+		t.Errorf("Expected no temporary files to be left, got %v", entries)
+	}
+
+	// A new file gets 0666 less the umask.
+	defer func(mask fs.FileMode) { umask = mask }(umask)
+	umask = 027
+	created := filepath.Join(t.TempDir(), "new.md")
+	if err := writeFileAtomic(created, []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(created); info.Mode().Perm() != 0640 {
+		t.Errorf("Expected a new file to honor the umask, got %v", info.Mode())
+	}
+
+	// A dangling symlink, through another one, gets its target created.
+	linkDir := t.TempDir()
+	if err := os.Symlink("target.md", filepath.Join(linkDir, "dangling.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("dangling.md", filepath.Join(linkDir, "chain.md")); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(filepath.Join(linkDir, "chain.md"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(linkDir, "target.md")); string(data) != "new" {
+		t.Errorf("Expected the dangling symlink's target to be written, got %q", data)
+	}
+	for _, name := range []string{"dangling.md", "chain.md"} {
+		if info, err := os.Lstat(filepath.Join(linkDir, name)); err != nil || info.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("Expected %s to stay a symlink", name)
+		}
+	}
+}
+
+func TestCheckDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	if _, ok := availableSpace(dir); !ok {
+		t.Skip("free space is not available on this platform")
+	}
+	if err := checkDiskSpace(dir, 1); err != nil {
+		t.Errorf("Expected a byte to fit, got %v", err)
+	}
+	err := checkDiskSpace(dir, 1<<62)
+	if !errors.Is(err, syscall.ENOSPC) || !strings.Contains(err.Error(), "available in "+dir) {
+		t.Errorf("Expected a clear ENOSPC error, got %v", err)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite messages/template.json")
+
+var wordRegex = regexp.MustCompile(`[A-Za-z]{3,}`)
+
+// messageTemplate lists the translatable messages in the package's source:
+// the formats of printf, fprintf, logf, fatalf, errorf and tr calls, and
+// the usage strings of flags, mapped to empty translations.
+func messageTemplate(t *testing.T) map[string]string {
+	t.Helper()
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := map[string]string{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			arg := -1
+			switch fun := call.Fun.(type) {
+			case *ast.Ident:
+				switch fun.Name {
+				case "printf", "logf", "warnf", "fatalf", "errorf", "tr":
+					arg = 0
+				case "fprintf", "exitf":
+					arg = 1
+				}
+			case *ast.SelectorExpr:
+				if x, ok := fun.X.(*ast.Ident); ok && x.Name == "fs" {
+					switch fun.Sel.Name {
+					case "Func":
+						arg = 1
+					case "Var", "BoolVar", "StringVar", "IntVar", "DurationVar":
+						arg = len(call.Args) - 1
+					}
+				}
+			}
+			if arg < 0 || arg >= len(call.Args) {
+				return true
+			}
+			if lit, ok := call.Args[arg].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				msg, err := strconv.Unquote(lit.Value)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// Formats without words, such as "  %s %s\n", need no translation.
+				if wordRegex.MatchString(formatVerbRegex.ReplaceAllString(msg, "")) {
+					messages[msg] = ""
+				}
+			}
+			return true
+		})
+	}
+	return messages
+}
+
+// TestMessageTemplate keeps messages/template.json, the starting point for
+// translations, in step with the source. Run with -update to rewrite it.
+func TestMessageTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(messageTemplate(t)); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	path := filepath.Join("messages", "template.json")
+	if *update {
+		if err := writeFileAtomic(path, data); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil || !bytes.Equal(data, want) {
+		t.Errorf("%s is out of date (run go test -run TestMessageTemplate -update): %v", path, err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	defer setMessages(nil)
+	catalog := filepath.Join(t.TempDir(), "de.json")
+	if err := os.WriteFile(catalog, []byte(`{"expected a markdown file": "Markdown-Datei erwartet", "Up to date: %s\n": ""}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseArgs([]string{"--messages", catalog}); err == nil || err.Error() != "Markdown-Datei erwartet" {
+		t.Errorf("Expected a translated error, got %v", err)
+	}
+	if got := tr("Up to date: %s\n"); got != "Up to date: %s\n" {
+		t.Errorf("Expected an empty translation to fall back to English, got %q", got)
+	}
+
+	if err := os.WriteFile(catalog, []byte(`{"Up to date: %s\n": "Aktuell\n"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadMessages(catalog); err == nil {
+		t.Errorf("Expected a translation dropping a verb to be rejected")
+	}
+}
+
+func TestSubcommandFlags(t *testing.T) {
+	dir := t.TempDir()
+	for _, args := range [][]string{
+		{"list", dir, "--in-place", "--quality", "5", "--stamp"},
+		{"check", dir, "--stamp"},
+		{"mv", filepath.Join(dir, "a.png"), filepath.Join(dir, "b.png"), dir, "--quality", "5"},
+	} {
+		if _, err := parseArgs(args); err == nil || !strings.Contains(err.Error(), "doesn't apply to the "+args[0]+" subcommand") {
+			t.Errorf("parseArgs(%q) error = %v, want a flag not applying to %s", args, err, args[0])
+		}
+	}
+	for _, args := range [][]string{
+		{"list", dir, "--recursive", "--quiet"},
+		{"check", dir, "--policy", "max-size=1MB"},
+		{"stats", filepath.Join(dir, "doc.md"), "--quality", "5"},
+	} {
+		if _, err := parseArgs(args); err != nil && strings.Contains(err.Error(), "doesn't apply") {
+			t.Errorf("parseArgs(%q) = %v, want its flags accepted", args, err)
+		}
+	}
+}
+
+func TestSubcommands(t *testing.T) {
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "a.png"), pixel.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "doc.md")
+	dataURI := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pixel.Bytes())
+	content := "![a](a.png)\n![gone](img/gone.png)\n![remote](https://example.com/x.png)\n![Arch](" + dataURI + ")\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"embed", doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.check || opts.list || opts.extract || !slices.Equal(opts.inputFiles, []string{doc}) {
+		t.Errorf("embed should parse as the default command, got %+v", opts)
+	}
+
+	run := func(args ...string) *cliOptions {
+		t.Helper()
+		opts, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if opts.recursive {
+			if err := opts.expandInputs(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return opts
+	}
+
+	var out strings.Builder
+	if err := run("list", root).listImages(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := doc + ":1: local a.png\n" +
+		doc + ":2: missing img/gone.png\n" +
+		doc + ":3: remote https://example.com/x.png\n" +
+		doc + ":4: embedded image/png, " + markdown.FormatSize(int64(pixel.Len())) + "\n"
+	if out.String() != want {
+		t.Errorf("list wrote:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	found, err := run("check", root).checkImages(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := doc + ":2: img/gone.png: image not found\n1 problems found in 1 documents\n"; found != 1 || out.String() != want {
+		t.Errorf("check found %d:\n%s\nwant:\n%s", found, out.String(), want)
+	}
+
+	assets := filepath.Join(root, "img")
+	if err := run("extract", doc, "--assets-dir", assets).extractImages(); err != nil {
+		t.Fatal(err)
+	}
+	name := markdown.ExtractedName("Arch", pixel.Bytes())
+	if data, err := os.ReadFile(filepath.Join(assets, name)); err != nil || !bytes.Equal(data, pixel.Bytes()) {
+		t.Errorf("Expected the embedded image in %s, got %v", name, err)
+	}
+	want = strings.Replace(content, dataURI, "img/"+name, 1)
+	if got, _ := os.ReadFile(doc); string(got) != want {
+		t.Errorf("Extracted document = %q; want %q", got, want)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	opts, err := parseArgs([]string{"--version"})
+	if err != nil {
+		t.Fatalf("--version should not need a markdown file: %v", err)
+	}
+	if !opts.showVersion {
+		t.Error("Expected showVersion to be set")
+	}
+
+	saved := version
+	defer func() { version = saved }()
+	version = "v1.2.3"
+	info := versionInfo()
+	if !strings.HasPrefix(info, "markdown-images v1.2.3\n") || !strings.Contains(info, "\ngo: go") {
+		t.Errorf("Unexpected version information:\n%s", info)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	embedded := &markdown.Result{Images: []markdown.ImageResult{{Embedded: true}}}
+	failed := &markdown.Result{Images: []markdown.ImageResult{{Err: errors.New("missing")}}}
+	skipped := &markdown.Result{Images: []markdown.ImageResult{{SkipReason: "excluded"}}}
+
+	for _, tc := range []struct {
+		name       string
+		strict     bool
+		results    []*markdown.Result
+		failed     int
+		docs       int
+		wantStatus int
+	}{
+		{"success", true, []*markdown.Result{embedded, skipped}, 0, 2, 0},
+		{"image failures without --strict", false, []*markdown.Result{failed}, 0, 1, 0},
+		{"some images fail", true, []*markdown.Result{embedded, failed}, 0, 2, exitPartial},
+		{"no image embedded", true, []*markdown.Result{failed, skipped}, 0, 2, exitFailure},
+		{"some documents fail", false, []*markdown.Result{embedded}, 1, 2, exitPartial},
+		{"every document fails", false, nil, 2, 2, exitFailure},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := &cliOptions{strict: tc.strict}
+			for _, result := range tc.results {
+				opts.tally(result)
+			}
+			if got := opts.exitStatus(tc.failed, tc.docs); got != tc.wantStatus {
+				t.Errorf("exitStatus = %d; want %d", got, tc.wantStatus)
+			}
+		})
+	}
+
+	unreadable := &markdown.Error{Code: markdown.CodeInputUnreadable, Path: "doc.md", Err: os.ErrNotExist}
+	if got := errorStatus(unreadable); got != exitInputError {
+		t.Errorf("errorStatus of an unreadable input = %d; want %d", got, exitInputError)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	root := t.TempDir()
+	writeTestFile := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile("a.svg", `<svg width="1" height="1"></svg>`)
+	writeTestFile("one.md", "![a](a.svg)\n")
+	writeTestFile("two.md", "![gone](gone.svg)\n")
+	writeTestFile("manifest.csv", "input,output,base-url\n# Comments are skipped.\none.md,out/one.md,\ntwo.md,,\n")
+
+	rows, err := loadManifest(filepath.Join(root, "manifest.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0].line != 3 || rows[0].fields["output"] != filepath.Join(root, "out", "one.md") {
+		t.Fatalf("loadManifest = %+v", rows)
+	}
+	if got, want := rows[0].args([]string{"--quality", "80"}), []string{"--quality", "80", filepath.Join(root, "one.md"), "--output", filepath.Join(root, "out", "one.md")}; !slices.Equal(got, want) {
+		t.Errorf("row args = %q; want %q", got, want)
+	}
+	if got := manifestArgs([]string{"--manifest", "m.csv", "--strict", "--manifest=n.csv"}); !slices.Equal(got, []string{"--strict"}) {
+		t.Errorf("manifestArgs = %q", got)
+	}
+
+	if _, err := parseArgs([]string{"--manifest", "m.csv", "doc.md"}); err == nil {
+		t.Error("Expected --manifest with an input file to be rejected")
+	}
+	if _, err := parseArgs([]string{"--base-url", "docs/"}); err == nil {
+		t.Error("Expected a relative --base-url to be rejected")
+	}
+
+	savedStatus := statusOutput
+	statusOutput = io.Discard
+	defer func() { statusOutput = savedStatus }()
+	opts, err := parseArgs([]string{"--manifest", filepath.Join(root, "manifest.csv"), "--config", "none"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, total, err := opts.processManifest(context.Background())
+	if err != nil || failed != 0 || total != 2 {
+		t.Fatalf("processManifest = %d, %d, %v; want 0 failed of 2", failed, total, err)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "out", "one.md")); err != nil || !strings.Contains(string(got), "data:image/svg+xml;base64,") {
+		t.Errorf("Expected the first row embedded into its output, got %q, %v", got, err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "two_embedded.md")); err != nil {
+		t.Errorf("Expected the second row embedded next to its input: %v", err)
+	}
+
+	writeTestFile("bad.csv", "one.md\n,out.md\n")
+	if _, err := loadManifest(filepath.Join(root, "bad.csv")); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("Expected a row without an input to be reported by line, got %v", err)
+	}
+}
+
+func TestProgressPrinter(t *testing.T) {
+	var out strings.Builder
+	plain := &progressPrinter{w: &out, width: 80}
+	for _, pr := range []markdown.Progress{
+		{Done: 0, Total: progressThreshold, Image: "a.png"},
+		{Done: 1, Total: progressThreshold, Image: "https://example.com/b.png", Downloaded: 2048},
+		{Done: progressThreshold, Total: progressThreshold, Downloaded: 2048},
+		{Done: 0, Total: 2, Image: "short.png"},
+	} {
+		plain.update(pr)
+	}
+	total := strconv.Itoa(progressThreshold)
+	want := "Image 1/" + total + " (0 B downloaded): a.png\nImage 2/" + total + " (2.0 KB downloaded): https://example.com/b.png\n"
+	if out.String() != want {
+		t.Errorf("Plain progress:\n%q\nwant:\n%q", out.String(), want)
+	}
+
+	out.Reset()
+	bar := &progressPrinter{w: &out, terminal: true, width: 40, always: true}
+	bar.update(markdown.Progress{Done: 1, Total: 2, Image: "images/a-very-long-file-name.png"})
+	bar.update(markdown.Progress{Done: 2, Total: 2})
+	want = "\r\033[K[##########----------] 1/2 images, 0 B…\r\033[K"
+	if out.String() != want {
+		t.Errorf("Terminal progress = %q; want %q", out.String(), want)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	root := t.TempDir()
+	policyFile := filepath.Join(root, policyFileNames[0])
+	rules := "max-size: {limit: 100, level: error}\nhttps-only: error\nalt-text: warn\nformats: {allow: [png, svg]}\n"
+	if err := os.WriteFile(policyFile, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "big.png"), make([]byte, 200), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "doc.md")
+	content := "![](big.png)\n![ok](https://example.com/a.svg)\n![old](http://example.com/b.gif)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"check", doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	found, err := opts.checkImages(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := doc + ":1: big.png: 200 B exceeds the limit of 100 B (max-size)\n" +
+		doc + ":1: warning: big.png: no alt text (alt-text)\n" +
+		doc + ":3: http://example.com/b.gif: not served over https (https-only)\n" +
+		doc + ":3: http://example.com/b.gif: image/gif is not an allowed format (image/png, image/svg+xml) (formats)\n" +
+		"3 problems found in 1 documents\n"
+	if found != 3 || out.String() != want {
+		t.Errorf("check found %d:\n%s\nwant:\n%s", found, out.String(), want)
+	}
+
+	opts.strict = true
+	ref := markdown.ImageReference{ImagePath: "a.png", AltText: "a"}
+	opts.tally(&markdown.Result{Images: []markdown.ImageResult{
+		{Reference: ref, Embedded: true, MIMEType: "image/png", EncodedSize: 50},
+		{Reference: ref, Embedded: true, MIMEType: "image/png", EncodedSize: 500},
+	}})
+	if opts.imagesEmbedded != 1 || opts.imagesFailed != 1 {
+		t.Errorf("tally counted %d embedded and %d failed; want an image over max-size to fail", opts.imagesEmbedded, opts.imagesFailed)
+	}
+
+	for _, invalid := range []string{"unknown-rule: error\n", "max-size: error\n", "alt-text: fatal\n", "formats: {allow: png}\n"} {
+		if err := os.WriteFile(policyFile, []byte(invalid), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseArgs([]string{"check", doc}); err == nil {
+			t.Errorf("Expected policy %q to be rejected", invalid)
+		}
+	}
+	if _, err := parseArgs([]string{"check", "--policy", "none", doc}); err != nil {
+		t.Errorf("--policy none should ignore the invalid policy: %v", err)
+	}
+
+	// Policy errors and violations are translated like any other message.
+	defer setMessages(nil)
+	catalog := filepath.Join(t.TempDir(), "de.json")
+	if err := os.WriteFile(catalog, []byte(`{"%s must be a level or a mapping": "%s muss eine Stufe oder eine Zuordnung sein", "no alt text": "kein Alternativtext"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(policyFile, []byte("max-size: [error]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseArgs([]string{"check", "--messages", catalog, doc}); err == nil || !strings.Contains(err.Error(), "max-size muss eine Stufe") {
+		t.Errorf("Expected a translated policy error, got %v", err)
+	}
+	pol := &policy{rules: []policyRule{{name: "alt-text", level: "warn"}}}
+	if v := pol.violations(policyImage{ref: markdown.ImageReference{ImagePath: "a.png"}}); len(v) != 1 || v[0].message != "kein Alternativtext" {
+		t.Errorf("Expected a translated violation, got %+v", v)
+	}
+}
+
+func TestInit(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "logo.svg"), []byte(`<svg width="1" height="1"></svg>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	template := "![Logo](logo.svg) ![Chart](charts/todo.png \"later\")\n\n# {{title}}\n\nBy {{author}}\n"
+	if err := os.WriteFile(filepath.Join(root, templateFileNames[0]), []byte(template), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "reports", "quarterly-report.md")
+
+	run := func(args ...string) error {
+		t.Helper()
+		opts, err := parseArgs(args)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return opts.initDocument(context.Background(), markdown.NewProcessor(opts.processorOptions()))
+	}
+	if err := run("init", doc, "--var", "author=Ops", "--exclude", "charts/*"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"![Logo](data:image/svg+xml;base64,", `![Chart](../charts/todo.png "later")`, "# Quarterly report\n", "By Ops\n"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("Expected the new document to contain %q, got:\n%s", want, got)
+		}
+	}
+	if err := run("init", doc); err == nil {
+		t.Error("Expected init to refuse to overwrite an existing document")
+	}
+	if err := run("init", filepath.Join(root, "reports", "broken.md")); err == nil {
+		t.Error("Expected init to fail when an image of the template can't be embedded")
+	}
+	if _, err := parseArgs([]string{"doc.md", "--template", "t.md"}); err == nil {
+		t.Error("Expected --template without init to be rejected")
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	root := t.TempDir()
+	files := map[string]string{
+		"same.md":      "![a](img/a.png)\n",
+		"edited.md":    "# Edited\n",
+		"uses-b.md":    "![b](img/b.png)\n",
+		"img/a.png":    "a",
+		"img/b.png":    "b",
+		"notes/new.md": "",
+	}
+	write := func(name, content string) {
+		t.Helper()
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = root
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+	for name, content := range files {
+		if name != "notes/new.md" {
+			write(name, content)
+		}
+	}
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-qm", "docs")
+	write("edited.md", "# Edited again\n")
+	write("img/b.png", "b2")
+	write("notes/new.md", "")
+
+	opts, err := parseArgs([]string{root, "--recursive", "--changed-since", "HEAD"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.keepChanged(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, file := range opts.inputFiles {
+		rel, _ := filepath.Rel(root, file)
+		got = append(got, filepath.ToSlash(rel))
+	}
+	slices.Sort(got)
+	if want := []string{"edited.md", "notes/new.md", "uses-b.md"}; !slices.Equal(got, want) {
+		t.Errorf("Changed documents = %q; want %q", got, want)
+	}
+
+	opts.changedSince = "no-such-ref"
+	if err := opts.keepChanged(); err == nil {
+		t.Error("Expected an unknown ref to be an error")
+	}
+	output := filepath.Join(root, "injected")
+	opts.changedSince = "--output=" + output
+	if err := opts.keepChanged(); err == nil {
+		t.Error("Expected a ref starting with - to be an error")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("Expected git not to write %s, got %v", output, err)
+	}
+	if _, err := parseArgs([]string{root, "--recursive", "--changed-since", "--output=" + output}); err == nil {
+		t.Error("Expected --changed-since starting with - to be rejected")
+	}
+}
+
+func TestAttributions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/licensed.png" {
+			w.Header().Set("Link", `<https://creativecommons.org/licenses/by/4.0/>; rel="license"`)
+		}
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "local.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "doc.md")
+	content := "# Doc\n\n![chart](" + server.URL + "/licensed.png)\n![](" + server.URL + "/plain.png)\n![local](local.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(dir, "doc_embedded.md")
+
+	opts, err := parseArgs([]string{doc, "--attributions", "file", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(output + attributionsSuffix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record attributionRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		t.Fatal(err)
+	}
+	if len(record.Images) != 2 || record.Images[0].URL != server.URL+"/licensed.png" ||
+		record.Images[0].License != "https://creativecommons.org/licenses/by/4.0/" || record.Images[0].SHA256 == "" ||
+		record.Images[1].License != "" || record.Images[1].Headers["Content-Type"] != "image/png" {
+		t.Errorf("Unexpected attributions:\n%s", data)
+	}
+	if out, _ := os.ReadFile(output); strings.Contains(string(out), attributionsHeading) {
+		t.Errorf("Expected no attributions section with --attributions file")
+	}
+
+	opts, err = parseArgs([]string{doc, "--attributions", "section", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	today := time.Now().UTC().Format(time.DateOnly)
+	want := "\n\n" + attributionsHeading + "\n\n" +
+		"- [chart](<" + server.URL + "/licensed.png>): license: https://creativecommons.org/licenses/by/4.0/, retrieved " + today + "\n" +
+		"- [" + server.URL + "/plain.png](<" + server.URL + "/plain.png>): no license information found, retrieved " + today + "\n"
+	if !strings.HasSuffix(string(out), want) {
+		t.Errorf("Expected the output to end with the attributions section %q, got:\n%s", want, out)
+	}
+
+	if _, err := parseArgs([]string{doc, "--attributions", "footer"}); err == nil {
+		t.Error("Expected an unknown --attributions mode to be an error")
+	}
+}
+
+func TestTempFiles(t *testing.T) {
+	// Start a new temporary directory in $TMPDIR.
+	removeTempFiles()
+	t.Setenv("TMPDIR", t.TempDir())
+	defer func() { tempFiles.keep = false }()
+	for _, keep := range []bool{false, true} {
+		tempFiles.keep = keep
+		f, err := createTemp("", "*.video")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		dir := filepath.Dir(f.Name())
+		if filepath.Dir(dir) != os.Getenv("TMPDIR") || !strings.HasPrefix(filepath.Base(dir), "markdown-images-") {
+			t.Fatalf("Expected the file in a markdown-images directory of $TMPDIR, got %s", f.Name())
+		}
+		cmd, err := tempCommand(context.Background(), "sh", "-c", `echo "$TMPDIR"`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out, err := cmd.Output(); err != nil || strings.TrimSpace(string(out)) != dir {
+			t.Errorf("Expected commands to get TMPDIR=%s, got %q (%v)", dir, out, err)
+		}
+		beside, err := createTemp(t.TempDir(), "out.*.tmp")
+		if err != nil {
+			t.Fatal(err)
+		}
+		beside.Close()
+
+		removeTempFiles()
+		if _, err := os.Stat(beside.Name()); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed, got %v", beside.Name(), err)
+		}
+		_, err = os.Stat(f.Name())
+		if keep && err != nil {
+			t.Errorf("Expected --keep-temp to keep %s, got %v", f.Name(), err)
+		} else if !keep && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed with its directory, got %v", dir, err)
+		}
+		if next, err := tempDir(); err != nil || next == dir {
+			t.Errorf("Expected a new temporary directory after removal, got %s (%v)", next, err)
+		}
+		removeTempFiles()
+	}
+}
+
+func TestReadOnlyInput(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to read-only directories")
+	}
+	root := t.TempDir()
+	input := filepath.Join(root, "docs")
+	if err := os.MkdirAll(filepath.Join(input, "guide"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(input, "guide", "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(input, "guide", "doc.md")
+	if err := os.WriteFile(doc, []byte("![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range []string{filepath.Join(input, "guide"), input} {
+		if err := os.Chmod(dir, 0555); err != nil {
+			t.Fatal(err)
+		}
+		defer os.Chmod(dir, 0755)
+	}
+	work := filepath.Join(root, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{input, "--recursive"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.expandInputs(); err != nil {
+		t.Fatal(err)
+	}
+	if failed := opts.embedEach(context.Background(), markdown.NewProcessor(opts.processorOptions())); failed != 0 {
+		t.Fatalf("Expected the output to be written to the working directory, got %d failures", failed)
+	}
+	if _, err := os.Stat(filepath.Join(work, "guide", "doc_embedded.md")); err != nil {
+		t.Errorf("Expected guide/doc_embedded.md in the working directory: %v", err)
+	}
+
+	opts, err = parseArgs([]string{doc, "--in-place"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Errorf("Expected --in-place to fail on a read-only directory, got %v", err)
+	}
+
+	if err := os.Chdir(filepath.Join(input, "guide")); err != nil {
+		t.Fatal(err)
+	}
+	opts, err = parseArgs([]string{"doc.md"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err == nil || !strings.Contains(err.Error(), "--output") {
+		t.Errorf("Expected a read-only working directory to require --output, got %v", err)
+	}
+}
+
+func TestDirWritableWritesNothing(t *testing.T) {
+	dir := t.TempDir()
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(dir, past, past); err != nil {
+		t.Fatal(err)
+	}
+	if !dirWritable(dir) {
+		t.Errorf("Expected %s to be writable", dir)
+	}
+	// Creating and removing a probe file would touch the directory.
+	if info, err := os.Stat(dir); err != nil || !info.ModTime().Equal(past) {
+		t.Errorf("Expected the directory untouched, got modification time %v, %v", info.ModTime(), err)
+	}
+}
+
+func TestInteractive(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	for _, name := range []string{"a.png", "c.png", "d.png"} {
+		if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := filepath.Join(dir, "doc.md")
+	content := "![a](a.png)\n![b](" + server.URL + "/b.png)\n![c](c.png)\n![d](d.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{doc, "--interactive", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.progress != "never" {
+		t.Errorf("Expected --interactive to turn off --progress, got %q", opts.progress)
+	}
+	var prompts bytes.Buffer
+	opts.prompt = &imagePrompt{in: bufio.NewReader(strings.NewReader("maybe\ns\ne\nn\n")), out: &prompts, client: server.Client()}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "doc_embedded.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "![a](a.png)\n![b](data:image/png;base64,"
+	if !strings.HasPrefix(string(out), want) || !strings.HasSuffix(string(out), ")\n![c](c.png)\n![d](d.png)\n") {
+		t.Errorf("Expected only b to be embedded, got:\n%s", out)
+	}
+	size := markdown.FormatSize(int64(buf.Len()))
+	for _, line := range []string{doc + ":1: a.png (local, " + size + ")", doc + ":2: " + server.URL + "/b.png (remote, " + size + ")", doc + ":3: c.png"} {
+		if !strings.Contains(prompts.String(), line) {
+			t.Errorf("Expected a prompt for %q, got:\n%s", line, prompts.String())
+		}
+	}
+	if got := strings.Count(prompts.String(), "Embed this image?"); got != 4 {
+		t.Errorf("Expected 4 prompts (one repeated, none after never), got %d:\n%s", got, prompts.String())
+	}
+
+	for _, args := range [][]string{{"-", "--interactive"}, {doc, "--interactive", "--plan", "plan.json"}, {"list", doc, "--interactive"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestVariant(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "doc.md")
+	content := "<!-- if:linked -->\nDownload a.png too.\n<!-- else -->\nEverything is in this file.\n<!-- endif -->\n![a](a.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		variant, output, want string
+	}{
+		{markdown.VariantEmbedded, "doc_embedded.md", "Everything is in this file.\n![a](data:image/png;base64,"},
+		{markdown.VariantLinked, "doc_linked.md", "Download a.png too.\n![a](a.png)\n"},
+	} {
+		opts, err := parseArgs([]string{doc, "--variant", tc.variant, "--no-cache"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+			t.Fatal(err)
+		}
+		out, err := os.ReadFile(filepath.Join(dir, tc.output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(out), tc.want) {
+			t.Errorf("Variant %s: expected output starting with %q, got:\n%s", tc.variant, tc.want, out)
+		}
+	}
+
+	if err := os.WriteFile(doc, []byte("<!-- if:linked -->\nunclosed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := parseArgs([]string{doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err == nil {
+		t.Error("Expected an unclosed conditional section to be an error")
+	}
+	for _, args := range [][]string{{doc, "--variant", "print"}, {doc, "--variant", "linked", "--interactive"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestBaseDir(t *testing.T) {
+	root := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "assets"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(root, "docs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "assets", "foo.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(root, "docs", "guide.md")
+	if err := os.WriteFile(doc, []byte("![a](/assets/foo.png) ![b](assets/foo.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{"list", doc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var list bytes.Buffer
+	if err := opts.listImages(&list); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(list.String(), "missing") != 2 {
+		t.Errorf("Expected the images to be missing relative to the document, got:\n%s", list.String())
+	}
+
+	opts, err = parseArgs([]string{doc, "--base-dir", root, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(filepath.Join(root, "docs", "guide_embedded.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(string(out), "data:image/png;base64,") != 2 {
+		t.Errorf("Expected both images embedded from the base directory, got:\n%s", out)
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	dir := t.TempDir()
+	for name, size := range map[string]int{"arch diagram.png": 2, "other/logo.png": 3, "logo.png": 4} {
+		if err := os.MkdirAll(filepath.Join(dir, "images", filepath.Dir(name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, size, size))); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "images", name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doc := filepath.Join(dir, "doc.md")
+	content := "![arch](<images/arch diagram.png>)\n![logo](images/logo.png)\n![other](images/other/logo.png)\n"
+	if err := os.WriteFile(doc, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	embedded := filepath.Join(dir, "embedded.md")
+	opts, err := parseArgs([]string{doc, "--source-comments", "--no-cache", "--output", embedded})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(out), "<!-- source: images/arch%20diagram.png sha256:") || strings.Count(string(out), "<!-- source: ") != 3 {
+		t.Fatalf("Expected a source comment before every image, got:\n%s", out)
+	}
+
+	opts, err = parseArgs([]string{"extract", embedded, "--assets-dir", filepath.Join(dir, "assets")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.extractImages(); err != nil {
+		t.Fatal(err)
+	}
+	out, err = os.ReadFile(embedded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(out), "\n")
+	if lines[0] != "![arch](assets/arch%20diagram.png)" || lines[1] != "![logo](assets/logo.png)" ||
+		!strings.HasPrefix(lines[2], "![other](assets/other-") || strings.Contains(string(out), "<!--") {
+		t.Errorf("Expected the original names restored, the clashing one hashed, and the comments removed, got:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "assets", "arch diagram.png")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncryptKey(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n\n![a](a.png)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	keyFile, badKey := filepath.Join(dir, "key"), filepath.Join(dir, "bad-key")
+	if err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(badKey, []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	opts, err := parseArgs([]string{doc, "--format", "html", "--encrypt-key", keyFile, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(filepath.Join(dir, "doc_embedded.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "data:image/png") || !strings.Contains(string(out), `data-encrypted-src="image/png,`) {
+		t.Errorf("Expected the image encrypted, got:\n%s", out)
+	}
+
+	// A theme's images are inlined into the page, and encrypted with it.
+	theme := filepath.Join(dir, "theme.css")
+	if err := os.WriteFile(theme, []byte("body { background: url(a.png); }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts, err = parseArgs([]string{doc, "--format", "html", "--theme", theme, "--encrypt-key", keyFile, "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	if out, err = os.ReadFile(filepath.Join(dir, "doc_embedded.html")); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "data:image/") || !strings.Contains(string(out), "var(--encrypted-image-1)") {
+		t.Errorf("Expected the theme's image encrypted too, got:\n%s", out)
+	}
+
+	for _, args := range [][]string{
+		{doc, "--encrypt-key", keyFile},
+		{doc, "--format", "html", "--encrypt-key", keyFile, "--shared-assets"},
+		{doc, "--format", "html", "--encrypt-key", filepath.Join(dir, "missing")},
+	} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+	if _, err := parseArgs([]string{doc, "--format", "html", "--encrypt-key", badKey}); err == nil || strings.Contains(err.Error(), "hunter2") {
+		t.Errorf("Expected an error that doesn't repeat the key, got %v", err)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	dir := t.TempDir()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "a.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	doc := filepath.Join(dir, "doc.md")
+	if err := os.WriteFile(doc, []byte("# Doc\n\nIntro.\n\n![a](a.png)\n\nOutro.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.Create(filepath.Join(dir, "diff.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	savedStdout, savedStatus := os.Stdout, statusOutput
+	os.Stdout, statusOutput = stdout, io.Discard
+	defer func() { os.Stdout, statusOutput = savedStdout, savedStatus }()
+
+	opts, err := parseArgs([]string{doc, "--diff", "--no-cache"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !opts.dryRun {
+		t.Error("Expected --diff to imply --dry-run")
+	}
+	if err := opts.embed(context.Background(), markdown.NewProcessor(opts.processorOptions()), opts.inputFiles); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(stdout.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := regexp.MustCompile(`^--- \S+doc\.md\n\+\+\+ \S+doc_embedded\.md\n@@ -2,6 \+2,6 @@\n \n Intro\.\n \n-!\[a\]\(a\.png\)\n\+!\[a\]\(data:image/png;base64,<\d+ bytes>\)\n \n Outro\.\n$`)
+	if !want.Match(out) {
+		t.Errorf("Unexpected diff:\n%s", out)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "doc_embedded.md")); !os.IsNotExist(err) {
+		t.Errorf("Expected no output written, got %v", err)
+	}
+
+	for _, args := range [][]string{{doc, "--diff", "--format", "html"}, {doc, "--diff", "--watch"}, {"check", doc, "--diff"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// manifestColumns are the columns of a --manifest, in the order they are
+// read when the file has no header row.
+var manifestColumns = []string{"input", "output", "profile", "base-url"}
+
+// manifestRow is one document of a --manifest: its input, and the output,
+// config profile and base URL it is embedded with, each empty if not set.
+type manifestRow struct {
+	line   int
+	fields map[string]string
+}
+
+// loadManifest reads a CSV manifest, as exported from a spreadsheet. A first
+// row starting with "input" is a header naming the columns, in any order;
+// otherwise they are manifestColumns. Lines starting with # are comments.
+// Relative input and output paths are relative to the manifest's directory.
+func loadManifest(path string) ([]manifestRow, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+
+	columns := manifestColumns
+	var rows []manifestRow
+	for first := true; ; first = false {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, errorf("invalid manifest %s: %v", path, err)
+		}
+		line, _ := r.FieldPos(0)
+		if first && strings.EqualFold(strings.TrimSpace(record[0]), "input") {
+			columns = nil
+			for _, name := range record {
+				name = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(name)), "_", "-")
+				if !slices.Contains(manifestColumns, name) {
+					return nil, errorf("unknown column %q in manifest %s (expected %s)", name, path, strings.Join(manifestColumns, ", "))
+				}
+				columns = append(columns, name)
+			}
+			continue
+		}
+		if len(record) > len(columns) {
+			return nil, errorf("manifest %s line %d has %d columns (expected at most %d)", path, line, len(record), len(columns))
+		}
+		row := manifestRow{line: line, fields: map[string]string{}}
+		for i, value := range record {
+			if value = strings.TrimSpace(value); value != "" {
+				row.fields[columns[i]] = value
+			}
+		}
+		if row.fields["input"] == "" {
+			return nil, errorf("manifest %s line %d has no input", path, line)
+		}
+		for _, name := range []string{"input", "output"} {
+			if value := row.fields[name]; value != "" && !filepath.IsAbs(value) {
+				row.fields[name] = filepath.Join(filepath.Dir(path), value)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// manifestArgs returns args without --manifest, the arguments every row of
+// the manifest is embedded with.
+func manifestArgs(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if strings.HasPrefix(args[i], "-") && name == "manifest" {
+			if !hasValue {
+				i++
+			}
+			continue
+		}
+		kept = append(kept, args[i])
+	}
+	return kept
+}
+
+// args returns the command line that embeds the row: base plus the row's
+// input and settings.
+func (row manifestRow) args(base []string) []string {
+	args := append(slices.Clone(base), row.fields["input"])
+	for _, name := range manifestColumns[1:] {
+		if value := row.fields[name]; value != "" {
+			args = append(args, "--"+name, value)
+		}
+	}
+	return args
+}
+
+// processManifest embeds the document of every row of the --manifest, each
+// with the command line's flags plus those of its row, and returns how many
+// rows there are and how many failed. Like several input files, a failing
+// row doesn't stop the others, and a summary is printed at the end.
+func (o *cliOptions) processManifest(ctx context.Context) (failed, total int, err error) {
+	rows, err := loadManifest(o.manifest)
+	if err != nil {
+		return 0, 0, err
+	}
+	base := manifestArgs(o.args)
+	var outcomes []fileOutcome
+	for _, row := range rows {
+		if ctx.Err() != nil {
+			break
+		}
+		input := row.fields["input"]
+		err := o.embedRow(ctx, row, base)
+		if err != nil {
+			logf("Error: %s line %d: %v", o.manifest, row.line, err)
+			failed++
+		}
+		outcomes = append(outcomes, fileOutcome{file: input, err: err})
+	}
+	printOutcomes(statusOutput, outcomes, failed)
+	return failed, len(rows), nil
+}
+
+// embedRow embeds the document of one manifest row.
+func (o *cliOptions) embedRow(ctx context.Context, row manifestRow, base []string) error {
+	opts, err := parseArgs(row.args(base))
+	if err != nil {
+		return err
+	}
+	processor := markdown.NewProcessor(opts.processorOptions())
+	err = opts.embed(ctx, processor, opts.inputFiles)
+	o.imagesEmbedded += opts.imagesEmbedded
+	o.imagesFailed += opts.imagesFailed
+	o.usage = append(o.usage, opts.usage...)
+	return err
+}
@@ -0,0 +1,219 @@
+package markdown
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// fileMarkerPrefix starts the comment FileMarker returns.
+const fileMarkerPrefix = "<!-- markdown-images:file "
+
+// FileMarker returns an HTML comment, to be placed on a line of its own
+// before each file of a concatenated document, that lets RenderHTML resolve
+// links between the files: [setup](install.md#setup) becomes a link to the
+// setup heading of the part that came from install.md. The markers are not
+// rendered.
+func FileMarker(file string) string {
+	return fileMarkerPrefix + filepath.ToSlash(filepath.Clean(file)) + " -->"
+}
+
+// markedFile returns the file named by a FileMarker block.
+func markedFile(n ast.Node, source []byte) (string, bool) {
+	block, ok := n.(*ast.HTMLBlock)
+	if !ok || block.Lines().Len() != 1 {
+		return "", false
+	}
+	line := block.Lines().At(0)
+	text := strings.TrimSpace(string(line.Value(source)))
+	if !strings.HasPrefix(text, fileMarkerPrefix) || !strings.HasSuffix(text, " -->") {
+		return "", false
+	}
+	return text[len(fileMarkerPrefix) : len(text)-len(" -->")], true
+}
+
+// fileAnchors maps the heading ids a file's own links use, as GitHub would
+// assign them to the file alone, to the ids in the combined page.
+type fileAnchors struct {
+	ids   map[string]string
+	seen  map[string]int
+	first string
+}
+
+// linkHeadings gives every heading a GitHub-style id and points links at
+// them. Intra-document links ([see](#setup)) are matched to headings
+// leniently, ignoring case and punctuation, and with FileMarker blocks, links
+// between the concatenated files are turned into links within the page.
+// Unlike goldmark's automatic ids, these leave out embedded images, whose
+// data URIs would otherwise end up in the anchors.
+func linkHeadings(doc ast.Node, source []byte) {
+	files, markers := collectAnchors(doc, source)
+	current := ""
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if file, ok := markedFile(n, source); ok {
+			current = file
+			return ast.WalkSkipChildren, nil
+		}
+		if link, ok := n.(*ast.Link); ok {
+			if id, ok := resolveLink(string(link.Destination), current, files); ok {
+				link.Destination = []byte("#" + id)
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, m := range markers {
+		m.Parent().RemoveChild(m.Parent(), m)
+	}
+}
+
+// LinkFiles is the markdown counterpart of the link resolution RenderHTML
+// does for documents concatenated with FileMarker lines: links between the
+// files, and links to headings within each file, are pointed at the
+// headings of the combined document as GitHub numbers them, and the markers
+// are removed.
+func LinkFiles(content string) string {
+	if !strings.Contains(content, fileMarkerPrefix) {
+		return content
+	}
+	content, bom := StripBOM(content)
+	source := []byte(content)
+	doc := goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser().Parse(text.NewReader(source))
+	files, markers := collectAnchors(doc, source)
+	starts := make([]int, len(markers))
+	for i, m := range markers {
+		starts[i] = m.Lines().At(0).Start
+	}
+	content = rewriteLinks(content, func(ref linkReference) (string, bool) {
+		current := ""
+		for i, m := range markers {
+			if starts[i] <= ref.start {
+				current, _ = markedFile(m, source)
+			}
+		}
+		id, ok := resolveLink(ref.destination, current, files)
+		return "#" + id, ok
+	})
+	content = fileMarkerLineRegex.ReplaceAllString(content, "")
+	if bom {
+		content = utf8BOM + content
+	}
+	return content
+}
+
+// fileMarkerLineRegex matches a FileMarker line with its line ending.
+var fileMarkerLineRegex = regexp.MustCompile(`(?m)^ {0,3}` + regexp.QuoteMeta(fileMarkerPrefix) + `.* -->[ \t]*(?:\r?\n)?`)
+
+// collectAnchors gives every heading of doc an id and returns the anchors of
+// each file named by a FileMarker block ("" for a document without markers),
+// along with the marker blocks.
+func collectAnchors(doc ast.Node, source []byte) (map[string]*fileAnchors, []ast.Node) {
+	files := map[string]*fileAnchors{"": {ids: map[string]string{}, seen: map[string]int{}}}
+	used := map[string]int{}
+	var markers []ast.Node
+	current := ""
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		if file, ok := markedFile(n, source); ok {
+			markers = append(markers, n)
+			current = file
+			if files[file] == nil {
+				files[file] = &fileAnchors{ids: map[string]string{}, seen: map[string]int{}}
+			}
+			return ast.WalkSkipChildren, nil
+		}
+		heading, ok := n.(*ast.Heading)
+		if !ok {
+			return ast.WalkContinue, nil
+		}
+		slug := githubSlug(headingText(heading, source))
+		if slug == "" {
+			slug = "section"
+		}
+		anchors := files[current]
+		local := numbered(slug, anchors.seen)
+		id := numbered(slug, used)
+		for used[id] > 1 { // A numbered id may collide with a heading's own text.
+			id = numbered(slug, used)
+		}
+		anchors.ids[local] = id
+		if anchors.first == "" {
+			anchors.first = id
+		}
+		heading.SetAttributeString("id", []byte(id))
+		return ast.WalkSkipChildren, nil
+	})
+	return files, markers
+}
+
+// numbered returns slug, or slug-N for its Nth repeat, as GitHub does.
+func numbered(slug string, seen map[string]int) string {
+	n := seen[slug]
+	seen[slug]++
+	if n == 0 {
+		return slug
+	}
+	id := fmt.Sprintf("%s-%d", slug, n)
+	seen[id]++
+	return id
+}
+
+// resolveLink returns the page id a link from the file current should point
+// to, if it targets a heading of the document or of a concatenated file.
+func resolveLink(destination, current string, files map[string]*fileAnchors) (string, bool) {
+	target, fragment, _ := strings.Cut(destination, "#")
+	anchors := files[current]
+	if target != "" {
+		if isURL(target) || strings.Contains(target, ":") {
+			return "", false
+		}
+		if unescaped, err := url.PathUnescape(target); err == nil {
+			target = unescaped
+		}
+		if anchors = files[path.Join(path.Dir(current), target)]; anchors == nil || current == "" {
+			return "", false
+		}
+		if fragment == "" {
+			return anchors.first, anchors.first != ""
+		}
+	} else if fragment == "" {
+		return "", false
+	}
+	if unescaped, err := url.PathUnescape(fragment); err == nil {
+		fragment = unescaped
+	}
+	if id, ok := anchors.ids[fragment]; ok {
+		return id, true
+	}
+	id, ok := anchors.ids[githubSlug(fragment)]
+	return id, ok
+}
+
+// githubSlug turns heading text into an anchor the way GitHub does: lower
+// case, punctuation other than - and _ dropped, spaces turned into dashes.
+func githubSlug(text string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(strings.TrimSpace(text)) {
+		switch {
+		case r == ' ':
+			b.WriteByte('-')
+		case r == '-' || r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r):
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
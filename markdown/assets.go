@@ -0,0 +1,75 @@
+package markdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// blankGIF is a transparent 1x1 placeholder for images whose data moved to
+// the shared stylesheet.
+const blankGIF = "data:image/gif;base64,R0lGODlhAQABAIAAAAAAAP///yH5BAEAAAAALAAAAAABAAEAAAIBRAA7"
+
+var dataSrcRegex = regexp.MustCompile(`<img src="(data:[^"]+)"`)
+
+// ShareAssets moves images that are embedded in at least minPages of the
+// given HTML pages into a shared stylesheet, so that logos and badges used
+// on every page of a site are stored once. Each moved image gets a blank
+// placeholder src and a class whose CSS content is the original data URI.
+//
+// It returns the rewritten pages, whether each page now needs the
+// stylesheet, and the stylesheet itself ("" if nothing was shared).
+func ShareAssets(pages []string, minPages int) ([]string, []bool, string) {
+	minPages = max(minPages, 2)
+	usage := map[string]int{}
+	for _, page := range pages {
+		seen := map[string]bool{}
+		for _, m := range dataSrcRegex.FindAllStringSubmatch(page, -1) {
+			if !seen[m[1]] {
+				seen[m[1]] = true
+				usage[m[1]]++
+			}
+		}
+	}
+
+	classes := map[string]string{}
+	for uri, n := range usage {
+		if n >= minPages {
+			sum := sha256.Sum256([]byte(uri))
+			classes[uri] = "mdimg-" + hex.EncodeToString(sum[:6])
+		}
+	}
+	rewritten := make([]string, len(pages))
+	linked := make([]bool, len(pages))
+	if len(classes) == 0 {
+		copy(rewritten, pages)
+		return rewritten, linked, ""
+	}
+
+	for i, page := range pages {
+		rewritten[i] = dataSrcRegex.ReplaceAllStringFunc(page, func(tag string) string {
+			uri := tag[len(`<img src="`) : len(tag)-1]
+			class, ok := classes[uri]
+			if !ok {
+				return tag
+			}
+			linked[i] = true
+			return fmt.Sprintf(`<img class="%s" src="%s"`, class, blankGIF)
+		})
+	}
+
+	uris := make([]string, 0, len(classes))
+	for uri := range classes {
+		uris = append(uris, uri)
+	}
+	sort.Slice(uris, func(i, j int) bool { return classes[uris[i]] < classes[uris[j]] })
+	var css strings.Builder
+	css.WriteString("/* Images shared by several pages, generated by markdown-images. */\n")
+	for _, uri := range uris {
+		fmt.Fprintf(&css, "img.%s { content: url(\"%s\"); }\n", classes[uri], uri)
+	}
+	return rewritten, linked, css.String()
+}
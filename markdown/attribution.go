@@ -0,0 +1,256 @@
+package markdown
+
+import (
+	"bytes"
+	"encoding/binary"
+	"html"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Attribution is what is known of the origin and license of a remote image,
+// recorded when Options.Attribution is set: embedding copies third-party
+// content into the document, and publishers may need a record of it.
+type Attribution struct {
+	// URL is where the image was downloaded from, and Retrieved when.
+	URL       string
+	Retrieved time.Time
+	// Headers are the response headers bearing on the image's origin and
+	// license: Content-Type, Last-Modified, ETag, Link and any whose name
+	// mentions a license, copyright, attribution or credit.
+	Headers map[string]string
+	// License is the license URL or terms, from a Link header with
+	// rel="license", an X-License header or the image's XMP metadata.
+	License string
+	// Creator and Copyright come from the image's XMP, EXIF or PNG text
+	// metadata.
+	Creator   string
+	Copyright string
+}
+
+// attributionHeaders are the response headers an Attribution always keeps.
+var attributionHeaders = []string{"Content-Type", "Last-Modified", "Etag", "Link"}
+
+// newAttribution collects the attribution of the image downloaded from
+// imageURL, with the response header, and content.
+func newAttribution(imageURL string, header http.Header, content []byte) *Attribution {
+	a := &Attribution{URL: imageURL, Retrieved: time.Now().UTC()}
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		keep := strings.Contains(lower, "license") || strings.Contains(lower, "copyright") ||
+			strings.Contains(lower, "attribution") || strings.Contains(lower, "credit")
+		for _, h := range attributionHeaders {
+			keep = keep || name == h
+		}
+		if keep {
+			if a.Headers == nil {
+				a.Headers = map[string]string{}
+			}
+			a.Headers[name] = strings.Join(values, ", ")
+		}
+	}
+	a.License = firstNonEmpty(licenseLink(header), header.Get("X-License"), header.Get("License"))
+
+	xmp := xmpPacket(content)
+	a.License = firstNonEmpty(a.License, xmpProperty(xmp, "xmpRights:WebStatement"), xmpProperty(xmp, "cc:license"), xmpProperty(xmp, "xmpRights:UsageTerms"))
+	a.Creator = firstNonEmpty(xmpProperty(xmp, "dc:creator"), xmpProperty(xmp, "cc:attributionName"))
+	a.Copyright = xmpProperty(xmp, "dc:rights")
+	artist, copyright := exifCredits(content)
+	author, pngCopyright := pngCredits(content)
+	a.Creator = firstNonEmpty(a.Creator, artist, author)
+	a.Copyright = firstNonEmpty(a.Copyright, copyright, pngCopyright)
+	return a
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// licenseLink returns the target of the first Link header with
+// rel="license" (RFC 4946), or "".
+func licenseLink(header http.Header) string {
+	for _, value := range header.Values("Link") {
+		for _, link := range strings.Split(value, ",") {
+			target, params, ok := strings.Cut(strings.TrimSpace(link), ";")
+			if !ok || !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			// Parsing the parameters as a media type's reads quoted values.
+			_, attrs, err := mime.ParseMediaType("link;" + params)
+			if err != nil {
+				continue
+			}
+			for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+				if rel == "license" {
+					return strings.Trim(target, "<>")
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// xmpPacket returns the XMP metadata embedded in an image, or the RDF
+// metadata of an SVG, or nil.
+func xmpPacket(content []byte) []byte {
+	for _, tags := range [][2]string{{"<x:xmpmeta", "</x:xmpmeta>"}, {"<rdf:RDF", "</rdf:RDF>"}} {
+		start := bytes.Index(content, []byte(tags[0]))
+		if start < 0 {
+			continue
+		}
+		if end := bytes.Index(content[start:], []byte(tags[1])); end >= 0 {
+			return content[start : start+end+len(tags[1])]
+		}
+	}
+	return nil
+}
+
+// xmpProperties are the XMP properties an Attribution is read from.
+var xmpProperties = map[string]*regexp.Regexp{
+	"xmpRights:WebStatement": xmpPropertyRegexp("xmpRights:WebStatement"),
+	"xmpRights:UsageTerms":   xmpPropertyRegexp("xmpRights:UsageTerms"),
+	"cc:license":             xmpPropertyRegexp("cc:license"),
+	"cc:attributionName":     xmpPropertyRegexp("cc:attributionName"),
+	"dc:creator":             xmpPropertyRegexp("dc:creator"),
+	"dc:rights":              xmpPropertyRegexp("dc:rights"),
+}
+
+// xmpPropertyRegexp matches the property name as an element, capturing its
+// attributes and content, or as an attribute, capturing its value.
+func xmpPropertyRegexp(name string) *regexp.Regexp {
+	quoted := regexp.QuoteMeta(name)
+	return regexp.MustCompile(`(?s)<` + quoted + `\b([^>]*?)(?:/>|>(.*?)</` + quoted + `>)|\s` + quoted + `="([^"]*)"`)
+}
+
+var (
+	xmpResourceRegex = regexp.MustCompile(`rdf:resource="([^"]*)"`)
+	xmpItemRegex     = regexp.MustCompile(`(?s)<rdf:li\b[^>]*>(.*?)</rdf:li>`)
+	xmlTagRegex      = regexp.MustCompile(`<[^>]*>`)
+)
+
+// xmpProperty returns the value of the XMP property name, written as an
+// attribute, a resource or an element, of the first item of an element's
+// list, such as the default language of dc:rights, or "".
+func xmpProperty(xmp []byte, name string) string {
+	if xmp == nil {
+		return ""
+	}
+	m := xmpProperties[name].FindSubmatch(xmp)
+	if m == nil {
+		return ""
+	}
+	value := m[3]
+	if r := xmpResourceRegex.FindSubmatch(m[1]); r != nil {
+		value = r[1]
+	} else if m[2] != nil {
+		value = m[2]
+		if item := xmpItemRegex.FindSubmatch(value); item != nil {
+			value = item[1]
+		}
+		value = xmlTagRegex.ReplaceAll(value, nil)
+	}
+	return strings.Join(strings.Fields(html.UnescapeString(string(value))), " ")
+}
+
+// exifCredits returns the Artist and Copyright tags of the EXIF metadata of
+// a JPEG or WebP image.
+func exifCredits(content []byte) (artist, copyright string) {
+	i := bytes.Index(content, []byte("Exif\x00\x00"))
+	if i < 0 {
+		return "", ""
+	}
+	tiff := content[i+6:]
+	if len(tiff) < 8 {
+		return "", ""
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return "", ""
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return "", ""
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for e := ifd + 2; e+12 <= len(tiff) && count > 0; e, count = e+12, count-1 {
+		tag, kind, n := order.Uint16(tiff[e:]), order.Uint16(tiff[e+2:]), int(order.Uint32(tiff[e+4:]))
+		if kind != 2 || (tag != 0x013b && tag != 0x8298) {
+			continue
+		}
+		// ASCII values of up to four bytes are stored in the entry itself.
+		value := tiff[e+8 : e+12]
+		if n > 4 {
+			off := int(order.Uint32(tiff[e+8:]))
+			if off < 0 || n > len(tiff)-off {
+				continue
+			}
+			value = tiff[off : off+n]
+		} else {
+			value = value[:n]
+		}
+		text := strings.TrimSpace(strings.ReplaceAll(strings.TrimRight(string(value), "\x00"), "\x00", "; "))
+		if tag == 0x013b {
+			artist = text
+		} else {
+			copyright = text
+		}
+	}
+	return artist, copyright
+}
+
+// pngCredits returns the Author and Copyright text chunks of a PNG image.
+func pngCredits(content []byte) (author, copyright string) {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(content, []byte(signature)) {
+		return "", ""
+	}
+	for pos := len(signature); pos+8 <= len(content); {
+		n := int(binary.BigEndian.Uint32(content[pos:]))
+		kind := string(content[pos+4 : pos+8])
+		if n < 0 || n > len(content)-pos-12 || kind == "IEND" {
+			break
+		}
+		data := content[pos+8 : pos+8+n]
+		pos += n + 12
+		keyword, text, ok := bytes.Cut(data, []byte{0})
+		if !ok {
+			continue
+		}
+		switch kind {
+		case "tEXt":
+		case "iTXt":
+			// Compression flag and method, language tag and translated
+			// keyword; compressed text is not read.
+			if len(text) < 2 || text[0] != 0 {
+				continue
+			}
+			parts := bytes.SplitN(text[2:], []byte{0}, 3)
+			if len(parts) < 3 {
+				continue
+			}
+			text = parts[2]
+		default:
+			continue
+		}
+		switch string(keyword) {
+		case "Author":
+			author = strings.TrimSpace(string(text))
+		case "Copyright":
+			copyright = strings.TrimSpace(string(text))
+		}
+	}
+	return author, copyright
+}
@@ -0,0 +1,144 @@
+package markdown_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+// withPNGText inserts tEXt chunks of keyword and text pairs after the IHDR
+// chunk of a PNG.
+func withPNGText(data []byte, pairs ...string) []byte {
+	const ihdrEnd = 8 + 8 + 13 + 4
+	out := append([]byte{}, data[:ihdrEnd]...)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		chunk := append([]byte("tEXt"+pairs[i]+"\x00"), pairs[i+1]...)
+		out = binary.BigEndian.AppendUint32(out, uint32(len(chunk)-4))
+		out = append(out, chunk...)
+		out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+	}
+	return append(out, data[ihdrEnd:]...)
+}
+
+// withEXIFArtist inserts an APP1 segment with an EXIF Artist tag after the
+// SOI marker of a JPEG.
+func withEXIFArtist(data []byte, artist string) []byte {
+	value := append([]byte(artist), 0)
+	tiff := []byte("II*\x00\x08\x00\x00\x00")
+	tiff = binary.LittleEndian.AppendUint16(tiff, 1)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 0x013b)
+	tiff = binary.LittleEndian.AppendUint16(tiff, 2)
+	tiff = binary.LittleEndian.AppendUint32(tiff, uint32(len(value)))
+	tiff = binary.LittleEndian.AppendUint32(tiff, 8+2+12+4)
+	tiff = binary.LittleEndian.AppendUint32(tiff, 0)
+	tiff = append(tiff, value...)
+	app1 := append([]byte("Exif\x00\x00"), tiff...)
+	out := append([]byte{}, data[:2]...)
+	out = append(out, 0xff, 0xe1)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(app1)+2))
+	out = append(out, app1...)
+	return append(out, data[2:]...)
+}
+
+func TestAttribution(t *testing.T) {
+	var pngBuf, jpegBuf bytes.Buffer
+	if err := png.Encode(&pngBuf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(&jpegBuf, image.NewRGBA(image.Rect(0, 0, 2, 2)), nil); err != nil {
+		t.Fatal(err)
+	}
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" width="4" height="4"><metadata><rdf:RDF><cc:Work rdf:about="">` +
+		`<cc:license rdf:resource="https://creativecommons.org/licenses/by-sa/4.0/"/>` +
+		`<dc:creator><cc:Agent><dc:title>Ada &amp; Co</dc:title></cc:Agent></dc:creator>` +
+		`<dc:rights><rdf:Alt><rdf:li xml:lang="x-default">© 2024 Ada</rdf:li></rdf:Alt></dc:rights>` +
+		`</cc:Work></rdf:RDF></metadata><rect width="4" height="4"/></svg>`
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/credited.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Header().Set("Link", `<https://example.com/about>; rel="author", <https://creativecommons.org/licenses/by/4.0/>; rel="license"`)
+			w.Header().Set("X-Photo-Credit", "Example Agency")
+			w.Header().Set("Set-Cookie", "session=secret")
+			w.Write(withPNGText(pngBuf.Bytes(), "Author", "Grace", "Copyright", "© 2023 Grace"))
+		case "/photo.jpg":
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Header().Set("X-License", "All rights reserved")
+			w.Write(withEXIFArtist(jpegBuf.Bytes(), "Linus"))
+		case "/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte(svg))
+		case "/plain.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngBuf.Bytes())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "local.png", 2, 2)
+	content := "![a](" + server.URL + "/credited.png) ![b](" + server.URL + "/photo.jpg) ![c](" + server.URL + "/logo.svg)\n" +
+		"![d](" + server.URL + "/plain.png) ![e](local.png) ![again](" + server.URL + "/credited.png)\n"
+	result, err := markdown.NewProcessor(markdown.Options{Attribution: true}).Process(content, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*markdown.Attribution{
+		{License: "https://creativecommons.org/licenses/by/4.0/", Creator: "Grace", Copyright: "© 2023 Grace"},
+		{License: "All rights reserved", Creator: "Linus"},
+		{License: "https://creativecommons.org/licenses/by-sa/4.0/", Creator: "Ada & Co", Copyright: "© 2024 Ada"},
+		{},
+		nil,
+		{License: "https://creativecommons.org/licenses/by/4.0/", Creator: "Grace", Copyright: "© 2023 Grace"},
+	}
+	if len(result.Images) != len(want) {
+		t.Fatalf("Expected %d images, got %d", len(want), len(result.Images))
+	}
+	for i, img := range result.Images {
+		got := img.Attribution
+		if !img.Embedded {
+			t.Errorf("Image %s not embedded: %v", img.Reference.ImagePath, img.Err)
+		}
+		if (got == nil) != (want[i] == nil) {
+			t.Errorf("Image %s: got attribution %+v, want %+v", img.Reference.ImagePath, got, want[i])
+			continue
+		}
+		if got == nil {
+			continue
+		}
+		if got.URL != img.Reference.ImagePath || got.Retrieved.IsZero() {
+			t.Errorf("Image %s: got URL %q retrieved %v", img.Reference.ImagePath, got.URL, got.Retrieved)
+		}
+		if got.License != want[i].License || got.Creator != want[i].Creator || got.Copyright != want[i].Copyright {
+			t.Errorf("Image %s: got license %q, creator %q, copyright %q, want %+v", img.Reference.ImagePath, got.License, got.Creator, got.Copyright, want[i])
+		}
+	}
+	headers := result.Images[0].Attribution.Headers
+	if headers["X-Photo-Credit"] != "Example Agency" || headers["Content-Type"] != "image/png" || headers["Link"] == "" {
+		t.Errorf("Expected the credit, content type and link headers, got %v", headers)
+	}
+	if _, ok := headers["Set-Cookie"]; ok {
+		t.Errorf("Expected unrelated headers to be left out, got %v", headers)
+	}
+
+	result, err = markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, img := range result.Images {
+		if img.Attribution != nil {
+			t.Errorf("Expected no attribution without the option, got %+v", img.Attribution)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package markdown
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PathOccurrence is one spelling of a local image path and the line it is on.
+type PathOccurrence struct {
+	Path string
+	Line int
+}
+
+// InconsistentPath is a local image file that a document reaches through more
+// than one spelling, such as ./img/a.png, img/a.png and ../docs/img/a.png.
+type InconsistentPath struct {
+	// File is the absolute path of the image.
+	File string
+	// Suggested is the normalized form: relative to the document directory,
+	// cleaned and with forward slashes.
+	Suggested string
+	// Occurrences lists every reference to File in document order.
+	Occurrences []PathOccurrence
+}
+
+// ImageUse is a reference to a local image file.
+type ImageUse struct {
+	// File is the absolute path of the image.
+	File string
+	PathOccurrence
+}
+
+// LocalImageUses lists the references to local image files in content, in
+// document order. References are resolved against baseDir the same way
+// Process resolves them; remote URLs and data URIs are ignored.
+func LocalImageUses(content, baseDir string) []ImageUse {
+	absBase, err := filepath.Abs(baseDir)
+	if err != nil {
+		absBase = baseDir
+	}
+
+	var uses []ImageUse
+	for _, ref := range findImageReferences(content) {
+		file, ok := localImageFile(absBase, ref.ImagePath)
+		if !ok {
+			continue
+		}
+		uses = append(uses, ImageUse{
+			File: file,
+			PathOccurrence: PathOccurrence{
+				Path: ref.ImagePath,
+				Line: strings.Count(content[:ref.StartPos], "\n") + 1,
+			},
+		})
+	}
+	return uses
+}
+
+// localImageFile returns the absolute path of the local file that path,
+// referenced from a document in absBase, points at.
+func localImageFile(absBase, path string) (string, bool) {
+	if path == "" || isURL(path) || strings.HasPrefix(path, "#") || strings.HasPrefix(path, "data:") {
+		return "", false
+	}
+	file, err := filepath.Abs(resolveLocalPath(absBase, path))
+	return file, err == nil
+}
+
+// AuditPaths reports the local image files in content that are referenced
+// through different relative paths, as found by LocalImageUses. The result
+// is sorted by suggested path.
+func AuditPaths(content, baseDir string) []InconsistentPath {
+	absBase, err := filepath.Abs(baseDir)
+	if err != nil {
+		absBase = baseDir
+	}
+
+	byFile := map[string]*InconsistentPath{}
+	var order []string
+	for _, use := range LocalImageUses(content, baseDir) {
+		group, ok := byFile[use.File]
+		if !ok {
+			suggested := use.File
+			if rel, err := filepath.Rel(absBase, use.File); err == nil {
+				suggested = filepath.ToSlash(rel)
+			}
+			group = &InconsistentPath{File: use.File, Suggested: suggested}
+			byFile[use.File] = group
+			order = append(order, use.File)
+		}
+		group.Occurrences = append(group.Occurrences, use.PathOccurrence)
+	}
+
+	var report []InconsistentPath
+	for _, file := range order {
+		group := byFile[file]
+		for _, occ := range group.Occurrences[1:] {
+			if occ.Path != group.Occurrences[0].Path {
+				report = append(report, *group)
+				break
+			}
+		}
+	}
+	sort.SliceStable(report, func(i, j int) bool { return report[i].Suggested < report[j].Suggested })
+	return report
+}
@@ -0,0 +1,60 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAuditPaths(t *testing.T) {
+	root := t.TempDir()
+	docs := filepath.Join(root, "docs")
+	if err := os.MkdirAll(filepath.Join(docs, "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(docs, "img"), "a.png", 2, 2)
+	writeTestPNG(t, filepath.Join(docs, "img"), "b.png", 2, 2)
+
+	content := "![a](./img/a.png)\n" +
+		"![b](img/b.png)\n" +
+		"<img src=\"img/a.png\">\n" +
+		"![b](img/b.png)\n" +
+		"![a](../docs/img/a.png)\n" +
+		"![r](https://example.com/img/a.png)\n"
+
+	got := markdown.AuditPaths(content, docs)
+	want := []markdown.InconsistentPath{{
+		File:      filepath.Join(docs, "img", "a.png"),
+		Suggested: "img/a.png",
+		Occurrences: []markdown.PathOccurrence{
+			{Path: "./img/a.png", Line: 1},
+			{Path: "img/a.png", Line: 3},
+			{Path: "../docs/img/a.png", Line: 5},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AuditPaths() = %+v; want %+v", got, want)
+	}
+
+	if got := markdown.AuditPaths("![a](img/a.png) ![a](img/a.png)", docs); len(got) != 0 {
+		t.Errorf("Expected no findings for consistent paths, got %+v", got)
+	}
+}
+
+func TestLocalImageUses(t *testing.T) {
+	docs := t.TempDir()
+	content := "![a](img/a.png)\n" +
+		"![r](https://example.com/b.png) ![d](data:image/png;base64,AAAA)\n" +
+		"<img src=\"../shared/c.svg\">\n"
+
+	got := markdown.LocalImageUses(content, docs)
+	want := []markdown.ImageUse{
+		{File: filepath.Join(docs, "img", "a.png"), PathOccurrence: markdown.PathOccurrence{Path: "img/a.png", Line: 1}},
+		{File: filepath.Join(filepath.Dir(docs), "shared", "c.svg"), PathOccurrence: markdown.PathOccurrence{Path: "../shared/c.svg", Line: 3}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("LocalImageUses() = %+v; want %+v", got, want)
+	}
+}
@@ -0,0 +1,28 @@
+package markdown
+
+import (
+	"image"
+
+	"golang.org/x/image/draw"
+)
+
+// reduceBitDepth converts an image with 16 bits per channel, as PNGs from
+// scientific and imaging tools often are, to 8 bits per channel, which is
+// all browsers display and about half the encoded size. Other images are
+// returned unchanged.
+func reduceBitDepth(img image.Image) image.Image {
+	b := img.Bounds()
+	var dst draw.Image
+	switch img.(type) {
+	case *image.Gray16:
+		dst = image.NewGray(b)
+	case *image.NRGBA64:
+		dst = image.NewNRGBA(b)
+	case *image.RGBA64:
+		dst = image.NewRGBA(b)
+	default:
+		return img
+	}
+	draw.Draw(dst, b, img, b.Min, draw.Src)
+	return dst
+}
@@ -0,0 +1,81 @@
+package markdown_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestBitDepthReduction(t *testing.T) {
+	tempDir := t.TempDir()
+	gray := image.NewGray16(image.Rect(0, 0, 64, 64))
+	rgba := image.NewNRGBA64(image.Rect(0, 0, 64, 64))
+	for y := range 64 {
+		for x := range 64 {
+			v := uint16(x*1021 + y*509)
+			gray.SetGray16(x, y, color.Gray16{Y: v})
+			rgba.SetNRGBA64(x, y, color.NRGBA64{R: v, G: ^v, B: v / 2, A: 0xffff})
+		}
+	}
+	for name, img := range map[string]image.Image{"gray.png": gray, "rgba.png": rgba} {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	embedded := func(opts markdown.Options, name string) (image.Image, int) {
+		t.Helper()
+		result, err := markdown.NewProcessor(opts).Process("![i]("+name+")", tempDir)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		uri := regexp.MustCompile(`data:[^)]+`).FindString(result.Content)
+		_, data, ok := markdown.DecodeDataURI(uri)
+		if !ok {
+			t.Fatalf("Expected %s to be embedded, got %q", name, result.Content)
+		}
+		img, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("Could not decode the embedded %s: %v", name, err)
+		}
+		return img, len(data)
+	}
+
+	for _, tc := range []struct {
+		name          string
+		reduced, full string
+	}{
+		{"gray.png", "*image.Gray", "*image.Gray16"},
+		// Opaque images decode as RGBA rather than NRGBA.
+		{"rgba.png", "*image.RGBA", "*image.RGBA64"},
+	} {
+		reduced, reducedSize := embedded(markdown.Options{}, tc.name)
+		full, fullSize := embedded(markdown.Options{PreserveBitDepth: true}, tc.name)
+		if got := fmt.Sprintf("%T", reduced); got != tc.reduced {
+			t.Errorf("%s: expected 8 bits per channel by default, got %s", tc.name, got)
+		}
+		if got := fmt.Sprintf("%T", full); got != tc.full {
+			t.Errorf("%s: expected 16 bits per channel with PreserveBitDepth, got %s", tc.name, got)
+		}
+		if reducedSize >= fullSize {
+			t.Errorf("%s: reduced image is %d bytes, not smaller than %d", tc.name, reducedSize, fullSize)
+		}
+		r, _, _, _ := reduced.At(10, 10).RGBA()
+		f, _, _, _ := full.At(10, 10).RGBA()
+		if diff := int(r) - int(f); diff > 0x101 || diff < -0x101 {
+			t.Errorf("%s: reduced pixel %#x too far from %#x", tc.name, r, f)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package markdown
+
+import "strings"
+
+// codeBlockRanges returns the byte ranges [start, end) of fenced code blocks
+// (``` or ~~~) in content. Unterminated fences run to the end of the document.
+func codeBlockRanges(content string) [][2]int {
+	var ranges [][2]int
+	var fence string
+	start := 0
+	for pos := 0; pos < len(content); {
+		end := strings.IndexByte(content[pos:], '\n')
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += pos + 1
+		}
+		line := strings.TrimLeft(content[pos:end], " ")
+		if fence == "" {
+			if f := fenceMarker(line); f != "" {
+				fence, start = f, pos
+			}
+		} else if marker := fenceMarker(line); marker != "" && strings.HasPrefix(marker, fence) &&
+			strings.TrimSpace(strings.TrimLeft(line, fence[:1])) == "" {
+			ranges = append(ranges, [2]int{start, end})
+			fence = ""
+		}
+		pos = end
+	}
+	if fence != "" {
+		ranges = append(ranges, [2]int{start, len(content)})
+	}
+	return ranges
+}
+
+// fenceMarker returns the run of backticks or tildes opening line if it is
+// a code fence (at least three characters), or "".
+func fenceMarker(line string) string {
+	if len(line) < 3 || (line[0] != '`' && line[0] != '~') {
+		return ""
+	}
+	n := 0
+	for n < len(line) && line[n] == line[0] {
+		n++
+	}
+	if n < 3 {
+		return ""
+	}
+	return line[:n]
+}
+
+// inRanges reports whether pos falls inside any of the ranges.
+func inRanges(ranges [][2]int, pos int) bool {
+	for _, r := range ranges {
+		if pos >= r[0] && pos < r[1] {
+			return true
+		}
+	}
+	return false
+}
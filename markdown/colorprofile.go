@@ -0,0 +1,347 @@
+package markdown
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+)
+
+// srgbD50 holds the primaries of sRGB adapted to the D50 white of the ICC
+// profile connection space, as in the standard sRGB profile. Its columns are
+// the XYZ of red, green and blue.
+var srgbD50 = matrix3{
+	{0.4361, 0.3851, 0.1431},
+	{0.2225, 0.7169, 0.0606},
+	{0.0139, 0.0971, 0.7141},
+}
+
+// toSRGB converts a decoded image to sRGB, the color space browsers assume
+// for images without a profile, since re-encoding drops embedded profiles:
+// CMYK images are converted to RGB, and RGB images whose ICC profile is a
+// matrix profile other than sRGB (Display P3, Adobe RGB, ProPhoto...) are
+// converted through it. Other images, including those with profiles that
+// can't be interpreted, are returned unchanged.
+func toSRGB(img image.Image, mimeType string, content []byte) image.Image {
+	if cmyk, ok := img.(*image.CMYK); ok {
+		return toNRGBA(cmyk, func(c color.NRGBA) color.NRGBA { return c })
+	}
+	data := iccProfileData(mimeType, content)
+	if data == nil {
+		return img
+	}
+	profile, err := parseMatrixProfile(data)
+	if err != nil || profile.isSRGB() {
+		return img
+	}
+	return profile.convert(img)
+}
+
+// toNRGBA copies img into an NRGBA image, passing every pixel through f.
+func toNRGBA(img image.Image, f func(color.NRGBA) color.NRGBA) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			out.SetNRGBA(x-b.Min.X, y-b.Min.Y, f(c))
+		}
+	}
+	return out
+}
+
+// iccProfileData returns the ICC profile embedded in a JPEG (APP2 segments)
+// or PNG (iCCP chunk), or nil if there is none.
+func iccProfileData(mimeType string, content []byte) []byte {
+	switch mimeType {
+	case "image/jpeg":
+		return jpegICCProfile(content)
+	case "image/png":
+		return pngICCProfile(content)
+	}
+	return nil
+}
+
+// jpegICCProfile joins the ICC_PROFILE APP2 segments of a JPEG, which may
+// split a large profile, in their sequence order.
+func jpegICCProfile(content []byte) []byte {
+	const marker = "ICC_PROFILE\x00"
+	type chunk struct {
+		seq  byte
+		data []byte
+	}
+	var chunks []chunk
+	for i := 2; i+4 <= len(content) && content[i] == 0xFF; {
+		kind := content[i+1]
+		if kind == 0xD8 || (kind >= 0xD0 && kind <= 0xD7) || kind == 0x01 || kind == 0xFF {
+			i += 2
+			continue
+		}
+		if kind == 0xDA || kind == 0xD9 {
+			break // Image data follows; profiles come before it.
+		}
+		length := int(binary.BigEndian.Uint16(content[i+2:]))
+		if length < 2 || i+2+length > len(content) {
+			break
+		}
+		segment := content[i+4 : i+2+length]
+		if kind == 0xE2 && len(segment) > len(marker)+2 && string(segment[:len(marker)]) == marker {
+			chunks = append(chunks, chunk{seq: segment[len(marker)], data: segment[len(marker)+2:]})
+		}
+		i += 2 + length
+	}
+	if len(chunks) == 0 {
+		return nil
+	}
+	sort.SliceStable(chunks, func(a, b int) bool { return chunks[a].seq < chunks[b].seq })
+	var data []byte
+	for _, c := range chunks {
+		data = append(data, c.data...)
+	}
+	return data
+}
+
+// pngICCProfile returns the decompressed profile of a PNG's iCCP chunk.
+func pngICCProfile(content []byte) []byte {
+	const signature = "\x89PNG\r\n\x1a\n"
+	if !bytes.HasPrefix(content, []byte(signature)) {
+		return nil
+	}
+	for i := len(signature); i+8 <= len(content); {
+		length := int(binary.BigEndian.Uint32(content[i:]))
+		kind := string(content[i+4 : i+8])
+		if length < 0 || i+12+length > len(content) || kind == "IDAT" {
+			return nil
+		}
+		if kind == "iCCP" {
+			chunk := content[i+8 : i+8+length]
+			// A profile name, a zero byte and the compression method precede
+			// the zlib stream.
+			name := bytes.IndexByte(chunk, 0)
+			if name < 0 || name+2 > len(chunk) {
+				return nil
+			}
+			r, err := zlib.NewReader(bytes.NewReader(chunk[name+2:]))
+			if err != nil {
+				return nil
+			}
+			defer r.Close()
+			data, err := io.ReadAll(io.LimitReader(r, 1<<24))
+			if err != nil {
+				return nil
+			}
+			return data
+		}
+		i += 12 + length
+	}
+	return nil
+}
+
+// matrix3 is a 3x3 matrix, row by row.
+type matrix3 [3][3]float64
+
+func (m matrix3) mul(n matrix3) matrix3 {
+	var r matrix3
+	for i := range 3 {
+		for j := range 3 {
+			for k := range 3 {
+				r[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return r
+}
+
+func (m matrix3) inverse() (matrix3, bool) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	if math.Abs(det) < 1e-9 {
+		return matrix3{}, false
+	}
+	var r matrix3
+	for i := range 3 {
+		for j := range 3 {
+			// The cofactor of m[j][i], divided by the determinant.
+			a, b := (j+1)%3, (j+2)%3
+			c, d := (i+1)%3, (i+2)%3
+			r[i][j] = (m[a][c]*m[b][d] - m[a][d]*m[b][c]) / det
+		}
+	}
+	return r, true
+}
+
+// toneCurve maps an encoded channel value in [0, 1] to linear light.
+type toneCurve func(float64) float64
+
+// matrixProfile is an RGB ICC profile defined by primaries and tone curves.
+type matrixProfile struct {
+	// primaries has the D50 XYZ of red, green and blue as columns.
+	primaries matrix3
+	curves    [3]toneCurve
+}
+
+// parseMatrixProfile reads the primaries and tone curves of an RGB profile.
+// Profiles defined by lookup tables instead are not supported.
+func parseMatrixProfile(data []byte) (*matrixProfile, error) {
+	if len(data) < 132 || string(data[16:20]) != "RGB " || string(data[20:24]) != "XYZ " {
+		return nil, errors.New("not an RGB profile")
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := range count {
+		entry := 132 + 12*i
+		if entry+12 > len(data) {
+			return nil, errors.New("truncated tag table")
+		}
+		offset, size := binary.BigEndian.Uint32(data[entry+4:]), binary.BigEndian.Uint32(data[entry+8:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, errors.New("tag out of range")
+		}
+		tags[string(data[entry:entry+4])] = data[offset : offset+size]
+	}
+	var p matrixProfile
+	for i, name := range []string{"r", "g", "b"} {
+		xyz, ok := tags[name+"XYZ"]
+		if !ok || len(xyz) < 20 || string(xyz[:4]) != "XYZ " {
+			return nil, errors.New("no matrix")
+		}
+		for j := range 3 {
+			p.primaries[j][i] = s15Fixed16(xyz[8+4*j:])
+		}
+		curve, err := parseToneCurve(tags[name+"TRC"])
+		if err != nil {
+			return nil, err
+		}
+		p.curves[i] = curve
+	}
+	return &p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseToneCurve reads a curveType or parametricCurveType tag.
+func parseToneCurve(tag []byte) (toneCurve, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("no tone curve")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(x float64) float64 { return x }, nil
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, gamma) }, nil
+		case len(tag) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+			}
+			return func(x float64) float64 {
+				pos := x * float64(n-1)
+				i := min(int(pos), n-2)
+				return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+			}, nil
+		}
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		counts := map[uint16]int{0: 1, 1: 3, 2: 4, 3: 5, 4: 7}
+		n, ok := counts[kind]
+		if !ok || len(tag) < 12+4*n {
+			break
+		}
+		// g, a, b, c, d, e, f as in the ICC specification; unused ones
+		// default so that every type is a case of type 4.
+		v := [7]float64{1, 1, 0, 0, 0, 0, 0}
+		for i := range n {
+			v[i] = s15Fixed16(tag[12+4*i:])
+		}
+		g, a, b, c, d, e, f := v[0], v[1], v[2], v[3], v[4], v[5], v[6]
+		switch kind {
+		case 1, 2:
+			// Below -b/a the curve is c (zero for type 1).
+			d, e, f, c = -b/a, c, c, 0
+		}
+		return func(x float64) float64 {
+			if x >= d {
+				return math.Pow(max(a*x+b, 0), g) + e
+			}
+			return c*x + f
+		}, nil
+	}
+	return nil, errors.New("unsupported tone curve")
+}
+
+// srgbDecode and srgbEncode convert between sRGB values and linear light.
+func srgbDecode(x float64) float64 {
+	if x <= 0.04045 {
+		return x / 12.92
+	}
+	return math.Pow((x+0.055)/1.055, 2.4)
+}
+
+func srgbEncode(x float64) float64 {
+	if x <= 0.0031308 {
+		return 12.92 * x
+	}
+	return 1.055*math.Pow(x, 1/2.4) - 0.055
+}
+
+// isSRGB reports whether converting through the profile would change
+// nothing visible.
+func (p *matrixProfile) isSRGB() bool {
+	for i := range 3 {
+		for j := range 3 {
+			if math.Abs(p.primaries[i][j]-srgbD50[i][j]) > 0.003 {
+				return false
+			}
+		}
+	}
+	for _, curve := range p.curves {
+		for _, x := range []float64{0.1, 0.25, 0.5, 0.75, 0.9} {
+			if math.Abs(curve(x)-srgbDecode(x)) > 0.01 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// convert maps the pixels of img from the profile's space to sRGB. Colors
+// outside the sRGB gamut are clipped.
+func (p *matrixProfile) convert(img image.Image) image.Image {
+	toSRGB, ok := srgbD50.inverse()
+	if !ok {
+		return img
+	}
+	m := toSRGB.mul(p.primaries)
+	var linear [3][256]float64
+	for c, curve := range p.curves {
+		for v := range 256 {
+			linear[c][v] = curve(float64(v) / 255)
+		}
+	}
+	const steps = 4096
+	var encode [steps + 1]uint8
+	for i := range encode {
+		encode[i] = uint8(math.Round(255 * srgbEncode(float64(i)/steps)))
+	}
+	return toNRGBA(img, func(c color.NRGBA) color.NRGBA {
+		in := [3]float64{linear[0][c.R], linear[1][c.G], linear[2][c.B]}
+		var out [3]uint8
+		for i := range 3 {
+			v := m[i][0]*in[0] + m[i][1]*in[1] + m[i][2]*in[2]
+			out[i] = encode[int(math.Round(min(max(v, 0), 1)*steps))]
+		}
+		return color.NRGBA{R: out[0], G: out[1], B: out[2], A: c.A}
+	})
+}
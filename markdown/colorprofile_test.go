@@ -0,0 +1,159 @@
+package markdown_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/base64"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+// testICCProfile builds an RGB matrix profile with the sRGB tone curve and
+// the given D50 primaries, one XYZ column per channel.
+func testICCProfile(primaries [3][3]float64) []byte {
+	fixed := func(v float64) []byte {
+		return binary.BigEndian.AppendUint32(nil, uint32(int32(v*65536)))
+	}
+	var tags [][]byte
+	for _, xyz := range primaries {
+		tag := []byte("XYZ \x00\x00\x00\x00")
+		for _, v := range xyz {
+			tag = append(tag, fixed(v)...)
+		}
+		tags = append(tags, tag)
+	}
+	trc := []byte("para\x00\x00\x00\x00\x00\x03\x00\x00")
+	for _, v := range []float64{2.4, 1 / 1.055, 0.055 / 1.055, 1 / 12.92, 0.04045} {
+		trc = append(trc, fixed(v)...)
+	}
+	tags = append(tags, trc, trc, trc)
+
+	header := make([]byte, 128)
+	copy(header[12:], "mntr")
+	copy(header[16:], "RGB ")
+	copy(header[20:], "XYZ ")
+	copy(header[36:], "acsp")
+	table := binary.BigEndian.AppendUint32(nil, uint32(len(tags)))
+	offset := 128 + 4 + 12*len(tags)
+	var data []byte
+	for i, sig := range []string{"rXYZ", "gXYZ", "bXYZ", "rTRC", "gTRC", "bTRC"} {
+		table = append(table, sig...)
+		table = binary.BigEndian.AppendUint32(table, uint32(offset+len(data)))
+		table = binary.BigEndian.AppendUint32(table, uint32(len(tags[i])))
+		data = append(data, tags[i]...)
+	}
+	profile := append(append(header, table...), data...)
+	binary.BigEndian.PutUint32(profile, uint32(len(profile)))
+	return profile
+}
+
+// withPNGProfile inserts an iCCP chunk holding profile after the IHDR chunk.
+func withPNGProfile(t *testing.T, content, profile []byte) []byte {
+	t.Helper()
+	var compressed bytes.Buffer
+	w := zlib.NewWriter(&compressed)
+	w.Write(profile)
+	w.Close()
+	chunk := append([]byte("iCCPtest\x00\x00"), compressed.Bytes()...)
+	var out []byte
+	out = append(out, content[:33]...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(chunk)-4))
+	out = append(out, chunk...)
+	out = binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(chunk))
+	return append(out, content[33:]...)
+}
+
+// withJPEGProfile inserts an ICC_PROFILE APP2 segment after the SOI marker.
+func withJPEGProfile(content, profile []byte) []byte {
+	segment := append([]byte("ICC_PROFILE\x00\x01\x01"), profile...)
+	out := append([]byte{0xFF, 0xD8, 0xFF, 0xE2}, binary.BigEndian.AppendUint16(nil, uint16(len(segment)+2))...)
+	out = append(out, segment...)
+	return append(out, content[2:]...)
+}
+
+// embeddedPixel processes a reference to name and returns the pixel at x, y
+// of the embedded image.
+func embeddedPixel(t *testing.T, dir, name string, x, y int) color.NRGBA {
+	t.Helper()
+	result, err := markdown.NewProcessor(markdown.Options{}).Process("![i]("+name+")", dir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	match := regexp.MustCompile(`base64,([A-Za-z0-9+/=]+)`).FindStringSubmatch(result.Content)
+	if match == nil {
+		t.Fatalf("Expected %s to be embedded, got %q", name, result.Content)
+	}
+	data, _ := base64.StdEncoding.DecodeString(match[1])
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Could not decode the embedded %s: %v", name, err)
+	}
+	return color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+}
+
+func near(a, b color.NRGBA, tolerance int) bool {
+	d := func(x, y uint8) bool { return int(x)-int(y) <= tolerance && int(y)-int(x) <= tolerance }
+	return d(a.R, b.R) && d(a.G, b.G) && d(a.B, b.B) && a.A == b.A
+}
+
+func TestColorProfileConversion(t *testing.T) {
+	srgb := [3][3]float64{{0.4361, 0.2225, 0.0139}, {0.3851, 0.7169, 0.0971}, {0.1431, 0.0606, 0.7141}}
+	// In the swapped space the red channel holds sRGB green and vice versa.
+	swapped := [3][3]float64{srgb[1], srgb[0], srgb[2]}
+
+	dir := t.TempDir()
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 1))
+	img.SetNRGBA(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.SetNRGBA(1, 0, color.NRGBA{0, 0, 255, 128})
+	img.SetNRGBA(2, 0, color.NRGBA{200, 100, 50, 255})
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	for name, profile := range map[string][3][3]float64{"swapped.png": swapped, "srgb.png": srgb} {
+		if err := os.WriteFile(filepath.Join(dir, name), withPNGProfile(t, buf.Bytes(), testICCProfile(profile)), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		name string
+		x    int
+		want color.NRGBA
+	}{
+		{"swapped.png", 0, color.NRGBA{0, 255, 0, 255}},
+		{"swapped.png", 1, color.NRGBA{0, 0, 255, 128}},
+		{"srgb.png", 2, color.NRGBA{200, 100, 50, 255}},
+	} {
+		if got := embeddedPixel(t, dir, tc.name, tc.x, 0); !near(got, tc.want, 1) {
+			t.Errorf("Pixel %d of %s = %v; want %v", tc.x, tc.name, got, tc.want)
+		}
+	}
+
+	solid := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range solid.Pix {
+		if i%4 == 0 || i%4 == 3 {
+			solid.Pix[i] = 255
+		}
+	}
+	buf.Reset()
+	if err := jpeg.Encode(&buf, solid, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "swapped.jpg"), withJPEGProfile(buf.Bytes(), testICCProfile(swapped)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := embeddedPixel(t, dir, "swapped.jpg", 4, 4); !near(got, color.NRGBA{0, 255, 0, 255}, 12) {
+		t.Errorf("Pixel of swapped.jpg = %v; want green", got)
+	}
+}
@@ -0,0 +1,138 @@
+package markdown_test
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// TestProcessConcurrent shares one Processor between goroutines; run with
+// -race to check the synchronization.
+func TestProcessConcurrent(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 6, 6)
+	writeTestPNG(t, tempDir, "b.png", 3, 9)
+	var remote bytes.Buffer
+	if err := png.Encode(&remote, image.NewGray(image.Rect(0, 0, 5, 5))); err != nil {
+		t.Fatal(err)
+	}
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(remote.Bytes())
+	}))
+	defer server.Close()
+
+	content := "![a](a.png) ![b](b.png){: width=2}\n![r](" + server.URL + "/r.png)\n![a again](a.png)\n"
+	processor := markdown.NewProcessor(markdown.Options{CacheDir: t.TempDir()})
+	const workers = 16
+	results := make([]string, workers)
+	var wg sync.WaitGroup
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if i%4 == 0 {
+				markdown.RegisterTransform("noop-concurrent", func(string) (markdown.Transform, error) {
+					return markdown.TransformFunc(func(img image.Image) (image.Image, error) { return img, nil }), nil
+				})
+			}
+			result, err := processor.Process(content, tempDir)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			for _, img := range result.Images {
+				if !img.Embedded {
+					t.Errorf("Image %s not embedded: %v", img.Reference.ImagePath, img.Err)
+				}
+			}
+			page, err := markdown.RenderHTML(result.Content, markdown.HTMLOptions{Highlight: "github", TOCDepth: 2})
+			if err != nil {
+				t.Error(err)
+			}
+			if _, errs := processor.InlineStylesheets(page, tempDir); len(errs) > 0 {
+				t.Error(errs)
+			}
+			results[i] = result.Content
+		}()
+	}
+	wg.Wait()
+
+	for i := 1; i < workers; i++ {
+		if results[i] != results[0] {
+			t.Fatalf("Worker %d produced different output", i)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("Expected the remote image to be downloaded once, got %d requests", n)
+	}
+}
+
+// TestJobs checks that Jobs downloads a document's images at once without
+// changing the output.
+func TestJobs(t *testing.T) {
+	var remote bytes.Buffer
+	if err := png.Encode(&remote, image.NewGray(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+	var inFlight, most atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for m := most.Load(); n > m && !most.CompareAndSwap(m, n); m = most.Load() {
+		}
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(remote.Bytes())
+	}))
+	defer server.Close()
+
+	var content strings.Builder
+	for i := range 8 {
+		fmt.Fprintf(&content, "![%d](%s/%d.png)\n", i, server.URL, i)
+	}
+	content.WriteString("<!-- mdimg: skip -->\n![skipped](" + server.URL + "/skipped.png)\n")
+	want, err := markdown.NewProcessor(markdown.Options{}).Process(content.String(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := most.Load(); n != 1 {
+		t.Fatalf("Expected images one at a time without Jobs, got %d at once", n)
+	}
+	most.Store(0)
+
+	var done []int
+	got, err := markdown.NewProcessor(markdown.Options{Jobs: 4, Progress: func(pr markdown.Progress) {
+		done = append(done, pr.Done)
+	}}).Process(content.String(), ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Content != want.Content {
+		t.Errorf("Expected the same output with Jobs, got:\n%s\nwant:\n%s", got.Content, want.Content)
+	}
+	if n := most.Load(); n < 2 || n > 4 {
+		t.Errorf("Expected 2 to 4 images at once with Jobs 4, got %d", n)
+	}
+	for i, img := range got.Images {
+		if img.Reference.ImagePath != want.Images[i].Reference.ImagePath || img.Embedded != want.Images[i].Embedded {
+			t.Errorf("Image %d: got %+v, want %+v", i, img, want.Images[i])
+		}
+	}
+	if len(done) != 10 || done[9] != 9 || !slices.IsSorted(done) {
+		t.Errorf("Expected progress reports for 9 images and the end, got %v", done)
+	}
+}
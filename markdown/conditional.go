@@ -0,0 +1,125 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The variants of a document that conditional sections select between: the
+// embedded output, and the document linking to its images.
+const (
+	VariantEmbedded = "embedded"
+	VariantLinked   = "linked"
+)
+
+// conditionalRegex matches the markers of a conditional section:
+// <!-- if:embedded -->, <!-- else --> and <!-- endif -->.
+var conditionalRegex = regexp.MustCompile(`<!--\s*(?:if:\s*(\S*?)|(else)|(endif))\s*-->`)
+
+// ResolveConditionals keeps the sections of content written for variant
+// and removes those written for the other, such as download instructions
+// that only the linked document needs:
+//
+//	<!-- if:linked -->
+//	Download the images from the assets directory.
+//	<!-- else -->
+//	The images are embedded in this document.
+//	<!-- endif -->
+//
+// The markers are removed too, with their lines if they stand alone.
+// Sections may be nested. Markers in code blocks and code spans are left
+// alone, and so are the comments of documents with no embedded or linked
+// section, and sections for other conditions, such as <!-- if:draft -->,
+// with their else and endif markers.
+func ResolveConditionals(content, variant string) (string, error) {
+	type section struct {
+		line int
+		// keep is set while the section's current branch is kept; parent
+		// if the enclosing section's is. foreign sections are for another
+		// condition and keep their markers.
+		keep, parent, inElse, foreign bool
+	}
+	var open []section
+	keeping := func() bool { return len(open) == 0 || open[len(open)-1].keep }
+	codeBlocks := codeBlockRanges(content)
+	var markers [][]int
+	used := false
+	for _, m := range conditionalRegex.FindAllStringSubmatchIndex(content, -1) {
+		if inRanges(codeBlocks, m[0]) || inCodeSpan(content, m[0]) {
+			continue
+		}
+		markers = append(markers, m)
+		if m[2] >= 0 && isVariant(content[m[2]:m[3]]) {
+			used = true
+		}
+	}
+	if !used {
+		return content, nil
+	}
+
+	var b strings.Builder
+	last := 0
+	for _, m := range markers {
+		line := strings.Count(content[:m[0]], "\n") + 1
+		switch {
+		case m[2] >= 0 && !isVariant(content[m[2]:m[3]]):
+			open = append(open, section{line: line, keep: keeping(), parent: keeping(), foreign: true})
+			continue
+		case len(open) > 0 && open[len(open)-1].foreign:
+			if m[6] >= 0 {
+				open = open[:len(open)-1]
+			}
+			continue
+		}
+		start, end := markerLine(content, m[0], m[1])
+		if keeping() {
+			b.WriteString(content[last:start])
+		}
+		last = end
+		switch {
+		case m[2] >= 0:
+			open = append(open, section{line: line, keep: keeping() && content[m[2]:m[3]] == variant, parent: keeping()})
+		case m[4] >= 0:
+			if len(open) == 0 || open[len(open)-1].inElse {
+				return "", fmt.Errorf("line %d: <!-- else --> without a matching <!-- if:variant -->", line)
+			}
+			top := &open[len(open)-1]
+			top.keep, top.inElse = top.parent && !top.keep, true
+		default:
+			if len(open) == 0 {
+				return "", fmt.Errorf("line %d: <!-- endif --> without a matching <!-- if:variant -->", line)
+			}
+			open = open[:len(open)-1]
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		if !open[i].foreign {
+			return "", fmt.Errorf("line %d: <!-- if:variant --> without a matching <!-- endif -->", open[i].line)
+		}
+	}
+	b.WriteString(content[last:])
+	return b.String(), nil
+}
+
+// isVariant reports whether name is one of the variants conditional
+// sections select between.
+func isVariant(name string) bool {
+	return name == VariantEmbedded || name == VariantLinked
+}
+
+// markerLine widens the marker at content[start:end] to its whole line,
+// line break included, if nothing else is on it.
+func markerLine(content string, start, end int) (int, int) {
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	lineEnd := strings.IndexByte(content[end:], '\n')
+	if lineEnd < 0 {
+		lineEnd = len(content)
+	} else {
+		lineEnd += end + 1
+	}
+	if strings.TrimSpace(content[lineStart:start]) != "" || strings.TrimSpace(content[end:lineEnd]) != "" {
+		return start, end
+	}
+	return lineStart, lineEnd
+}
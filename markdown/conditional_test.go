@@ -0,0 +1,59 @@
+package markdown_test
+
+import (
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestResolveConditionals(t *testing.T) {
+	content := "# Guide\n<!-- if:linked -->\nDownload the assets.\n<!-- else -->\nImages are embedded.\n<!-- endif -->\n" +
+		"Size: <!-- if:embedded -->one file<!-- endif --><!-- if:linked -->a folder<!-- endif -->.\n" +
+		"<!--if:embedded-->\nOffline copy.\n<!-- if:linked -->\nNever shown.\n<!-- endif -->\n<!-- endif -->\n" +
+		"```\n<!-- if:linked -->\n```\n`<!-- endif -->`\n"
+	testCases := []struct {
+		variant string
+		want    string
+	}{
+		{
+			variant: markdown.VariantEmbedded,
+			want:    "# Guide\nImages are embedded.\nSize: one file.\nOffline copy.\n```\n<!-- if:linked -->\n```\n`<!-- endif -->`\n",
+		},
+		{
+			variant: markdown.VariantLinked,
+			want:    "# Guide\nDownload the assets.\nSize: a folder.\n```\n<!-- if:linked -->\n```\n`<!-- endif -->`\n",
+		},
+	}
+	for _, tc := range testCases {
+		got, err := markdown.ResolveConditionals(content, tc.variant)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Variant %s: got:\n%s\nwant:\n%s", tc.variant, got, tc.want)
+		}
+	}
+
+	// Other conditions, and documents without sections, are left alone.
+	for _, tc := range []struct{ content, want string }{
+		{"<!-- if:draft -->\nx\n<!-- endif -->\n<!-- else -->\n", "<!-- if:draft -->\nx\n<!-- endif -->\n<!-- else -->\n"},
+		{"<!-- if:linked -->\na\n<!-- if:draft -->\nb\n<!-- else -->\nc\n<!-- endif -->\n<!-- endif -->\n<!-- if:x -->\n", "<!-- if:x -->\n"},
+		{"<!-- if:embedded -->\n<!-- if:draft -->\nb\n<!-- else -->\nc\n<!-- endif -->\n<!-- endif -->\n", "<!-- if:draft -->\nb\n<!-- else -->\nc\n<!-- endif -->\n"},
+	} {
+		got, err := markdown.ResolveConditionals(tc.content, markdown.VariantEmbedded)
+		if err != nil || got != tc.want {
+			t.Errorf("ResolveConditionals(%q) = %q, %v; want %q", tc.content, got, err, tc.want)
+		}
+	}
+
+	for _, bad := range []string{
+		"<!-- if:linked -->\nx\n",
+		"<!-- if:linked -->\nx\n<!-- endif -->\n<!-- endif -->\n",
+		"<!-- else -->\n<!-- if:linked -->\nx\n<!-- endif -->\n",
+		"<!-- if:linked -->\n<!-- else -->\n<!-- else -->\n<!-- endif -->\n",
+	} {
+		if _, err := markdown.ResolveConditionals(bad, markdown.VariantEmbedded); err == nil {
+			t.Errorf("Expected an error for %q", bad)
+		}
+	}
+}
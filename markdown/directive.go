@@ -0,0 +1,98 @@
+package markdown
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// directivePrefix introduces a per-image directive comment:
+//
+//	<!-- mdimg: quality=60 max-width=800 -->
+//	![chart](chart.png)
+//
+// fallback=path-or-URL, which may be repeated, gives the sources to try in
+// turn if the image's own can't be read.
+const directivePrefix = "mdimg:"
+
+// Directive holds per-image overrides of the global options, read from a
+// directive comment placed immediately before the image. Zero values mean
+// "not set".
+type Directive struct {
+	Quality   int
+	MaxWidth  int
+	MaxHeight int
+	Width     int
+	Height    int
+	// Skip leaves the image reference untouched.
+	Skip bool
+	// Fallbacks are the paths or URLs to embed instead, in order, if the
+	// image's own can't be read (see Fallback).
+	Fallbacks []string
+	// Tags are the profile names given as |tag suffixes of the alt text
+	// (see TagProfile), rather than in the directive comment.
+	Tags []string
+}
+
+// findDirective looks for a directive comment that ends right before pos,
+// separated from it by whitespace only.
+func findDirective(content string, pos int) (Directive, bool, error) {
+	before := strings.TrimRight(content[:pos], " \t\r\n")
+	if !strings.HasSuffix(before, "-->") {
+		return Directive{}, false, nil
+	}
+	start := strings.LastIndex(before, "<!--")
+	// In "<!-->" the opening and closing delimiters overlap.
+	if start < 0 || start+len("<!--") > len(before)-len("-->") {
+		return Directive{}, false, nil
+	}
+	body := strings.TrimSpace(before[start+len("<!--") : len(before)-len("-->")])
+	if !strings.HasPrefix(body, directivePrefix) {
+		return Directive{}, false, nil
+	}
+	d, err := parseDirective(strings.TrimPrefix(body, directivePrefix))
+	return d, true, err
+}
+
+// parseDirective parses space-separated key=value settings.
+func parseDirective(s string) (Directive, error) {
+	var d Directive
+	for _, field := range strings.Fields(s) {
+		key, value, hasValue := strings.Cut(field, "=")
+		if key == "skip" && !hasValue {
+			d.Skip = true
+			continue
+		}
+		if key == "fallback" {
+			if value == "" {
+				return d, fmt.Errorf("invalid value in directive setting %q", field)
+			}
+			d.Fallbacks = append(d.Fallbacks, value)
+			continue
+		}
+		var target *int
+		switch key {
+		case "quality":
+			target = &d.Quality
+		case "max-width":
+			target = &d.MaxWidth
+		case "max-height":
+			target = &d.MaxHeight
+		case "width":
+			target = &d.Width
+		case "height":
+			target = &d.Height
+		default:
+			return d, fmt.Errorf("unknown directive setting %q", field)
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return d, fmt.Errorf("invalid value in directive setting %q", field)
+		}
+		*target = n
+	}
+	if d.Quality > 100 {
+		return d, fmt.Errorf("quality must be between 1 and 100, got %d", d.Quality)
+	}
+	return d, nil
+}
@@ -0,0 +1,141 @@
+package markdown_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTestJPEG writes a noisy JPEG, so that quality settings affect its size.
+func writeTestJPEG(t *testing.T, dir, name string, width, height int) {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			img.Set(x, y, color.RGBA{uint8(x * y), uint8(x + 3*y), uint8(7 * x), 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatalf("Failed to encode test JPEG: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test JPEG: %v", err)
+	}
+}
+
+func TestDirectives(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestJPEG(t, tempDir, "photo.jpg", 120, 80)
+	writeTestPNG(t, tempDir, "wide.png", 900, 100)
+
+	testCases := []struct {
+		name       string
+		opts       markdown.Options
+		markdown   string
+		wantWidth  int
+		wantHeight int
+		wantSkip   bool
+	}{
+		{
+			name:      "Default max width",
+			markdown:  "![wide](wide.png)",
+			wantWidth: 400, wantHeight: 44,
+		},
+		{
+			name:      "Directive raises max width",
+			markdown:  "<!-- mdimg: max-width=800 -->\n![wide](wide.png)",
+			wantWidth: 800, wantHeight: 88,
+		},
+		{
+			name:      "Global max width disabled",
+			opts:      markdown.Options{MaxWidth: -1},
+			markdown:  "![wide](wide.png)",
+			wantWidth: 900, wantHeight: 100,
+		},
+		{
+			name:      "Directive max height",
+			markdown:  "<!-- mdimg: max-height=50 -->  ![wide](wide.png)",
+			wantWidth: 400, wantHeight: 44,
+		},
+		{
+			name:      "Directive dimensions",
+			markdown:  "<!-- mdimg: width=30 height=20 -->\n![photo](photo.jpg){: width=60}",
+			wantWidth: 30, wantHeight: 20,
+		},
+		{
+			name:      "Directive only applies to the next image",
+			markdown:  "<!-- mdimg: width=30 -->\n\nSome text\n![photo](photo.jpg)",
+			wantWidth: 120, wantHeight: 80,
+		},
+		{
+			name:     "Skip",
+			markdown: "<!-- mdimg: skip -->\n![photo](photo.jpg)",
+			wantSkip: true,
+		},
+		{
+			name:      "Invalid directive is ignored",
+			markdown:  "<!-- mdimg: quality=lots -->\n![photo](photo.jpg)",
+			wantWidth: 120, wantHeight: 80,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(tc.opts).Process(tc.markdown, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			img := result.Images[0]
+			if tc.wantSkip {
+				if img.Embedded || img.SkipReason == "" {
+					t.Errorf("Expected image to be skipped, got %+v", img)
+				}
+				if result.Content != tc.markdown {
+					t.Errorf("Expected content to be unchanged, got %q", result.Content)
+				}
+				return
+			}
+			if img.Width != tc.wantWidth || img.Height != tc.wantHeight {
+				t.Errorf("Expected %dx%d, got %dx%d", tc.wantWidth, tc.wantHeight, img.Width, img.Height)
+			}
+			if !strings.Contains(result.Content, "<!-- mdimg:") && strings.Contains(tc.markdown, "<!-- mdimg:") {
+				t.Errorf("Expected the directive comment to be preserved")
+			}
+		})
+	}
+}
+
+func TestDirectiveQuality(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestJPEG(t, tempDir, "photo.jpg", 120, 80)
+
+	sizeWith := func(opts markdown.Options, doc string) int {
+		result, err := markdown.NewProcessor(opts).Process(doc, tempDir)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		return result.Images[0].EncodedSize
+	}
+
+	defaultSize := sizeWith(markdown.Options{}, "![p](photo.jpg)")
+	lowSize := sizeWith(markdown.Options{}, "<!-- mdimg: quality=20 -->\n![p](photo.jpg)")
+	globalLowSize := sizeWith(markdown.Options{Quality: 20}, "![p](photo.jpg)")
+	overriddenSize := sizeWith(markdown.Options{Quality: 20}, "<!-- mdimg: quality=85 -->\n![p](photo.jpg)")
+
+	if lowSize >= defaultSize {
+		t.Errorf("Expected quality=20 directive to shrink the image: %d >= %d", lowSize, defaultSize)
+	}
+	if globalLowSize != lowSize {
+		t.Errorf("Expected global quality 20 to match the directive: %d != %d", globalLowSize, lowSize)
+	}
+	if overriddenSize != defaultSize {
+		t.Errorf("Expected directive to override the global quality: %d != %d", overriddenSize, defaultSize)
+	}
+}
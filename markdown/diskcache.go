@@ -0,0 +1,104 @@
+package markdown
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// diskCacheVersion is part of every key, so changing how images are encoded
+// invalidates old entries.
+const diskCacheVersion = 3
+
+// diskCacheEntry is one encoded image stored under Options.CacheDir.
+type diskCacheEntry struct {
+	MIMEType string `json:"mime_type"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int    `json:"size"`
+	Data     string `json:"data"`
+}
+
+// diskCacheKey derives the cache key for re-encoding a raster image: the
+// hash of its bytes plus every parameter that affects the output. It returns
+// "" if the result can't be keyed, such as with pipelines built in code.
+func (p *Processor) diskCacheKey(ref ImageReference, sourceHash, mimeType string) string {
+	maxWidth, maxHeight := p.maxDimensions(ref.Directive)
+	var pipelines []string
+	for _, pipeline := range p.opts.Pipelines {
+		if !pipeline.Matches(ref.ImagePath) {
+			continue
+		}
+		if pipeline.Spec == "" {
+			return ""
+		}
+		pipelines = append(pipelines, pipeline.Spec)
+	}
+	if _, registered := lookupFormat(mimeType); registered {
+		// Third-party codecs may change without notice.
+		return ""
+	}
+	params := fmt.Sprintf("v%d|%s|%s|%dx%d|max %dx%d|q%d|16-bit %t|%s|%s",
+		diskCacheVersion, sourceHash, mimeType, ref.Width, ref.Height, maxWidth, maxHeight,
+		p.quality(ref.Directive), p.opts.PreserveBitDepth, strings.Join(pipelines, "\x00"), p.profileKey(ref.Directive))
+	sum := sha256.Sum256([]byte(params))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Processor) diskCachePath(key string) string {
+	return filepath.Join(p.opts.CacheDir, key[:2], key+".json")
+}
+
+// loadDiskCache returns the cached encoding for key, if any. Unreadable
+// entries are treated as misses.
+func (p *Processor) loadDiskCache(key string) (diskCacheEntry, bool) {
+	if p.opts.CacheDir == "" || key == "" {
+		return diskCacheEntry{}, false
+	}
+	data, err := os.ReadFile(p.diskCachePath(key))
+	if err != nil {
+		return diskCacheEntry{}, false
+	}
+	var entry diskCacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Data == "" {
+		return diskCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// storeDiskCache saves an encoding. Failing to write the cache never fails
+// the run.
+func (p *Processor) storeDiskCache(key string, entry diskCacheEntry) {
+	if p.opts.CacheDir == "" || key == "" {
+		return
+	}
+	path := p.diskCachePath(key)
+	data, err := json.Marshal(entry)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+	}
+	if err == nil {
+		// Write then rename, so concurrent runs and goroutines never see
+		// half an entry.
+		var tmp *os.File
+		if tmp, err = os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp"); err == nil {
+			_, err = tmp.Write(data)
+			if closeErr := tmp.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(tmp.Name(), path)
+			}
+			if err != nil {
+				os.Remove(tmp.Name())
+			}
+		}
+	}
+	if err != nil {
+		p.log.Debug("Could not write cache entry", "path", path, "error", err)
+	}
+}
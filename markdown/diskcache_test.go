@@ -0,0 +1,59 @@
+package markdown_test
+
+import (
+	"image"
+	"markdown-images/markdown"
+	"testing"
+)
+
+func TestDiskCache(t *testing.T) {
+	tempDir := t.TempDir()
+	cacheDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 40, 20)
+
+	var encodes int
+	markdown.RegisterTransform("count-encodes", func(arg string) (markdown.Transform, error) {
+		return markdown.TransformFunc(func(img image.Image) (image.Image, error) {
+			encodes++
+			return img, nil
+		}), nil
+	})
+	pipeline, err := markdown.ParsePipeline("count-encodes")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	run := func(opts markdown.Options) *markdown.Result {
+		t.Helper()
+		opts.CacheDir = cacheDir
+		opts.Pipelines = []markdown.Pipeline{pipeline}
+		result, err := markdown.NewProcessor(opts).Process("![a](a.png){: width=20}", tempDir)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		return result
+	}
+
+	first := run(markdown.Options{})
+	second := run(markdown.Options{})
+	if encodes != 1 {
+		t.Errorf("Expected the second run to be served from the cache, got %d encodes", encodes)
+	}
+	if first.Content != second.Content || second.Images[0].Width != 20 || second.Images[0].Height != 10 {
+		t.Errorf("Cached result differs: %+v vs %+v", first.Images[0], second.Images[0])
+	}
+
+	run(markdown.Options{AttrStyle: markdown.AttrStyleHTML})
+	if encodes != 1 {
+		t.Errorf("Output formatting should not invalidate the cache, got %d encodes", encodes)
+	}
+	run(markdown.Options{MaxWidth: 10})
+	if encodes != 2 {
+		t.Errorf("Expected a new encode after changing parameters, got %d encodes", encodes)
+	}
+
+	writeTestPNG(t, tempDir, "a.png", 40, 40)
+	if changed := run(markdown.Options{}); encodes != 3 || changed.Images[0].Height != 20 {
+		t.Errorf("Expected a new encode after the source changed, got %d encodes, %+v", encodes, changed.Images[0])
+	}
+}
@@ -0,0 +1,67 @@
+package markdown
+
+import (
+	"fmt"
+	"strings"
+)
+
+// document holds the per-call state of Process, so a Processor itself keeps
+// no state between documents.
+type document struct {
+	content string
+	// newline is the document's line ending, used for every line we add.
+	newline string
+	// definitions are the reference-style definitions in order of first use.
+	definitions []string
+	// labels maps a definition's destination to its label.
+	labels    map[string]string
+	nextLabel int
+}
+
+func newDocument(content string) *document {
+	return &document{content: content, newline: DetectNewline(content), labels: make(map[string]string)}
+}
+
+// reference returns the label of the reference definition for dataURI and
+// title, adding the definition on first use.
+func (d *document) reference(dataURI, title string) string {
+	dest := markdownDestination(dataURI, title)
+	if label, ok := d.labels[dest]; ok {
+		return label
+	}
+	var label string
+	for {
+		d.nextLabel++
+		label = fmt.Sprintf("img%d", d.nextLabel)
+		// Don't clash with labels the author already uses.
+		if !strings.Contains(strings.ToLower(d.content), "["+label+"]") {
+			break
+		}
+	}
+	d.labels[dest] = label
+	d.definitions = append(d.definitions, fmt.Sprintf("[%s]: %s", label, dest))
+	return label
+}
+
+// writeDefinitions appends the collected reference definitions, separated
+// from the body by a blank line. Whether the document ends with a line
+// ending is preserved.
+func (d *document) writeDefinitions(b *strings.Builder) {
+	if len(d.definitions) == 0 {
+		return
+	}
+	nl := d.newline
+	body := b.String()
+	endsWithNewline := strings.HasSuffix(body, "\n")
+	switch {
+	case strings.HasSuffix(body, nl+nl):
+	case endsWithNewline:
+		b.WriteString(nl)
+	default:
+		b.WriteString(nl + nl)
+	}
+	b.WriteString(strings.Join(d.definitions, nl))
+	if endsWithNewline {
+		b.WriteString(nl)
+	}
+}
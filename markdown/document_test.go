@@ -0,0 +1,52 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestReferenceStyle(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+	writeTestPNG(t, tempDir, "b.png", 6, 6)
+
+	input := "# Doc\n\n![first](a.png) and ![again](a.png \"T\")\n\n![second](b.png)\n\n[img1]: https://example.com/taken"
+	processor := markdown.NewProcessor(markdown.Options{ReferenceStyle: true})
+	result, err := processor.Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	body, definitions, found := strings.Cut(result.Content, "[img1]: https://example.com/taken\n\n")
+	if !found {
+		t.Fatalf("Expected definitions after the original content, got %q", result.Content)
+	}
+	if body != "# Doc\n\n![first][img2] and ![again][img3]\n\n![second][img4]\n\n" {
+		t.Errorf("Unexpected body %q", body)
+	}
+	expected := `^\[img2\]: data:image/png;base64,\S+\n` +
+		`\[img3\]: data:image/png;base64,\S+ "T"\n` +
+		`\[img4\]: data:image/png;base64,\S+$`
+	if !regexp.MustCompile(expected).MatchString(definitions) {
+		t.Errorf("Definitions %q do not match %s", definitions, expected)
+	}
+}
+
+func TestReferenceStyleSharesDefinitions(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "logo.png", 4, 4)
+
+	processor := markdown.NewProcessor(markdown.Options{ReferenceStyle: true, AttrStyle: markdown.AttrStyleKramdown})
+	result, err := processor.Process("![a](logo.png)\n![b](./logo.png)\n", tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !strings.HasPrefix(result.Content, "![a][img1]{: width=4 height=4}\n![b][img1]{: width=4 height=4}\n\n[img1]: data:image/png;base64,") {
+		t.Errorf("Expected both images to share one definition, got %q", result.Content)
+	}
+	if strings.Count(result.Content, "[img1]:") != 1 {
+		t.Errorf("Expected exactly one definition, got %q", result.Content)
+	}
+}
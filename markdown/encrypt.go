@@ -0,0 +1,288 @@
+package markdown
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// htmlTagRegex matches an HTML start tag with attributes.
+var htmlTagRegex = regexp.MustCompile(`<[a-zA-Z][\w-]*\s[^>]*>`)
+
+// dataImageHeader matches the header of an image data URI up to its comma:
+// the image type, then media type parameters such as ;name=x.png or ;utf8,
+// and ;base64 if the payload is base64 rather than percent-encoded.
+const dataImageHeader = `data:(image/[\w.+-]+)(?:;[\w.+-]+(?:=[^;,"'\s]*)?)*?(;base64)?,`
+
+// encryptedAttrRegex matches an HTML attribute holding an image data URI,
+// such as the src of an embedded image or the href of a link to it.
+var encryptedAttrRegex = regexp.MustCompile(`(\s)(src|href|poster|data)=(?:"` + dataImageHeader + `([^"]*)"|'` + dataImageHeader + `([^']*)')`)
+
+// srcsetRegex matches a srcset attribute with a base64 image data URI among
+// its candidates.
+var srcsetRegex = regexp.MustCompile(`(\s)srcset=(?:"([^"]*data:image/[^"]*)"|'([^']*data:image/[^']*)')`)
+
+// cssImageRegex matches a CSS url() of an image data URI, quoted, quoted
+// with &quot; inside a style attribute, or not.
+var cssImageRegex = regexp.MustCompile(`url\(\s*(?:"` + dataImageHeader + `([^"]*)"|'` + dataImageHeader + `([^']*)'|&quot;` + dataImageHeader + `(.*?)&quot;|` + dataImageHeader + `([^)"'\s]*))\s*\)`)
+
+// styleElementRegex matches a <style> element: the start tag up to its >,
+// and the rest.
+var styleElementRegex = regexp.MustCompile(`(?is)(<style\b[^>]*)>(.*?</style>)`)
+
+// plainImageRegex matches an image data URI left unencrypted.
+var plainImageRegex = regexp.MustCompile(`data:image/[\w.+-]+(?:;[^;,"'\s<>]*)*,`)
+
+// EncryptionKeySize is the size in bytes of the AES-256 keys EncryptImages
+// takes.
+const EncryptionKeySize = 32
+
+// decryptScript decrypts the attributes and stylesheet images EncryptImages
+// writes in the browser, with the base64 key in the #key= fragment of the
+// page's URL or, failing that, typed in when asked.
+const decryptScript = `<script>
+(async () => {
+  const elements = document.querySelectorAll("[data-encrypted], [data-encrypted-css]");
+  if (elements.length === 0) return;
+  // URLSearchParams turns the + of a base64 key into a space.
+  const bytes = (s) => Uint8Array.from(atob(s.replace(/[ -]/g, "+").replace(/_/g, "/")), (c) => c.charCodeAt(0));
+  const fragment = new URLSearchParams(location.hash.slice(1)).get("key");
+  const secret = fragment || prompt("This page's images are encrypted. Enter the key to show them:");
+  if (!secret) return;
+  let key;
+  try {
+    key = await crypto.subtle.importKey("raw", bytes(secret.trim()), "AES-GCM", false, ["decrypt"]);
+  } catch (e) {
+    alert("Invalid image key.");
+    return;
+  }
+  // open decrypts a payload: to a URL of the image, or the text of a srcset.
+  const open = async (type, payload) => {
+    const sealed = bytes(payload);
+    const plain = await crypto.subtle.decrypt({ name: "AES-GCM", iv: sealed.slice(0, 12) }, key, sealed.slice(12));
+    return type === "text" ? new TextDecoder().decode(plain) : URL.createObjectURL(new Blob([plain], { type }));
+  };
+  const items = (el, name) => (el.getAttribute(name) || "").split(" ").filter(Boolean);
+  try {
+    for (const el of elements) {
+      for (const name of items(el, "data-encrypted")) {
+        const [type, payload] = el.getAttribute("data-encrypted-" + name).split(",");
+        el.setAttribute(name, await open(type, payload));
+      }
+      for (const item of items(el, "data-encrypted-css")) {
+        const [n, type, payload] = item.split(",");
+        document.documentElement.style.setProperty("--encrypted-image-" + n, 'url("' + await open(type, payload) + '")');
+      }
+    }
+  } catch (e) {
+    alert("The key does not decrypt this page's images.");
+  }
+})();
+</script>
+`
+
+// imageSealer encrypts the images of a page for EncryptImages.
+type imageSealer struct {
+	gcm cipher.AEAD
+	// cssImages numbers the CSS custom properties images are moved to.
+	cssImages int
+}
+
+// seal encrypts data to the "type,base64(nonce|ciphertext)" form the
+// decryption script reads.
+func (s *imageSealer) seal(mimeType string, data []byte) (string, error) {
+	nonce := make([]byte, s.gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return mimeType + "," + base64.StdEncoding.EncodeToString(s.gcm.Seal(nonce, nonce, data, nil)), nil
+}
+
+// dataImage returns the type, payload and whether it is base64 of the image
+// data URI matched by m, whose alternatives each capture the groups of
+// dataImageHeader and the payload from group first on.
+func dataImage(text string, m []int, first int) (mimeType, payload string, base64Encoded bool) {
+	for i := 2 * first; i+5 < len(m); i += 6 {
+		if m[i] >= 0 {
+			return text[m[i]:m[i+1]], text[m[i+4]:m[i+5]], m[i+2] >= 0
+		}
+	}
+	return "", "", false
+}
+
+// decodePayload decodes the payload of a data URI, base64 or
+// percent-encoded, after unescaping the HTML entities of an attribute
+// value if inHTML.
+func decodePayload(payload string, base64Encoded, inHTML bool) ([]byte, error) {
+	if inHTML {
+		payload = html.UnescapeString(payload)
+	}
+	if base64Encoded {
+		return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+	}
+	if decoded, err := url.PathUnescape(payload); err == nil {
+		return []byte(decoded), nil
+	}
+	// A stray % is kept, as browsers do.
+	return []byte(payload), nil
+}
+
+// sealAttrs encrypts the image attributes of tag, an HTML start tag, into
+// data-encrypted-<name> attributes, and returns it with their names. A
+// srcset is encrypted as a whole, as text.
+func (s *imageSealer) sealAttrs(tag string) (string, []string, error) {
+	var names []string
+	var b strings.Builder
+	last := 0
+	for _, m := range encryptedAttrRegex.FindAllStringSubmatchIndex(tag, -1) {
+		name := tag[m[4]:m[5]]
+		mimeType, payload, base64Encoded := dataImage(tag, m, 3)
+		data, err := decodePayload(payload, base64Encoded, true)
+		if err != nil {
+			continue
+		}
+		sealed, err := s.seal(mimeType, data)
+		if err != nil {
+			return "", nil, err
+		}
+		fmt.Fprintf(&b, `%s%sdata-encrypted-%s="%s"`, tag[last:m[0]], tag[m[2]:m[3]], name, sealed)
+		last = m[1]
+		names = append(names, name)
+	}
+	b.WriteString(tag[last:])
+	tag = b.String()
+
+	if m := srcsetRegex.FindStringSubmatchIndex(tag); m != nil {
+		valueStart, valueEnd := m[4], m[5]
+		if valueStart < 0 {
+			valueStart, valueEnd = m[6], m[7]
+		}
+		sealed, err := s.seal("text", []byte(tag[valueStart:valueEnd]))
+		if err != nil {
+			return "", nil, err
+		}
+		tag = fmt.Sprintf(`%s%sdata-encrypted-srcset="%s"%s`, tag[:m[0]], tag[m[2]:m[3]], sealed, tag[m[1]:])
+		names = append(names, "srcset")
+	}
+	return tag, names, nil
+}
+
+// sealCSS replaces every image data URI in css, a stylesheet or, if inHTML,
+// a start tag with a style attribute, with a var(--encrypted-image-<n>)
+// custom property, and returns it with the encrypted images as
+// "n,type,payload" items.
+func (s *imageSealer) sealCSS(css string, inHTML bool) (string, []string, error) {
+	var items []string
+	var b strings.Builder
+	last := 0
+	for _, m := range cssImageRegex.FindAllStringSubmatchIndex(css, -1) {
+		mimeType, payload, base64Encoded := dataImage(css, m, 1)
+		data, err := decodePayload(payload, base64Encoded, inHTML)
+		if err != nil {
+			continue
+		}
+		sealed, err := s.seal(mimeType, data)
+		if err != nil {
+			return "", nil, err
+		}
+		s.cssImages++
+		fmt.Fprintf(&b, "%svar(--encrypted-image-%d)", css[last:m[0]], s.cssImages)
+		last = m[1]
+		items = append(items, fmt.Sprintf("%d,%s", s.cssImages, sealed))
+	}
+	b.WriteString(css[last:])
+	return b.String(), items, nil
+}
+
+// EncryptImages encrypts the images embedded as data URIs in page, an HTML
+// page such as RenderHTML writes, with AES-256-GCM under key, and adds a
+// script that decrypts them in the browser with the base64 key given in the
+// #key= fragment of the page's URL or typed in when asked. Until then the
+// images show their alt text. Images in src, href, poster and data
+// attributes are encrypted in place, srcset attributes as a whole, and
+// those of <style> elements and style attributes become CSS custom
+// properties the script sets. It returns the page and how many images and
+// attributes it encrypted, or an error if an image is left it can't
+// encrypt, so that none is shipped in the clear.
+//
+// Encryption is experimental: it hides the images from whoever has the page
+// but not the key, but the prose, alt text and image types stay readable.
+func EncryptImages(page string, key []byte) (string, int, error) {
+	if len(key) != EncryptionKeySize {
+		return "", 0, fmt.Errorf("encryption key must be %d bytes, got %d", EncryptionKeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", 0, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", 0, err
+	}
+	s := &imageSealer{gcm: gcm}
+
+	var b strings.Builder
+	last, encrypted := 0, 0
+	for _, m := range styleElementRegex.FindAllStringSubmatchIndex(page, -1) {
+		css, items, err := s.sealCSS(page[m[4]:m[5]], false)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(items) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, `%s%s data-encrypted-css="%s">%s`, page[last:m[0]], page[m[2]:m[3]], strings.Join(items, " "), css)
+		last = m[1]
+		encrypted += len(items)
+	}
+	b.WriteString(page[last:])
+	page = b.String()
+
+	b.Reset()
+	last = 0
+	for _, tag := range htmlTagRegex.FindAllStringIndex(page, -1) {
+		start, end := tag[0], tag[1]
+		tagText, names, err := s.sealAttrs(page[start:end])
+		if err != nil {
+			return "", 0, err
+		}
+		tagText, items, err := s.sealCSS(tagText, true)
+		if err != nil {
+			return "", 0, err
+		}
+		if len(names) == 0 && len(items) == 0 {
+			continue
+		}
+		// Mark the element after its name, e.g. <img data-encrypted="src" ...>.
+		nameEnd := strings.IndexAny(tagText, " \t\r\n/>")
+		b.WriteString(page[last:start])
+		b.WriteString(tagText[:nameEnd])
+		if len(names) > 0 {
+			fmt.Fprintf(&b, ` data-encrypted="%s"`, strings.Join(names, " "))
+		}
+		if len(items) > 0 {
+			fmt.Fprintf(&b, ` data-encrypted-css="%s"`, strings.Join(items, " "))
+		}
+		b.WriteString(tagText[nameEnd:])
+		last = end
+		encrypted += len(names) + len(items)
+	}
+	b.WriteString(page[last:])
+	page = b.String()
+	if m := plainImageRegex.FindStringIndex(page); m != nil {
+		return "", 0, fmt.Errorf("line %d: can't encrypt the image data URI there", strings.Count(page[:m[0]], "\n")+1)
+	}
+	if encrypted == 0 {
+		return page, 0, nil
+	}
+	if i := strings.LastIndex(page, "</body>"); i >= 0 {
+		return page[:i] + decryptScript + page[i:], encrypted, nil
+	}
+	return page + decryptScript, encrypted, nil
+}
@@ -0,0 +1,149 @@
+package markdown_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestEncryptImages(t *testing.T) {
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	uri := "data:image/png;base64," + base64.StdEncoding.EncodeToString(pixel.Bytes())
+	content := "# Secret\n\n[![chart](" + uri + ")](" + uri + ")\n\n<img src='" + uri + "' alt=\"raw\">\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := bytes.Repeat([]byte{7}, markdown.EncryptionKeySize)
+	encrypted, n, err := markdown.EncryptImages(page, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || strings.Contains(encrypted, "data:image/") {
+		t.Fatalf("Expected 3 attributes encrypted and no data URI left, got %d:\n%s", n, encrypted)
+	}
+	if !strings.Contains(encrypted, `<a data-encrypted="href" data-encrypted-href="image/png,`) ||
+		!strings.Contains(encrypted, `<img data-encrypted="src" data-encrypted-src="image/png,`) ||
+		!strings.Contains(encrypted, "crypto.subtle.decrypt") || !strings.HasSuffix(encrypted, "</script>\n</body>\n</html>\n") {
+		t.Errorf("Expected marked elements and the decrypting script before </body>, got:\n%s", encrypted)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payloads := regexp.MustCompile(`data-encrypted-\w+="image/png,([^"]+)"`).FindAllStringSubmatch(encrypted, -1)
+	if len(payloads) != 3 || payloads[0][1] == payloads[1][1] {
+		t.Fatalf("Expected 3 payloads with their own nonces, got %q", payloads)
+	}
+	for _, p := range payloads {
+		sealed, err := base64.StdEncoding.DecodeString(p[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+		if err != nil || !bytes.Equal(plain, pixel.Bytes()) {
+			t.Errorf("Expected the payload to decrypt to the image, got error %v", err)
+		}
+	}
+
+	// Images in stylesheets, style attributes and srcset are encrypted too.
+	styled := "<html><head><style>\nbody { background: url(\"" + uri + "\"); }\n</style></head><body>\n" +
+		"<div style='border-image: url(" + uri + ")'>x</div>\n<img srcset=\"" + uri + " 1x, " + uri + " 2x\" alt=\"hi\">\n</body></html>\n"
+	encrypted, n, err = markdown.EncryptImages(styled, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || strings.Contains(encrypted, "data:image/") {
+		t.Fatalf("Expected 3 images encrypted and no data URI left, got %d:\n%s", n, encrypted)
+	}
+	for _, want := range []string{
+		`<style data-encrypted-css="1,image/png,`,
+		"background: var(--encrypted-image-1)",
+		`<div data-encrypted-css="2,image/png,`,
+		"border-image: var(--encrypted-image-2)",
+		`<img data-encrypted="srcset" data-encrypted-srcset="text,`,
+	} {
+		if !strings.Contains(encrypted, want) {
+			t.Errorf("Expected %q in:\n%s", want, encrypted)
+		}
+	}
+	srcset := regexp.MustCompile(`data-encrypted-srcset="text,([^"]+)"`).FindStringSubmatch(encrypted)
+	sealed, err := base64.StdEncoding.DecodeString(srcset[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil); err != nil || string(plain) != uri+" 1x, "+uri+" 2x" {
+		t.Errorf("Expected the srcset to decrypt to its candidates, got %q, %v", plain, err)
+	}
+	if _, _, err := markdown.EncryptImages("<p><code>"+uri+"</code></p>", key); err == nil {
+		t.Error("Expected an image that can't be encrypted to be an error")
+	}
+
+	// Data URIs with media type parameters, or percent-encoded rather than
+	// base64, are encrypted like the others.
+	named := "data:image/png;name=chart.png;base64," + base64.StdEncoding.EncodeToString(pixel.Bytes())
+	svg := `<svg xmlns="http://www.w3.org/2000/svg"/>`
+	for _, tc := range []struct {
+		name, page string
+		want       []byte
+	}{
+		{"parameters in src", `<p><img src="` + named + `" alt="chart"></p>`, pixel.Bytes()},
+		{"parameters in url()", `<div style="background: url('` + named + `')">x</div>`, pixel.Bytes()},
+		{"utf8 in src", `<p><img src="data:image/svg+xml;utf8,` + strings.ReplaceAll(url.PathEscape(svg), "%2F", "/") + `" alt="logo"></p>`, []byte(svg)},
+		{"utf8 in url()", `<style>a { background: url("data:image/svg+xml;charset=utf-8,` + url.PathEscape(svg) + `"); }</style>`, []byte(svg)},
+		{"utf8 in srcset", `<img srcset="data:image/svg+xml;utf8,%3Csvg%2F%3E 1x" alt="x">`, nil},
+	} {
+		encrypted, n, err := markdown.EncryptImages(tc.page, key)
+		if err != nil {
+			t.Errorf("%s: %v", tc.name, err)
+			continue
+		}
+		if n != 1 || strings.Contains(encrypted, "data:image/") {
+			t.Errorf("%s: expected the image encrypted and no data URI left, got %d:\n%s", tc.name, n, encrypted)
+			continue
+		}
+		if tc.want == nil {
+			continue
+		}
+		payload := regexp.MustCompile(`(?:data-encrypted-src="|data-encrypted-css="\d+,)image/[\w+.-]+,([^" ]+)`).FindStringSubmatch(encrypted)
+		sealed, err := base64.StdEncoding.DecodeString(payload[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil); err != nil || !bytes.Equal(plain, tc.want) {
+			t.Errorf("%s: expected the payload to decrypt to the image, got %q, %v", tc.name, plain, err)
+		}
+	}
+	for _, page := range []string{
+		"<p>" + named + "</p>",
+		"<p>data:image/svg+xml;utf8,%3Csvg%2F%3E</p>",
+	} {
+		if _, _, err := markdown.EncryptImages(page, key); err == nil {
+			t.Errorf("Expected an image that can't be encrypted to be an error in %q", page)
+		}
+	}
+
+	if unchanged, n, err := markdown.EncryptImages("<p>No images</p>", key); err != nil || n != 0 || unchanged != "<p>No images</p>" {
+		t.Errorf("Expected a page without images unchanged, got %q, %d, %v", unchanged, n, err)
+	}
+	if _, _, err := markdown.EncryptImages(page, key[:16]); err == nil {
+		t.Error("Expected a short key to be an error")
+	}
+}
@@ -0,0 +1,144 @@
+package markdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrorCode is a stable identifier for a kind of failure. Codes and their
+// names never change meaning once released, so scripts can match on them
+// instead of on English messages.
+type ErrorCode string
+
+// Error codes. The first digit groups the failure: 0 input/output documents,
+// 1 local files, 2 remote fetches, 3 image decoding and encoding, 4 limits.
+const (
+	CodeInputUnreadable     ErrorCode = "MI0001"
+	CodeOutputUnwritable    ErrorCode = "MI0002"
+	CodeFileNotFound        ErrorCode = "MI1001"
+	CodeFileUnreadable      ErrorCode = "MI1002"
+	CodeDownloadFailed      ErrorCode = "MI2001"
+	CodeHTTPStatus          ErrorCode = "MI2002"
+	CodeContentTypeMismatch ErrorCode = "MI2003"
+	CodeUnsupportedFormat   ErrorCode = "MI3001"
+	CodeEncodeFailed        ErrorCode = "MI3002"
+	CodeTimeout             ErrorCode = "MI4001"
+	CodeInterrupted         ErrorCode = "MI4002"
+	CodeImageTooLarge       ErrorCode = "MI4003"
+)
+
+var codeNames = map[ErrorCode]string{
+	CodeInputUnreadable:     "input-unreadable",
+	CodeOutputUnwritable:    "output-unwritable",
+	CodeFileNotFound:        "file-not-found",
+	CodeFileUnreadable:      "file-unreadable",
+	CodeDownloadFailed:      "download-failed",
+	CodeHTTPStatus:          "http-status",
+	CodeContentTypeMismatch: "content-type-mismatch",
+	CodeUnsupportedFormat:   "unsupported-format",
+	CodeEncodeFailed:        "encode-failed",
+	CodeTimeout:             "timeout",
+	CodeInterrupted:         "interrupted",
+	CodeImageTooLarge:       "image-too-large",
+}
+
+// Name returns the short, stable name of the code, e.g. "file-not-found".
+func (c ErrorCode) Name() string {
+	if name, ok := codeNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Sentinel errors for the failure kinds callers commonly branch on. They
+// match coded errors with errors.Is:
+//
+//	if errors.Is(img.Err, markdown.ErrImageNotFound) { ... }
+var (
+	// ErrImageNotFound matches CodeFileNotFound: a local file does not exist.
+	ErrImageNotFound = errors.New("image not found")
+	// ErrUnsupportedFormat matches CodeUnsupportedFormat: the data is not an
+	// image this package can decode, or cannot be processed as requested.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+)
+
+var codeSentinels = map[ErrorCode]error{
+	CodeFileNotFound:      ErrImageNotFound,
+	CodeUnsupportedFormat: ErrUnsupportedFormat,
+}
+
+// HTTPError is a non-200 response to a request for a remote image. It is
+// wrapped in an Error with CodeHTTPStatus; use errors.As to get the status.
+type HTTPError struct {
+	Status int
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("bad status: %d %s", e.Status, http.StatusText(e.Status))
+}
+
+// DecodeError is image data that could not be decoded. It is wrapped in an
+// Error with CodeUnsupportedFormat.
+type DecodeError struct {
+	// Format is the sniffed MIME type, or "" if the data was not recognized.
+	Format string
+	Err    error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Format == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("decoding %s: %v", e.Format, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// Error is a failure tagged with a stable error code.
+type Error struct {
+	Code ErrorCode
+	// Path is the image path, URL or document the failure relates to.
+	Path string
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s %s: %s: %v", e.Code, e.Code.Name(), e.Path, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether target is the sentinel error for e's code.
+func (e *Error) Is(target error) bool {
+	sentinel, ok := codeSentinels[e.Code]
+	return ok && sentinel == target
+}
+
+// CodeOf returns the error code carried by err, or "" if it has none.
+func CodeOf(err error) ErrorCode {
+	var codedErr *Error
+	if errors.As(err, &codedErr) {
+		return codedErr.Code
+	}
+	return ""
+}
+
+// contextError reports why ctx is done: CodeInterrupted if it was canceled,
+// CodeTimeout if its deadline expired.
+func contextError(ctx context.Context, path string) *Error {
+	code := CodeTimeout
+	if errors.Is(ctx.Err(), context.Canceled) {
+		code = CodeInterrupted
+	}
+	return newError(code, path, context.Cause(ctx))
+}
+
+func newError(code ErrorCode, path string, err error) *Error {
+	return &Error{Code: code, Path: path, Err: err}
+}
@@ -0,0 +1,132 @@
+package markdown_test
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io/fs"
+	"markdown-images/markdown"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/login.png":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			fmt.Fprint(w, "<html><body>Please sign in</body></html>")
+		case "/garbage.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "not really a png")
+		case "/small.png":
+			w.Header().Set("Content-Type", "image/png")
+			png.Encode(w, image.NewGray(image.Rect(0, 0, 4, 4)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	testCases := []struct {
+		name     string
+		markdown string
+		wantCode markdown.ErrorCode
+	}{
+		{
+			name:     "Missing local file",
+			markdown: "![missing](nonexistent.jpg)",
+			wantCode: markdown.CodeFileNotFound,
+		},
+		{
+			name:     "Remote 404",
+			markdown: fmt.Sprintf("![gone](%s/gone.png)", server.URL),
+			wantCode: markdown.CodeHTTPStatus,
+		},
+		{
+			name:     "HTML page instead of image",
+			markdown: fmt.Sprintf("![login](%s/login.png)", server.URL),
+			wantCode: markdown.CodeContentTypeMismatch,
+		},
+		{
+			name:     "Undecodable image",
+			markdown: fmt.Sprintf("![garbage](%s/garbage.png)", server.URL),
+			wantCode: markdown.CodeUnsupportedFormat,
+		},
+		{
+			name:     "Oversized resize",
+			markdown: fmt.Sprintf("![huge](%s/small.png){: width=100000 height=100000}", server.URL),
+			wantCode: markdown.CodeEncodeFailed,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{}).Process(tc.markdown, t.TempDir())
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if len(result.Images) != 1 {
+				t.Fatalf("Expected 1 image result, got %d", len(result.Images))
+			}
+			imgErr := result.Images[0].Err
+			if code := markdown.CodeOf(imgErr); code != tc.wantCode {
+				t.Errorf("Expected code %s, got %q (error: %v)", tc.wantCode, code, imgErr)
+			}
+			if !strings.HasPrefix(imgErr.Error(), string(tc.wantCode)+" "+tc.wantCode.Name()) {
+				t.Errorf("Expected message to start with the code and its name, got %q", imgErr.Error())
+			}
+		})
+	}
+}
+
+func TestErrorKinds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/truncated.png":
+			w.Header().Set("Content-Type", "image/png")
+			fmt.Fprint(w, "\x89PNG\r\n\x1a\n\x00\x00")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	imageErr := func(content string) error {
+		t.Helper()
+		result, err := markdown.NewProcessor(markdown.Options{}).Process(content, t.TempDir())
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if len(result.Images) != 1 || result.Images[0].Err == nil {
+			t.Fatalf("Expected one failed image, got %+v", result.Images)
+		}
+		return result.Images[0].Err
+	}
+
+	err := imageErr("![missing](nonexistent.png)")
+	if !errors.Is(err, markdown.ErrImageNotFound) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected ErrImageNotFound wrapping fs.ErrNotExist, got %v", err)
+	}
+	if errors.Is(err, markdown.ErrUnsupportedFormat) {
+		t.Errorf("A missing file should not match ErrUnsupportedFormat")
+	}
+
+	err = imageErr(fmt.Sprintf("![gone](%s/gone.png)", server.URL))
+	var httpErr *markdown.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Status != http.StatusNotFound {
+		t.Errorf("Expected an HTTPError with status 404, got %v", err)
+	}
+
+	err = imageErr(fmt.Sprintf("![broken](%s/truncated.png)", server.URL))
+	var decodeErr *markdown.DecodeError
+	if !errors.As(err, &decodeErr) || decodeErr.Format != "image/png" {
+		t.Errorf("Expected a DecodeError for image/png, got %v", err)
+	}
+	if !errors.Is(err, markdown.ErrUnsupportedFormat) {
+		t.Errorf("Expected a decode failure to match ErrUnsupportedFormat, got %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// RegexpPrefix marks an Exclude or Include pattern as a regular expression,
+// as in re:^assets/.*\.png$, rather than a wildcard pattern.
+const RegexpPrefix = "re:"
+
+// compiledPatterns caches the regular expressions of patterns by their text.
+var compiledPatterns sync.Map
+
+// CheckPattern returns the error of a pattern that is an invalid regular
+// expression, and nil for any other pattern.
+func CheckPattern(pattern string) error {
+	_, err := compilePattern(pattern)
+	return err
+}
+
+// compilePattern returns the regular expression of a pattern with
+// RegexpPrefix, or nil for a wildcard pattern.
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	expr, ok := strings.CutPrefix(pattern, RegexpPrefix)
+	if !ok {
+		return nil, nil
+	}
+	if re, ok := compiledPatterns.Load(expr); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	compiledPatterns.Store(expr, re)
+	return re, nil
+}
+
+// excludedBy returns the first of patterns that imagePath matches, if any.
+func excludedBy(patterns []string, imagePath string) (string, bool) {
+	for _, pattern := range patterns {
+		if patternMatch(pattern, imagePath) {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// patternMatch reports whether s matches pattern: anywhere for a regular
+// expression, unless it is anchored, and as a whole for a wildcard pattern.
+// Invalid regular expressions match nothing.
+func patternMatch(pattern, s string) bool {
+	re, err := compilePattern(pattern)
+	switch {
+	case err != nil:
+		return false
+	case re != nil:
+		return re.MatchString(s)
+	}
+	return wildcardMatch(pattern, s)
+}
+
+// wildcardMatch reports whether s matches pattern, in which * stands for
+// any run of characters, slashes included, and everything else for itself.
+func wildcardMatch(pattern, s string) bool {
+	// star and retry are where the last * was seen in pattern and the
+	// position in s it currently absorbs up to.
+	star, retry := -1, 0
+	p, i := 0, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, retry = p, i
+			p++
+		case p < len(pattern) && pattern[p] == s[i]:
+			p++
+			i++
+		case star >= 0:
+			retry++
+			p, i = star+1, retry
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
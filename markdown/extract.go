@@ -0,0 +1,113 @@
+package markdown
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"mime"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ImageReferences returns every markdown and HTML image reference in
+// content, in document order, including the images already embedded as data
+// URIs.
+func ImageReferences(content string) []ImageReference {
+	return scanImageReferences(content, true)
+}
+
+// DecodeDataURI returns the MIME type and the bytes of a base64 data URI.
+// Whitespace in the payload, such as the line breaks --wrap-base64 inserts,
+// is ignored. It reports false for anything else.
+func DecodeDataURI(uri string) (string, []byte, bool) {
+	header, payload, ok := strings.Cut(strings.TrimPrefix(uri, "data:"), ",")
+	if !ok || !strings.HasPrefix(uri, "data:") {
+		return "", nil, false
+	}
+	mimeType, ok := strings.CutSuffix(header, ";base64")
+	if !ok {
+		return "", nil, false
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(payload), ""))
+	if err != nil {
+		return "", nil, false
+	}
+	return mimeType, data, true
+}
+
+// ExtractDataURIs is the inverse of Process: it passes the content of every
+// base64 data URI image in content to save, which stores it and returns the
+// path to reference it by, and rewrites the reference to that path. It
+// returns the rewritten content and how many images it extracted, stopping
+// at the first error of save. The source comment of an image, if it has
+// one, sets its EmbeddedSource and is removed.
+func ExtractDataURIs(content string, save func(ref ImageReference, data []byte) (string, error)) (string, int, error) {
+	refs := scanImageReferences(content, true)
+	sortReferences(refs)
+
+	var b strings.Builder
+	last, prevEnd, extracted := 0, 0, 0
+	for _, ref := range refs {
+		from := max(last, prevEnd)
+		prevEnd = max(prevEnd, ref.EndPos)
+		_, data, ok := DecodeDataURI(ref.ImagePath)
+		if !ok {
+			continue
+		}
+		var comment []int
+		if from <= ref.StartPos {
+			if source, loc, ok := lastSourceComment(content[from:ref.StartPos]); ok {
+				ref.EmbeddedSource, comment = source, []int{from + loc[0], from + loc[1]}
+			}
+		}
+		path, err := save(ref, data)
+		if err != nil {
+			return content, 0, err
+		}
+		if comment != nil {
+			b.WriteString(content[last:comment[0]])
+			last = comment[1]
+		}
+		b.WriteString(content[last:ref.pathStart])
+		b.WriteString(path)
+		last = ref.pathEnd
+		extracted++
+	}
+	b.WriteString(content[last:])
+	return b.String(), extracted, nil
+}
+
+// RestoredName returns the file name an image extracted from a data URI
+// had when it was embedded, from the path or URL its source comment records:
+// the base name, with the extension of the sniffed format if it was
+// embedded in another, e.g. arch.png for images/arch.png. It returns "" if
+// source has no base name.
+func RestoredName(source string, data []byte) string {
+	name := path.Base(source)
+	if u, err := url.Parse(source); err == nil && u.Path != "" {
+		name = path.Base(u.Path)
+	}
+	if name == "." || name == ".." || name == "/" || strings.ContainsAny(name, `/\`) {
+		return ""
+	}
+	mimeType := sniffImageType(data)
+	ext := path.Ext(name)
+	if sniffed, ok := imageExtensions[mimeType]; ok && !strings.HasPrefix(mime.TypeByExtension(strings.ToLower(ext)), mimeType) {
+		name = strings.TrimSuffix(name, ext) + sniffed
+	}
+	return name
+}
+
+// ExtractedName returns a file name for an image extracted from a data URI:
+// the slug of its alt text, a hash of its content, so that the same image
+// extracted twice is stored once, and the extension of the sniffed format,
+// e.g. architecture-3f2a9c1e.png.
+func ExtractedName(altText string, data []byte) string {
+	name := Slug(altText)
+	if name == "" {
+		name = "image"
+	}
+	sum := sha256.Sum256(data)
+	return name + "-" + hex.EncodeToString(sum[:4]) + imageExtensions[sniffImageType(data)]
+}
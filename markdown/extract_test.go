@@ -0,0 +1,95 @@
+package markdown_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"net/url"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestExtractDataURIs(t *testing.T) {
+	var pixel bytes.Buffer
+	if err := png.Encode(&pixel, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	payload := base64.StdEncoding.EncodeToString(pixel.Bytes())
+	// The HTML image has its payload wrapped as --wrap-base64 writes it.
+	wrapped := payload[:10] + "\n" + payload[10:]
+	content := "![Arch diagram](data:image/png;base64," + payload + " \"Overview\")\n" +
+		"<img src=\"data:image/png;base64," + wrapped + "\" alt=\"Arch diagram\">\n" +
+		"![logo](img/logo.png)\n![svg](data:image/svg+xml,%3Csvg%3E)\n"
+
+	refs := markdown.ImageReferences(content)
+	if len(refs) != 4 {
+		t.Fatalf("Expected 4 references including data URIs, got %d", len(refs))
+	}
+
+	var saved [][]byte
+	got, n, err := markdown.ExtractDataURIs(content, func(ref markdown.ImageReference, data []byte) (string, error) {
+		saved = append(saved, data)
+		return "assets/" + markdown.ExtractedName(ref.AltText, data), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(saved) != 2 || !bytes.Equal(saved[0], pixel.Bytes()) || !bytes.Equal(saved[1], pixel.Bytes()) {
+		t.Fatalf("Expected both base64 images decoded, got %d", n)
+	}
+	name := markdown.ExtractedName("Arch diagram", pixel.Bytes())
+	if !strings.HasPrefix(name, "arch-diagram-") || !strings.HasSuffix(name, ".png") {
+		t.Errorf("Unexpected name %q", name)
+	}
+	want := "![Arch diagram](assets/" + name + " \"Overview\")\n" +
+		"<img src=\"assets/" + name + "\" alt=\"Arch diagram\">\n" +
+		"![logo](img/logo.png)\n![svg](data:image/svg+xml,%3Csvg%3E)\n"
+	if got != want {
+		t.Errorf("ExtractDataURIs =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "arch diagram.png", 2, 2)
+	writeTestPNG(t, tempDir, "logo.jpeg", 2, 2)
+	content := "See ![arch](<arch diagram.png>) and ![logo](logo.jpeg).\n"
+	result, err := markdown.NewProcessor(markdown.Options{SourceComments: true}).Process(content, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch := result.Images[0]
+	comment := "<!-- source: arch%20diagram.png sha256:" + arch.SourceSHA256 + " -->![arch](data:image/png;base64,"
+	if arch.SourceSHA256 == "" || !strings.Contains(result.Content, "See "+comment) {
+		t.Errorf("Expected %q before the first image, got:\n%s", comment, result.Content)
+	}
+	changed := markdown.ReplaceSourceHash(result.Content, arch.SourceSHA256, strings.Repeat("0", 64))
+	if !strings.Contains(changed, "arch%20diagram.png sha256:"+strings.Repeat("0", 64)+" -->") {
+		t.Errorf("Expected the source hash to be replaced, got:\n%s", changed)
+	}
+
+	var sources []string
+	got, n, err := markdown.ExtractDataURIs(result.Content, func(ref markdown.ImageReference, data []byte) (string, error) {
+		sources = append(sources, ref.EmbeddedSource)
+		return "assets/" + url.PathEscape(markdown.RestoredName(ref.EmbeddedSource, data)), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || len(sources) != 2 || sources[0] != "arch diagram.png" || sources[1] != "logo.jpeg" {
+		t.Fatalf("Expected the recorded sources, got %q", sources)
+	}
+	// The logo was embedded as a PNG, so it is restored with that extension.
+	if want := "See ![arch](assets/arch%20diagram.png) and ![logo](assets/logo.png).\n"; got != want {
+		t.Errorf("ExtractDataURIs =\n%s\nwant:\n%s", got, want)
+	}
+
+	for source, want := range map[string]string{"https://example.com/img/chart.png?v=2": "chart.png", "..": "", "img/": "img.png"} {
+		if got := markdown.RestoredName(source, []byte("\x89PNG\r\n\x1a\n")); got != want {
+			t.Errorf("RestoredName(%q) = %q, want %q", source, got, want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package markdown
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Fallback gives the alternative sources of the images whose path or URL
+// starts with Prefix, such as an internal mirror and a vendored copy of a
+// CDN: each alternative replaces the prefix in turn, and the first source
+// that can be read is embedded. Relative alternatives are resolved like the
+// image paths of the document.
+type Fallback struct {
+	Prefix       string
+	Alternatives []string
+}
+
+// ParseFallback parses a fallback written as
+// "prefix=alternative,alternative", e.g.
+// "https://cdn.example.com/img/=https://mirror.internal/img/,vendor/img/".
+func ParseFallback(spec string) (Fallback, error) {
+	prefix, alternatives, ok := strings.Cut(spec, "=")
+	if !ok || prefix == "" {
+		return Fallback{}, fmt.Errorf("fallback %q must look like prefix=alternative,alternative", spec)
+	}
+	f := Fallback{Prefix: prefix}
+	for _, alt := range strings.Split(alternatives, ",") {
+		if alt = strings.TrimSpace(alt); alt != "" {
+			f.Alternatives = append(f.Alternatives, alt)
+		}
+	}
+	if len(f.Alternatives) == 0 {
+		return Fallback{}, fmt.Errorf("fallback %q has no alternatives", spec)
+	}
+	return f, nil
+}
+
+// sources returns the paths and URLs to try for ref, in order: its own,
+// the fallbacks of its directive and the alternatives of the Fallback with
+// the longest prefix it starts with.
+func (p *Processor) sources(ref ImageReference) []string {
+	sources := append([]string{ref.ImagePath}, ref.Directive.Fallbacks...)
+	var best *Fallback
+	for i, f := range p.opts.Fallbacks {
+		if strings.HasPrefix(ref.ImagePath, f.Prefix) && (best == nil || len(f.Prefix) > len(best.Prefix)) {
+			best = &p.opts.Fallbacks[i]
+		}
+	}
+	if best != nil {
+		for _, alt := range best.Alternatives {
+			sources = append(sources, alt+strings.TrimPrefix(ref.ImagePath, best.Prefix))
+		}
+	}
+	return slices.Compact(sources)
+}
+
+// embedFirst is embedCached for the first of the sources of ref that can be
+// read. If none can, it returns the error and result of ref's own source.
+func (p *Processor) embedFirst(ctx context.Context, ref ImageReference, baseDir string, res *ImageResult) (string, error) {
+	sources := p.sources(ref)
+	start := *res
+	var firstErr error
+	var firstRes ImageResult
+	for i, source := range sources {
+		alt := ref
+		alt.ImagePath = source
+		*res = start
+		encoded, err := p.embedCached(ctx, alt, baseDir, res)
+		if err == nil {
+			if i > 0 {
+				p.log.Debug("Embedded a fallback", "image", ref.ImagePath, "fallback", source)
+			}
+			return encoded, nil
+		}
+		if firstErr == nil {
+			firstErr, firstRes = err, *res
+		}
+		if ctx.Err() != nil || !sourceUnavailable(err) || i == len(sources)-1 {
+			break
+		}
+		p.log.Warn("Could not read image; trying the next fallback", "image", source, "fallback", sources[i+1], "error", err)
+	}
+	*res = firstRes
+	return "", firstErr
+}
+
+// sourceUnavailable reports whether err means an image's source could not
+// be read, as opposed to an image that was read but can't be embedded.
+func sourceUnavailable(err error) bool {
+	switch CodeOf(err) {
+	case CodeFileNotFound, CodeFileUnreadable, CodeDownloadFailed, CodeHTTPStatus, CodeContentTypeMismatch, CodeTimeout:
+		return true
+	}
+	return false
+}
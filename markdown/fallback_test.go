@@ -0,0 +1,123 @@
+package markdown_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestParseFallback(t *testing.T) {
+	f, err := markdown.ParseFallback("https://cdn.example.com/img/=https://mirror.internal/img/, vendor/img/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.Prefix != "https://cdn.example.com/img/" || len(f.Alternatives) != 2 || f.Alternatives[1] != "vendor/img/" {
+		t.Errorf("Unexpected fallback %+v", f)
+	}
+	for _, spec := range []string{"", "https://cdn.example.com/", "=vendor/", "https://cdn.example.com/=", "https://cdn.example.com/= , "} {
+		if _, err := markdown.ParseFallback(spec); err == nil {
+			t.Errorf("Expected an error for %q", spec)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/mirror/logo.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	}))
+	defer server.Close()
+	cdn, mirror := server.URL+"/cdn/", server.URL+"/mirror/"
+
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, tempDir, "vendor/icon.png", 2, 2)
+	writeTestPNG(t, tempDir, "local.png", 2, 2)
+
+	testCases := []struct {
+		name       string
+		fallbacks  []markdown.Fallback
+		markdown   string
+		wantSource string
+		wantErr    markdown.ErrorCode
+	}{
+		{
+			name:       "Directive falls back to a local copy",
+			markdown:   "<!-- mdimg: fallback=missing.png fallback=vendor/icon.png -->\n![icon](" + cdn + "icon.png)",
+			wantSource: filepath.Join(tempDir, "vendor", "icon.png"),
+		},
+		{
+			name:       "Prefix falls back to a mirror",
+			fallbacks:  []markdown.Fallback{{Prefix: cdn, Alternatives: []string{mirror, "vendor/"}}},
+			markdown:   "![logo](" + cdn + "logo.png)",
+			wantSource: mirror + "logo.png",
+		},
+		{
+			name:       "Prefix falls back past the mirror",
+			fallbacks:  []markdown.Fallback{{Prefix: cdn, Alternatives: []string{mirror, "vendor/"}}},
+			markdown:   "![icon](" + cdn + "icon.png)",
+			wantSource: filepath.Join(tempDir, "vendor", "icon.png"),
+		},
+		{
+			name:       "Longest prefix wins",
+			fallbacks:  []markdown.Fallback{{Prefix: server.URL + "/", Alternatives: []string{"missing/"}}, {Prefix: cdn, Alternatives: []string{mirror}}},
+			markdown:   "![logo](" + cdn + "logo.png)",
+			wantSource: mirror + "logo.png",
+		},
+		{
+			name:       "Own source comes first",
+			fallbacks:  []markdown.Fallback{{Prefix: "local", Alternatives: []string{"vendor/icon"}}},
+			markdown:   "![local](local.png)",
+			wantSource: filepath.Join(tempDir, "local.png"),
+		},
+		{
+			name:       "Every source fails",
+			fallbacks:  []markdown.Fallback{{Prefix: cdn, Alternatives: []string{"missing/"}}},
+			markdown:   "<!-- mdimg: fallback=" + mirror + "missing.png -->\n![gone](" + cdn + "gone.png)",
+			wantSource: cdn + "gone.png",
+			wantErr:    markdown.CodeHTTPStatus,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{Fallbacks: tc.fallbacks}).Process(tc.markdown, tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(result.Images) != 1 {
+				t.Fatalf("Expected 1 image, got %d", len(result.Images))
+			}
+			img := result.Images[0]
+			if img.Source != tc.wantSource {
+				t.Errorf("Expected source %q, got %q", tc.wantSource, img.Source)
+			}
+			if tc.wantErr != "" {
+				if img.Embedded || markdown.CodeOf(img.Err) != tc.wantErr {
+					t.Errorf("Expected error %s, got embedded %v, error %v", tc.wantErr, img.Embedded, img.Err)
+				}
+				return
+			}
+			if !img.Embedded {
+				t.Errorf("Expected the image to be embedded, got error %v", img.Err)
+			}
+		})
+	}
+}
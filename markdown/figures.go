@@ -0,0 +1,95 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// ListOfFiguresMarker, on a line of its own, is replaced by a linked list of
+// the numbered figures when Options.FigureLabel is set.
+const ListOfFiguresMarker = "<!-- list-of-figures -->"
+
+// figure is a numbered embedded image.
+type figure struct {
+	number int
+	// caption is the plain caption, e.g. "Figure 3: Throughput"; linkText
+	// is the same as markdown link text.
+	caption, linkText string
+}
+
+// newFigure numbers ref, captioning it with its alt text, or its title if
+// it has no alt text.
+func (p *Processor) newFigure(ref ImageReference, number int) figure {
+	label := fmt.Sprintf("%s %d", p.opts.FigureLabel, number)
+	fig := figure{number: number, caption: label, linkText: label}
+	switch {
+	case ref.AltText != "":
+		fig.caption += ": " + htmlAlt(ref)
+		fig.linkText += ": " + markdownAlt(ref)
+	case ref.Title != "":
+		fig.caption += ": " + ref.Title
+		fig.linkText += ": " + escapeLinkText(ref.Title)
+	}
+	return fig
+}
+
+// id is the anchor of the figure, which the list of figures links to.
+func (f figure) id() string {
+	return fmt.Sprintf("figure-%d", f.number)
+}
+
+// numberedFigure renders an embedded image as a <figure> with the caption
+// and id of fig. Like Figcaption, it always uses an HTML img tag, since
+// markdown inside HTML blocks is not rendered.
+func (p *Processor) numberedFigure(doc *document, ref ImageReference, dataURI string, width, height int, fig figure) string {
+	if p.opts.WrapWidth > 0 {
+		dataURI = wrapPayload(dataURI, p.opts.WrapWidth, doc.newline)
+	}
+	return fmt.Sprintf(`<figure id="%s">%s<figcaption>%s</figcaption></figure>`,
+		fig.id(), htmlImage(htmlAlt(ref), ref.Title, dataURI, width, height), html.EscapeString(fig.caption))
+}
+
+// insertListOfFigures replaces every ListOfFiguresMarker line outside code
+// blocks with a list linking to figures.
+func insertListOfFigures(content string, figures []figure, newline string) string {
+	var list strings.Builder
+	for i, fig := range figures {
+		if i > 0 {
+			list.WriteString(newline)
+		}
+		fmt.Fprintf(&list, "- [%s](#%s)", fig.linkText, fig.id())
+	}
+	codeBlocks := codeBlockRanges(content)
+	var b strings.Builder
+	last := 0
+	for pos := 0; ; {
+		i := strings.Index(content[pos:], ListOfFiguresMarker)
+		if i < 0 {
+			break
+		}
+		start, end := pos+i, pos+i+len(ListOfFiguresMarker)
+		pos = end
+		lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+		lineEnd := strings.IndexByte(content[end:], '\n')
+		if lineEnd < 0 {
+			lineEnd = len(content)
+		} else {
+			lineEnd += end
+		}
+		if inRanges(codeBlocks, start) || strings.TrimSpace(content[lineStart:start]) != "" || strings.TrimSpace(content[end:lineEnd]) != "" {
+			continue
+		}
+		b.WriteString(content[last:start])
+		b.WriteString(list.String())
+		last = end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// escapeLinkText escapes the characters that would end markdown link text.
+func escapeLinkText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(s)
+}
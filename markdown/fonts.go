@@ -0,0 +1,102 @@
+package markdown
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// FontEmbedding selects which webfonts InlineStylesheets embeds.
+type FontEmbedding string
+
+const (
+	// FontsWOFF2 embeds fonts, keeping only the WOFF2 sources of @font-face
+	// rules that offer one. Every current browser reads WOFF2, and it is
+	// the smallest format, so older formats would only add weight.
+	FontsWOFF2 FontEmbedding = "woff2"
+	// FontsAll embeds every font source as written.
+	FontsAll FontEmbedding = "all"
+	// FontsNone leaves fonts as references, so pages fall back to system
+	// fonts offline.
+	FontsNone FontEmbedding = "none"
+)
+
+// ParseFontEmbedding converts a command line value into a FontEmbedding.
+func ParseFontEmbedding(s string) (FontEmbedding, error) {
+	switch fonts := FontEmbedding(strings.ToLower(s)); fonts {
+	case FontsWOFF2, FontsAll, FontsNone:
+		return fonts, nil
+	case "":
+		return FontsWOFF2, nil
+	}
+	return "", fmt.Errorf("unknown font embedding %q (want woff2, all or none)", s)
+}
+
+// woff2UserAgent is sent to Google Fonts, which picks the font format for
+// the browser named in the User-Agent and would serve TrueType otherwise.
+const woff2UserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0 Safari/537.36"
+
+var (
+	fontFaceRegex = regexp.MustCompile(`(?i)@font-face\s*\{[^}]*\}`)
+	fontSrcRegex  = regexp.MustCompile(`(?i)(^|[{;\s])src\s*:\s*([^;}]*);?`)
+)
+
+// isFont reports whether an asset reference names a font file.
+func isFont(ref string) bool {
+	_, ok := fontTypes[strings.ToLower(path.Ext(stripQuery(ref)))]
+	return ok
+}
+
+// pruneFontSources drops the non-WOFF2 sources, and src declarations left
+// without sources (such as the EOT line of the "bulletproof" syntax), from
+// every @font-face rule in css that offers WOFF2. local() sources are kept.
+func pruneFontSources(css string) string {
+	return fontFaceRegex.ReplaceAllStringFunc(css, func(rule string) string {
+		if !strings.Contains(strings.ToLower(rule), "woff2") {
+			return rule
+		}
+		return fontSrcRegex.ReplaceAllStringFunc(rule, func(decl string) string {
+			m := fontSrcRegex.FindStringSubmatch(decl)
+			var kept []string
+			for _, source := range splitSources(m[2]) {
+				lower := strings.ToLower(source)
+				if strings.HasPrefix(lower, "local(") || strings.Contains(lower, "woff2") {
+					kept = append(kept, source)
+				}
+			}
+			if len(kept) == 0 {
+				return m[1]
+			}
+			return m[1] + "src: " + strings.Join(kept, ", ") + ";"
+		})
+	})
+}
+
+// splitSources splits a src value at the commas between its sources.
+func splitSources(value string) []string {
+	var sources []string
+	depth, start := 0, 0
+	var quote byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			sources = append(sources, strings.TrimSpace(value[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(value[start:]); last != "" {
+		sources = append(sources, last)
+	}
+	return sources
+}
@@ -0,0 +1,66 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFontEmbedding(t *testing.T) {
+	tempDir := t.TempDir()
+	for name, content := range map[string]string{"f.eot": "EOT", "f.woff2": "wOF2", "f.woff": "wOFF", "g.ttf": "TTF"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := "<style>@font-face { font-family: F; src: url(f.eot);\n" +
+		"  src: local(\"F\"), url(f.woff2) format(\"woff2\"), url('f.woff') format(\"woff\"); }\n" +
+		"@font-face { font-family: G; src: url(g.ttf); }</style>"
+
+	inlined, errs := markdown.NewProcessor(markdown.Options{}).InlineStylesheets(page, tempDir)
+	if len(errs) != 0 {
+		t.Fatalf("Unexpected errors %v", errs)
+	}
+	want := "<style>@font-face { font-family: F; \n" +
+		"  src: local(\"F\"), url(\"data:font/woff2;base64,d09GMg==\") format(\"woff2\"); }\n" +
+		"@font-face { font-family: G; src: url(\"data:font/ttf;base64,VFRG\"); }</style>"
+	if inlined != want {
+		t.Errorf("Unexpected WOFF2 embedding:\n%s", inlined)
+	}
+
+	inlined, _ = markdown.NewProcessor(markdown.Options{Fonts: markdown.FontsAll}).InlineStylesheets(page, tempDir)
+	if strings.Count(inlined, "data:font/") != 3 || !strings.Contains(inlined, "data:application/vnd.ms-fontobject") {
+		t.Errorf("Expected every source embedded, got:\n%s", inlined)
+	}
+	inlined, _ = markdown.NewProcessor(markdown.Options{Fonts: markdown.FontsNone}).InlineStylesheets(page, tempDir)
+	if inlined != page {
+		t.Errorf("Expected fonts left as references, got:\n%s", inlined)
+	}
+	if _, err := markdown.ParseFontEmbedding("ttf"); err == nil {
+		t.Errorf("Expected an error for an unknown mode")
+	}
+}
+
+func TestGoogleFontsUserAgent(t *testing.T) {
+	var agent string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agent = r.UserAgent()
+		w.Write([]byte("body { color: black }"))
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: &http.Transport{Proxy: func(*http.Request) (*url.URL, error) { return url.Parse(server.URL) }}}
+
+	page := `<link rel="stylesheet" href="http://fonts.googleapis.com/css2?family=Inter">`
+	inlined, errs := markdown.NewProcessor(markdown.Options{HTTPClient: client}).InlineStylesheets(page, ".")
+	if len(errs) != 0 || !strings.Contains(inlined, "body { color: black }") {
+		t.Fatalf("Expected the stylesheet inlined, got %q, %v", inlined, errs)
+	}
+	if !strings.Contains(agent, "Chrome") {
+		t.Errorf("Expected a browser User-Agent for Google Fonts, got %q", agent)
+	}
+}
@@ -0,0 +1,114 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"sync"
+)
+
+// DecodeFunc decodes an image of a registered format. It may be called from
+// several goroutines at once.
+type DecodeFunc func(r io.Reader) (image.Image, error)
+
+// EncodeFunc encodes an image in a registered format. quality is the JPEG
+// quality setting (1-100), which lossy encoders may honor. Like DecodeFunc,
+// it must be safe for concurrent use.
+type EncodeFunc func(w io.Writer, img image.Image, quality int) error
+
+// registeredFormat is a codec added with RegisterFormat.
+type registeredFormat struct {
+	mimeType string
+	magic    string
+	decode   DecodeFunc
+	encode   EncodeFunc
+}
+
+var (
+	formatsMu sync.RWMutex
+	formats   []registeredFormat
+)
+
+// RegisterFormat adds support for an image format, typically from the init
+// function of a side package, so that formats such as JPEG XL or DDS need no
+// changes here. magic is the format's leading bytes, where '?' matches any
+// byte (as in image.RegisterFormat); an empty magic registers codecs for a
+// format that is already recognized, such as an encoder for image/webp.
+//
+// decode is required. If encode is nil, images are re-encoded as PNG;
+// otherwise they are embedded as mimeType, so register an encoder only for
+// formats the target renderers can display. Later registrations of the same
+// MIME type take precedence.
+func RegisterFormat(mimeType, magic string, decode DecodeFunc, encode EncodeFunc) {
+	formatsMu.Lock()
+	defer formatsMu.Unlock()
+	formats = append([]registeredFormat{{mimeType: mimeType, magic: magic, decode: decode, encode: encode}}, formats...)
+}
+
+// sniffRegistered returns the MIME type of the first registered format whose
+// magic matches content, or "".
+func sniffRegistered(content []byte) string {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.magic != "" && matchMagic(f.magic, content) {
+			return f.mimeType
+		}
+	}
+	return ""
+}
+
+// lookupFormat returns the registered codecs for mimeType.
+func lookupFormat(mimeType string) (registeredFormat, bool) {
+	formatsMu.RLock()
+	defer formatsMu.RUnlock()
+	for _, f := range formats {
+		if f.mimeType == mimeType {
+			return f, true
+		}
+	}
+	return registeredFormat{}, false
+}
+
+func matchMagic(magic string, content []byte) bool {
+	if len(content) < len(magic) {
+		return false
+	}
+	for i := 0; i < len(magic); i++ {
+		if magic[i] != '?' && magic[i] != content[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeImage decodes content of the sniffed mimeType, preferring a
+// registered decoder over the standard library's. A panicking decoder, or
+// one returning no pixels, fails only the image at hand.
+func decodeImage(mimeType string, content []byte) (img image.Image, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			img, err = nil, fmt.Errorf("%s decoder failed: %v", mimeType, r)
+		}
+	}()
+	if f, ok := lookupFormat(mimeType); ok {
+		img, err = f.decode(bytes.NewReader(content))
+	} else {
+		img, _, err = image.Decode(bytes.NewReader(content))
+	}
+	if err == nil && (img == nil || img.Bounds().Empty()) {
+		return nil, fmt.Errorf("%s decoder returned an empty image", mimeType)
+	}
+	return img, err
+}
+
+// safeEncode calls a registered encoder, turning a panic into an error.
+func safeEncode(encode EncodeFunc, w io.Writer, img image.Image, quality int) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("encoder failed: %v", r)
+		}
+	}()
+	return encode(w, img, quality)
+}
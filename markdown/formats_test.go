@@ -0,0 +1,214 @@
+package markdown_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"io"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// decodeTestFormat decodes a made-up format: magic, then width and height
+// as single bytes.
+func decodeTestFormat(r io.Reader) (image.Image, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return image.NewRGBA(image.Rect(0, 0, int(data[5]), int(data[6]))), nil
+}
+
+func TestRegisterFormat(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"a.xt1": "XTST1\x08\x04",
+		"b.xt2": "XTST2\x08\x04",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markdown.RegisterFormat("image/x-test1", "XTST1", decodeTestFormat, func(w io.Writer, img image.Image, quality int) error {
+		_, err := w.Write([]byte{'X', 'T', 'S', 'T', '1', byte(img.Bounds().Dx()), byte(img.Bounds().Dy())})
+		return err
+	})
+	markdown.RegisterFormat("image/x-test2", "XT?T2", decodeTestFormat, nil)
+
+	result, err := markdown.NewProcessor(markdown.Options{}).Process("![a](a.xt1){: width=4} ![b](b.xt2)", tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for _, img := range result.Images {
+		if !img.Embedded {
+			t.Fatalf("Expected %s to be embedded, got %v", img.Reference.ImagePath, img.Err)
+		}
+	}
+
+	// The format with an encoder keeps its MIME type and is resized.
+	encoded := regexp.MustCompile(`data:image/x-test1;base64,([^)]+)`).FindStringSubmatch(result.Content)
+	if encoded == nil {
+		t.Fatalf("Expected an image/x-test1 data URI in %q", result.Content)
+	}
+	if data, _ := base64.StdEncoding.DecodeString(encoded[1]); !bytes.Equal(data, []byte("XTST1\x04\x02")) {
+		t.Errorf("Unexpected encoded data %q", data)
+	}
+	// The decode-only format is converted to PNG.
+	if !strings.Contains(result.Content, "![b](data:image/png;base64,") {
+		t.Errorf("Expected the decode-only format as PNG, got %q", result.Content)
+	}
+}
+
+func TestObjectAndEmbedTags(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"diagram.svg": `<svg xmlns="http://www.w3.org/2000/svg" width="3" height="3"/>`,
+		"figure.pdf":  "%PDF-1.7\x08\x04",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := `<object data="diagram.svg" type="image/svg+xml">Diagram</object>` + "\n" +
+		`<embed src="figure.pdf" type="application/pdf" width="400">` + "\n" +
+		`<embed src="movie.mp4">`
+
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Images) != 2 {
+		t.Fatalf("Expected the SVG and the PDF, got %d images", len(result.Images))
+	}
+	if !strings.HasPrefix(result.Content, `<object data="data:image/svg+xml;base64,`) || !strings.Contains(result.Content, `" type="image/svg+xml">Diagram</object>`) {
+		t.Errorf("Expected the SVG embedded in the object tag, got %q", result.Content)
+	}
+	if markdown.CodeOf(result.Images[1].Err) != markdown.CodeUnsupportedFormat || !strings.Contains(result.Content, `<embed src="figure.pdf"`) {
+		t.Errorf("Expected the PDF to fail without a rasterizer, got %v", result.Images[1].Err)
+	}
+
+	// With a registered decoder, the first page is embedded as PNG.
+	markdown.RegisterFormat("application/pdf", "%PDF-", func(r io.Reader) (image.Image, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return image.NewRGBA(image.Rect(0, 0, int(data[8]), int(data[9]))), nil
+	}, nil)
+	result, err = markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !strings.Contains(result.Content, `<embed src="data:image/png;base64,`) || !strings.Contains(result.Content, `" type="image/png" width="400">`) {
+		t.Errorf("Expected the rasterized PDF in the embed tag, got %q", result.Content)
+	}
+	if !strings.HasSuffix(result.Content, `<embed src="movie.mp4">`) {
+		t.Errorf("Expected other embedded content to be left alone, got %q", result.Content)
+	}
+}
+
+func TestPDFThumbnails(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "spec.pdf"), []byte("%PDF-1.7\x40\x20"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	markdown.RegisterFormat("application/pdf", "%PDF-", func(r io.Reader) (image.Image, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return image.NewRGBA(image.Rect(0, 0, int(data[8]), int(data[9]))), nil
+	}, nil)
+	content := "Read the [spec](spec.pdf).\n\n  - [Missing](missing.pdf)\n" +
+		"- ![not a link](spec.pdf) [remote](https://example.com/a.pdf)\n```\n[code](spec.pdf)\n```\n"
+
+	result, err := markdown.NewProcessor(markdown.Options{PDFThumbnails: 16}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Images) != 3 || !result.Images[0].Reference.Preview || result.Images[0].Width != 16 {
+		t.Fatalf("Unexpected images %+v", result.Images)
+	}
+	if !regexp.MustCompile(`^!\[spec\]\(data:image/png;base64,[^)]+\)\nRead the \[spec\]\(spec.pdf\)\.\n`).MatchString(result.Content) {
+		t.Errorf("Expected a preview above the link, got %q", result.Content)
+	}
+	// A preview that can't be rendered is left out.
+	if result.Images[1].Err == nil || !strings.Contains(result.Content, "\n\n  - [Missing](missing.pdf)\n- ![not a link](data:") {
+		t.Errorf("Expected no preview for the missing PDF, got %q", result.Content)
+	}
+	if !strings.HasSuffix(result.Content, "```\n[code](spec.pdf)\n```\n") {
+		t.Errorf("Expected links in code blocks to be ignored, got %q", result.Content)
+	}
+
+	result, err = markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil || len(result.Images) != 1 {
+		t.Errorf("Expected no previews by default, got %+v, %v", result.Images, err)
+	}
+}
+
+func TestVideoPosters(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"demo.mp4":  "\x00\x00\x00\x18ftypisom\x30\x10",
+		"clip.webm": "\x1a\x45\xdf\xa3\x30\x10",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := "<video controls src=\"demo.mp4\"></video>\n" +
+		"<video controls>\n  <source src=\"clip.webm\" type=\"video/webm\">\n</video>\n" +
+		"<video src=\"demo.mp4\" poster=\"cover.png\"></video>\n" +
+		"- [Watch the demo](demo.mp4)\n"
+
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil || result.Content != content || len(result.Images) != 0 {
+		t.Fatalf("Expected videos to be ignored by default, got %q, %v", result.Content, err)
+	}
+
+	result, err = markdown.NewProcessor(markdown.Options{VideoPosters: 8}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(result.Images) != 3 || markdown.CodeOf(result.Images[0].Err) != markdown.CodeUnsupportedFormat {
+		t.Fatalf("Expected the videos to fail without a decoder, got %+v", result.Images)
+	}
+	if result.Content != content {
+		t.Errorf("Expected the document unchanged without a decoder, got %q", result.Content)
+	}
+
+	frame := func(r io.Reader) (image.Image, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return image.NewRGBA(image.Rect(0, 0, int(data[len(data)-2]), int(data[len(data)-1]))), nil
+	}
+	markdown.RegisterFormat("video/mp4", "", frame, nil)
+	markdown.RegisterFormat("video/webm", "", frame, nil)
+	result, err = markdown.NewProcessor(markdown.Options{VideoPosters: 8}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for _, want := range []string{
+		`<video controls src="demo.mp4" poster="data:image/png;base64,`,
+		"<video controls poster=\"data:image/png;base64,",
+		"<source src=\"clip.webm\" type=\"video/webm\">\n</video>\n",
+		`<video src="demo.mp4" poster="cover.png"></video>`,
+		"\n- [Watch the demo](demo.mp4)\n",
+	} {
+		if !strings.Contains(result.Content, want) {
+			t.Errorf("Expected %q in %q", want, result.Content)
+		}
+	}
+	if preview := result.Images[2]; !preview.Reference.Preview || preview.Width != 8 {
+		t.Errorf("Expected an 8 pixel wide preview above the link, got %+v", preview)
+	}
+}
@@ -0,0 +1,110 @@
+package markdown_test
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+// offlineTransport fails every request, keeping fuzzing off the network.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errors.New("offline")
+}
+
+// FuzzProcess feeds arbitrary documents through the scanner. None of the
+// images can be loaded, so the document must come back unchanged, and every
+// reference must point at the text it was found in.
+func FuzzProcess(f *testing.F) {
+	for _, seed := range []string{
+		"![alt](image.png)",
+		"![alt](image.png \"title\"){: width=50 height=20}",
+		"![a](<path with spaces.png>) ![b](b.png 'single')",
+		"![a\\](b)](c.png)",
+		"<img src=\"a.png\" alt=\"x\" width=\"10\">",
+		"<img src='a.png'/><object data=\"d.svg\"></object><embed src=\"e.pdf\">",
+		"<video poster=\"p.png\" src=\"v.mp4\"></video>",
+		"```\n![code](a.png)\n```\n![real](b.png)",
+		"    ![indented](a.png)\n\n`![span](a.png)`",
+		"<!-- markdown-images: skip -->\n![a](a.png)",
+		"[![badge](b.svg)](https://example.com)",
+		"![unclosed](a.png\n![x](",
+		"<img src=\"a.png\"",
+		"{: width=}![a](a.png){:",
+	} {
+		f.Add(seed)
+	}
+	processor := markdown.NewProcessor(markdown.Options{
+		HTTPClient: &http.Client{Transport: offlineTransport{}},
+	})
+	baseDir := f.TempDir()
+
+	f.Fuzz(func(t *testing.T, content string) {
+		result, err := processor.Process(content, baseDir)
+		if err != nil {
+			return
+		}
+		embedded := false
+		for _, img := range result.Images {
+			ref := img.Reference
+			embedded = embedded || img.Embedded
+			if ref.Preview {
+				continue
+			}
+			if ref.StartPos < 0 || ref.StartPos > ref.EndPos || ref.EndPos > len(content) {
+				t.Fatalf("reference %q out of bounds: [%d:%d] of %d", ref.FullMatch, ref.StartPos, ref.EndPos, len(content))
+			}
+			if got := content[ref.StartPos:ref.EndPos]; got != ref.FullMatch {
+				t.Fatalf("reference at [%d:%d] = %q, FullMatch %q", ref.StartPos, ref.EndPos, got, ref.FullMatch)
+			}
+		}
+		if !embedded && result.Content != content {
+			t.Fatalf("content changed without embedding:\n%q\n%q", content, result.Content)
+		}
+	})
+}
+
+// FuzzAttributeBlock fuzzes the {: width= height=} block after a loadable
+// image. Whatever it holds, the image must be found once, the text after
+// it kept, and an embedded image must have a usable size.
+func FuzzAttributeBlock(f *testing.F) {
+	for _, seed := range []string{
+		"{: width=50}",
+		"{: width=50 height=20}",
+		"{width=1 height=99999999}",
+		"{: height=1}",
+		"{: width=-5}",
+		"{: width=50 height=20 .class #id}",
+		"{:width=50}{: width=20}",
+		"{: width=99999999999999999999}",
+		"{: width=\n50}",
+		"{",
+	} {
+		f.Add(seed)
+	}
+	tempDir := f.TempDir()
+	writeTestPNG(f, tempDir, "a.png", 400, 10)
+	processor := markdown.NewProcessor(markdown.Options{})
+
+	f.Fuzz(func(t *testing.T, block string) {
+		content := "![a](a.png)" + block
+		result, err := processor.Process(content, tempDir)
+		if err != nil {
+			return
+		}
+		if len(result.Images) != 1 {
+			t.Fatalf("found %d images in %q", len(result.Images), content)
+		}
+		img := result.Images[0]
+		if !strings.HasSuffix(result.Content, content[img.Reference.EndPos:]) {
+			t.Fatalf("text after the image lost: %q became %q", content, result.Content)
+		}
+		if img.Embedded && (img.Width <= 0 || img.Height <= 0) {
+			t.Fatalf("embedded %q at %dx%d", content, img.Width, img.Height)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+package markdown
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// GitHub's raw and camo endpoints rate-limit anonymous clients aggressively.
+// Requests to them are retried after the delay the server asks for, and
+// downloads are kept for the lifetime of the Processor.
+const (
+	gitHubRetries = 3
+	maxGitHubWait = time.Minute
+)
+
+// isGitHubHost reports whether host serves GitHub-hosted images.
+func isGitHubHost(host string) bool {
+	host = strings.ToLower(host)
+	return host == "github.com" || host == "githubusercontent.com" || strings.HasSuffix(host, ".githubusercontent.com")
+}
+
+// sendsGitHubToken reports whether the token may be sent to host. Camo
+// proxies third-party images, so it never gets the token.
+func sendsGitHubToken(host string) bool {
+	host = strings.ToLower(host)
+	return host == "github.com" || host == "raw.githubusercontent.com" || host == "api.github.com"
+}
+
+// get fetches requestURL. For GitHub hosts a rate-limited response is
+// retried up to gitHubRetries times, honoring Retry-After and
+// X-RateLimit-Reset; if it is still limited and a token is configured, raw
+// file URLs fall back to the authenticated contents API.
+func (p *Processor) get(ctx context.Context, client *http.Client, requestURL string) (*http.Response, error) {
+	u, err := url.Parse(requestURL)
+	if err != nil {
+		return nil, err
+	}
+	gitHub := isGitHubHost(u.Hostname())
+	for attempt := 0; ; attempt++ {
+		resp, err := p.send(ctx, client, requestURL, "")
+		if err != nil || !gitHub {
+			return resp, err
+		}
+		wait, limited := rateLimitWait(resp, time.Now())
+		if !limited {
+			return resp, nil
+		}
+		if attempt == gitHubRetries {
+			if apiURL := gitHubContentsURL(u); apiURL != "" && p.opts.GitHubToken != "" {
+				resp.Body.Close()
+				return p.send(ctx, client, apiURL, "application/vnd.github.raw")
+			}
+			return resp, nil
+		}
+		resp.Body.Close()
+		p.log.Debug("Rate limited, retrying", "host", u.Host, "wait", wait)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, context.Cause(ctx)
+		}
+	}
+}
+
+// send performs a single GET, adding the GitHub token where appropriate.
+func (p *Processor) send(ctx context.Context, client *http.Client, requestURL, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if req.URL.Hostname() == "fonts.googleapis.com" {
+		req.Header.Set("User-Agent", woff2UserAgent)
+	}
+	if p.opts.GitHubToken != "" && sendsGitHubToken(req.URL.Hostname()) {
+		req.Header.Set("Authorization", "Bearer "+p.opts.GitHubToken)
+	}
+	return client.Do(req)
+}
+
+// rateLimitWait reports whether resp is a rate-limit response and how long
+// to wait before retrying, capped at maxGitHubWait.
+func rateLimitWait(resp *http.Response, now time.Time) (time.Duration, bool) {
+	retryAfter := resp.Header.Get("Retry-After")
+	exhausted := resp.Header.Get("X-RateLimit-Remaining") == "0"
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+	case resp.StatusCode == http.StatusForbidden && (retryAfter != "" || exhausted):
+	default:
+		return 0, false
+	}
+
+	wait := time.Second
+	if secs, err := strconv.Atoi(retryAfter); err == nil {
+		wait = time.Duration(secs) * time.Second
+	} else if at, err := http.ParseTime(retryAfter); err == nil {
+		wait = at.Sub(now)
+	} else if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil && exhausted {
+		wait = time.Unix(reset, 0).Sub(now)
+	}
+	return min(max(wait, 0), maxGitHubWait), true
+}
+
+// gitHubContentsURL maps a raw file URL
+// (raw.githubusercontent.com/owner/repo/ref/path) to the equivalent contents
+// API URL, or returns "" for other URLs. Refs containing slashes are not
+// supported.
+func gitHubContentsURL(u *url.URL) string {
+	if strings.ToLower(u.Hostname()) != "raw.githubusercontent.com" {
+		return ""
+	}
+	parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 4)
+	if len(parts) < 4 || parts[3] == "" {
+		return ""
+	}
+	owner, repo, ref, file := parts[0], parts[1], parts[2], parts[3]
+	return "https://api.github.com/repos/" + owner + "/" + repo + "/contents/" + file + "?ref=" + url.QueryEscape(ref)
+}
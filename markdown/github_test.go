@@ -0,0 +1,104 @@
+package markdown_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"markdown-images/markdown"
+	"net/http"
+	"testing"
+)
+
+// gitHubTransport rate-limits the first limited requests, then serves a PNG.
+type gitHubTransport struct {
+	limited  int
+	requests []*http.Request
+}
+
+func (rt *gitHubTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.requests = append(rt.requests, req)
+	if req.URL.Host != "api.github.com" && len(rt.requests) <= rt.limited {
+		return &http.Response{
+			StatusCode: http.StatusTooManyRequests,
+			Header:     http.Header{"Retry-After": []string{"0"}},
+			Body:       io.NopCloser(bytes.NewReader(nil)),
+			Request:    req,
+		}, nil
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 4, 4)))
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"image/png"}},
+		Body:       io.NopCloser(&buf),
+		Request:    req,
+	}, nil
+}
+
+func TestGitHubRateLimitRetry(t *testing.T) {
+	transport := &gitHubTransport{limited: 2}
+	processor := markdown.NewProcessor(markdown.Options{HTTPClient: &http.Client{Transport: transport}})
+
+	input := "![a](https://raw.githubusercontent.com/o/r/main/a.png) ![b](https://raw.githubusercontent.com/o/r/main/a.png){: width=2}"
+	result, err := processor.Process(input, t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for _, img := range result.Images {
+		if !img.Embedded {
+			t.Errorf("Expected %s to be embedded, got %v", img.Reference.ImagePath, img.Err)
+		}
+	}
+	// Two rate-limited attempts and one success; the second reference is
+	// served from the download cache.
+	if len(transport.requests) != 3 {
+		t.Errorf("Expected 3 requests, got %d", len(transport.requests))
+	}
+	for _, req := range transport.requests {
+		if req.Header.Get("Authorization") != "" {
+			t.Errorf("Unexpected Authorization header without a token")
+		}
+	}
+}
+
+func TestGitHubAPIFallback(t *testing.T) {
+	transport := &gitHubTransport{limited: 100}
+	processor := markdown.NewProcessor(markdown.Options{
+		HTTPClient:  &http.Client{Transport: transport},
+		GitHubToken: "secret",
+	})
+
+	result, err := processor.Process("![a](https://raw.githubusercontent.com/o/r/v1.0/docs/a.png)", t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !result.Images[0].Embedded {
+		t.Fatalf("Expected image to be embedded, got %v", result.Images[0].Err)
+	}
+	last := transport.requests[len(transport.requests)-1]
+	if got := last.URL.String(); got != "https://api.github.com/repos/o/r/contents/docs/a.png?ref=v1.0" {
+		t.Errorf("Unexpected fallback URL %s", got)
+	}
+	if last.Header.Get("Authorization") != "Bearer secret" || last.Header.Get("Accept") != "application/vnd.github.raw" {
+		t.Errorf("Unexpected fallback headers %v", last.Header)
+	}
+	if transport.requests[0].Header.Get("Authorization") != "Bearer secret" {
+		t.Errorf("Expected the token on raw.githubusercontent.com requests")
+	}
+}
+
+func TestGitHubTokenNotSentToCamo(t *testing.T) {
+	transport := &gitHubTransport{}
+	processor := markdown.NewProcessor(markdown.Options{
+		HTTPClient:  &http.Client{Transport: transport},
+		GitHubToken: "secret",
+	})
+
+	if _, err := processor.Process("![a](https://camo.githubusercontent.com/abc/def)", t.TempDir()); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if len(transport.requests) != 1 || transport.requests[0].Header.Get("Authorization") != "" {
+		t.Errorf("Expected one request without a token, got %v", transport.requests)
+	}
+}
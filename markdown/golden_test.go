@@ -0,0 +1,104 @@
+package markdown_test
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata/golden")
+
+// dataURIPayloadRegex matches the payload of a base64 data URI. Encoder
+// output may change between Go releases, so golden files keep only the
+// MIME type; the report records the embedded dimensions instead.
+var dataURIPayloadRegex = regexp.MustCompile(`(data:[\w.+/-]+;base64,)[A-Za-z0-9+/=]+`)
+
+// TestGolden processes each document in testdata/golden/<name>/input.md,
+// with the options in an optional options.json beside it, and compares the
+// output and a per-image report with output.md and report.txt. Run
+//
+//	go test ./markdown -run TestGolden -update
+//
+// to rewrite them after an intended change, and review the diff.
+func TestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "golden", "*", "input.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) == 0 {
+		t.Fatal("No golden inputs found")
+	}
+	for _, input := range inputs {
+		dir := filepath.Dir(input)
+		t.Run(filepath.Base(dir), func(t *testing.T) {
+			content, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var opts markdown.Options
+			if data, err := os.ReadFile(filepath.Join(dir, "options.json")); err == nil {
+				if err := json.Unmarshal(data, &opts); err != nil {
+					t.Fatalf("Invalid options.json: %v", err)
+				}
+			} else if !errors.Is(err, os.ErrNotExist) {
+				t.Fatal(err)
+			}
+			opts.HTTPClient = &http.Client{Transport: offlineTransport{}}
+
+			result, err := markdown.NewProcessor(opts).Process(string(content), dir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			checkGolden(t, filepath.Join(dir, "output.md"), dataURIPayloadRegex.ReplaceAllString(result.Content, "${1}..."))
+			checkGolden(t, filepath.Join(dir, "report.txt"), goldenReport(string(content), result))
+		})
+	}
+}
+
+// goldenReport lists the outcome for each image, one line per reference.
+func goldenReport(content string, result *markdown.Result) string {
+	var b strings.Builder
+	for _, img := range result.Images {
+		ref := img.Reference
+		fmt.Fprintf(&b, "line %d: %s: ", strings.Count(content[:ref.StartPos], "\n")+1, ref.ImagePath)
+		switch {
+		case img.Err != nil:
+			code := markdown.CodeOf(img.Err)
+			fmt.Fprintf(&b, "%s %s", code, code.Name())
+		case img.Embedded:
+			fmt.Fprintf(&b, "embedded %s %dx%d", img.MIMEType, img.Width, img.Height)
+		default:
+			fmt.Fprintf(&b, "skipped (%s)", img.SkipReason)
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// checkGolden compares got with the golden file at path, or rewrites the
+// file with -update.
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs from the output (run with -update to accept it):\n--- want\n%s\n--- got\n%s", path, want, got)
+	}
+}
@@ -0,0 +1,72 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+
+	"github.com/alecthomas/chroma"
+	chromahtml "github.com/alecthomas/chroma/formatters/html"
+	"github.com/alecthomas/chroma/lexers"
+	"github.com/alecthomas/chroma/styles"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// HighlightStyles lists the styles accepted by HTMLOptions.Highlight.
+func HighlightStyles() []string {
+	return styles.Names()
+}
+
+// highlighter renders fenced code blocks with chroma, styled inline so the
+// page needs neither a highlighting script nor a stylesheet. Blocks without
+// a language, or with one chroma doesn't know, are rendered plain.
+type highlighter struct {
+	style     *chroma.Style
+	formatter *chromahtml.Formatter
+}
+
+func newHighlighter(style string) (*highlighter, error) {
+	s, ok := styles.Registry[style]
+	if !ok {
+		return nil, fmt.Errorf("unknown highlight style %q", style)
+	}
+	return &highlighter{style: s, formatter: chromahtml.New(chromahtml.WithClasses(false), chromahtml.TabWidth(4))}, nil
+}
+
+func (h *highlighter) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, h.renderFencedCodeBlock)
+}
+
+func (h *highlighter) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block := node.(*ast.FencedCodeBlock)
+	var code bytes.Buffer
+	for i := 0; i < block.Lines().Len(); i++ {
+		line := block.Lines().At(i)
+		code.Write(line.Value(source))
+	}
+	language := string(block.Language(source))
+
+	if lexer := lexers.Get(language); language != "" && lexer != nil {
+		if tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String()); err == nil {
+			var highlighted bytes.Buffer
+			if err := h.formatter.Format(&highlighted, h.style, tokens); err == nil {
+				w.Write(highlighted.Bytes())
+				w.WriteByte('\n')
+				return ast.WalkSkipChildren, nil
+			}
+		}
+	}
+	w.WriteString("<pre><code")
+	if language != "" {
+		fmt.Fprintf(w, ` class="language-%s"`, html.EscapeString(language))
+	}
+	w.WriteString(">")
+	w.WriteString(html.EscapeString(code.String()))
+	w.WriteString("</code></pre>\n")
+	return ast.WalkSkipChildren, nil
+}
@@ -0,0 +1,177 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// HTMLOptions configures RenderHTML.
+type HTMLOptions struct {
+	// Title is the page title. Empty means the document's first heading.
+	Title string
+	// CSS is placed in a <style> element in the page head, e.g. a Theme.
+	CSS string
+	// Stylesheets are linked from the page head, in order, after CSS.
+	Stylesheets []string
+	// Highlight is the style, one of HighlightStyles (e.g. "github"), in
+	// which fenced code blocks with a language are highlighted. Empty
+	// leaves code blocks plain.
+	Highlight string
+	// TOCDepth, when positive, starts the page with a linked table of
+	// contents of the headings of this level or higher.
+	TOCDepth int
+}
+
+// newConverter returns a renderer of GitHub-flavored markdown. Raw HTML,
+// such as the img tags written with AttrStyleHTML, is passed through, and so
+// are data URIs of every image type (goldmark would otherwise drop SVG ones).
+// Heading ids are assigned by linkHeadings.
+func newConverter(opts HTMLOptions) (goldmark.Markdown, error) {
+	rendererOptions := []renderer.Option{gmhtml.WithUnsafe()}
+	if opts.Highlight != "" {
+		h, err := newHighlighter(opts.Highlight)
+		if err != nil {
+			return nil, err
+		}
+		rendererOptions = append(rendererOptions, renderer.WithNodeRenderers(util.Prioritized(h, 100)))
+	}
+	return goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(rendererOptions...),
+	), nil
+}
+
+// RenderHTML converts markdown, typically the Content of a processed Result,
+// into a standalone HTML page.
+func RenderHTML(content string, opts HTMLOptions) (string, error) {
+	content, _ = StripBOM(content)
+	converter, err := newConverter(opts)
+	if err != nil {
+		return "", err
+	}
+	source := []byte(content)
+	doc := converter.Parser().Parse(text.NewReader(source))
+	linkHeadings(doc, source)
+	var body bytes.Buffer
+	if opts.TOCDepth > 0 {
+		writeTOC(&body, doc, source, opts.TOCDepth)
+	}
+	if err := converter.Renderer().Render(&body, source, doc); err != nil {
+		return "", err
+	}
+	title := opts.Title
+	if title == "" {
+		title = documentTitle(content)
+	}
+
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+	b.WriteString("<meta name=\"viewport\" content=\"width=device-width, initial-scale=1\">\n")
+	fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+	if opts.CSS != "" {
+		fmt.Fprintf(&b, "<style>\n%s\n</style>\n", strings.TrimRight(opts.CSS, "\n"))
+	}
+	for _, href := range opts.Stylesheets {
+		fmt.Fprintf(&b, "<link rel=\"stylesheet\" href=\"%s\">\n", html.EscapeString(href))
+	}
+	b.WriteString("</head>\n<body>\n")
+	b.Write(body.Bytes())
+	b.WriteString("</body>\n</html>\n")
+	return b.String(), nil
+}
+
+// documentTitle returns the text of the first ATX heading outside code
+// blocks, or "".
+func documentTitle(content string) string {
+	codeBlocks := codeBlockRanges(content)
+	pos := 0
+	for _, line := range strings.SplitAfter(content, "\n") {
+		if !inRanges(codeBlocks, pos) {
+			if level, title := atxHeading(strings.TrimRight(line, "\r\n")); level > 0 {
+				return title
+			}
+		}
+		pos += len(line)
+	}
+	return ""
+}
+
+// writeTOC writes a nested list linking to the headings of doc up to depth,
+// using the ids linkHeadings assigned them.
+func writeTOC(w *bytes.Buffer, doc ast.Node, source []byte, depth int) {
+	type entry struct {
+		level     int
+		id, title string
+	}
+	var entries []entry
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		heading, ok := n.(*ast.Heading)
+		if !entering || !ok {
+			return ast.WalkContinue, nil
+		}
+		if id, ok := heading.AttributeString("id"); ok && heading.Level <= depth {
+			if idBytes, ok := id.([]byte); ok {
+				entries = append(entries, entry{heading.Level, string(idBytes), headingText(heading, source)})
+			}
+		}
+		return ast.WalkSkipChildren, nil
+	})
+	if len(entries) == 0 {
+		return
+	}
+
+	// levels holds the heading level of every open list; a deeper heading
+	// opens a nested one.
+	w.WriteString("<nav class=\"toc\">\n")
+	var levels []int
+	for _, e := range entries {
+		for len(levels) > 0 && levels[len(levels)-1] > e.level {
+			w.WriteString("</li>\n</ul>\n")
+			levels = levels[:len(levels)-1]
+		}
+		if len(levels) == 0 || levels[len(levels)-1] < e.level {
+			w.WriteString("<ul>\n")
+			levels = append(levels, e.level)
+		} else {
+			w.WriteString("</li>\n")
+		}
+		fmt.Fprintf(w, "<li><a href=\"#%s\">%s</a>", html.EscapeString(e.id), html.EscapeString(e.title))
+	}
+	for range levels {
+		w.WriteString("</li>\n</ul>\n")
+	}
+	w.WriteString("</nav>\n")
+}
+
+// headingText returns the plain text of a heading, without images.
+func headingText(heading ast.Node, source []byte) string {
+	var b strings.Builder
+	ast.Walk(heading, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Image:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			b.Write(n.Value(source))
+			if n.SoftLineBreak() {
+				b.WriteByte(' ')
+			}
+		case *ast.String:
+			b.Write(n.Value)
+		}
+		return ast.WalkContinue, nil
+	})
+	return strings.TrimSpace(b.String())
+}
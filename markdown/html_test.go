@@ -0,0 +1,166 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"strings"
+	"testing"
+)
+
+func TestRenderHTML(t *testing.T) {
+	content := "# Guide & Notes\n\n![logo](data:image/svg+xml;base64,PHN2Zy8+)\n\n<img src=\"data:image/png;base64,AAAA\" width=\"10\">\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{Stylesheets: []string{"site.css"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"<title>Guide &amp; Notes</title>",
+		`<link rel="stylesheet" href="site.css">`,
+		`<img src="data:image/svg+xml;base64,PHN2Zy8+" alt="logo">`,
+		`<img src="data:image/png;base64,AAAA" width="10">`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %q in page:\n%s", want, page)
+		}
+	}
+}
+
+func TestShareAssets(t *testing.T) {
+	logo := `<img src="data:image/png;base64,TE9HTw==" alt="logo">`
+	pages := []string{
+		"<head></head>" + logo + `<img src="data:image/png;base64,T05F">` + logo,
+		"<head></head>" + logo,
+		"<head></head>" + `<img src="data:image/png;base64,T05F">`,
+		"<head></head>plain",
+	}
+	rewritten, linked, css := markdown.ShareAssets(pages, 2)
+
+	if strings.Count(css, "content: url(") != 2 || !strings.Contains(css, "data:image/png;base64,TE9HTw==") {
+		t.Errorf("Unexpected stylesheet:\n%s", css)
+	}
+	for i, want := range []bool{true, true, true, false} {
+		if linked[i] != want {
+			t.Errorf("Page %d: expected linked=%v", i, want)
+		}
+		if strings.Contains(rewritten[i], "TE9HTw==") || strings.Contains(rewritten[i], "T05F") {
+			t.Errorf("Page %d still embeds a shared image: %s", i, rewritten[i])
+		}
+	}
+	if !strings.Contains(rewritten[0], `<img class="mdimg-`) || !strings.Contains(rewritten[0], `alt="logo"`) {
+		t.Errorf("Unexpected rewritten page: %s", rewritten[0])
+	}
+
+	if _, _, css := markdown.ShareAssets(pages[:1], 2); css != "" {
+		t.Errorf("Expected nothing shared within a single page, got:\n%s", css)
+	}
+}
+
+func TestThemes(t *testing.T) {
+	if names := strings.Join(markdown.ThemeNames(), ","); names != "dark,github,print" {
+		t.Errorf("Unexpected themes %q", names)
+	}
+	for _, name := range markdown.ThemeNames() {
+		css, ok := markdown.Theme(name)
+		if !ok || !strings.Contains(css, "max-width: 100%") {
+			t.Errorf("Expected theme %s to keep images within the page", name)
+		}
+	}
+	if _, ok := markdown.Theme("solarized"); ok {
+		t.Errorf("Expected no solarized theme")
+	}
+
+	css, _ := markdown.Theme("dark")
+	page, err := markdown.RenderHTML("# Title\n", markdown.HTMLOptions{CSS: css, Stylesheets: []string{"extra.css"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(page, "<style>\n/* dark:") || strings.Index(page, "</style>") > strings.Index(page, `href="extra.css"`) {
+		t.Errorf("Expected the theme before the linked stylesheets:\n%s", page)
+	}
+}
+
+func TestRenderHTMLTableOfContents(t *testing.T) {
+	content := "# Guide\n\n## Install `go`\n\n### Linux ![icon](data:image/png;base64,AAAA)\n\n#### Too deep\n\n## Use\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{TOCDepth: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "<body>\n<nav class=\"toc\">\n<ul>\n<li><a href=\"#guide\">Guide</a><ul>\n" +
+		"<li><a href=\"#install-go\">Install go</a><ul>\n<li><a href=\"#linux\">Linux</a></li>\n</ul>\n" +
+		"</li>\n<li><a href=\"#use\">Use</a></li>\n</ul>\n</li>\n</ul>\n</nav>\n<h1 id=\"guide\">"
+	if !strings.Contains(page, want) {
+		t.Errorf("Unexpected table of contents:\n%s", page)
+	}
+
+	page, _ = markdown.RenderHTML(content, markdown.HTMLOptions{})
+	if strings.Contains(page, "<nav") {
+		t.Errorf("Expected no table of contents by default")
+	}
+}
+
+func TestRenderHTMLHighlight(t *testing.T) {
+	content := "```go\nfunc main() {}\n```\n\n```\n<plain>\n```\n\n```nosuchlang\nx < y\n```\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{Highlight: "github"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<span style="color:#000;font-weight:bold">func</span>`,
+		"<pre><code>&lt;plain&gt;\n</code></pre>",
+		"<pre><code class=\"language-nosuchlang\">x &lt; y\n</code></pre>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %q in\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "class=\"chroma\"") || strings.Contains(page, "<script") {
+		t.Errorf("Expected inline styles only:\n%s", page)
+	}
+
+	if _, err := markdown.RenderHTML(content, markdown.HTMLOptions{Highlight: "nope"}); err == nil {
+		t.Errorf("Expected an error for an unknown style")
+	}
+	page, _ = markdown.RenderHTML(content, markdown.HTMLOptions{})
+	if !strings.Contains(page, "<pre><code class=\"language-go\">func main() {}\n</code></pre>") {
+		t.Errorf("Expected plain code without a style:\n%s", page)
+	}
+}
+
+func TestRenderHTMLInternalLinks(t *testing.T) {
+	content := "# Setup & Install\n\nSee [usage](#Usage), [again](#setup--install) and [away](https://example.com/#usage).\n\n" +
+		"## Usage\n\n## Usage\n\n[second](#usage-1)\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<h1 id="setup--install">`, `<h2 id="usage">`, `<h2 id="usage-1">`,
+		`<a href="#usage">usage</a>`, `<a href="#setup--install">again</a>`,
+		`<a href="https://example.com/#usage">away</a>`, `<a href="#usage-1">second</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %q in\n%s", want, page)
+		}
+	}
+}
+
+func TestRenderHTMLFileMarkers(t *testing.T) {
+	content := markdown.FileMarker("docs/intro.md") + "\n# Intro\n\nRead [install](install.md#usage), [the guide](../guide.md) and [here](#usage).\n\n## Usage\n" +
+		markdown.FileMarker("docs/install.md") + "\n# Install\n\n## Usage\n\nBack to [intro](intro.md#usage), or [up](#install).\n" +
+		markdown.FileMarker("guide.md") + "\n# Guide\n"
+	page, err := markdown.RenderHTML(content, markdown.HTMLOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<h2 id="usage">`, `<h2 id="usage-1">`,
+		`<a href="#usage-1">install</a>`, `<a href="#guide">the guide</a>`, `<a href="#usage">here</a>`,
+		`<a href="#usage">intro</a>`, `<a href="#install">up</a>`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("Expected %q in\n%s", want, page)
+		}
+	}
+	if strings.Contains(page, "markdown-images:file") {
+		t.Errorf("Expected the file markers to be removed:\n%s", page)
+	}
+}
@@ -0,0 +1,92 @@
+package markdown
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// includeRegex matches the include directives <!-- include: file.md --> and
+// the Marked-style {{include file.md}} (optionally {{include: file.md}}).
+var includeRegex = regexp.MustCompile(`<!--\s*include:\s*(.+?)\s*-->|\{\{\s*include:?\s+(.+?)\s*\}\}`)
+
+// maxIncludeDepth bounds nesting as a safety net beyond cycle detection.
+const maxIncludeDepth = 32
+
+// ResolveIncludes replaces include directives in content with the contents of
+// the referenced files, recursively. Include paths are relative to the file
+// containing the directive, and the images of an included file are rebased
+// so that they resolve relative to baseDir, the directory of content.
+// Directives inside fenced code blocks are left alone. Included files lose
+// their byte-order mark and take on the line endings of the including file.
+func ResolveIncludes(content, baseDir string) (string, error) {
+	return resolveIncludes(content, baseDir, nil, nil)
+}
+
+// IncludedFiles returns the absolute paths of the files ResolveIncludes
+// would read for content, directly or through nested includes, in the order
+// they are included. On error the files found so far are returned.
+func IncludedFiles(content, baseDir string) ([]string, error) {
+	var files []string
+	_, err := resolveIncludes(content, baseDir, nil, &files)
+	return files, err
+}
+
+// resolveIncludes implements ResolveIncludes, appending the path of every
+// included file to files if it is not nil.
+func resolveIncludes(content, baseDir string, stack []string, files *[]string) (string, error) {
+	if len(stack) > maxIncludeDepth {
+		return "", fmt.Errorf("includes nested deeper than %d levels", maxIncludeDepth)
+	}
+	codeBlocks := codeBlockRanges(content)
+	newline := DetectNewline(content)
+
+	var b strings.Builder
+	last := 0
+	for _, match := range includeRegex.FindAllStringSubmatchIndex(content, -1) {
+		if inRanges(codeBlocks, match[0]) {
+			continue
+		}
+		target := ""
+		if match[2] >= 0 {
+			target = content[match[2]:match[3]]
+		} else {
+			target = content[match[4]:match[5]]
+		}
+
+		path, err := filepath.Abs(filepath.Join(baseDir, filepath.FromSlash(target)))
+		if err != nil {
+			return "", err
+		}
+		for _, parent := range stack {
+			if parent == path {
+				return "", fmt.Errorf("include cycle: %s includes itself via %s", target, strings.Join(stack, " -> "))
+			}
+		}
+		if files != nil {
+			*files = append(*files, path)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", newError(CodeInputUnreadable, target, err)
+		}
+
+		includedDir := filepath.Dir(path)
+		included, _ := StripBOM(string(data))
+		included, err = resolveIncludes(included, includedDir, append(stack, path), files)
+		if err != nil {
+			return "", err
+		}
+		// The included text takes on the line endings of the including file.
+		included = NormalizeNewlines(RebaseImagePaths(included, includedDir, baseDir), newline)
+		included = strings.TrimSuffix(included, newline)
+
+		b.WriteString(content[last:match[0]])
+		b.WriteString(included)
+		last = match[1]
+	}
+	b.WriteString(content[last:])
+	return b.String(), nil
+}
@@ -0,0 +1,70 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("Failed to create directory for %s: %v", path, err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", path, err)
+	}
+}
+
+func TestResolveIncludes(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "chapters", "two.md"), "## Two\n![fig](img/fig.png)\n<!-- include: parts/detail.md -->\n")
+	writeFile(t, filepath.Join(root, "chapters", "parts", "detail.md"), "Detail ![d](d.png)\n")
+	writeFile(t, filepath.Join(root, "three.md"), "## Three\n")
+
+	input := "# Book\n<!-- include: chapters/two.md -->\n{{include three.md}}\n```\n<!-- include: missing.md -->\n```\n"
+	got, err := markdown.ResolveIncludes(input, root)
+	if err != nil {
+		t.Fatalf("ResolveIncludes failed: %v", err)
+	}
+	expected := "# Book\n## Two\n![fig](chapters/img/fig.png)\nDetail ![d](chapters/parts/d.png)\n## Three\n```\n<!-- include: missing.md -->\n```\n"
+	if got != expected {
+		t.Errorf("Unexpected result:\n%s\nwant:\n%s", got, expected)
+	}
+}
+
+func TestIncludedFiles(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "chapters", "two.md"), "<!-- include: parts/detail.md -->\n")
+	writeFile(t, filepath.Join(root, "chapters", "parts", "detail.md"), "Detail\n")
+
+	files, err := markdown.IncludedFiles("{{include chapters/two.md}}\n<!-- include: missing.md -->\n", root)
+	if err == nil {
+		t.Errorf("Expected an error for the missing include")
+	}
+	abs, _ := filepath.Abs(root)
+	want := []string{
+		filepath.Join(abs, "chapters", "two.md"),
+		filepath.Join(abs, "chapters", "parts", "detail.md"),
+		filepath.Join(abs, "missing.md"),
+	}
+	if strings.Join(files, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got %q, want %q", files, want)
+	}
+}
+
+func TestResolveIncludesErrors(t *testing.T) {
+	root := t.TempDir()
+	writeFile(t, filepath.Join(root, "a.md"), "{{include: b.md}}")
+	writeFile(t, filepath.Join(root, "b.md"), "<!-- include: a.md -->")
+
+	if _, err := markdown.ResolveIncludes("<!-- include: a.md -->", root); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("Expected an include cycle error, got %v", err)
+	}
+	_, err := markdown.ResolveIncludes("<!-- include: missing.md -->", root)
+	if markdown.CodeOf(err) != markdown.CodeInputUnreadable {
+		t.Errorf("Expected %s for a missing include, got %v", markdown.CodeInputUnreadable, err)
+	}
+}
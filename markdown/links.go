@@ -0,0 +1,159 @@
+package markdown
+
+import (
+	"html"
+	"strings"
+)
+
+// linkReference is the location of a link destination within a document.
+type linkReference struct {
+	destination string
+	// start and end delimit the destination as written.
+	start, end int
+	// inTag is set for the href of an <a> tag, which is HTML-escaped.
+	inTag bool
+}
+
+// findLinks returns the destinations of the markdown links ([text](dest)),
+// link reference definitions ([id]: dest) and <a href> tags in content, in
+// document order. Images, including those inside link text, are not links,
+// and code blocks are skipped.
+func findLinks(content string) []linkReference {
+	s := &scanner{content: content, closeFrom: -1}
+	codeBlocks := codeBlockRanges(content)
+	var refs []linkReference
+	for i := 0; i < len(content); {
+		next := strings.IndexAny(content[i:], "![<")
+		if next < 0 {
+			break
+		}
+		i += next
+		if inRanges(codeBlocks, i) || (i > 0 && content[i-1] == '\\') {
+			i++
+			continue
+		}
+		switch content[i] {
+		case '!':
+			if ref, ok := s.markdownImage(i); ok {
+				i = ref.EndPos
+				continue
+			}
+		case '[':
+			if ref, end, ok := s.markdownLink(i); ok {
+				refs = append(refs, ref)
+				i = end
+				continue
+			}
+		case '<':
+			if tag, ok := s.htmlTag(i); ok {
+				if href, ok := tag.attr("href"); ok && tag.name == "a" {
+					refs = append(refs, linkReference{destination: html.UnescapeString(href.value), start: href.valueStart, end: href.valueEnd, inTag: true})
+				}
+				i = tag.end
+				continue
+			}
+		}
+		i++
+	}
+	return refs
+}
+
+// markdownLink parses an inline link or a link reference definition whose
+// text starts at i, returning the position after its destination.
+func (s *scanner) markdownLink(i int) (linkReference, int, bool) {
+	c := s.content
+	textEnd := linkTextEnd(c, i+1)
+	if textEnd < 0 || textEnd+1 >= len(c) {
+		return linkReference{}, 0, false
+	}
+	switch c[textEnd+1] {
+	case '(':
+		start, end, _, next, ok := s.destination(textEnd + 1)
+		if !ok {
+			return linkReference{}, 0, false
+		}
+		return linkReference{destination: c[start:end], start: start, end: end}, next, true
+	case ':':
+		lineStart := strings.LastIndexByte(c[:i], '\n') + 1
+		if strings.TrimLeft(c[lineStart:i], " ") != "" || i-lineStart > 3 {
+			return linkReference{}, 0, false
+		}
+		start := textEnd + 2
+		for start < len(c) && (c[start] == ' ' || c[start] == '\t') {
+			start++
+		}
+		end := start
+		if start < len(c) && c[start] == '<' {
+			start++
+			end = strings.IndexAny(c[start:], ">\n")
+			if end < 0 || c[start+end] != '>' {
+				return linkReference{}, 0, false
+			}
+			end += start
+		} else {
+			for end < len(c) && !isSpace(c[end]) {
+				end++
+			}
+		}
+		if end == start {
+			return linkReference{}, 0, false
+		}
+		return linkReference{destination: c[start:end], start: start, end: end}, end, true
+	}
+	return linkReference{}, 0, false
+}
+
+// linkTextEnd returns the position of the ']' closing link text that starts
+// at from, allowing nested brackets as in [![badge](b.svg)](docs.md), or -1.
+// Link text does not span blank lines.
+func linkTextEnd(c string, from int) int {
+	depth := 0
+	for p := from; p < len(c); p++ {
+		switch c[p] {
+		case '\\':
+			p++
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return p
+			}
+			depth--
+		case '\n':
+			if rest := strings.TrimLeft(c[p+1:], " \t\r"); rest == "" || rest[0] == '\n' {
+				return -1
+			}
+		}
+	}
+	return -1
+}
+
+// RewriteLinks replaces the destination of every link in content (markdown
+// links, link reference definitions and <a href> tags, outside code blocks)
+// for which rewrite returns true. Destinations are passed as written, and
+// images are left alone; see RebaseImagePaths for those.
+func RewriteLinks(content string, rewrite func(destination string) (string, bool)) string {
+	return rewriteLinks(content, func(ref linkReference) (string, bool) {
+		return rewrite(ref.destination)
+	})
+}
+
+// rewriteLinks is RewriteLinks with access to the location of each link.
+func rewriteLinks(content string, rewrite func(ref linkReference) (string, bool)) string {
+	var b strings.Builder
+	last := 0
+	for _, ref := range findLinks(content) {
+		destination, ok := rewrite(ref)
+		if !ok {
+			continue
+		}
+		if ref.inTag {
+			destination = html.EscapeString(destination)
+		}
+		b.WriteString(content[last:ref.start])
+		b.WriteString(destination)
+		last = ref.end
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
@@ -0,0 +1,56 @@
+package markdown_test
+
+import (
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestRewriteLinks(t *testing.T) {
+	content := "See [install](install.md#linux \"Install\") and [![badge](badge.svg)](docs.md).\n" +
+		"![not a link](install.md)\n" +
+		"<a href=\"install.md?x=1&amp;y=2\">install</a>\n" +
+		"\\[escaped](install.md)\n\n" +
+		"```\n[code](install.md)\n```\n\n" +
+		"[ref]: <install.md>\n"
+	var seen []string
+	got := markdown.RewriteLinks(content, func(destination string) (string, bool) {
+		seen = append(seen, destination)
+		if strings.HasPrefix(destination, "install.md") {
+			return "setup.md" + strings.TrimPrefix(destination, "install.md") + "&", true
+		}
+		return "", false
+	})
+
+	want := []string{"install.md#linux", "docs.md", "install.md?x=1&y=2", "install.md"}
+	if strings.Join(seen, " ") != strings.Join(want, " ") {
+		t.Errorf("Got links %q, want %q", seen, want)
+	}
+	for _, s := range []string{
+		"[install](setup.md#linux& \"Install\")",
+		"[![badge](badge.svg)](docs.md)",
+		"![not a link](install.md)",
+		"<a href=\"setup.md?x=1&amp;y=2&amp;\">",
+		"\\[escaped](install.md)",
+		"[code](install.md)",
+		"[ref]: <setup.md&>",
+	} {
+		if !strings.Contains(got, s) {
+			t.Errorf("Expected %q in\n%s", s, got)
+		}
+	}
+}
+
+func TestLinkFiles(t *testing.T) {
+	content := markdown.FileMarker("intro.md") + "\n# Intro\n\nSee [usage](guide/install.md#usage) and [here](#usage).\n\n## Usage\n\n" +
+		markdown.FileMarker("guide/install.md") + "\n# Install\n\n## Usage\n\n[Back](../intro.md), [up](#Install), [away](other.md).\n"
+	want := "# Intro\n\nSee [usage](#usage-1) and [here](#usage).\n\n## Usage\n\n" +
+		"# Install\n\n## Usage\n\n[Back](#intro), [up](#install), [away](other.md).\n"
+	if got := markdown.LinkFiles(content); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+	if plain := "[a](#b)\n"; markdown.LinkFiles(plain) != plain {
+		t.Errorf("Expected a document without markers to be left alone")
+	}
+}
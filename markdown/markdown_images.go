@@ -2,7 +2,11 @@ package markdown
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"image"
 	"image/gif"
@@ -10,14 +14,20 @@ import (
 	"image/png"
 	"io"
 	"log"
+	"log/slog"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime/debug"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
 	"time"
 
 	"golang.org/x/image/draw"
@@ -26,209 +36,959 @@ import (
 // ImageReference represents an image reference found in markdown or HTML
 type ImageReference struct {
 	FullMatch string
+	// AltText is the alt text as written for markdown images (including any
+	// backslash escapes) and entity-decoded for HTML images.
 	AltText   string
 	ImagePath string
-	StartPos  int
-	EndPos    int
-	Width     int
-	Height    int
-	IsHTML    bool
+	// Title is the optional markdown title (![alt](path "title")) or HTML title attribute.
+	Title    string
+	StartPos int
+	EndPos   int
+	Width    int
+	Height   int
+	IsHTML   bool
+	// Tag is the element of an HTML reference: img, object or embed for SVG
+	// and PDF files, or video for a poster frame.
+	Tag string
+	// Preview marks a thumbnail of a linked PDF (see Options.PDFThumbnails).
+	// It is inserted before the link's line and has no text in the source:
+	// FullMatch is empty and StartPos equals EndPos.
+	Preview bool
+	// Directive holds overrides from a directive comment preceding the image.
+	Directive Directive
+	// EmbeddedSource is the path or URL an image embedded as a data URI
+	// was made from, as recorded by its source comment (see
+	// Options.SourceComments). Only ExtractDataURIs sets it.
+	EmbeddedSource string
+
+	// pathStart and pathEnd locate ImagePath within the document.
+	pathStart, pathEnd int
+}
+
+// Defaults applied when the corresponding Options field is zero.
+const (
+	DefaultQuality  = 85
+	DefaultMaxWidth = 400
+)
+
+// Options controls how a Processor embeds images.
+type Options struct {
+	// Logger receives the processor's log records: warnings about images
+	// left as references, and debug records for every image processed and
+	// downloaded, with its path or URL, sizes in bytes and duration. Nil
+	// logs warnings through the standard logger, and debug records too if
+	// Debug is set.
+	Logger *slog.Logger
+	// Debug enables debug records when Logger is nil.
+	Debug bool
+	// AttrStyle selects how the final dimensions are written next to each
+	// embedded image. The zero value behaves like AttrStyleNone.
+	AttrStyle AttrStyle
+	// Figcaption wraps each embedded image that has a title in an HTML
+	// <figure> with the title as its <figcaption>.
+	Figcaption bool
+	// CollapseOver wraps embedded images larger than this many bytes in a
+	// collapsible <details> block, if they are alone on their line. Zero
+	// disables collapsing.
+	CollapseOver int64
+	// SourceComments writes a <!-- source: path sha256:hex --> comment
+	// before every embedded image, naming the path or URL it was embedded
+	// from, as written, and the SHA-256 of its content, so that readers and
+	// tools such as ExtractDataURIs can trace it back.
+	SourceComments bool
+	// WrapWidth, when positive, emits HTML img tags whose base64 payload is
+	// broken into lines of this many columns. Renderers strip the whitespace
+	// from URLs, but editors and diff tools no longer choke on huge lines.
+	WrapWidth int
+	// ReferenceStyle writes ![alt][img1] in the body and collects the
+	// [img1]: data:... definitions at the end of the document, keeping the
+	// prose readable. Identical images share one definition.
+	ReferenceStyle bool
+	// Quality is the JPEG quality (1-100). Zero means DefaultQuality.
+	Quality int
+	// MaxWidth and MaxHeight bound images that have no explicit dimensions;
+	// larger images are scaled down to fit. A zero MaxWidth means
+	// DefaultMaxWidth, a zero MaxHeight means no limit and a negative value
+	// disables the limit.
+	MaxWidth  int
+	MaxHeight int
+	// PreserveBitDepth keeps 16 bits per channel in re-encoded images that
+	// have them. By default they are reduced to 8 bits, which halves the
+	// size of 16-bit PNGs with no difference on screen.
+	PreserveBitDepth bool
+	// HTTPClient fetches remote images. Nil means a client with a 30 second timeout.
+	HTTPClient *http.Client
+	// ImageTimeout bounds the time spent downloading, decoding and
+	// re-encoding each image. An image that takes longer is left as a
+	// reference with CodeTimeout. Zero means no limit.
+	ImageTimeout time.Duration
+	// BaseURL, if set, is where relative image paths point instead of the
+	// document's directory: with https://example.com/docs/, img/a.png is
+	// downloaded from https://example.com/docs/img/a.png and /logo.png from
+	// https://example.com/logo.png. Audio and video stay local.
+	BaseURL string
+	// GitHubToken authenticates requests to github.com and
+	// raw.githubusercontent.com, and enables the contents API fallback when
+	// raw downloads stay rate-limited.
+	GitHubToken string
+	// AllowedFormats lists the MIME types that may be embedded, e.g.
+	// "image/png". Images of other types are left as references. Empty
+	// allows every format.
+	AllowedFormats []string
+	// MaxEmbedSize leaves images whose base64 payload is larger than this
+	// many bytes as references. Zero means no limit.
+	MaxEmbedSize int64
+	// MaxImageSize leaves images whose source is larger than this many
+	// bytes as references, without reading more of them than that. Zero
+	// means no limit. EmbedImage fails such images with CodeImageTooLarge.
+	MaxImageSize int64
+	// FallbackPlaceholder embeds a placeholder showing the URL of a remote
+	// image whose host can't be reached, such as an intranet server from
+	// outside its network, instead of leaving the reference. Servers that
+	// answer with an error status still fail the image.
+	FallbackPlaceholder bool
+	// TagProfiles adds profiles, or replaces the built-in lossless, photo
+	// and icon ones, that authors select by ending an image's alt text
+	// with |name, as in ![diagram|lossless](x.png).
+	TagProfiles map[string]TagProfile
+	// Exclude leaves the images whose path or URL matches one of these
+	// patterns untouched. In a pattern * matches any run of characters,
+	// slashes included, e.g. https://img.shields.io/*; a pattern starting
+	// with RegexpPrefix is a regular expression instead.
+	Exclude []string
+	// Include, if not empty, leaves the images whose path or URL matches
+	// none of these patterns untouched, as if they were excluded. Patterns
+	// are written as for Exclude, e.g. assets/*, and Exclude wins over it.
+	Include []string
+	// BeforeEmbed, if set, is called with the position of every image in
+	// the document (counting from zero) before it is embedded. It may change
+	// the reference's Width and Height, and returns a non-empty reason to
+	// leave the image untouched instead.
+	BeforeEmbed func(index int, ref *ImageReference) string
+	// Pipelines are applied, in order, to the raster images whose path they
+	// match, after the built-in resizing.
+	Pipelines []Pipeline
+	// CacheDir, if set, keeps re-encoded raster images on disk keyed by the
+	// source's hash and every parameter that affects the result, so repeat
+	// runs with unchanged settings skip decoding and re-encoding.
+	CacheDir string
+	// PDFThumbnails, when positive, inserts an embedded preview of the first
+	// page, at most this many pixels wide, above every link to a local PDF
+	// ([spec](spec.pdf)). Rendering PDFs needs a decoder registered with
+	// RegisterFormat; previews that can't be rendered are left out.
+	PDFThumbnails int
+	// VideoPosters, when positive, inserts a poster frame at most this many
+	// pixels wide above every link to a local .mp4 or .webm file, and
+	// embeds one as the poster of <video> tags that have none. Extracting
+	// frames needs decoders for video/mp4 and video/webm registered with
+	// RegisterFormat.
+	VideoPosters int
+	// EmbedMediaUnder inlines local audio and video files smaller than this
+	// many bytes as data URIs. Zero, the default, never embeds media: clips
+	// are large and gain nothing from re-encoding, so they stay references
+	// and are reported in Result.Media.
+	EmbedMediaUnder int64
+	// Fonts selects the webfonts InlineStylesheets embeds. The zero value
+	// behaves like FontsWOFF2.
+	Fonts FontEmbedding
+	// ImageTemplate, if set, writes every embedded image (but not the
+	// <object>, <embed> and <video> tags whose files are embedded in place),
+	// executed with an ImageData. It can wrap images in a <figure> captioned
+	// with the alt text, or link them to themselves for a lightbox. It runs
+	// before CollapseOver, and an error executing it fails Process.
+	ImageTemplate *template.Template
+	// FigureLabel, if set, numbers the embedded images as figures, writing
+	// each as a <figure> captioned "<FigureLabel> N: <alt text>" with the id
+	// figure-N, and replaces ListOfFiguresMarker lines with a list linking
+	// to them. Previews and images embedded in <object>, <embed> and <video>
+	// tags are not numbered, nor are images left as references.
+	FigureLabel string
+	// Progress, if set, is called before each image of a document is
+	// processed and once more when the document is finished or
+	// interrupted, so that long documents can show how far along they are.
+	// It is called from the goroutine running Process.
+	Progress func(Progress)
+	// Jobs is how many images of a document are loaded and encoded at
+	// once. Zero or one processes them one at a time. Directives, skip
+	// options, BeforeEmbed and Progress are still applied in order, and
+	// the output doesn't depend on Jobs.
+	Jobs int
+	// Fallbacks give alternative sources for the images under their
+	// prefixes, tried in order, after those of the image's directive, when
+	// an image's own source can't be read.
+	Fallbacks []Fallback
+	// Attribution records the Attribution of every remote image in its
+	// ImageResult: its URL, the response headers about its origin and
+	// the license, creator and copyright found in them or its metadata.
+	Attribution bool
+}
+
+// ImageResult records what happened to a single image reference.
+type ImageResult struct {
+	Reference ImageReference
+	// Source is the file or URL the image was loaded from: the reference
+	// resolved against the document's directory or Options.BaseURL. It is
+	// empty for images that were not loaded.
+	Source   string
+	MIMEType string
+	// OriginalSize is the size in bytes of the source image.
+	OriginalSize int
+	// SourceSHA256 is the hex SHA-256 of the source image bytes.
+	SourceSHA256 string
+	// EncodedSize is the size in bytes of the embedded image before base64 encoding.
+	EncodedSize int
+	// Width and Height are the dimensions of the embedded image, zero if unknown.
+	Width    int
+	Height   int
+	Embedded bool
+	// DataURISHA256 is the hex SHA-256 of the data URI of an embedded
+	// image, which finds the image in the output again.
+	DataURISHA256 string
+	// Placeholder marks an embedded placeholder for a remote image whose
+	// host could not be reached (see Options.FallbackPlaceholder).
+	Placeholder bool
+	// SkipReason explains why an image was deliberately left untouched.
+	SkipReason string
+	// TooLarge is set when the image was left untouched for exceeding
+	// MaxImageSize or MaxEmbedSize.
+	TooLarge bool
+	// Attribution is set for remote images with Options.Attribution.
+	Attribution *Attribution
+	Err         error
+}
+
+// Result is the outcome of processing a markdown document.
+type Result struct {
+	Content string
+	Images  []ImageResult
+	// Media lists the audio and video references, embedded or not.
+	Media []MediaResult
+}
+
+// Processor finds and embeds images in markdown documents. Encoded images
+// are cached, so an image shared by several references or documents is only
+// loaded and encoded once per Processor.
+//
+// A Processor is safe for concurrent use: Process, InlineStylesheets and
+// the other methods may be called from several goroutines, which then share
+// the cache, and an image requested by several of them at once is encoded
+// by one while the others wait. The callbacks in Options (BeforeEmbed and
+// the transforms of Pipelines) are called concurrently in that case, so
+// they must be safe for concurrent use too, as must the transforms with
+// Jobs over one. The package-level registries, RegisterFormat and
+// RegisterTransform, may be updated at any time.
+type Processor struct {
+	opts Options
+	log  *slog.Logger
+
+	mu    sync.Mutex
+	cache map[string]cachedImage
+	// pending holds the keys being encoded, closed once they are cached or
+	// have failed.
+	pending map[string]chan struct{}
+	// downloads keeps GitHub-hosted images by URL, so that rate-limited
+	// hosts are asked for each image only once.
+	downloads map[string]download
+	// downloaded counts the bytes of remote images downloaded, for
+	// Options.Progress.
+	downloaded atomic.Int64
+}
+
+// download is a downloaded image and the response headers it came with.
+type download struct {
+	content []byte
+	header  http.Header
+}
+
+// cachedImage is a successfully embedded image, keyed by source and settings.
+type cachedImage struct {
+	encoded string
+	result  ImageResult
+}
+
+// NewProcessor returns a Processor configured with opts.
+func NewProcessor(opts Options) *Processor {
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+		if opts.Debug {
+			logger = slog.New(slog.NewTextHandler(log.Writer(), &slog.HandlerOptions{Level: slog.LevelDebug}))
+		}
+	}
+	return &Processor{
+		opts:      opts,
+		log:       logger,
+		cache:     make(map[string]cachedImage),
+		pending:   make(map[string]chan struct{}),
+		downloads: make(map[string]download),
+	}
 }
 
 // ProcessMarkdown finds and embeds images in a markdown string.
 func ProcessMarkdown(content, baseDir string, debugMode bool) (string, error) {
+	result, err := NewProcessor(Options{Debug: debugMode}).Process(content, baseDir)
+	if err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// Process finds and embeds images in a markdown string, reporting the outcome
+// for every image reference found.
+func (p *Processor) Process(content, baseDir string) (*Result, error) {
+	return p.ProcessContext(context.Background(), content, baseDir)
+}
+
+// ProcessContext is Process with a context bounding the whole document. If
+// ctx is done before every image is processed, it returns a partial result,
+// with the images processed so far embedded and the rest left as
+// references, and an error wrapping context.Cause(ctx): CodeTimeout for an
+// expired deadline, CodeInterrupted for a canceled ctx. Downloads are
+// canceled with ctx; decoding and encoding can't be interrupted, so an
+// image that overruns is abandoned and finishes in the background.
+func (p *Processor) ProcessContext(ctx context.Context, content, baseDir string) (*Result, error) {
+	result := &Result{}
+	// Inlined media becomes single-line data URIs, so line numbers hold.
+	content, result.Media = p.embedMedia(content, baseDir)
+
 	imageRefs := findImageReferences(content)
-	sort.Slice(imageRefs, func(i, j int) bool {
-		return imageRefs[i].StartPos < imageRefs[j].StartPos
+	// Audio and video are handled by embedMedia; only <video> tags remain,
+	// for their poster frames.
+	imageRefs = slices.DeleteFunc(imageRefs, func(ref ImageReference) bool {
+		return ref.Tag != "video" && mediaType(ref.ImagePath) != ""
 	})
+	if p.opts.VideoPosters <= 0 {
+		imageRefs = slices.DeleteFunc(imageRefs, func(ref ImageReference) bool { return ref.Tag == "video" })
+	} else {
+		imageRefs = append(imageRefs, findPreviewLinks(content, videoLinkRegex, p.opts.VideoPosters)...)
+	}
+	if p.opts.PDFThumbnails > 0 {
+		imageRefs = append(imageRefs, findPreviewLinks(content, pdfLinkRegex, p.opts.PDFThumbnails)...)
+	}
+	sortReferences(imageRefs)
 
+	doc := newDocument(content)
 	var builder strings.Builder
 	lastIndex := 0
-
-	for _, imgRef := range imageRefs {
+	var figures []figure
+
+	jobs := p.embedAll(ctx, content, baseDir, imageRefs)
+	var ctxErr error
+	for _, job := range jobs {
+		imgRef := job.ref
+		if !job.ran {
+			ctxErr = contextError(ctx, imgRef.ImagePath)
+			break
+		}
 		builder.WriteString(content[lastIndex:imgRef.StartPos])
-
-		if debugMode {
-			log.Printf("Processing image: %s, Width: %d, Height: %d", imgRef.ImagePath, imgRef.Width, imgRef.Height)
+		imgResult, encoded, err := job.result, job.encoded, job.err
+		if job.skipReason != "" {
+			imgResult.SkipReason = job.skipReason
+			builder.WriteString(imgRef.FullMatch)
+			result.Images = append(result.Images, imgResult)
+			lastIndex = imgRef.EndPos
+			continue
 		}
-
-		base64Data, mimeType, err := imageToBase64(imgRef, baseDir, debugMode)
-		if err != nil {
-			log.Printf("Warning: Could not convert image %s to base64: %v. Keeping original reference.", imgRef.ImagePath, err)
+		var skipReason string
+		var tooLarge *Error
+		if errors.As(err, &tooLarge) && tooLarge.Code == CodeImageTooLarge {
+			skipReason, imgResult.TooLarge, err = tooLarge.Err.Error(), true, nil
+		}
+		if err != nil && ctx.Err() != nil {
+			ctxErr = contextError(ctx, imgRef.ImagePath)
+			imgResult.Err = ctxErr
 			builder.WriteString(imgRef.FullMatch)
-		} else {
-			newImageRef := fmt.Sprintf("![%s](data:%s;base64,%s)", imgRef.AltText, mimeType, base64Data)
-			builder.WriteString(newImageRef)
+			result.Images = append(result.Images, imgResult)
+			lastIndex = imgRef.EndPos
+			break
+		}
+		if err == nil && skipReason == "" {
+			skipReason = p.policySkipReason(&imgResult, len(encoded))
 		}
+		switch {
+		case err != nil:
+			p.log.Warn("Could not embed image; keeping the reference", "image", imgRef.ImagePath, "error", err, "duration", job.duration)
+			imgResult.Err = err
+			builder.WriteString(imgRef.FullMatch)
+		case skipReason != "":
+			p.log.Debug("Skipping image", "image", imgRef.ImagePath, "reason", skipReason)
+			imgResult.SkipReason = skipReason
+			builder.WriteString(imgRef.FullMatch)
+		default:
+			p.log.Debug("Embedded image", "image", imgRef.ImagePath, "mime_type", imgResult.MIMEType,
+				"bytes", imgResult.OriginalSize, "encoded_bytes", imgResult.EncodedSize, "duration", job.duration)
+			imgResult.Embedded = true
+			dataURI := fmt.Sprintf("data:%s;base64,%s", imgResult.MIMEType, encoded)
+			imgResult.DataURISHA256 = dataURIHash(dataURI)
+			embedded := p.formatImage(doc, imgRef, dataURI, imgResult.Width, imgResult.Height)
+			var fig figure
+			if p.opts.FigureLabel != "" && (imgRef.Tag == "" || imgRef.Tag == "img") && !imgRef.Preview {
+				fig = p.newFigure(imgRef, len(figures)+1)
+				figures = append(figures, fig)
+				embedded = p.numberedFigure(doc, imgRef, dataURI, imgResult.Width, imgResult.Height, fig)
+			}
+			if p.opts.ImageTemplate != nil && (imgRef.Tag == "" || imgRef.Tag == "img") {
+				if embedded, err = p.executeImageTemplate(imgRef, imgResult, dataURI, embedded, doc.newline, fig); err != nil {
+					return nil, fmt.Errorf("image template for %s: %w", imgRef.ImagePath, err)
+				}
+			}
+			if p.opts.SourceComments {
+				embedded = sourceComment(imgRef.ImagePath, imgResult.SourceSHA256) + embedded
+			}
+			// Only whole images can be collapsed; object and video start tags
+			// would be separated from their content. The block needs a line of
+			// its own, or it would split the paragraph around the image.
+			if p.opts.CollapseOver > 0 && int64(imgResult.EncodedSize) > p.opts.CollapseOver && (imgRef.Tag == "" || imgRef.Tag == "img") {
+				if indent, ok := aloneOnLine(content, imgRef.StartPos, imgRef.EndPos); ok {
+					embedded = collapse(imgRef.ImagePath, int64(imgResult.EncodedSize), embedded, indent, doc.newline)
+				}
+			}
+			if imgRef.Preview {
+				// Put the preview on a line of its own, indented like the link.
+				lineStart := strings.LastIndexByte(content[:imgRef.StartPos], '\n') + 1
+				embedded += doc.newline + content[lineStart:imgRef.StartPos]
+			}
+			builder.WriteString(embedded)
+		}
+		result.Images = append(result.Images, imgResult)
 		lastIndex = imgRef.EndPos
 	}
 
 	builder.WriteString(content[lastIndex:])
-	return builder.String(), nil
+	doc.writeDefinitions(&builder)
+	result.Content = builder.String()
+	if p.opts.FigureLabel != "" {
+		result.Content = insertListOfFigures(result.Content, figures, doc.newline)
+	}
+	return result, ctxErr
 }
 
-func findImageReferences(content string) []ImageReference {
-	var refs []ImageReference
-	// Regex for Markdown: ![alt](path){: width=W height=H}
-	markdownRegex := regexp.MustCompile(`!\[([^\]]*)\]\(([^)]+?)\)(?:\{:\s*(?:width=(\d+))?\s*(?:height=(\d+))?\s*\})?`)
-	// Regex for HTML: <img src="..." alt="..." width="..." height="...">
-	htmlRegex := regexp.MustCompile(`<img[^>]+src=["']([^"']+)["'][^>]*alt=["']([^"']*)["'][^>]*>`)
+// embedJob is the outcome of embedding one image reference of a document.
+type embedJob struct {
+	ref ImageReference
+	// ran is set for the references processed before ctx was done.
+	ran bool
+	// skipReason is why the image was left untouched without loading it.
+	skipReason string
+	result     ImageResult
+	encoded    string
+	err        error
+	duration   time.Duration
+}
 
-	// Process Markdown matches
-	for _, match := range markdownRegex.FindAllStringSubmatchIndex(content, -1) {
-		imagePath := content[match[4]:match[5]]
-		if strings.HasPrefix(imagePath, "data:") {
-			continue
+// embedAll applies the directives, tags and skip options to refs, in order,
+// and embeds the images that are not skipped, up to Jobs at a time. It
+// stops starting images once ctx is done, and returns when every image
+// started has finished.
+func (p *Processor) embedAll(ctx context.Context, content, baseDir string, refs []ImageReference) []embedJob {
+	jobs := make([]embedJob, len(refs))
+	for i, ref := range refs {
+		jobs[i].ref = ref
+	}
+	finished := make(chan struct{}, len(refs))
+	progress := p.newProgress(len(refs))
+	running, done := 0, 0
+	for i, imgRef := range refs {
+		for ; running >= max(p.opts.Jobs, 1); running-- {
+			<-finished
+			done++
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		progress.report(done, imgRef.ImagePath)
+
+		// Directives apply to the line's own images, not to previews.
+		directive, found, err := findDirective(content, imgRef.StartPos)
+		if imgRef.Preview {
+			found, err = false, nil
+		}
+		if err != nil {
+			p.log.Warn("Ignoring invalid directive", "image", imgRef.ImagePath, "error", err)
+		} else if found {
+			imgRef.Directive = directive
+			if directive.Width > 0 || directive.Height > 0 {
+				imgRef.Width, imgRef.Height = directive.Width, directive.Height
+			}
+		}
+		if !imgRef.Preview {
+			imgRef.AltText, imgRef.Directive.Tags = p.splitTags(imgRef.AltText)
 		}
 
-		var width, height int
-		if match[6] != -1 && match[7] != -1 {
-			width, _ = strconv.Atoi(content[match[6]:match[7]])
+		p.log.Debug("Processing image", "image", imgRef.ImagePath, "width", imgRef.Width, "height", imgRef.Height)
+
+		var skipReason string
+		if imgRef.Directive.Skip {
+			skipReason = "skipped by directive"
+		} else if pattern, ok := excludedBy(p.opts.Exclude, imgRef.ImagePath); ok {
+			skipReason = "excluded by " + pattern
+		} else if _, ok := excludedBy(p.opts.Include, imgRef.ImagePath); !ok && len(p.opts.Include) > 0 {
+			skipReason = "not included"
+		} else if p.opts.BeforeEmbed != nil {
+			skipReason = p.opts.BeforeEmbed(i, &imgRef)
 		}
-		if match[8] != -1 && match[9] != -1 {
-			height, _ = strconv.Atoi(content[match[8]:match[9]])
+		job := &jobs[i]
+		job.ref, job.ran, job.skipReason = imgRef, true, skipReason
+		job.result = ImageResult{Reference: imgRef}
+		if skipReason != "" {
+			done++
+			continue
 		}
+		running++
+		go func() {
+			started := time.Now()
+			job.encoded, job.err = p.embedOrPlaceholder(ctx, job.ref, baseDir, &job.result)
+			job.duration = time.Since(started)
+			finished <- struct{}{}
+		}()
+	}
+	for ; running > 0; running-- {
+		<-finished
+		done++
+	}
+	progress.report(done, "")
+	return jobs
+}
 
-		refs = append(refs, ImageReference{
-			FullMatch: content[match[0]:match[1]],
-			AltText:   content[match[2]:match[3]],
-			ImagePath: imagePath,
-			StartPos:  match[0],
-			EndPos:    match[1],
-			Width:     width,
-			Height:    height,
-		})
+// EmbedImage embeds the single image ref refers to, resolved against
+// baseDir, as Process would, and returns its data URI. Only the data URI is
+// made: nothing is written around it, and AllowedFormats and MaxEmbedSize
+// are not applied. The result's Err is set if the image can't be embedded.
+func (p *Processor) EmbedImage(ctx context.Context, ref ImageReference, baseDir string) (string, ImageResult) {
+	res := ImageResult{Reference: ref}
+	encoded, err := p.embedOrPlaceholder(ctx, ref, baseDir, &res)
+	if err != nil {
+		res.Err = err
+		return "", res
 	}
+	res.Embedded = true
+	dataURI := fmt.Sprintf("data:%s;base64,%s", res.MIMEType, encoded)
+	res.DataURISHA256 = dataURIHash(dataURI)
+	return dataURI, res
+}
 
-	// Process HTML matches
-	htmlWidthRegex := regexp.MustCompile(`width=["'](\d+)["']`)
-	htmlHeightRegex := regexp.MustCompile(`height=["'](\d+)["']`)
-	for _, match := range htmlRegex.FindAllStringSubmatchIndex(content, -1) {
-		fullMatch := content[match[0]:match[1]]
-		imagePath := content[match[2]:match[3]]
-		if strings.HasPrefix(imagePath, "data:") {
-			continue
+// dataURIHash returns the hex SHA-256 of a data URI.
+func dataURIHash(dataURI string) string {
+	sum := sha256.Sum256([]byte(dataURI))
+	return hex.EncodeToString(sum[:])
+}
+
+// policySkipReason explains why an encoded image must not be embedded under
+// the AllowedFormats and MaxEmbedSize options, or returns "".
+func (p *Processor) policySkipReason(res *ImageResult, encodedLen int) string {
+	if len(p.opts.AllowedFormats) > 0 && !slices.Contains(p.opts.AllowedFormats, res.MIMEType) {
+		return res.MIMEType + " is not an allowed format"
+	}
+	if p.opts.MaxEmbedSize > 0 && int64(encodedLen) > p.opts.MaxEmbedSize {
+		res.TooLarge = true
+		return fmt.Sprintf("embedded size %s exceeds %s", FormatSize(int64(encodedLen)), FormatSize(p.opts.MaxEmbedSize))
+	}
+	return ""
+}
+
+// checkImageSize returns a CodeImageTooLarge error for an image of size
+// bytes over MaxImageSize.
+func (p *Processor) checkImageSize(path string, size int64) error {
+	if limit := p.opts.MaxImageSize; limit > 0 && size > limit {
+		return newError(CodeImageTooLarge, path, fmt.Errorf("image size %s exceeds %s", FormatSize(size), FormatSize(limit)))
+	}
+	return nil
+}
+
+// sortReferences orders references by their position in the document,
+// previews first since they are inserted before the text at their position.
+func sortReferences(refs []ImageReference) {
+	sort.SliceStable(refs, func(i, j int) bool {
+		if refs[i].StartPos == refs[j].StartPos {
+			return refs[i].Preview && !refs[j].Preview
 		}
-		altText := content[match[4]:match[5]]
+		return refs[i].StartPos < refs[j].StartPos
+	})
+}
 
-		var width, height int
-		widthMatch := htmlWidthRegex.FindStringSubmatch(fullMatch)
-		if len(widthMatch) > 1 {
-			width, _ = strconv.Atoi(widthMatch[1])
+// embedCached is embedImage with a per-Processor cache keyed by the resolved
+// source and every setting that affects the encoded bytes.
+func (p *Processor) embedCached(ctx context.Context, ref ImageReference, baseDir string, res *ImageResult) (string, error) {
+	if remote, ok := p.remotePath(ref.ImagePath); ok {
+		ref.ImagePath = remote
+	}
+	source := ref.ImagePath
+	if !isURL(source) {
+		source = resolveLocalPath(baseDir, source)
+		if abs, err := filepath.Abs(source); err == nil {
+			source = abs
+		}
+	}
+	key := fmt.Sprintf("%s|%dx%d|%+v", source, ref.Width, ref.Height, ref.Directive)
+	res.Source = source
+
+	p.mu.Lock()
+	for {
+		if cached, ok := p.cache[key]; ok {
+			p.mu.Unlock()
+			res.MIMEType = cached.result.MIMEType
+			res.OriginalSize = cached.result.OriginalSize
+			res.SourceSHA256 = cached.result.SourceSHA256
+			res.EncodedSize = cached.result.EncodedSize
+			res.Width, res.Height = cached.result.Width, cached.result.Height
+			res.Attribution = cached.result.Attribution
+			return cached.encoded, nil
 		}
-		heightMatch := htmlHeightRegex.FindStringSubmatch(fullMatch)
-		if len(heightMatch) > 1 {
-			height, _ = strconv.Atoi(heightMatch[1])
+		done, busy := p.pending[key]
+		if !busy {
+			break
 		}
+		// Another goroutine is encoding the image; if it fails, try again.
+		p.mu.Unlock()
+		<-done
+		p.mu.Lock()
+	}
+	done := make(chan struct{})
+	p.pending[key] = done
+	p.mu.Unlock()
+
+	encoded, err := p.embedWithin(ctx, ref, baseDir, res)
+	p.mu.Lock()
+	if err == nil {
+		p.cache[key] = cachedImage{encoded: encoded, result: *res}
+	}
+	delete(p.pending, key)
+	close(done)
+	p.mu.Unlock()
+	return encoded, err
+}
 
-		refs = append(refs, ImageReference{
-			FullMatch: fullMatch,
-			AltText:   altText,
-			ImagePath: imagePath,
-			StartPos:  match[0],
-			EndPos:    match[1],
-			Width:     width,
-			Height:    height,
-			IsHTML:    true,
-		})
+// embedWithin is embedImage bounded by ctx and Options.ImageTimeout. An
+// image still being processed when they expire is abandoned: it completes
+// in the background, recording into a copy of res.
+func (p *Processor) embedWithin(ctx context.Context, ref ImageReference, baseDir string, res *ImageResult) (string, error) {
+	if p.opts.ImageTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeoutCause(ctx, p.opts.ImageTimeout,
+			fmt.Errorf("gave up after %s: %w", p.opts.ImageTimeout, context.DeadlineExceeded))
+		defer cancel()
+	}
+	if ctx.Done() == nil {
+		return p.embedImage(ctx, ref, baseDir, res)
 	}
 
-	return refs
+	type outcome struct {
+		encoded string
+		res     ImageResult
+		err     error
+	}
+	done := make(chan outcome, 1)
+	go func(res ImageResult) {
+		encoded, err := p.embedImage(ctx, ref, baseDir, &res)
+		done <- outcome{encoded, res, err}
+	}(*res)
+	select {
+	case o := <-done:
+		// A download canceled by ctx fails as a timeout or interruption,
+		// not as a download.
+		if o.err == nil || ctx.Err() == nil {
+			*res = o.res
+			return o.encoded, o.err
+		}
+	case <-ctx.Done():
+	}
+	return "", contextError(ctx, ref.ImagePath)
 }
 
-func imageToBase64(ref ImageReference, baseDir string, debugMode bool) (string, string, error) {
+// embedImage loads, resizes and re-encodes the image behind ref, returning its
+// base64 payload and recording MIME type and sizes in res.
+//
+// Decoders, transforms and encoders may panic on malformed input; the panic
+// is recovered and reported as a DecodeError for this image alone.
+func (p *Processor) embedImage(ctx context.Context, ref ImageReference, baseDir string, res *ImageResult) (encoded string, err error) {
 	var content []byte
-	var err error
+	defer func() {
+		if r := recover(); r != nil {
+			p.log.Debug("Recovered from panic", "image", ref.ImagePath, "panic", r, "stack", string(debug.Stack()))
+			encoded, err = "", newError(CodeUnsupportedFormat, ref.ImagePath, &DecodeError{Format: sniffImageType(content), Err: fmt.Errorf("panic: %v", r)})
+		}
+	}()
 
 	if isURL(ref.ImagePath) {
-		content, err = downloadImageContent(ref.ImagePath)
+		var header http.Header
+		content, header, err = p.downloadImage(ctx, ref.ImagePath)
 		if err != nil {
-			return "", "", fmt.Errorf("failed to download image: %v", err)
+			return "", err
+		}
+		if p.opts.Attribution {
+			res.Attribution = newAttribution(ref.ImagePath, header, content)
 		}
 	} else {
-		fullPath := filepath.Join(baseDir, ref.ImagePath)
-		content, err = os.ReadFile(fullPath)
-		if err != nil {
-			return "", "", fmt.Errorf("failed to read image file: %v", err)
+		file := resolveLocalPath(baseDir, ref.ImagePath)
+		if info, err := os.Stat(file); err == nil {
+			if err := p.checkImageSize(ref.ImagePath, info.Size()); err != nil {
+				return "", err
+			}
+		}
+		content, err = os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			return "", newError(CodeFileNotFound, ref.ImagePath, err)
+		} else if err != nil {
+			return "", newError(CodeFileUnreadable, ref.ImagePath, err)
 		}
 	}
-
-	// Check for SVG first, as it's text-based
-	if strings.Contains(strings.ToLower(string(content)), "<svg") {
+	res.OriginalSize = len(content)
+	sum := sha256.Sum256(content)
+	res.SourceSHA256 = hex.EncodeToString(sum[:])
+
+	// Identify the format by content, not by extension.
+	mimeType := sniffImageType(content)
+	switch mimeType {
+	case "":
+		return "", newError(CodeUnsupportedFormat, ref.ImagePath, &DecodeError{Err: errors.New("unrecognized image data")})
+	case "image/svg+xml":
 		if ref.Width > 0 || ref.Height > 0 {
 			content = updateSVGDimensions(content, ref.Width, ref.Height)
 		}
-		return base64.StdEncoding.EncodeToString(content), "image/svg+xml", nil
+		res.MIMEType = mimeType
+		res.EncodedSize = len(content)
+		res.Width, res.Height = ref.Width, ref.Height
+		return base64.StdEncoding.EncodeToString(content), nil
+	case "image/x-icon":
+		if _, ok := lookupFormat(mimeType); ok {
+			break // A registered codec handles icons like any raster format.
+		}
+		// There is no ICO codec; icons are embedded unchanged.
+		if ref.Width > 0 || ref.Height > 0 {
+			return "", newError(CodeUnsupportedFormat, ref.ImagePath, errors.New("ICO images cannot be resized"))
+		}
+		res.MIMEType = mimeType
+		res.EncodedSize = len(content)
+		return base64.StdEncoding.EncodeToString(content), nil
 	}
 
-	img, format, err := image.Decode(bytes.NewReader(content))
+	if err := missingCodec(mimeType); err != nil {
+		return "", newError(CodeUnsupportedFormat, ref.ImagePath, err)
+	}
+
+	cacheKey := p.diskCacheKey(ref, res.SourceSHA256, mimeType)
+	if entry, ok := p.loadDiskCache(cacheKey); ok {
+		res.MIMEType = entry.MIMEType
+		res.EncodedSize = entry.Size
+		res.Width, res.Height = entry.Width, entry.Height
+		return entry.Data, nil
+	}
+
+	img, err := decodeImage(mimeType, content)
 	if err != nil {
-		return "", "", fmt.Errorf("unsupported image format: %v", err)
+		return "", newError(CodeUnsupportedFormat, ref.ImagePath, &DecodeError{Format: mimeType, Err: err})
 	}
+	img = toSRGB(img, mimeType, content)
 
-	img = resizeImage(img, ref.Width, ref.Height)
+	maxWidth, maxHeight := p.maxDimensions(ref.Directive)
+	bounds := img.Bounds()
+	if w, h := resizeTarget(bounds.Dx(), bounds.Dy(), ref.Width, ref.Height, maxWidth, maxHeight); (w != bounds.Dx() || h != bounds.Dy()) && int64(w)*int64(h) > maxResizePixels {
+		return "", newError(CodeEncodeFailed, ref.ImagePath, fmt.Errorf("requested size %dx%d is too large", w, h))
+	}
+	img = resizeImage(img, ref.Width, ref.Height, maxWidth, maxHeight)
+	if img, err = p.applyPipelines(ref.ImagePath, img); err != nil {
+		return "", newError(CodeEncodeFailed, ref.ImagePath, err)
+	}
+	img, format, err := p.applyProfiles(ref.Directive, img)
+	if err != nil {
+		return "", newError(CodeEncodeFailed, ref.ImagePath, err)
+	}
+	if !p.opts.PreserveBitDepth {
+		img = reduceBitDepth(img)
+	}
 
 	var encodeBuf bytes.Buffer
-	var mimeType string
-	switch format {
-	case "png":
+	f, registered := lookupFormat(mimeType)
+	switch {
+	case format == "png":
 		mimeType = "image/png"
 		err = png.Encode(&encodeBuf, img)
-	case "gif":
-		mimeType = "image/gif"
-		err = gif.Encode(&encodeBuf, img, nil)
-	default: // jpeg and others
+	case format == "jpeg":
 		mimeType = "image/jpeg"
-		err = jpeg.Encode(&encodeBuf, img, &jpeg.Options{Quality: 85})
+		err = jpeg.Encode(&encodeBuf, flatten(img), &jpeg.Options{Quality: p.quality(ref.Directive)})
+	case registered && f.encode != nil:
+		err = safeEncode(f.encode, &encodeBuf, img, p.quality(ref.Directive))
+	case mimeType == "image/gif":
+		err = gif.Encode(&encodeBuf, img, nil)
+	case mimeType == "image/jpeg":
+		err = jpeg.Encode(&encodeBuf, img, &jpeg.Options{Quality: p.quality(ref.Directive)})
+	default: // png, and formats browsers may not display (webp, bmp, tiff, decode-only registrations)
+		mimeType = "image/png"
+		err = png.Encode(&encodeBuf, img)
 	}
 
 	if err != nil {
-		return "", "", fmt.Errorf("failed to re-encode image: %v", err)
+		return "", newError(CodeEncodeFailed, ref.ImagePath, err)
 	}
 
-	return base64.StdEncoding.EncodeToString(encodeBuf.Bytes()), mimeType, nil
+	res.MIMEType = mimeType
+	res.EncodedSize = encodeBuf.Len()
+	res.Width, res.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	encoded = base64.StdEncoding.EncodeToString(encodeBuf.Bytes())
+	p.storeDiskCache(cacheKey, diskCacheEntry{MIMEType: mimeType, Width: res.Width, Height: res.Height, Size: res.EncodedSize, Data: encoded})
+	return encoded, nil
 }
 
-func downloadImageContent(imageURL string) ([]byte, error) {
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Get(imageURL)
+func (p *Processor) downloadImageContent(ctx context.Context, imageURL string) ([]byte, error) {
+	content, _, err := p.downloadImage(ctx, imageURL)
+	return content, err
+}
+
+// downloadImage downloads the image at imageURL, returning it with the
+// response headers.
+func (p *Processor) downloadImage(ctx context.Context, imageURL string) ([]byte, http.Header, error) {
+	client := p.opts.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	requestURL, err := normalizeURL(imageURL)
 	if err != nil {
-		return nil, err
+		return nil, nil, newError(CodeDownloadFailed, imageURL, err)
+	}
+	p.mu.Lock()
+	cached, ok := p.downloads[requestURL]
+	p.mu.Unlock()
+	if ok {
+		return cached.content, cached.header, nil
+	}
+	started := time.Now()
+	resp, err := p.get(ctx, client, requestURL)
+	if err != nil {
+		return nil, nil, newError(CodeDownloadFailed, imageURL, err)
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("bad status: %s", resp.Status)
+		return nil, nil, newError(CodeHTTPStatus, imageURL, &HTTPError{Status: resp.StatusCode})
+	}
+	if err := p.checkImageSize(imageURL, resp.ContentLength); err != nil {
+		return nil, nil, err
+	}
+	var body io.Reader = countingReader{resp.Body, &p.downloaded}
+	if p.opts.MaxImageSize > 0 {
+		// Stop reading bodies without a length one byte past the limit.
+		body = io.LimitReader(body, p.opts.MaxImageSize+1)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, newError(CodeDownloadFailed, imageURL, err)
+	}
+	if p.opts.MaxImageSize > 0 && int64(len(content)) > p.opts.MaxImageSize {
+		return nil, nil, newError(CodeImageTooLarge, imageURL, fmt.Errorf("image size exceeds %s", FormatSize(p.opts.MaxImageSize)))
+	}
+	p.log.Debug("Downloaded image", "url", imageURL, "bytes", len(content), "duration", time.Since(started))
+	if err := checkContentType(resp.Header.Get("Content-Type"), content); err != nil {
+		return nil, nil, newError(CodeContentTypeMismatch, imageURL, err)
+	}
+	if u, err := url.Parse(requestURL); err == nil && isGitHubHost(u.Hostname()) {
+		p.mu.Lock()
+		p.downloads[requestURL] = download{content, resp.Header}
+		p.mu.Unlock()
 	}
-	return io.ReadAll(resp.Body)
+	return content, resp.Header, nil
 }
 
-func resizeImage(img image.Image, targetWidth, targetHeight int) image.Image {
-	srcWidth := img.Bounds().Dx()
-	srcHeight := img.Bounds().Dy()
+// checkContentType rejects responses that are clearly not images, such as
+// HTML error or login pages served with a 200 status.
+func checkContentType(declared string, content []byte) error {
+	if declared == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return nil
+	}
+	sniffed, _, _ := mime.ParseMediaType(http.DetectContentType(content))
+	if mediaType == "text/html" || (sniffed == "text/html" && !bytes.Contains(bytes.ToLower(content), []byte("<svg"))) {
+		return fmt.Errorf("server sent %s, expected an image", declared)
+	}
+	return nil
+}
 
-	if targetWidth <= 0 && targetHeight <= 0 {
-		if srcWidth > 400 {
-			targetWidth = 400
-		} else {
-			return img // No resize needed
+// quality returns the JPEG quality for an image, honoring its directive.
+func (p *Processor) quality(d Directive) int {
+	if d.Quality > 0 {
+		return d.Quality
+	}
+	profiles := p.profiles(d)
+	for i := len(profiles) - 1; i >= 0; i-- {
+		if profiles[i].Quality > 0 {
+			return profiles[i].Quality
 		}
 	}
-
-	if targetWidth > 0 && targetHeight <= 0 {
-		targetHeight = int(float64(targetWidth) * float64(srcHeight) / float64(srcWidth))
-	} else if targetHeight > 0 && targetWidth <= 0 {
-		targetWidth = int(float64(targetHeight) * float64(srcWidth) / float64(srcHeight))
+	if p.opts.Quality > 0 {
+		return p.opts.Quality
 	}
+	return DefaultQuality
+}
 
-	if targetWidth == srcWidth && targetHeight == srcHeight {
-		return img
+// maxDimensions returns the bounding box for images without explicit
+// dimensions, honoring the image's directive. Zero means unbounded.
+func (p *Processor) maxDimensions(d Directive) (int, int) {
+	maxWidth, maxHeight := p.opts.MaxWidth, p.opts.MaxHeight
+	if maxWidth == 0 {
+		maxWidth = DefaultMaxWidth
+	}
+	if d.MaxWidth > 0 {
+		maxWidth = d.MaxWidth
+	}
+	if d.MaxHeight > 0 {
+		maxHeight = d.MaxHeight
 	}
+	return max(maxWidth, 0), max(maxHeight, 0)
+}
 
-	resized := image.NewRGBA(image.Rect(0, 0, targetWidth, targetHeight))
+// maxResizePixels bounds the size of a resized image, so that a typo such
+// as width=80000 fails cleanly instead of exhausting memory.
+const maxResizePixels = 1 << 26
+
+// resizeImage scales img to the target dimensions. Without targets, images
+// larger than maxWidth x maxHeight (zero meaning unbounded) are scaled down
+// to fit, preserving the aspect ratio.
+func resizeImage(img image.Image, targetWidth, targetHeight, maxWidth, maxHeight int) image.Image {
+	width, height := resizeTarget(img.Bounds().Dx(), img.Bounds().Dy(), targetWidth, targetHeight, maxWidth, maxHeight)
+	if width == img.Bounds().Dx() && height == img.Bounds().Dy() {
+		return img // No resize needed
+	}
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
 	draw.ApproxBiLinear.Scale(resized, resized.Bounds(), img, img.Bounds(), draw.Over, nil)
 	return resized
 }
 
+// resizeTarget returns the dimensions resizeImage scales an image of
+// srcWidth x srcHeight to, at least 1x1.
+func resizeTarget(srcWidth, srcHeight, targetWidth, targetHeight, maxWidth, maxHeight int) (int, int) {
+	if srcWidth <= 0 || srcHeight <= 0 {
+		return srcWidth, srcHeight
+	}
+	if targetWidth <= 0 && targetHeight <= 0 {
+		scale := 1.0
+		if maxWidth > 0 && srcWidth > maxWidth {
+			scale = float64(maxWidth) / float64(srcWidth)
+		}
+		if maxHeight > 0 && srcHeight > maxHeight {
+			scale = min(scale, float64(maxHeight)/float64(srcHeight))
+		}
+		if scale == 1.0 {
+			return srcWidth, srcHeight
+		}
+		return clampDimension(float64(srcWidth) * scale), clampDimension(float64(srcHeight) * scale)
+	}
+
+	width, height := float64(targetWidth), float64(targetHeight)
+	if targetHeight <= 0 {
+		height = width * float64(srcHeight) / float64(srcWidth)
+	} else if targetWidth <= 0 {
+		width = height * float64(srcWidth) / float64(srcHeight)
+	}
+	return clampDimension(width), clampDimension(height)
+}
+
+// clampDimension converts a computed dimension to a pixel count between 1
+// and 1<<30, beyond which conversions to int would overflow.
+func clampDimension(n float64) int {
+	return int(min(max(n, 1), 1<<30))
+}
+
 func updateSVGDimensions(content []byte, targetWidth, targetHeight int) []byte {
 	// This is a simplified implementation. A more robust one would parse the SVG XML.
 	widthRegex := regexp.MustCompile(`width=["']([^"']+)["']`)
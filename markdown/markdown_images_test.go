@@ -2,6 +2,7 @@ package markdown_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"fmt"
 	"image"
@@ -252,3 +253,157 @@ func TestProcessMarkdown(t *testing.T) {
 		})
 	}
 }
+
+func TestProcessorCachesSharedImages(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Content-Type", "image/svg+xml")
+		fmt.Fprint(w, `<svg width="10" height="10"></svg>`)
+	}))
+	defer server.Close()
+
+	processor := markdown.NewProcessor(markdown.Options{})
+	doc := fmt.Sprintf("![a](%[1]s/logo.svg) ![b](%[1]s/logo.svg)", server.URL)
+	for i := 0; i < 2; i++ {
+		result, err := processor.Process(doc, t.TempDir())
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		if strings.Count(result.Content, "data:image/svg+xml;base64,") != 2 {
+			t.Errorf("Expected both references to be embedded, got %s", result.Content)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("Expected the shared image to be downloaded once, got %d requests", requests)
+	}
+}
+
+func TestEmbedPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "small.png", 2, 2)
+	writeTestPNG(t, tempDir, "large.png", 300, 300)
+	if err := os.WriteFile(filepath.Join(tempDir, "logo.svg"), []byte(`<svg width="1" height="1"></svg>`), 0644); err != nil {
+		t.Fatalf("Failed to write SVG: %v", err)
+	}
+
+	processor := markdown.NewProcessor(markdown.Options{
+		AllowedFormats: []string{"image/png"},
+		MaxEmbedSize:   200,
+	})
+	input := "![s](small.png) ![l](large.png) ![v](logo.svg)"
+	result, err := processor.Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !result.Images[0].Embedded {
+		t.Errorf("Expected the small PNG to be embedded, got %+v", result.Images[0])
+	}
+	for _, img := range result.Images[1:] {
+		if img.Embedded || img.SkipReason == "" || img.Err != nil {
+			t.Errorf("Expected %s to be skipped, got %+v", img.Reference.ImagePath, img)
+		}
+	}
+	if !strings.HasSuffix(result.Content, " ![l](large.png) ![v](logo.svg)") {
+		t.Errorf("Expected skipped references to be kept, got %q", result.Content)
+	}
+}
+
+func TestMaxImageSize(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "small.png", 2, 2)
+	writeTestPNG(t, tempDir, "large.png", 300, 300)
+	large, err := os.ReadFile(filepath.Join(tempDir, "large.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		if r.URL.Path == "/chunked.png" {
+			// Flushing first sends the body without a Content-Length.
+			w.(http.Flusher).Flush()
+		}
+		w.Write(large)
+	}))
+	defer server.Close()
+
+	processor := markdown.NewProcessor(markdown.Options{MaxImageSize: 500})
+	input := "![s](small.png) ![l](large.png) ![r](" + server.URL + "/large.png) ![c](" + server.URL + "/chunked.png)"
+	result, err := processor.Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !result.Images[0].Embedded {
+		t.Errorf("Expected the small PNG to be embedded, got %+v", result.Images[0])
+	}
+	for _, img := range result.Images[1:] {
+		if img.Embedded || img.Err != nil || !img.TooLarge || !strings.HasSuffix(img.SkipReason, "exceeds 500 B") {
+			t.Errorf("Expected %s to be skipped as too large, got %+v", img.Reference.ImagePath, img)
+		}
+	}
+	if !strings.Contains(result.Content, " ![l](large.png) ![r]("+server.URL+"/large.png) ![c]("+server.URL+"/chunked.png)") {
+		t.Errorf("Expected oversized references to be kept, got %q", result.Content)
+	}
+
+	_, res := processor.EmbedImage(context.Background(), markdown.ImageReference{ImagePath: "large.png"}, tempDir)
+	if markdown.CodeOf(res.Err) != markdown.CodeImageTooLarge {
+		t.Errorf("Expected EmbedImage to fail with %s, got %v", markdown.CodeImageTooLarge, res.Err)
+	}
+}
+
+func TestExclude(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "small.png", 2, 2)
+	if err := os.Mkdir(filepath.Join(tempDir, "vendor"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, tempDir, "vendor/logo.png", 2, 2)
+
+	processor := markdown.NewProcessor(markdown.Options{
+		Exclude: []string{"https://img.shields.io/*", "vendor/*.png", "*.gif"},
+	})
+	input := "![s](small.png) ![b](https://img.shields.io/badge/build-passing-green) ![v](vendor/logo.png) ![a](anim.gif)"
+	result, err := processor.Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !result.Images[0].Embedded {
+		t.Errorf("Expected small.png to be embedded, got %+v", result.Images[0])
+	}
+	for i, want := range []string{"excluded by https://img.shields.io/*", "excluded by vendor/*.png", "excluded by *.gif"} {
+		if img := result.Images[i+1]; img.Embedded || img.Err != nil || img.SkipReason != want {
+			t.Errorf("Expected %s to be %s, got %+v", img.Reference.ImagePath, want, img)
+		}
+	}
+	if !strings.HasSuffix(result.Content, " ![b](https://img.shields.io/badge/build-passing-green) ![v](vendor/logo.png) ![a](anim.gif)") {
+		t.Errorf("Expected excluded references to be kept, got %q", result.Content)
+	}
+}
+
+func TestInclude(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tempDir, "assets", "icons"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, tempDir, "assets/icons/a.png", 2, 2)
+	writeTestPNG(t, tempDir, "assets/b.png", 2, 2)
+	writeTestPNG(t, tempDir, "other.png", 2, 2)
+
+	processor := markdown.NewProcessor(markdown.Options{
+		Include: []string{"assets/*", `re:^https://cdn\.example\.com/`},
+		Exclude: []string{"re:icons/"},
+	})
+	input := "![a](assets/icons/a.png) ![b](assets/b.png) ![o](other.png) ![r](https://img.shields.io/x.png)"
+	result, err := processor.Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for i, want := range []string{"excluded by re:icons/", "", "not included", "not included"} {
+		if img := result.Images[i]; img.SkipReason != want || img.Embedded != (want == "") {
+			t.Errorf("Expected %s to be skipped as %q, got %+v", img.Reference.ImagePath, want, img)
+		}
+	}
+	if !strings.HasSuffix(result.Content, " ![o](other.png) ![r](https://img.shields.io/x.png)") {
+		t.Errorf("Expected references not included to be kept, got %q", result.Content)
+	}
+}
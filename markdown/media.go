@@ -0,0 +1,144 @@
+package markdown
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path"
+	"strings"
+)
+
+// mediaTypes maps the extensions of audio and video files to their MIME types.
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".ogv":  "video/ogg",
+	".mov":  "video/quicktime",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".oga":  "audio/ogg",
+	".opus": "audio/ogg",
+	".wav":  "audio/wav",
+	".flac": "audio/flac",
+}
+
+// MediaResult records what happened to an audio or video reference. Media
+// is never re-encoded; it is left as a reference unless it is a local file
+// smaller than Options.EmbedMediaUnder.
+type MediaResult struct {
+	// Path is the file as referenced in the document.
+	Path string
+	// Line is the 1-based line of the reference.
+	Line     int
+	MIMEType string
+	// Size is the file size in bytes, -1 for remote media.
+	Size     int64
+	Embedded bool
+	Err      error
+}
+
+// mediaType returns the MIME type of an audio or video path, or "".
+func mediaType(p string) string {
+	return mediaTypes[strings.ToLower(path.Ext(stripQuery(p)))]
+}
+
+// mediaReference is the location of a media path within a document.
+type mediaReference struct {
+	path       string
+	start, end int
+}
+
+// findMediaReferences returns the media paths of <video>, <audio> and
+// <source> src attributes and of markdown images such as ![demo](demo.mp4),
+// in document order. Data URIs are skipped.
+func findMediaReferences(content string) []mediaReference {
+	var refs []mediaReference
+	s := &scanner{content: content, closeFrom: -1}
+	for i := 0; i < len(content); {
+		next := strings.IndexAny(content[i:], "!<")
+		if next < 0 {
+			break
+		}
+		i += next
+		var src htmlAttr
+		var end int
+		if content[i] == '!' {
+			ref, ok := s.markdownImage(i)
+			if !ok {
+				i++
+				continue
+			}
+			src, end = htmlAttr{value: ref.ImagePath, valueStart: ref.pathStart, valueEnd: ref.pathEnd}, ref.EndPos
+		} else {
+			tag, ok := s.htmlTag(i)
+			if !ok {
+				i++
+				continue
+			}
+			end = tag.end
+			switch tag.name {
+			case "video", "audio", "source":
+				src, _ = tag.attr("src")
+			}
+		}
+		if !strings.HasPrefix(src.value, "data:") && mediaType(src.value) != "" {
+			refs = append(refs, mediaReference{path: src.value, start: src.valueStart, end: src.valueEnd})
+		}
+		i = end
+	}
+	return refs
+}
+
+// embedMedia applies the media policy to content: every audio and video
+// reference is reported, and local files under EmbedMediaUnder bytes are
+// inlined as data URIs.
+func (p *Processor) embedMedia(content, baseDir string) (string, []MediaResult) {
+	refs := findMediaReferences(content)
+	if len(refs) == 0 {
+		return content, nil
+	}
+	var results []MediaResult
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		res := MediaResult{
+			Path:     ref.path,
+			Line:     strings.Count(content[:ref.start], "\n") + 1,
+			MIMEType: mediaType(ref.path),
+			Size:     -1,
+		}
+		if !isURL(ref.path) {
+			data, err := p.readMedia(resolveLocalPath(baseDir, ref.path), &res)
+			switch {
+			case errors.Is(err, os.ErrNotExist):
+				res.Err = newError(CodeFileNotFound, ref.path, err)
+			case err != nil:
+				res.Err = newError(CodeFileUnreadable, ref.path, err)
+			case data != nil:
+				b.WriteString(content[last:ref.start])
+				b.WriteString("data:" + res.MIMEType + ";base64," + base64.StdEncoding.EncodeToString(data))
+				last = ref.end
+				res.Embedded = true
+			}
+		}
+		results = append(results, res)
+	}
+	b.WriteString(content[last:])
+	return b.String(), results
+}
+
+// readMedia records the size of a local media file and returns its contents
+// if it is small enough to embed, or nil.
+func (p *Processor) readMedia(file string, res *MediaResult) ([]byte, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	res.Size = info.Size()
+	if p.opts.EmbedMediaUnder <= 0 || res.Size >= p.opts.EmbedMediaUnder {
+		return nil, nil
+	}
+	return os.ReadFile(file)
+}
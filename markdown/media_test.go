@@ -0,0 +1,56 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMediaPolicy(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"click.mp3": "ID3 small",
+		"demo.mp4":  strings.Repeat("\x00", 4096),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	content := "<audio controls src=\"click.mp3\"></audio>\n" +
+		"<video controls>\n  <source src=\"demo.mp4\" type=\"video/mp4\">\n</video>\n" +
+		"![clip](demo.mp4)\n<audio src=\"https://example.com/talk.ogg\"></audio>\n<audio src=\"gone.wav\"></audio>\n"
+
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if result.Content != content || len(result.Images) != 0 {
+		t.Errorf("Expected media to stay references by default, got %q", result.Content)
+	}
+	if len(result.Media) != 5 {
+		t.Fatalf("Expected 5 media references, got %+v", result.Media)
+	}
+	if m := result.Media[1]; m.Path != "demo.mp4" || m.Line != 3 || m.Size != 4096 || m.MIMEType != "video/mp4" || m.Embedded {
+		t.Errorf("Unexpected video result %+v", m)
+	}
+	if m := result.Media[3]; m.Size != -1 || m.Err != nil {
+		t.Errorf("Expected remote media to be reported without a size, got %+v", m)
+	}
+	if m := result.Media[4]; markdown.CodeOf(m.Err) != markdown.CodeFileNotFound {
+		t.Errorf("Expected a missing file error, got %+v", m)
+	}
+
+	result, err = markdown.NewProcessor(markdown.Options{EmbedMediaUnder: 1024}).Process(content, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !strings.HasPrefix(result.Content, `<audio controls src="data:audio/mpeg;base64,SUQzIHNtYWxs"></audio>`) || !result.Media[0].Embedded {
+		t.Errorf("Expected the small clip inlined, got %q", result.Content)
+	}
+	if !strings.Contains(result.Content, `<source src="demo.mp4"`) || !strings.Contains(result.Content, "![clip](demo.mp4)") {
+		t.Errorf("Expected the large video to stay a reference, got %q", result.Content)
+	}
+}
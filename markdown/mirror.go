@@ -0,0 +1,69 @@
+package markdown
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// imageExtensions maps sniffed MIME types to the extension of mirrored files.
+var imageExtensions = map[string]string{
+	"image/png":     ".png",
+	"image/jpeg":    ".jpg",
+	"image/gif":     ".gif",
+	"image/webp":    ".webp",
+	"image/bmp":     ".bmp",
+	"image/tiff":    ".tif",
+	"image/x-icon":  ".ico",
+	"image/svg+xml": ".svg",
+	"image/avif":    ".avif",
+	"image/heic":    ".heic",
+}
+
+// Download fetches a remote image the way Process does: with the same
+// client, retries and GitHub token, and rejecting responses that are not
+// images.
+func (p *Processor) Download(ctx context.Context, imageURL string) ([]byte, error) {
+	return p.downloadImageContent(ctx, imageURL)
+}
+
+// RemoteImageURLs lists the http and https image URLs in content, each once,
+// in document order.
+func RemoteImageURLs(content string) []string {
+	var urls []string
+	seen := map[string]bool{}
+	for _, ref := range findImageReferences(content) {
+		u, err := url.Parse(ref.ImagePath)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || seen[ref.ImagePath] {
+			continue
+		}
+		seen[ref.ImagePath] = true
+		urls = append(urls, ref.ImagePath)
+	}
+	return urls
+}
+
+// MirrorName returns a file name for a local copy of the image at imageURL:
+// the slug of the URL's base name, a hash of the URL that keeps images with
+// the same base name apart, and the extension of the sniffed format, e.g.
+// logo-3f2a9c1e.png. It is the same for the same URL and format.
+func MirrorName(imageURL string, content []byte) string {
+	base, ext := "", ""
+	if u, err := url.Parse(imageURL); err == nil {
+		base = path.Base(u.Path)
+		ext = path.Ext(base)
+		base = strings.TrimSuffix(base, ext)
+	}
+	if sniffed, ok := imageExtensions[sniffImageType(content)]; ok {
+		ext = sniffed
+	}
+	name := Slug(base)
+	if name == "" {
+		name = "image"
+	}
+	sum := sha256.Sum256([]byte(imageURL))
+	return name + "-" + hex.EncodeToString(sum[:4]) + strings.ToLower(ext)
+}
@@ -0,0 +1,67 @@
+package markdown_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestRemoteImageURLs(t *testing.T) {
+	content := "![a](https://example.com/a.png) ![local](a.png)\n" +
+		"<img src=\"http://example.com/b\">\n" +
+		"![again](https://example.com/a.png) ![ftp](ftp://example.com/c.png)\n"
+	want := []string{"https://example.com/a.png", "http://example.com/b"}
+	if got := markdown.RemoteImageURLs(content); !reflect.DeepEqual(got, want) {
+		t.Errorf("RemoteImageURLs() = %v; want %v", got, want)
+	}
+}
+
+func TestMirrorName(t *testing.T) {
+	dir := t.TempDir()
+	png, err := os.ReadFile(filepath.Join(dir, writeTestPNG(t, dir, "a.png", 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := markdown.MirrorName("https://example.com/img/Team Logo.gif?v=2", png)
+	if !strings.HasPrefix(name, "team-logo-") || !strings.HasSuffix(name, ".png") {
+		t.Errorf("Expected a slug with the sniffed extension, got %q", name)
+	}
+	if other := markdown.MirrorName("https://example.org/img/Team Logo.gif", png); other == name {
+		t.Errorf("Expected different URLs to get different names, both got %q", name)
+	}
+	if got := markdown.MirrorName("https://example.com/", nil); !strings.HasPrefix(got, "image-") {
+		t.Errorf("Expected a default name, got %q", got)
+	}
+}
+
+func TestDownload(t *testing.T) {
+	dir := t.TempDir()
+	png, err := os.ReadFile(filepath.Join(dir, writeTestPNG(t, dir, "a.png", 2, 2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/a.png" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(png)
+	}))
+	defer server.Close()
+
+	processor := markdown.NewProcessor(markdown.Options{})
+	got, err := processor.Download(context.Background(), server.URL+"/a.png")
+	if err != nil || string(got) != string(png) {
+		t.Errorf("Download() = %d bytes, %v; want the PNG", len(got), err)
+	}
+	if _, err := processor.Download(context.Background(), server.URL+"/missing.png"); markdown.CodeOf(err) != markdown.CodeHTTPStatus {
+		t.Errorf("Expected %s for a missing image, got %v", markdown.CodeHTTPStatus, err)
+	}
+}
@@ -0,0 +1,33 @@
+package markdown
+
+import "strings"
+
+// utf8BOM is the UTF-8 encoded byte-order mark.
+const utf8BOM = "\uFEFF"
+
+// DetectNewline returns the line ending used by content: "\r\n" when most
+// lines end in CRLF, "\n" otherwise.
+func DetectNewline(content string) string {
+	crlf := strings.Count(content, "\r\n")
+	if crlf > 0 && crlf >= strings.Count(content, "\n")-crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// NormalizeNewlines converts every line ending in content to newline.
+func NormalizeNewlines(content, newline string) string {
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	if newline == "\n" {
+		return content
+	}
+	return strings.ReplaceAll(content, "\n", newline)
+}
+
+// StripBOM removes a leading UTF-8 byte-order mark, reporting whether there was one.
+func StripBOM(content string) (string, bool) {
+	if strings.HasPrefix(content, utf8BOM) {
+		return content[len(utf8BOM):], true
+	}
+	return content, false
+}
@@ -0,0 +1,77 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLineEndingPreservation(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 40, 40)
+
+	testCases := []struct {
+		name  string
+		opts  markdown.Options
+		input string
+	}{
+		{"Reference style with trailing newline", markdown.Options{ReferenceStyle: true}, "\uFEFF# Title\r\n\r\n![a](a.png)\r\n"},
+		{"Reference style without trailing newline", markdown.Options{ReferenceStyle: true}, "# Title\r\n\r\n![a](a.png)"},
+		{"Collapsed image", markdown.Options{CollapseOver: 1}, "# Title\r\n![a](a.png)\r\n"},
+		{"Wrapped payload", markdown.Options{WrapWidth: 20}, "\uFEFF# Title\r\n![a](a.png)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(tc.opts).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			out := result.Content
+			if strings.Count(out, "\n") != strings.Count(out, "\r\n") {
+				t.Errorf("Expected only CRLF line endings, got %q", out)
+			}
+			if strings.HasPrefix(tc.input, "\uFEFF") != strings.HasPrefix(out, "\uFEFF") {
+				t.Errorf("Byte-order mark not preserved in %q", out)
+			}
+			if strings.HasSuffix(tc.input, "\r\n") != strings.HasSuffix(out, "\r\n") {
+				t.Errorf("Trailing newline state not preserved in %q", out)
+			}
+			if !strings.HasPrefix(strings.TrimPrefix(out, "\uFEFF"), "# Title\r\n") {
+				t.Errorf("Untouched text was modified: %q", out)
+			}
+		})
+	}
+}
+
+func TestIncludeLineEndings(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "part.md"), []byte("\uFEFFline one\nline two\n"), 0644); err != nil {
+		t.Fatalf("Failed to write part: %v", err)
+	}
+
+	got, err := markdown.ResolveIncludes("# Doc\r\n<!-- include: part.md -->\r\nend", root)
+	if err != nil {
+		t.Fatalf("ResolveIncludes failed: %v", err)
+	}
+	if expected := "# Doc\r\nline one\r\nline two\r\nend"; got != expected {
+		t.Errorf("Got %q, want %q", got, expected)
+	}
+}
+
+func TestDetectNewline(t *testing.T) {
+	testCases := map[string]string{
+		"a\nb\n":         "\n",
+		"a\r\nb\r\n":     "\r\n",
+		"a\r\nb\r\nc\n":  "\r\n",
+		"a\nb\nc\r\n":    "\n",
+		"no line ending": "\n",
+	}
+	for input, expected := range testCases {
+		if got := markdown.DetectNewline(input); got != expected {
+			t.Errorf("DetectNewline(%q) = %q; want %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package markdown
+
+import (
+	"fmt"
+	"html"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// AttrStyle selects the syntax used to write image dimensions into the output.
+type AttrStyle string
+
+const (
+	// AttrStyleNone drops dimensions; the embedded image is already resized.
+	AttrStyleNone AttrStyle = "none"
+	// AttrStyleKramdown writes a kramdown block: ![alt](data){: width=W height=H}
+	AttrStyleKramdown AttrStyle = "kramdown"
+	// AttrStylePandoc writes a Pandoc attribute block: ![alt](data){width=W height=H}
+	AttrStylePandoc AttrStyle = "pandoc"
+	// AttrStyleHTML writes a raw HTML tag: <img src="data" alt="alt" width="W" height="H">
+	AttrStyleHTML AttrStyle = "html"
+)
+
+// ParseAttrStyle converts a command line value into an AttrStyle.
+func ParseAttrStyle(s string) (AttrStyle, error) {
+	switch style := AttrStyle(strings.ToLower(s)); style {
+	case AttrStyleNone, AttrStyleKramdown, AttrStylePandoc, AttrStyleHTML:
+		return style, nil
+	case "":
+		return AttrStyleNone, nil
+	}
+	return "", fmt.Errorf("unknown attribute style %q (want none, kramdown, pandoc or html)", s)
+}
+
+// formatImage renders an embedded image in the configured attribute style.
+// The title is carried into the markdown title or the HTML title attribute.
+// The alt text is converted between markdown and HTML escaping as needed but
+// otherwise copied byte for byte, so emoji, right-to-left text and combining
+// characters come out exactly as they went in.
+func (p *Processor) formatImage(doc *document, ref ImageReference, dataURI string, width, height int) string {
+	switch ref.Tag {
+	case "object", "embed":
+		return embedInTag(ref, dataURI)
+	case "video":
+		// The video itself stays a reference; the frame becomes its poster.
+		return strings.TrimRight(ref.FullMatch[:len(ref.FullMatch)-1], "/ \t\r\n") + fmt.Sprintf(` poster="%s">`, dataURI)
+	}
+	alt, title := htmlAlt(ref), ref.Title
+	if p.opts.WrapWidth > 0 {
+		// Only HTML attributes tolerate line breaks inside the URL.
+		dataURI = wrapPayload(dataURI, p.opts.WrapWidth, doc.newline)
+		if !p.opts.Figcaption || title == "" {
+			return htmlImage(alt, title, dataURI, width, height)
+		}
+	}
+	if p.opts.Figcaption && title != "" {
+		return fmt.Sprintf("<figure>%s<figcaption>%s</figcaption></figure>",
+			htmlImage(alt, title, dataURI, width, height), html.EscapeString(title))
+	}
+	if p.opts.AttrStyle == AttrStyleHTML {
+		return htmlImage(alt, title, dataURI, width, height)
+	}
+
+	alt = markdownAlt(ref)
+	var image string
+	if p.opts.ReferenceStyle {
+		image = fmt.Sprintf("![%s][%s]", alt, doc.reference(dataURI, title))
+	} else {
+		image = fmt.Sprintf("![%s](%s)", alt, markdownDestination(dataURI, title))
+	}
+	switch p.opts.AttrStyle {
+	case AttrStyleKramdown:
+		if dims := dimensionList(width, height, "%s=%d"); dims != "" {
+			return fmt.Sprintf("%s{:%s}", image, dims)
+		}
+	case AttrStylePandoc:
+		if dims := dimensionList(width, height, "%s=%d"); dims != "" {
+			return fmt.Sprintf("%s{%s}", image, strings.TrimPrefix(dims, " "))
+		}
+	}
+	return image
+}
+
+// embedInTag replaces the file referenced by an <object> or <embed> tag with
+// dataURI, keeping the element and any fallback content after it. A type
+// attribute is updated to the embedded format, which differs from the
+// original when a PDF was rasterized.
+func embedInTag(ref ImageReference, dataURI string) string {
+	s := &scanner{content: ref.FullMatch, closeFrom: -1}
+	tag, ok := s.htmlTag(0)
+	if !ok {
+		return ref.FullMatch
+	}
+	mimeType, _, _ := strings.Cut(strings.TrimPrefix(dataURI, "data:"), ";")
+	var b strings.Builder
+	last := 0
+	for _, a := range tag.attrs {
+		var value string
+		switch {
+		case a.name == "type" && a.valueEnd > a.valueStart:
+			value = mimeType
+		case (a.name == "data" && ref.Tag == "object") || (a.name == "src" && ref.Tag == "embed"):
+			value = dataURI
+		default:
+			continue
+		}
+		b.WriteString(ref.FullMatch[last:a.valueStart])
+		b.WriteString(value)
+		last = a.valueEnd
+	}
+	b.WriteString(ref.FullMatch[last:])
+	return b.String()
+}
+
+// markdownAlt returns the alt text of ref as markdown link text. Markdown
+// sources are kept as written; alt text from HTML has its brackets and
+// backslashes escaped.
+func markdownAlt(ref ImageReference) string {
+	if !ref.IsHTML {
+		return ref.AltText
+	}
+	var b strings.Builder
+	for i := 0; i < len(ref.AltText); i++ {
+		// Byte-wise, so that even invalid UTF-8 is copied unchanged.
+		if c := ref.AltText[i]; c == '\\' || c == '[' || c == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(ref.AltText[i])
+	}
+	return b.String()
+}
+
+// htmlAlt returns the plain alt text of ref, with markdown backslash escapes
+// resolved. HTML sources were already entity-decoded by the scanner.
+func htmlAlt(ref ImageReference) string {
+	if ref.IsHTML || !strings.Contains(ref.AltText, `\`) {
+		return ref.AltText
+	}
+	var b strings.Builder
+	s := ref.AltText
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && isASCIIPunct(s[i+1]) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// isASCIIPunct reports whether b may be backslash-escaped in CommonMark.
+func isASCIIPunct(b byte) bool {
+	return strings.IndexByte("!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~", b) >= 0
+}
+
+// markdownDestination formats a link destination with its optional title.
+func markdownDestination(dest, title string) string {
+	if title == "" {
+		return dest
+	}
+	return dest + ` "` + strings.ReplaceAll(title, `"`, `\"`) + `"`
+}
+
+// htmlImage renders an embedded image as an HTML img tag.
+func htmlImage(alt, title, dataURI string, width, height int) string {
+	var titleAttr string
+	if title != "" {
+		titleAttr = fmt.Sprintf(` title="%s"`, html.EscapeString(title))
+	}
+	return fmt.Sprintf(`<img src="%s" alt="%s"%s%s>`, dataURI, html.EscapeString(alt), titleAttr, dimensionList(width, height, `%s="%d"`))
+}
+
+// wrapPayload breaks the base64 payload of a data URI into lines of at most
+// width characters, starting on the line after the header.
+func wrapPayload(dataURI string, width int, newline string) string {
+	comma := strings.IndexByte(dataURI, ',')
+	if comma < 0 {
+		return dataURI
+	}
+	payload := dataURI[comma+1:]
+	var b strings.Builder
+	b.WriteString(dataURI[:comma+1])
+	for len(payload) > 0 {
+		n := min(width, len(payload))
+		b.WriteString(newline + payload[:n])
+		payload = payload[n:]
+	}
+	return b.String()
+}
+
+// aloneOnLine reports whether content[start:end] has only whitespace beside
+// it on its line, and returns the indentation before it.
+func aloneOnLine(content string, start, end int) (string, bool) {
+	lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+	indent := content[lineStart:start]
+	rest := content[end:]
+	if i := strings.IndexByte(rest, '\n'); i >= 0 {
+		rest = rest[:i]
+	}
+	return indent, strings.Trim(indent, " \t") == "" && strings.Trim(rest, " \t\r") == ""
+}
+
+// collapse wraps an embedded image in a <details> block whose summary names
+// the source file and its size, so huge images don't dominate the document.
+// The lines of the block after the first are indented like the image's.
+func collapse(source string, size int64, embedded, indent, newline string) string {
+	name := path.Base(source)
+	if u, err := url.Parse(source); err == nil && u.Path != "" {
+		name = path.Base(u.Path)
+	}
+	return fmt.Sprintf("<details><summary>%s (%s)</summary>%[5]s%[5]s%[4]s%[3]s%[5]s%[5]s%[4]s</details>",
+		html.EscapeString(name), FormatSize(size), embedded, indent, newline)
+}
+
+// dimensionList formats the known dimensions with pattern, each preceded by a
+// space. Unknown (zero) dimensions are omitted.
+func dimensionList(width, height int, pattern string) string {
+	var b strings.Builder
+	if width > 0 {
+		b.WriteString(" " + fmt.Sprintf(pattern, "width", width))
+	}
+	if height > 0 {
+		b.WriteString(" " + fmt.Sprintf(pattern, "height", height))
+	}
+	return b.String()
+}
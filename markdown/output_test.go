@@ -0,0 +1,271 @@
+package markdown_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/png"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// writeTestPNG writes a blank PNG of the given size into dir and returns its name.
+func writeTestPNG(t testing.TB, dir, name string, width, height int) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height))); err != nil {
+		t.Fatalf("Failed to encode test PNG: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, name), buf.Bytes(), 0644); err != nil {
+		t.Fatalf("Failed to write test PNG: %v", err)
+	}
+	return name
+}
+
+func TestAttrStyles(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "square.png", 10, 10)
+
+	testCases := []struct {
+		style    markdown.AttrStyle
+		input    string
+		expected string
+	}{
+		{markdown.AttrStyleNone, "![sq](square.png){: width=5}", `^!\[sq\]\(data:image/png;base64,[^)]+\)$`},
+		{markdown.AttrStyleKramdown, "![sq](square.png){: width=5}", `^!\[sq\]\(data:image/png;base64,[^)]+\)\{: width=5 height=5\}$`},
+		{markdown.AttrStylePandoc, "![sq](square.png){: width=5}", `^!\[sq\]\(data:image/png;base64,[^)]+\)\{width=5 height=5\}$`},
+		{markdown.AttrStyleHTML, `<img src="square.png" alt="a &quot;b&quot;" height="4">`, `^<img src="data:image/png;base64,[^"]+" alt="a &#34;b&#34;" width="4" height="4">$`},
+		{markdown.AttrStylePandoc, "![sq](square.png){height=6}", `^!\[sq\]\(data:image/png;base64,[^)]+\)\{width=6 height=6\}$`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(string(tc.style), func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{AttrStyle: tc.style}).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !regexp.MustCompile(tc.expected).MatchString(result.Content) {
+				t.Errorf("Output %q does not match %s", result.Content, tc.expected)
+			}
+		})
+	}
+}
+
+func TestTitlePropagation(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "square.png", 10, 10)
+
+	testCases := []struct {
+		name     string
+		opts     markdown.Options
+		input    string
+		expected string
+	}{
+		{
+			name:     "Markdown title kept",
+			input:    `![sq](square.png "A \"quoted\" title")`,
+			expected: `^!\[sq\]\(data:image/png;base64,[^ ]+ "A \\"quoted\\" title"\)$`,
+		},
+		{
+			name:     "Angle-bracket destination",
+			input:    `![sq](<square.png> 'Single')`,
+			expected: `^!\[sq\]\(data:image/png;base64,[^ ]+ "Single"\)$`,
+		},
+		{
+			name:     "HTML title into markdown",
+			input:    `<img src="square.png" alt="sq" title="Tom &amp; Jerry">`,
+			expected: `^!\[sq\]\(data:image/png;base64,[^ ]+ "Tom & Jerry"\)$`,
+		},
+		{
+			name:     "Markdown title into HTML attribute",
+			opts:     markdown.Options{AttrStyle: markdown.AttrStyleHTML},
+			input:    `![sq](square.png "Tom & Jerry")`,
+			expected: `^<img src="data:image/png;base64,[^"]+" alt="sq" title="Tom &amp; Jerry" width="10" height="10">$`,
+		},
+		{
+			name:     "Figcaption",
+			opts:     markdown.Options{Figcaption: true},
+			input:    `![sq](square.png "Caption")`,
+			expected: `^<figure><img src="data:image/png;base64,[^"]+" alt="sq" title="Caption" width="10" height="10"><figcaption>Caption</figcaption></figure>$`,
+		},
+		{
+			name:     "Figcaption skipped without title",
+			opts:     markdown.Options{Figcaption: true},
+			input:    `![sq](square.png)`,
+			expected: `^!\[sq\]\(data:image/png;base64,[^)]+\)$`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(tc.opts).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !regexp.MustCompile(tc.expected).MatchString(result.Content) {
+				t.Errorf("Output %q does not match %s", result.Content, tc.expected)
+			}
+		})
+	}
+}
+
+func TestAltTextRoundTrip(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "square.png", 10, 10)
+	htmlOpts := markdown.Options{AttrStyle: markdown.AttrStyleHTML}
+
+	testCases := []struct {
+		name  string
+		opts  markdown.Options
+		input string
+		want  string
+	}{
+		{"Emoji", markdown.Options{}, "![🦊 fox 👩\u200d💻](square.png)", "![🦊 fox 👩\u200d💻](data:"},
+		{"Right-to-left", markdown.Options{}, "![שלום עולם \u200fمرحبا](square.png)", "![שלום עולם \u200fمرحبا](data:"},
+		{"Combining characters", markdown.Options{}, "![Cafe\u0301 n\u0303](square.png)", "![Cafe\u0301 n\u0303](data:"},
+		{"Invalid UTF-8", markdown.Options{}, "![a\xffb](square.png)", "![a\xffb](data:"},
+		{"Markdown escapes kept", markdown.Options{}, `![a \] b \*](square.png)`, `![a \] b \*](data:`},
+		{"Markdown to HTML", htmlOpts, "![🦊 \\[x\\] & <y>](square.png)", `alt="🦊 [x] &amp; &lt;y&gt;"`},
+		{"Markdown to HTML combining", htmlOpts, "![Cafe\u0301](square.png)", "alt=\"Cafe\u0301\""},
+		{"HTML entities to markdown", markdown.Options{}, `<img src="square.png" alt="&#x1F98A; [x] \ &eacute;">`, "![🦊 \\[x\\] \\\\ é](data:"},
+		{"HTML to HTML", htmlOpts, `<img src="square.png" alt="מה &quot;שלום&quot; 🦊">`, `alt="מה &#34;שלום&#34; 🦊"`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(tc.opts).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !strings.Contains(result.Content, tc.want) {
+				t.Errorf("Expected %q in output %q", tc.want, result.Content)
+			}
+		})
+	}
+}
+
+func TestCollapseOver(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "small.png", 2, 2)
+	writeTestPNG(t, tempDir, "chart.png", 300, 300)
+
+	processor := markdown.NewProcessor(markdown.Options{CollapseOver: 200})
+	result, err := processor.Process("![small](small.png)\n![chart](chart.png)", tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+
+	expected := `^!\[small\]\(data:image/png;base64,[^)]+\)\n` +
+		`<details><summary>chart\.png \([0-9.]+ KB\)</summary>\n\n!\[chart\]\(data:image/png;base64,[^)]+\)\n\n</details>$`
+	if !regexp.MustCompile(expected).MatchString(result.Content) {
+		t.Errorf("Output %q does not match %s", result.Content, expected)
+	}
+
+	// An image in the middle of a paragraph is left as is rather than
+	// splitting it; one alone in a list item is collapsed inside the item.
+	result, err = processor.Process("See ![chart](chart.png) here.\n\n- Item\n\n  ![chart](chart.png)\n", tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	expected = `^See !\[chart\]\(data:image/png;base64,[^)]+\) here\.\n\n- Item\n\n` +
+		`  <details><summary>chart\.png \([0-9.]+ KB\)</summary>\n\n  !\[chart\]\(data:image/png;base64,[^)]+\)\n\n  </details>\n$`
+	if !regexp.MustCompile(expected).MatchString(result.Content) {
+		t.Errorf("Output %q does not match %s", result.Content, expected)
+	}
+}
+
+func TestWrapWidth(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "square.png", 40, 40)
+
+	for _, width := range []int{76, 120} {
+		result, err := markdown.NewProcessor(markdown.Options{WrapWidth: width}).Process(`![sq](square.png "Title")`, tempDir)
+		if err != nil {
+			t.Fatalf("Process failed: %v", err)
+		}
+		lines := strings.Split(result.Content, "\n")
+		if len(lines) < 3 {
+			t.Fatalf("Expected the payload to be wrapped, got %q", result.Content)
+		}
+		if lines[0] != `<img src="data:image/png;base64,` {
+			t.Errorf("Unexpected first line %q", lines[0])
+		}
+		for _, line := range lines[1 : len(lines)-1] {
+			if len(line) != width {
+				t.Errorf("Expected payload lines of %d columns, got %d", width, len(line))
+			}
+		}
+		if !strings.HasSuffix(result.Content, `" alt="sq" title="Title" width="40" height="40">`) {
+			t.Errorf("Unexpected tag ending in %q", lines[len(lines)-1])
+		}
+
+		payload := strings.Join(lines[1:], "")
+		payload = payload[:strings.IndexByte(payload, '"')]
+		if _, err := base64.StdEncoding.DecodeString(payload); err != nil {
+			t.Errorf("Unwrapped payload is not valid base64: %v", err)
+		}
+	}
+}
+
+func TestImageTemplate(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "chart.png", 8, 4)
+	if err := os.WriteFile(filepath.Join(tempDir, "logo.svg"), []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="4" height="4"/>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl, err := markdown.ParseImageTemplate(`<figure><a href="{{.Src}}">{{.Markup}}</a><figcaption>{{html .Alt}} ({{.Width}}x{{.Height}}, {{.Path}})</figcaption></figure>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor := markdown.NewProcessor(markdown.Options{ImageTemplate: tmpl, AttrStyle: markdown.AttrStyleHTML})
+	result, err := processor.Process("![Q1 <sales>](chart.png)\n<embed src=\"logo.svg\">\n", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pattern := `^<figure><a href="data:image/png;base64,[^"]+"><img src="data:image/png;base64,[^"]+" alt="Q1 &lt;sales&gt;" width="8" height="4"></a>` +
+		`<figcaption>Q1 &lt;sales&gt; \(8x4, chart.png\)</figcaption></figure>\n<embed src="data:image/svg\+xml;base64,[^"]+">\n$`
+	if !regexp.MustCompile(pattern).MatchString(result.Content) {
+		t.Errorf("Unexpected output:\n%s", result.Content)
+	}
+
+	tmpl, _ = markdown.ParseImageTemplate(`{{.Nope}}`)
+	if _, err := markdown.NewProcessor(markdown.Options{ImageTemplate: tmpl}).Process("![a](chart.png)", tempDir); err == nil {
+		t.Errorf("Expected an error from a failing template")
+	}
+}
+
+func TestFigureNumbering(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+	content := "# Report\n\n<!-- list-of-figures -->\n\n![Sales \\[Q1\\]](a.png)\n\n<img src=\"a.png\" title=\"Costs & fees\">\n\n" +
+		"![missing](missing.png)\n\n```\n<!-- list-of-figures -->\n```\n"
+	result, err := markdown.NewProcessor(markdown.Options{FigureLabel: "Figure"}).Process(content, tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Report\n\n- [Figure 1: Sales \\[Q1\\]](#figure-1)\n- [Figure 2: Costs & fees](#figure-2)\n\n",
+		`<figure id="figure-1"><img src="data:image/png;base64,`,
+		`alt="Sales [Q1]" width="4" height="4"><figcaption>Figure 1: Sales [Q1]</figcaption></figure>`,
+		`title="Costs &amp; fees" width="4" height="4"><figcaption>Figure 2: Costs &amp; fees</figcaption></figure>`,
+		"![missing](missing.png)",
+		"```\n<!-- list-of-figures -->\n```\n",
+	} {
+		if !strings.Contains(result.Content, want) {
+			t.Errorf("Expected %q in\n%s", want, result.Content)
+		}
+	}
+}
+
+func TestParseAttrStyle(t *testing.T) {
+	if style, err := markdown.ParseAttrStyle("Pandoc"); err != nil || style != markdown.AttrStylePandoc {
+		t.Errorf("Expected pandoc, got %q, %v", style, err)
+	}
+	if _, err := markdown.ParseAttrStyle("textile"); err == nil {
+		t.Errorf("Expected an error for an unknown style")
+	}
+}
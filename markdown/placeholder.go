@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net"
+	"net/url"
+)
+
+// Placeholder dimensions, used unless the reference asks for a size.
+const (
+	placeholderWidth  = 480
+	placeholderHeight = 120
+	// placeholderURLLength is how much of the URL the placeholder shows.
+	placeholderURLLength = 64
+)
+
+// embedOrPlaceholder embeds the image like embedCached, but with
+// FallbackPlaceholder set, embeds a placeholder instead of failing when the
+// host of a remote image can't be reached.
+func (p *Processor) embedOrPlaceholder(ctx context.Context, ref ImageReference, baseDir string, res *ImageResult) (string, error) {
+	encoded, err := p.embedFirst(ctx, ref, baseDir, res)
+	if err == nil || !p.opts.FallbackPlaceholder || !unreachable(err) {
+		return encoded, err
+	}
+	p.log.Warn("Image host unreachable; embedding a placeholder", "image", ref.ImagePath, "error", err)
+	svg := placeholderSVG(ref.ImagePath, ref.Width, ref.Height)
+	// The placeholder has no source hash, so that a refresh replaces it as
+	// soon as the image can be downloaded.
+	*res = ImageResult{
+		Reference:   ref,
+		Source:      res.Source,
+		MIMEType:    "image/svg+xml",
+		EncodedSize: len(svg),
+		Width:       ref.Width,
+		Height:      ref.Height,
+		Placeholder: true,
+	}
+	return base64.StdEncoding.EncodeToString(svg), nil
+}
+
+// unreachable reports whether err is a download that failed because the
+// host could not be resolved, refused the connection or didn't answer, as
+// opposed to a server that answered with an error.
+func unreachable(err error) bool {
+	if CodeOf(err) != CodeDownloadFailed {
+		return false
+	}
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var urlErr *url.Error
+	return errors.As(err, &dnsErr) || errors.As(err, &opErr) || (errors.As(err, &urlErr) && urlErr.Timeout())
+}
+
+// placeholderSVG draws a grey box stating that the image at imageURL is
+// unavailable, width by height pixels or the default size.
+func placeholderSVG(imageURL string, width, height int) []byte {
+	switch {
+	case width <= 0 && height <= 0:
+		width, height = placeholderWidth, placeholderHeight
+	case width <= 0:
+		width = height * placeholderWidth / placeholderHeight
+	case height <= 0:
+		height = width * placeholderHeight / placeholderWidth
+	}
+	shown := []rune(imageURL)
+	if len(shown) > placeholderURLLength {
+		shown = append(shown[:placeholderURLLength-1], '…')
+	}
+	return fmt.Appendf(nil, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d">`+
+		`<rect x="0.5" y="0.5" width="%d" height="%d" fill="#f3f3f3" stroke="#999" stroke-dasharray="4 4"/>`+
+		`<text x="50%%" y="45%%" text-anchor="middle" font-family="sans-serif" font-size="14" fill="#555">Image unavailable</text>`+
+		`<text x="50%%" y="65%%" text-anchor="middle" font-family="monospace" font-size="11" fill="#777">%s</text></svg>`,
+		width, height, width, height, width-1, height-1, html.EscapeString(string(shown)))
+}
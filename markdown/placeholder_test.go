@@ -0,0 +1,61 @@
+package markdown_test
+
+import (
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+// closedURL returns a URL on a local port nothing listens on.
+func closedURL(t *testing.T, path string) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	return "http://" + addr + path
+}
+
+func TestFallbackPlaceholder(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+	unreachable := closedURL(t, "/diagrams/network.png")
+
+	processor := markdown.NewProcessor(markdown.Options{FallbackPlaceholder: true})
+	input := "![net](" + unreachable + ") ![gone](" + server.URL + "/gone.png)"
+	result, err := processor.Process(input, t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	placeholder := result.Images[0]
+	if !placeholder.Embedded || !placeholder.Placeholder || placeholder.Err != nil || placeholder.SourceSHA256 != "" {
+		t.Fatalf("Expected a placeholder for the unreachable host, got %+v", placeholder)
+	}
+	match := regexp.MustCompile(`data:image/svg\+xml;base64,([A-Za-z0-9+/=]+)`).FindStringSubmatch(result.Content)
+	if match == nil {
+		t.Fatalf("Expected an embedded SVG, got %q", result.Content)
+	}
+	svg, err := base64.StdEncoding.DecodeString(match[1])
+	if err != nil || !strings.Contains(string(svg), unreachable) {
+		t.Errorf("Expected the placeholder to show the URL, got %s, %v", svg, err)
+	}
+	if img := result.Images[1]; img.Embedded || img.Placeholder || markdown.CodeOf(img.Err) != markdown.CodeHTTPStatus {
+		t.Errorf("Expected an error status to fail the image, got %+v", img)
+	}
+
+	result, err = markdown.NewProcessor(markdown.Options{}).Process(input, t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if img := result.Images[0]; img.Embedded || markdown.CodeOf(img.Err) != markdown.CodeDownloadFailed {
+		t.Errorf("Expected no placeholder without the option, got %+v", img)
+	}
+}
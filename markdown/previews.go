@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Inline markdown links, [text](path) with an optional angle-bracketed
+// destination or title, to PDFs and to videos.
+var (
+	pdfLinkRegex   = linkRegex(`pdf`)
+	videoLinkRegex = linkRegex(`mp4|webm`)
+)
+
+func linkRegex(extensions string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)\[([^\]\n]*)\]\(\s*<?([^()\s<>]+\.(?:` + extensions + `))>?(?:\s+"[^"\n]*")?\s*\)`)
+}
+
+// findPreviewLinks returns a preview reference for every link matched by
+// linkRegex to a local file outside code blocks. Each preview is positioned
+// at the start of the link's line, after its indentation, and has no source
+// text of its own.
+func findPreviewLinks(content string, linkRegex *regexp.Regexp, maxWidth int) []ImageReference {
+	codeBlocks := codeBlockRanges(content)
+	var refs []ImageReference
+	for _, m := range linkRegex.FindAllStringSubmatchIndex(content, -1) {
+		start := m[0]
+		path := content[m[4]:m[5]]
+		if (start > 0 && content[start-1] == '!') || isURL(path) || inRanges(codeBlocks, start) {
+			continue
+		}
+		lineStart := strings.LastIndexByte(content[:start], '\n') + 1
+		pos := lineStart
+		for pos < start && (content[pos] == ' ' || content[pos] == '\t') {
+			pos++
+		}
+		refs = append(refs, ImageReference{
+			AltText:   content[m[2]:m[3]],
+			ImagePath: path,
+			StartPos:  pos,
+			EndPos:    pos,
+			Preview:   true,
+			Directive: Directive{MaxWidth: maxWidth},
+			pathStart: m[4],
+			pathEnd:   m[5],
+		})
+	}
+	return refs
+}
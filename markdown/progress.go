@@ -0,0 +1,53 @@
+package markdown
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+// Progress reports how far ProcessContext is through a document, for
+// progress indicators.
+type Progress struct {
+	// Done is how many of the document's Total image references have been
+	// processed, embedded or not.
+	Done, Total int
+	// Image is the path or URL of the image about to be processed, empty
+	// in the last call, made when the document is finished or interrupted.
+	Image string
+	// Downloaded is how many bytes of remote images have been downloaded
+	// for the document so far.
+	Downloaded int64
+}
+
+// progress reports the progress of one document to Options.Progress.
+type progress struct {
+	p     *Processor
+	total int
+	// start is the processor's download count when the document started.
+	start int64
+}
+
+func (p *Processor) newProgress(total int) *progress {
+	return &progress{p: p, total: total, start: p.downloaded.Load()}
+}
+
+// report calls Options.Progress, if set, with done images processed and
+// image being the next.
+func (pr *progress) report(done int, image string) {
+	if pr.p.opts.Progress == nil {
+		return
+	}
+	pr.p.opts.Progress(Progress{Done: done, Total: pr.total, Image: image, Downloaded: pr.p.downloaded.Load() - pr.start})
+}
+
+// countingReader adds the bytes read through it to n.
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c countingReader) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,39 @@
+package markdown_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestProgress(t *testing.T) {
+	svg := []byte(`<svg width="1" height="1"></svg>`)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(svg)
+	}))
+	defer server.Close()
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+
+	var reports []markdown.Progress
+	processor := markdown.NewProcessor(markdown.Options{Progress: func(pr markdown.Progress) {
+		reports = append(reports, pr)
+	}})
+	remote := server.URL + "/b.svg"
+	if _, err := processor.Process("![a](a.png)\n![b]("+remote+")\n", tempDir); err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	size := int64(len(svg))
+	want := []markdown.Progress{
+		{Done: 0, Total: 2, Image: "a.png"},
+		{Done: 1, Total: 2, Image: remote},
+		{Done: 2, Total: 2, Downloaded: size},
+	}
+	if !reflect.DeepEqual(reports, want) {
+		t.Errorf("Progress reports = %+v; want %+v", reports, want)
+	}
+}
@@ -0,0 +1,115 @@
+package markdown
+
+import (
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// RewriteImagePaths replaces the path of every image reference in content
+// for which rewrite returns true. Paths are passed as written.
+func RewriteImagePaths(content string, rewrite func(path string) (string, bool)) string {
+	refs := findImageReferences(content)
+	sortReferences(refs)
+
+	var b strings.Builder
+	last := 0
+	for _, ref := range refs {
+		rewritten, ok := rewrite(ref.ImagePath)
+		if !ok {
+			continue
+		}
+		b.WriteString(content[last:ref.pathStart])
+		b.WriteString(rewritten)
+		last = ref.pathEnd
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// RebaseImagePaths rewrites the relative local image paths in content, which
+// are relative to fromDir, so that they are relative to toDir instead.
+// Remote URLs, absolute paths and data URIs are left alone.
+func RebaseImagePaths(content, fromDir, toDir string) string {
+	return RewriteImagePaths(content, func(path string) (string, bool) {
+		return rebasePath(path, fromDir, toDir)
+	})
+}
+
+// RebaseLinks rewrites the relative link destinations in content, which are
+// relative to fromDir, so that they are relative to toDir instead, keeping
+// their query and fragment. URLs with a scheme, such as mailto: links,
+// absolute paths and links within the document are left alone.
+func RebaseLinks(content, fromDir, toDir string) string {
+	return RewriteLinks(content, func(destination string) (string, bool) {
+		p, suffix := destination, ""
+		if i := strings.IndexAny(p, "?#"); i >= 0 {
+			p, suffix = p[:i], p[i:]
+		}
+		if u, err := url.Parse(p); err != nil || u.Scheme != "" {
+			return "", false
+		}
+		rebased, ok := rebasePath(p, fromDir, toDir)
+		if !ok {
+			return "", false
+		}
+		if strings.HasSuffix(p, "/") {
+			rebased += "/"
+		}
+		return rebased + suffix, true
+	})
+}
+
+// rebasePath re-expresses a path relative to fromDir as a path relative to
+// toDir, using forward slashes as markdown expects.
+func rebasePath(p, fromDir, toDir string) (string, bool) {
+	if p == "" || isURL(p) || strings.HasPrefix(p, "/") || strings.HasPrefix(p, "#") || filepath.IsAbs(p) {
+		return "", false
+	}
+	fromAbs, err := filepath.Abs(filepath.Join(fromDir, filepath.FromSlash(p)))
+	if err != nil {
+		return "", false
+	}
+	toAbs, err := filepath.Abs(toDir)
+	if err != nil {
+		return "", false
+	}
+	rel, err := filepath.Rel(toAbs, fromAbs)
+	if err != nil {
+		return "", false
+	}
+	return filepath.ToSlash(rel), true
+}
+
+// RenameImagePaths rewrites the references in content, a document in
+// baseDir, to the local image file oldPath so that they point at newPath,
+// and returns how many it rewrote. Rewritten paths are relative to baseDir,
+// keeping a leading "./", and spaces are written as %20.
+func RenameImagePaths(content, baseDir, oldPath, newPath string) (string, int) {
+	absBase, err := filepath.Abs(baseDir)
+	if err != nil {
+		absBase = baseDir
+	}
+	oldAbs, err1 := filepath.Abs(oldPath)
+	newAbs, err2 := filepath.Abs(newPath)
+	if err1 != nil || err2 != nil {
+		return content, 0
+	}
+	renamed := 0
+	content = RewriteImagePaths(content, func(path string) (string, bool) {
+		if file, ok := localImageFile(absBase, path); !ok || file != oldAbs {
+			return "", false
+		}
+		rel, err := filepath.Rel(absBase, newAbs)
+		if err != nil {
+			return "", false
+		}
+		rewritten := filepath.ToSlash(rel)
+		if strings.HasPrefix(path, "./") && !strings.HasPrefix(rewritten, "../") {
+			rewritten = "./" + rewritten
+		}
+		renamed++
+		return strings.ReplaceAll(rewritten, " ", "%20"), true
+	})
+	return content, renamed
+}
@@ -0,0 +1,92 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRebaseImagePaths(t *testing.T) {
+	root := t.TempDir()
+	from := filepath.Join(root, "docs", "guide")
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Relative markdown path", "![a](img/a.png)", "![a](docs/guide/img/a.png)"},
+		{"Parent directory", "![a](../shared/a.png \"Title\")", "![a](docs/shared/a.png \"Title\")"},
+		{"HTML image", `<img src="./a.png" alt="a">`, `<img src="docs/guide/a.png" alt="a">`},
+		{"Remote URL untouched", "![a](https://example.com/a.png)", "![a](https://example.com/a.png)"},
+		{"Root-relative path untouched", "![a](/assets/a.png)", "![a](/assets/a.png)"},
+		{"Data URI untouched", "![a](data:image/png;base64,AAAA)", "![a](data:image/png;base64,AAAA)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := markdown.RebaseImagePaths(tc.input, from, root); got != tc.expected {
+				t.Errorf("RebaseImagePaths(%q) = %q; want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRebaseLinks(t *testing.T) {
+	root := t.TempDir()
+	from := filepath.Join(root, "docs", "guide")
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Relative link", "[setup](setup.md)", "[setup](docs/guide/setup.md)"},
+		{"Fragment and query kept", "[s](../ref.md?v=2#install)", "[s](docs/ref.md?v=2#install)"},
+		{"Directory link", "[all](examples/)", "[all](docs/guide/examples/)"},
+		{"HTML link", `<a href="setup.md">setup</a>`, `<a href="docs/guide/setup.md">setup</a>`},
+		{"Fragment only untouched", "[up](#top)", "[up](#top)"},
+		{"Mail link untouched", "[mail](mailto:a@example.com)", "[mail](mailto:a@example.com)"},
+		{"Remote URL untouched", "[site](https://example.com/a.md)", "[site](https://example.com/a.md)"},
+		{"Images untouched", "![a](img/a.png)", "![a](img/a.png)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := markdown.RebaseLinks(tc.input, from, root); got != tc.expected {
+				t.Errorf("RebaseLinks(%q) = %q; want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestRenameImagePaths(t *testing.T) {
+	root := t.TempDir()
+	docs := filepath.Join(root, "docs")
+	if err := os.MkdirAll(filepath.Join(docs, "img"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestPNG(t, filepath.Join(docs, "img"), "old.png", 2, 2)
+	writeTestPNG(t, filepath.Join(docs, "img"), "other.png", 2, 2)
+	oldPath := filepath.Join(docs, "img", "old.png")
+	newPath := filepath.Join(root, "assets", "new logo.png")
+
+	content := "![a](img/old.png \"Title\")\n" +
+		"<img src=\"./img/old.png\" alt=\"a\">\n" +
+		"![b](img/other.png) ![c](../docs/img/old.png)\n" +
+		"![r](https://example.com/img/old.png)\n"
+	got, n := markdown.RenameImagePaths(content, docs, oldPath, newPath)
+	expected := "![a](../assets/new%20logo.png \"Title\")\n" +
+		"<img src=\"../assets/new%20logo.png\" alt=\"a\">\n" +
+		"![b](img/other.png) ![c](../assets/new%20logo.png)\n" +
+		"![r](https://example.com/img/old.png)\n"
+	if got != expected || n != 3 {
+		t.Errorf("RenameImagePaths() = %q, %d; want %q, 3", got, n, expected)
+	}
+
+	got, n = markdown.RenameImagePaths("![a](./img/old.png)", docs, oldPath, filepath.Join(docs, "img", "new.png"))
+	if got != "![a](./img/new.png)" || n != 1 {
+		t.Errorf("Expected a leading ./ to be kept, got %q, %d", got, n)
+	}
+}
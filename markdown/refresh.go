@@ -0,0 +1,36 @@
+package markdown
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// dataURIRegex matches a base64 data URI as Process writes it.
+var dataURIRegex = regexp.MustCompile(`data:[\w.+/-]+;base64,[A-Za-z0-9+/=]+`)
+
+// ReplaceDataURI replaces every data URI in content whose SHA-256 is
+// oldSHA256, as recorded in ImageResult.DataURISHA256, with dataURI, and
+// returns how many it replaced.
+func ReplaceDataURI(content, oldSHA256, dataURI string) (string, int) {
+	replaced := 0
+	content = dataURIRegex.ReplaceAllStringFunc(content, func(match string) string {
+		if dataURIHash(match) != oldSHA256 {
+			return match
+		}
+		replaced++
+		return dataURI
+	})
+	return content, replaced
+}
+
+// ElideDataURIs replaces the payload of every base64 data URI in content
+// with its decoded size, e.g. data:image/png;base64,<12345 bytes>, so that
+// documents with embedded images can be shown to people.
+func ElideDataURIs(content string) string {
+	return dataURIRegex.ReplaceAllStringFunc(content, func(match string) string {
+		header, payload, _ := strings.Cut(match, ",")
+		size := len(payload)/4*3 - (len(payload) - len(strings.TrimRight(payload, "=")))
+		return fmt.Sprintf("%s,<%d bytes>", header, size)
+	})
+}
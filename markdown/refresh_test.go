@@ -0,0 +1,61 @@
+package markdown_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestEmbedImageAndReplaceDataURI(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+	processor := markdown.NewProcessor(markdown.Options{})
+
+	result, err := processor.Process("![a](a.png) and again ![b](a.png)", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := result.Images[0]
+	if !old.Embedded || old.DataURISHA256 == "" {
+		t.Fatalf("Expected an embedded image with a data URI hash, got %+v", old)
+	}
+
+	// Replace the image and embed it again from its reference.
+	writeTestPNG(t, tempDir, "a.png", 8, 8)
+	dataURI, res := markdown.NewProcessor(markdown.Options{}).EmbedImage(context.Background(), old.Reference, tempDir)
+	if res.Err != nil || !strings.HasPrefix(dataURI, "data:image/png;base64,") {
+		t.Fatalf("EmbedImage() = %q, %v", dataURI, res.Err)
+	}
+	if res.SourceSHA256 == old.SourceSHA256 || res.Width != 8 {
+		t.Errorf("Expected the changed image, got %+v", res)
+	}
+
+	content, n := markdown.ReplaceDataURI(result.Content, old.DataURISHA256, dataURI)
+	if n != 2 || strings.Count(content, dataURI) != 2 {
+		t.Errorf("Expected both data URIs replaced, replaced %d in %q", n, content)
+	}
+	if _, n := markdown.ReplaceDataURI(content, old.DataURISHA256, dataURI); n != 0 {
+		t.Errorf("Expected nothing left to replace, replaced %d", n)
+	}
+
+	if err := os.Remove(filepath.Join(tempDir, "a.png")); err != nil {
+		t.Fatal(err)
+	}
+	if _, res := markdown.NewProcessor(markdown.Options{}).EmbedImage(context.Background(), old.Reference, tempDir); markdown.CodeOf(res.Err) != markdown.CodeFileNotFound {
+		t.Errorf("Expected %s for a missing image, got %v", markdown.CodeFileNotFound, res.Err)
+	}
+}
+
+func TestElideDataURIs(t *testing.T) {
+	// "hello" and "hi" encode to 5 and 2 bytes, with one and two padding
+	// characters.
+	content := "![a](data:image/png;base64,aGVsbG8=) ![b](data:image/svg+xml;base64,aGk=)"
+	want := "![a](data:image/png;base64,<5 bytes>) ![b](data:image/svg+xml;base64,<2 bytes>)"
+	if got := markdown.ElideDataURIs(content); got != want {
+		t.Errorf("ElideDataURIs() = %q, want %q", got, want)
+	}
+}
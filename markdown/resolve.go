@@ -0,0 +1,77 @@
+package markdown
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/unicode/norm"
+)
+
+// remotePath resolves a relative image path against Options.BaseURL.
+func (p *Processor) remotePath(ref string) (string, bool) {
+	if p.opts.BaseURL == "" || ref == "" || isURL(ref) || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") {
+		return "", false
+	}
+	base, err := url.Parse(p.opts.BaseURL)
+	if err != nil {
+		return "", false
+	}
+	rel, err := url.Parse(filepath.ToSlash(ref))
+	if err != nil {
+		return "", false
+	}
+	return base.ResolveReference(rel).String(), true
+}
+
+// LocalPath returns the file a reference such as an ImageReference's
+// ImagePath points at, relative to baseDir, resolved as Process resolves it.
+// Remote URLs and data URIs yield false.
+func LocalPath(baseDir, ref string) (string, bool) {
+	if ref == "" || isURL(ref) || strings.HasPrefix(ref, "data:") {
+		return "", false
+	}
+	return resolveLocalPath(baseDir, ref), true
+}
+
+// resolveLocalPath finds the file a local image reference points at. Besides
+// the path as written it tries the percent-decoded path and the NFC and NFD
+// Unicode normalizations, since macOS and Linux disagree on how accented
+// file names are stored. If nothing exists, the path as written is returned.
+func resolveLocalPath(baseDir, imagePath string) string {
+	candidates := []string{imagePath}
+	if unescaped, err := url.PathUnescape(imagePath); err == nil && unescaped != imagePath {
+		candidates = append(candidates, unescaped)
+	}
+	for _, c := range candidates {
+		candidates = append(candidates, norm.NFC.String(c), norm.NFD.String(c))
+	}
+
+	for _, c := range candidates {
+		fullPath := filepath.Join(baseDir, filepath.FromSlash(c))
+		if _, err := os.Stat(fullPath); err == nil {
+			return fullPath
+		}
+	}
+	return filepath.Join(baseDir, filepath.FromSlash(imagePath))
+}
+
+// normalizeURL converts an internationalized domain name in rawURL to its
+// ASCII (punycode) form, e.g. bücher.example becomes xn--bcher-kva.example.
+func normalizeURL(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	host, err := idna.Lookup.ToASCII(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	if port := u.Port(); port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+	return u.String(), nil
+}
@@ -0,0 +1,108 @@
+package markdown_test
+
+import (
+	"bytes"
+	"io"
+	"markdown-images/markdown"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestInternationalizedFileNames(t *testing.T) {
+	tempDir := t.TempDir()
+	// "café" with a decomposed é (NFD), as macOS stores it.
+	writeTestPNG(t, tempDir, "cafe\u0301.png", 4, 4)
+	writeTestPNG(t, tempDir, "my image.png", 4, 4)
+
+	testCases := []struct {
+		name     string
+		markdown string
+	}{
+		{"NFC reference to NFD file", "![cafe](caf\u00e9.png)"},
+		{"NFD reference to NFD file", "![cafe](cafe\u0301.png)"},
+		{"Percent-encoded path", "![cafe](caf%C3%A9.png)"},
+		{"Percent-encoded space", "![space](my%20image.png)"},
+		{"Unicode alt text", "![日本語](caf\u00e9.png)"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{}).Process(tc.markdown, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if !result.Images[0].Embedded {
+				t.Errorf("Expected image to be embedded, got error %v", result.Images[0].Err)
+			}
+		})
+	}
+}
+
+// recordingTransport answers every request with an SVG and remembers the
+// requested hosts.
+type recordingTransport struct {
+	hosts []string
+}
+
+func (rt *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	rt.hosts = append(rt.hosts, req.URL.Host)
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": []string{"image/svg+xml"}},
+		Body:       io.NopCloser(bytes.NewReader([]byte(`<svg width="1" height="1"></svg>`))),
+		Request:    req,
+	}, nil
+}
+
+func TestInternationalizedDomainNames(t *testing.T) {
+	transport := &recordingTransport{}
+	processor := markdown.NewProcessor(markdown.Options{HTTPClient: &http.Client{Transport: transport}})
+
+	result, err := processor.Process("![logo](https://bücher.example:8443/bild.svg)", t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if !result.Images[0].Embedded {
+		t.Fatalf("Expected image to be embedded, got error %v", result.Images[0].Err)
+	}
+	if len(transport.hosts) != 1 || transport.hosts[0] != "xn--bcher-kva.example:8443" {
+		t.Errorf("Expected a request to the punycode host, got %v", transport.hosts)
+	}
+	if !strings.HasPrefix(result.Content, "![logo](data:image/svg+xml;base64,") {
+		t.Errorf("Unexpected output %q", result.Content)
+	}
+}
+
+func TestBaseURL(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(`<svg width="1" height="1"></svg>`))
+	}))
+	defer server.Close()
+
+	processor := markdown.NewProcessor(markdown.Options{BaseURL: server.URL + "/docs/"})
+	input := "![a](img/a.svg) ![b](/logo.svg) ![c](../up.svg)"
+	result, err := processor.Process(input, t.TempDir())
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for _, img := range result.Images {
+		if !img.Embedded {
+			t.Errorf("Expected %s to be embedded, got error %v", img.Reference.ImagePath, img.Err)
+		}
+	}
+	slices.Sort(paths)
+	want := []string{"/docs/img/a.svg", "/logo.svg", "/up.svg"}
+	if strings.Join(paths, " ") != strings.Join(want, " ") {
+		t.Errorf("Expected requests for %v, got %v", want, paths)
+	}
+}
@@ -0,0 +1,434 @@
+package markdown
+
+import (
+	"html"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// The scanner finds image references in a single left-to-right pass. It
+// replaces an earlier set of regular expressions, which were slow on
+// documents that already contain multi-megabyte data URIs: every byte is
+// examined a bounded number of times, and a span that turns out to be an
+// image (embedded or not) is skipped as a whole.
+
+// Limits that keep failed parses cheap: markdown destinations may nest
+// parentheses at most maxParenDepth deep (as in cmark), and attribute blocks
+// are short by nature, as is the distance from a <video> to its <source>.
+const (
+	maxParenDepth      = 32
+	maxAttrBlockLength = 256
+	maxVideoSearch     = 4096
+)
+
+// scanner holds the state of one pass over a document.
+type scanner struct {
+	content string
+	// closeFrom and closeAt remember the last search for an unescaped ']'
+	// (closeAt is -1 if there was none), so that long runs of unterminated
+	// "![" are not rescanned from each opening bracket.
+	closeFrom, closeAt int
+}
+
+// htmlAttr is an attribute of an HTML tag, with the location of its value.
+type htmlAttr struct {
+	name                 string
+	value                string
+	valueStart, valueEnd int
+}
+
+// htmlTag is a parsed HTML start tag spanning content[start:end].
+type htmlTag struct {
+	name       string
+	attrs      []htmlAttr
+	start, end int
+}
+
+// attr returns the attribute with the given (lower-case) name.
+func (t *htmlTag) attr(name string) (htmlAttr, bool) {
+	for _, a := range t.attrs {
+		if a.name == name {
+			return a, true
+		}
+	}
+	return htmlAttr{}, false
+}
+
+// findImageReferences returns the markdown and HTML image references in
+// content, in document order. Images that are already data URIs are skipped.
+func findImageReferences(content string) []ImageReference {
+	return scanImageReferences(content, false)
+}
+
+// scanImageReferences is findImageReferences, keeping the data URI images
+// if withData is set.
+func scanImageReferences(content string, withData bool) []ImageReference {
+	s := &scanner{content: content, closeFrom: -1}
+	var refs []ImageReference
+	for i := 0; i < len(content); {
+		next := strings.IndexAny(content[i:], "!<")
+		if next < 0 {
+			break
+		}
+		i += next
+
+		var ref ImageReference
+		var ok bool
+		if content[i] == '!' {
+			ref, ok = s.markdownImage(i)
+		} else {
+			ref, ok = s.htmlImage(i)
+		}
+		if !ok {
+			i++
+			continue
+		}
+		if withData || !strings.HasPrefix(ref.ImagePath, "data:") {
+			refs = append(refs, ref)
+		}
+		i = ref.EndPos
+	}
+	return refs
+}
+
+// markdownImage parses ![alt](path "title"){: width=W height=H} at i.
+func (s *scanner) markdownImage(i int) (ImageReference, bool) {
+	c := s.content
+	if i+1 >= len(c) || c[i+1] != '[' {
+		return ImageReference{}, false
+	}
+	altEnd := s.findClose(i + 2)
+	if altEnd < 0 || altEnd+1 >= len(c) || c[altEnd+1] != '(' {
+		return ImageReference{}, false
+	}
+
+	pathStart, pathEnd, title, end, ok := s.destination(altEnd + 1)
+	if !ok {
+		return ImageReference{}, false
+	}
+
+	ref := ImageReference{
+		AltText:   c[i+2 : altEnd],
+		ImagePath: c[pathStart:pathEnd],
+		Title:     title,
+		StartPos:  i,
+		pathStart: pathStart,
+		pathEnd:   pathEnd,
+	}
+	if blockEnd, width, height, ok := s.dimensionBlock(end); ok {
+		end, ref.Width, ref.Height = blockEnd, width, height
+	}
+	ref.EndPos = end
+	ref.FullMatch = c[i:end]
+	return ref, true
+}
+
+// destination parses the (path "title") of a markdown link or image, whose
+// opening parenthesis is at open, returning the location of the path, the
+// title and the position after the closing parenthesis.
+func (s *scanner) destination(open int) (pathStart, pathEnd int, title string, end int, ok bool) {
+	c := s.content
+	pos := skipSpace(c, open+1)
+	if pos < len(c) && c[pos] == '<' {
+		end := strings.IndexAny(c[pos+1:], "<>\n")
+		if end < 0 || c[pos+1+end] != '>' {
+			return 0, 0, "", 0, false
+		}
+		pathStart, pathEnd = pos+1, pos+1+end
+		pos = pathEnd + 1
+	} else {
+		pathStart = pos
+		depth := 0
+	path:
+		for ; pos < len(c); pos++ {
+			switch c[pos] {
+			case '\\':
+				pos++
+			case ' ', '\t', '\n', '\r':
+				break path
+			case '(':
+				depth++
+				if depth > maxParenDepth {
+					return 0, 0, "", 0, false
+				}
+			case ')':
+				if depth == 0 {
+					break path
+				}
+				depth--
+			}
+		}
+		pathEnd = min(pos, len(c))
+		if pathEnd == pathStart {
+			return 0, 0, "", 0, false
+		}
+	}
+
+	pos = skipSpace(c, pos)
+	if pos < len(c) && (c[pos] == '"' || c[pos] == '\'' || c[pos] == '(') {
+		closing := c[pos]
+		if closing == '(' {
+			closing = ')'
+		}
+		end := pos + 1
+		for ; end < len(c) && c[end] != closing; end++ {
+			if c[end] == '\\' {
+				end++
+			}
+		}
+		if end >= len(c) || strings.Contains(c[pos:end], "\n\n") {
+			return 0, 0, "", 0, false
+		}
+		title = unquoteTitle(c[pos : end+1])
+		pos = skipSpace(c, end+1)
+	}
+	if pos >= len(c) || c[pos] != ')' {
+		return 0, 0, "", 0, false
+	}
+	return pathStart, pathEnd, title, pos + 1, true
+}
+
+// dimensionBlock parses a kramdown {: width=W height=H} or Pandoc
+// {width=W height=H} block starting at pos, returning the position after it.
+// Width and height are optional and may appear in either order. The block
+// must follow the image directly and ends at the first '}', so on a line such
+// as ![a](1.png){: width=50} ![b](2.png){: width=60} each block stays with
+// its own image.
+func (s *scanner) dimensionBlock(pos int) (end, width, height int, ok bool) {
+	c := s.content
+	if pos >= len(c) || c[pos] != '{' {
+		return 0, 0, 0, false
+	}
+	lineEnd := strings.IndexAny(c[pos:min(len(c), pos+maxAttrBlockLength)], "}\n")
+	if lineEnd < 0 || c[pos+lineEnd] != '}' {
+		return 0, 0, 0, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(c[pos+1:pos+lineEnd], ":"))
+	seen := map[string]bool{}
+	for _, field := range fields {
+		key, value, _ := strings.Cut(field, "=")
+		n, err := strconv.Atoi(strings.Trim(value, `"'`))
+		if err != nil || n < 0 || (key != "width" && key != "height") || seen[key] {
+			return 0, 0, 0, false
+		}
+		seen[key] = true
+		if key == "width" {
+			width = n
+		} else {
+			height = n
+		}
+	}
+	return pos + lineEnd + 1, width, height, true
+}
+
+// htmlImage parses an <img> tag at i, or an <object data="..."> or
+// <embed src="..."> tag referencing an SVG or PDF file.
+func (s *scanner) htmlImage(i int) (ImageReference, bool) {
+	tag, ok := s.htmlTag(i)
+	if !ok {
+		return ImageReference{}, false
+	}
+	var src htmlAttr
+	switch tag.name {
+	case "img", "embed":
+		src, ok = tag.attr("src")
+	case "object":
+		src, ok = tag.attr("data")
+	case "video":
+		if _, hasPoster := tag.attr("poster"); hasPoster {
+			return ImageReference{}, false
+		}
+		if src, ok = tag.attr("src"); !ok {
+			src, ok = s.videoSource(tag.end)
+		}
+	default:
+		return ImageReference{}, false
+	}
+	if !ok || src.value == "" || ((tag.name == "object" || tag.name == "embed") && !isDocumentImage(&tag, src.value)) {
+		return ImageReference{}, false
+	}
+
+	ref := ImageReference{
+		FullMatch: s.content[tag.start:tag.end],
+		ImagePath: src.value,
+		StartPos:  tag.start,
+		EndPos:    tag.end,
+		IsHTML:    true,
+		Tag:       tag.name,
+		pathStart: src.valueStart,
+		pathEnd:   src.valueEnd,
+	}
+	if alt, ok := tag.attr("alt"); ok {
+		ref.AltText = html.UnescapeString(alt.value)
+	}
+	if title, ok := tag.attr("title"); ok {
+		ref.Title = html.UnescapeString(title.value)
+	}
+	if width, ok := tag.attr("width"); ok {
+		ref.Width, _ = strconv.Atoi(width.value)
+	}
+	if height, ok := tag.attr("height"); ok {
+		ref.Height, _ = strconv.Atoi(height.value)
+	}
+	return ref, true
+}
+
+// videoSource returns the src of the first <source> element of a <video>
+// whose start tag ends at pos. Only the next few kilobytes are searched, so
+// an unclosed video can't make the scan quadratic.
+func (s *scanner) videoSource(pos int) (htmlAttr, bool) {
+	window := s.content[pos:min(len(s.content), pos+maxVideoSearch)]
+	if end := strings.Index(strings.ToLower(window), "</video"); end >= 0 {
+		window = window[:end]
+	}
+	for i := strings.IndexByte(window, '<'); i >= 0; {
+		inner := &scanner{content: window, closeFrom: -1}
+		if tag, ok := inner.htmlTag(i); ok && tag.name == "source" {
+			if src, ok := tag.attr("src"); ok {
+				src.valueStart += pos
+				src.valueEnd += pos
+				return src, true
+			}
+		}
+		next := strings.IndexByte(window[i+1:], '<')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return htmlAttr{}, false
+}
+
+// isDocumentImage reports whether an <object> or <embed> tag refers to an
+// SVG or PDF file, going by its type attribute or the path's extension.
+// Other embedded content, such as video, is left alone.
+func isDocumentImage(tag *htmlTag, src string) bool {
+	if typ, ok := tag.attr("type"); ok {
+		switch strings.ToLower(typ.value) {
+		case "image/svg+xml", "application/pdf":
+			return true
+		}
+	}
+	switch strings.ToLower(path.Ext(stripQuery(src))) {
+	case ".svg", ".pdf":
+		return true
+	}
+	return false
+}
+
+// htmlTag parses an HTML start tag at i. Tag and attribute names are
+// lower-cased; attribute values are returned raw (not entity-decoded). A '<'
+// outside a quoted value ends the attempt, so an unterminated tag never
+// swallows the tags after it.
+func (s *scanner) htmlTag(i int) (htmlTag, bool) {
+	c := s.content
+	pos := i + 1
+	nameEnd := pos
+	for nameEnd < len(c) && isTagNameChar(c[nameEnd]) {
+		nameEnd++
+	}
+	if nameEnd == pos {
+		return htmlTag{}, false
+	}
+	tag := htmlTag{name: strings.ToLower(c[pos:nameEnd]), start: i}
+
+	pos = nameEnd
+	for {
+		for pos < len(c) && (isSpace(c[pos]) || c[pos] == '/') {
+			pos++
+		}
+		if pos >= len(c) {
+			return htmlTag{}, false
+		}
+		if c[pos] == '>' {
+			tag.end = pos + 1
+			return tag, true
+		}
+		if pos == nameEnd {
+			// Attributes must be separated from the tag name.
+			return htmlTag{}, false
+		}
+
+		attrStart := pos
+		for pos < len(c) && !isSpace(c[pos]) && c[pos] != '=' && c[pos] != '>' && c[pos] != '/' {
+			if c[pos] == '<' {
+				return htmlTag{}, false
+			}
+			pos++
+		}
+		attr := htmlAttr{name: strings.ToLower(c[attrStart:pos])}
+		pos = skipSpace(c, pos)
+		if pos < len(c) && c[pos] == '=' {
+			pos = skipSpace(c, pos+1)
+			if pos >= len(c) {
+				return htmlTag{}, false
+			}
+			if quote := c[pos]; quote == '"' || quote == '\'' {
+				end := strings.IndexByte(c[pos+1:], quote)
+				if end < 0 {
+					return htmlTag{}, false
+				}
+				attr.valueStart, attr.valueEnd = pos+1, pos+1+end
+				pos = attr.valueEnd + 1
+			} else {
+				attr.valueStart = pos
+				for pos < len(c) && !isSpace(c[pos]) && c[pos] != '>' {
+					if c[pos] == '<' {
+						return htmlTag{}, false
+					}
+					pos++
+				}
+				attr.valueEnd = pos
+			}
+			attr.value = c[attr.valueStart:attr.valueEnd]
+		}
+		tag.attrs = append(tag.attrs, attr)
+	}
+}
+
+// findClose returns the position of the first unescaped ']' at or after
+// from, or -1.
+func (s *scanner) findClose(from int) int {
+	if s.closeFrom >= 0 && from >= s.closeFrom && (s.closeAt < 0 || from <= s.closeAt) {
+		return s.closeAt
+	}
+	s.closeFrom, s.closeAt = from, -1
+	for p := from; p < len(s.content); p++ {
+		switch s.content[p] {
+		case '\\':
+			p++
+		case ']':
+			s.closeAt = p
+			return p
+		}
+	}
+	return -1
+}
+
+func skipSpace(c string, pos int) int {
+	for pos < len(c) && isSpace(c[pos]) {
+		pos++
+	}
+	return pos
+}
+
+func isSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\f'
+}
+
+func isTagNameChar(b byte) bool {
+	return b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b == '-'
+}
+
+// unquoteTitle strips the delimiters from a markdown link title.
+func unquoteTitle(s string) string {
+	if len(s) < 2 {
+		return ""
+	}
+	first, last := s[0], s[len(s)-1]
+	if (first == '"' && last == '"') || (first == '\'' && last == '\'') || (first == '(' && last == ')') {
+		return strings.ReplaceAll(s[1:len(s)-1], `\`+string(last), string(last))
+	}
+	return ""
+}
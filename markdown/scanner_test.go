@@ -0,0 +1,161 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestScannerSkipsHugeExistingDataURIs(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping 50 MB input in short mode")
+	}
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+
+	payload := strings.Repeat("QUFB", 50<<20/4)
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{"Markdown data URI", "# Doc\n![big](data:image/png;base64," + payload + ")\n![a](a.png)\n"},
+		{"HTML data URI", "<img src=\"data:image/png;base64," + payload + "\" alt=\"big\">\n![a](a.png)\n"},
+		{"Unterminated data URI", "![big](data:image/png;base64," + payload + "\n![a](a.png)\n"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			start := time.Now()
+			result, err := markdown.NewProcessor(markdown.Options{}).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if len(result.Images) != 1 || !result.Images[0].Embedded {
+				t.Fatalf("Expected only the local image to be processed, got %d results", len(result.Images))
+			}
+			if !strings.Contains(result.Content, payload) {
+				t.Errorf("Expected the existing data URI to be preserved")
+			}
+			t.Logf("Processed %d MB in %v", len(tc.input)>>20, time.Since(start))
+		})
+	}
+}
+
+func TestScannerPathologicalInputs(t *testing.T) {
+	testCases := []struct {
+		name  string
+		input string
+	}{
+		{"Unterminated alt text", strings.Repeat("![", 1<<20)},
+		{"Unterminated destination", strings.Repeat("![a](", 1<<18)},
+		{"Unterminated title", strings.Repeat(`![a](b "`, 1<<17)},
+		{"Unterminated HTML tags", strings.Repeat("<img src=", 1<<18)},
+		{"Unterminated attribute block", strings.Repeat("![a](data:x){", 1<<17)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if _, err := markdown.NewProcessor(markdown.Options{}).Process(tc.input, t.TempDir()); err != nil {
+					t.Errorf("Process failed: %v", err)
+				}
+			}()
+			select {
+			case <-done:
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Scanner did not finish within 10s on %d bytes", len(tc.input))
+			}
+		})
+	}
+}
+
+func TestScannerSyntax(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 4, 4)
+	writeTestPNG(t, tempDir, "a (1).png", 4, 4)
+
+	testCases := []struct {
+		name         string
+		input        string
+		wantEmbedded int
+	}{
+		{"Plain", "![a](a.png)", 1},
+		{"Parentheses in path", "![a](<a (1).png>)", 1},
+		{"Escaped bracket in alt", `![a \] b](a.png)`, 1},
+		{"Not an image link", "[a](a.png)", 0},
+		{"Space between brackets", "![a] (a.png)", 0},
+		{"HTML without alt", `<img src="a.png">`, 1},
+		{"HTML with attributes in any order", `<IMG width="2" ALT='x' src=a.png />`, 1},
+		{"HTML with > in attribute", `<img alt="a > b" src="a.png">`, 1},
+		{"Other tag", `<image src="a.png">`, 0},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{}).Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if got := strings.Count(result.Content, "data:image/png;base64,"); got != tc.wantEmbedded {
+				t.Errorf("Expected %d embedded images, got %d: %q", tc.wantEmbedded, got, result.Content)
+			}
+		})
+	}
+}
+
+func TestScannerAdjacentAttributeBlocks(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "1.png", 100, 100)
+	writeTestPNG(t, tempDir, "2.png", 100, 100)
+
+	testCases := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			"Two images on one line",
+			"![a](1.png){: width=50} ![b](2.png){: width=60}",
+			[]string{`alt="a" width="50" height="50"`, `alt="b" width="60" height="60"`},
+		},
+		{
+			"No space between images",
+			"![a](1.png){width=50}![b](2.png){width=60}",
+			[]string{`alt="a" width="50" height="50"`, `alt="b" width="60" height="60"`},
+		},
+		{
+			"Only the second image has a block",
+			"![a](1.png) ![b](2.png){: width=30}",
+			[]string{`alt="a" width="100" height="100"`, `alt="b" width="30" height="30"`},
+		},
+		{
+			"Height before width",
+			"![a](1.png){: height=50 width=60} ![b](2.png){: height=20}",
+			[]string{`alt="a" width="60" height="50"`, `alt="b" width="20" height="20"`},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := markdown.NewProcessor(markdown.Options{AttrStyle: markdown.AttrStyleHTML})
+			result, err := p.Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			if strings.Contains(result.Content, "{") {
+				t.Errorf("Attribute block left in output: %q", result.Content)
+			}
+			last := 0
+			for _, want := range tc.want {
+				i := strings.Index(result.Content[last:], want)
+				if i < 0 {
+					t.Fatalf("Expected %q after offset %d in %q", want, last, result.Content)
+				}
+				last += i + len(want)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"testing"
+)
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		input    string
+		expected int64
+		wantErr  bool
+	}{
+		{input: "1024", expected: 1024},
+		{input: "500K", expected: 500 * 1024},
+		{input: "500kb", expected: 500 * 1024},
+		{input: "2MB", expected: 2 << 20},
+		{input: "1.5 GiB", expected: 3 << 29},
+		{input: "", wantErr: true},
+		{input: "lots", wantErr: true},
+		{input: "-5K", wantErr: true},
+	}
+
+	for _, tc := range testCases {
+		got, err := markdown.ParseSize(tc.input)
+		if tc.wantErr {
+			if err == nil {
+				t.Errorf("ParseSize(%q): expected an error, got %d", tc.input, got)
+			}
+			continue
+		}
+		if err != nil || got != tc.expected {
+			t.Errorf("ParseSize(%q) = %d, %v; want %d", tc.input, got, err, tc.expected)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := map[int64]string{
+		512:               "512 B",
+		2048:              "2.0 KB",
+		1887437:           "1.8 MB",
+		3 * (1 << 30) / 2: "1.5 GB",
+	}
+	for input, expected := range testCases {
+		if got := markdown.FormatSize(input); got != expected {
+			t.Errorf("FormatSize(%d) = %q; want %q", input, got, expected)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package markdown
+
+import (
+	"bytes"
+	"fmt"
+
+	// Register decoders for formats we re-encode as PNG.
+	_ "golang.org/x/image/bmp"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+)
+
+// sniffImageType determines the MIME type of image data from its magic
+// bytes, so files without (or with misleading) extensions are labelled
+// correctly. Formats added with RegisterFormat are checked first. It returns
+// "" if the data is not a recognized image.
+func sniffImageType(content []byte) string {
+	if mimeType := sniffRegistered(content); mimeType != "" {
+		return mimeType
+	}
+	switch {
+	case bytes.HasPrefix(content, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(content, []byte("\xff\xd8\xff")):
+		return "image/jpeg"
+	case bytes.HasPrefix(content, []byte("GIF87a")), bytes.HasPrefix(content, []byte("GIF89a")):
+		return "image/gif"
+	case len(content) >= 12 && bytes.Equal(content[:4], []byte("RIFF")) && bytes.Equal(content[8:12], []byte("WEBP")):
+		return "image/webp"
+	case bytes.HasPrefix(content, []byte("BM")) && len(content) >= 26:
+		return "image/bmp"
+	case bytes.HasPrefix(content, []byte("II*\x00")), bytes.HasPrefix(content, []byte("MM\x00*")):
+		return "image/tiff"
+	case bytes.HasPrefix(content, []byte("\x00\x00\x01\x00")):
+		return "image/x-icon"
+	case isSVG(content):
+		return "image/svg+xml"
+	}
+	return sniffUnsupported(content)
+}
+
+// sniffUnsupported recognizes formats that have no built-in codec, so they
+// can be reported with a hint instead of as unrecognized data.
+func sniffUnsupported(content []byte) string {
+	if len(content) >= 12 && bytes.Equal(content[4:8], []byte("ftyp")) {
+		switch string(content[8:12]) {
+		case "heic", "heix", "hevc", "heim", "heis", "mif1", "msf1":
+			return "image/heic"
+		case "avif", "avis":
+			return "image/avif"
+		case "isom", "iso2", "iso4", "iso5", "iso6", "mp41", "mp42", "avc1", "dash", "M4V ":
+			return "video/mp4"
+		}
+	}
+	switch {
+	case bytes.HasPrefix(content, []byte("\xff\x0a")), bytes.HasPrefix(content, []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return "image/jxl"
+	case bytes.HasPrefix(content, []byte("\x00\x00\x00\x0cjP  \r\n\x87\n")):
+		return "image/jp2"
+	case bytes.HasPrefix(content, []byte("8BPS")):
+		return "image/vnd.adobe.photoshop"
+	case bytes.HasPrefix(content, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(content, []byte("\x1a\x45\xdf\xa3")):
+		return "video/webm"
+	}
+	return ""
+}
+
+// codecNames names the formats sniffUnsupported recognizes.
+var codecNames = map[string]string{
+	"image/heic":                "HEIC",
+	"image/avif":                "AVIF",
+	"image/jxl":                 "JPEG XL",
+	"image/jp2":                 "JPEG 2000",
+	"image/vnd.adobe.photoshop": "Photoshop",
+	"application/pdf":           "PDF",
+	"video/mp4":                 "MP4 video",
+	"video/webm":                "WebM video",
+}
+
+// missingCodec returns an error explaining how to add support for mimeType,
+// or nil if the format can be decoded.
+func missingCodec(mimeType string) error {
+	name, ok := codecNames[mimeType]
+	if !ok {
+		return nil
+	}
+	if _, registered := lookupFormat(mimeType); registered {
+		return nil
+	}
+	return fmt.Errorf("%s images are not supported by this build: import a package that registers a %s decoder with markdown.RegisterFormat, or convert the image to PNG or JPEG", name, mimeType)
+}
+
+// isSVG reports whether content is an SVG document: text (no NUL bytes in
+// its first kilobyte, unlike every binary image format) containing an <svg
+// element.
+func isSVG(content []byte) bool {
+	head := content[:min(len(content), 1024)]
+	if bytes.IndexByte(head, 0) >= 0 {
+		return false
+	}
+	return bytes.Contains(bytes.ToLower(content), []byte("<svg"))
+}
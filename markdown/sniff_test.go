@@ -0,0 +1,167 @@
+package markdown_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
+)
+
+func TestExtensionlessImages(t *testing.T) {
+	tempDir := t.TempDir()
+	img := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	encode := func(name string, enc func(*bytes.Buffer) error) {
+		var buf bytes.Buffer
+		if err := enc(&buf); err != nil {
+			t.Fatalf("Failed to encode %s: %v", name, err)
+		}
+		if err := os.WriteFile(filepath.Join(tempDir, name), buf.Bytes(), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+	encode("png-hero", func(b *bytes.Buffer) error { return png.Encode(b, img) })
+	encode("jpeg-hero", func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) })
+	encode("gif-hero", func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) })
+	encode("bmp-hero", func(b *bytes.Buffer) error { return bmp.Encode(b, img) })
+	encode("tiff-hero", func(b *bytes.Buffer) error { return tiff.Encode(b, img, nil) })
+	encode("misnamed.jpg", func(b *bytes.Buffer) error { return png.Encode(b, img) })
+	encode("icon", func(b *bytes.Buffer) error {
+		_, err := b.Write([]byte("\x00\x00\x01\x00\x01\x00\x10\x10\x00\x00\x01\x00\x20\x00"))
+		return err
+	})
+	encode("svg-hero", func(b *bytes.Buffer) error {
+		_, err := b.WriteString(`<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg" width="3" height="3"/>`)
+		return err
+	})
+	encode("notes", func(b *bytes.Buffer) error {
+		_, err := b.WriteString("just some text")
+		return err
+	})
+
+	testCases := []struct {
+		file     string
+		wantMIME string
+	}{
+		{"png-hero", "image/png"},
+		{"jpeg-hero", "image/jpeg"},
+		{"gif-hero", "image/gif"},
+		{"bmp-hero", "image/png"},
+		{"tiff-hero", "image/png"},
+		{"misnamed.jpg", "image/png"},
+		{"icon", "image/x-icon"},
+		{"svg-hero", "image/svg+xml"},
+		{"notes", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.file, func(t *testing.T) {
+			result, err := markdown.NewProcessor(markdown.Options{}).Process("![x]("+tc.file+")", tempDir)
+			if err != nil {
+				t.Fatalf("Process failed: %v", err)
+			}
+			img := result.Images[0]
+			if tc.wantMIME == "" {
+				if markdown.CodeOf(img.Err) != markdown.CodeUnsupportedFormat {
+					t.Errorf("Expected %s, got %v", markdown.CodeUnsupportedFormat, img.Err)
+				}
+				return
+			}
+			if img.MIMEType != tc.wantMIME {
+				t.Errorf("Expected MIME type %s, got %q (error: %v)", tc.wantMIME, img.MIMEType, img.Err)
+			}
+			if !strings.Contains(result.Content, "data:"+tc.wantMIME+";base64,") {
+				t.Errorf("Expected a %s data URI, got %q", tc.wantMIME, result.Content)
+			}
+		})
+	}
+}
+
+func TestMissingCodecs(t *testing.T) {
+	tempDir := t.TempDir()
+	files := map[string]string{
+		"photo.heic":  "\x00\x00\x00\x18ftypheic\x00\x00\x00\x00mif1heic",
+		"photo.avif":  "\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1",
+		"photo.jxl":   "\xff\x0a\x00\x00",
+		"design.psd":  "8BPS\x00\x01",
+		"panics.xpan": "XPAN",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestPNG(t, tempDir, "ok.png", 2, 2)
+	markdown.RegisterFormat("image/x-panics", "XPAN", func(r io.Reader) (image.Image, error) {
+		panic("corrupt header")
+	}, nil)
+
+	input := "![h](photo.heic) ![a](photo.avif) ![j](photo.jxl) ![p](design.psd) ![x](panics.xpan) ![ok](ok.png)"
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	want := []string{"HEIC images are not supported", "AVIF images are not supported", "JPEG XL images are not supported", "Photoshop images are not supported", "decoder failed: corrupt header"}
+	for i, w := range want {
+		img := result.Images[i]
+		if markdown.CodeOf(img.Err) != markdown.CodeUnsupportedFormat || !strings.Contains(img.Err.Error(), w) {
+			t.Errorf("%s: expected an unsupported-format error containing %q, got %v", img.Reference.ImagePath, w, img.Err)
+		}
+	}
+	if !result.Images[5].Embedded {
+		t.Errorf("Expected the rest of the document to be processed, got %v", result.Images[5].Err)
+	}
+}
+
+// corruptImage panics when its pixels are read, as lazily decoding images
+// may on truncated data.
+type corruptImage struct{ image.Rectangle }
+
+func (corruptImage) ColorModel() color.Model   { return color.RGBAModel }
+func (c corruptImage) Bounds() image.Rectangle { return c.Rectangle }
+func (corruptImage) At(x, y int) color.Color   { panic("truncated pixel data") }
+
+func TestPanicContainment(t *testing.T) {
+	tempDir := t.TempDir()
+	for name, content := range map[string]string{"lazy.xlzy": "XLZY", "empty.xnil": "XNIL"} {
+		if err := os.WriteFile(filepath.Join(tempDir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestPNG(t, tempDir, "ok.png", 2, 2)
+	markdown.RegisterFormat("image/x-lazy", "XLZY", func(r io.Reader) (image.Image, error) {
+		return corruptImage{image.Rect(0, 0, 8, 8)}, nil
+	}, nil)
+	markdown.RegisterFormat("image/x-nil", "XNIL", func(r io.Reader) (image.Image, error) {
+		return nil, nil
+	}, nil)
+
+	input := "![lazy](lazy.xlzy) ![nil](empty.xnil) ![ok](ok.png)"
+	result, err := markdown.NewProcessor(markdown.Options{}).Process(input, tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	for _, img := range result.Images[:2] {
+		var decodeErr *markdown.DecodeError
+		if !errors.As(img.Err, &decodeErr) || markdown.CodeOf(img.Err) != markdown.CodeUnsupportedFormat {
+			t.Errorf("%s: expected a DecodeError, got %v", img.Reference.ImagePath, img.Err)
+		}
+	}
+	if !strings.Contains(result.Images[0].Err.Error(), "truncated pixel data") {
+		t.Errorf("Expected the panic value in the error, got %v", result.Images[0].Err)
+	}
+	if !result.Images[2].Embedded {
+		t.Errorf("Expected the rest of the document to be processed, got %v", result.Images[2].Err)
+	}
+}
@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// sourceCommentRegex matches the comment Options.SourceComments writes
+// before an embedded image.
+var sourceCommentRegex = regexp.MustCompile(`<!-- source: (\S+)(?: sha256:([0-9a-f]{64}))? -->`)
+
+// sourceEscaper keeps a path from ending the comment it is written in or
+// being split at whitespace; url.PathUnescape reverses it.
+var sourceEscaper = strings.NewReplacer("%", "%25", " ", "%20", "\t", "%09", "\r", "%0D", "\n", "%0A", "--", "-%2D")
+
+// sourceComment records that an image was embedded from source, whose
+// content has the SHA-256 sum, if known.
+func sourceComment(source, sum string) string {
+	if sum == "" {
+		return fmt.Sprintf("<!-- source: %s -->", sourceEscaper.Replace(source))
+	}
+	return fmt.Sprintf("<!-- source: %s sha256:%s -->", sourceEscaper.Replace(source), sum)
+}
+
+// lastSourceComment returns the source recorded by the last source comment
+// in s, and where the comment is.
+func lastSourceComment(s string) (source string, loc []int, ok bool) {
+	all := sourceCommentRegex.FindAllStringSubmatchIndex(s, -1)
+	if len(all) == 0 {
+		return "", nil, false
+	}
+	m := all[len(all)-1]
+	source, err := url.PathUnescape(s[m[2]:m[3]])
+	if err != nil {
+		return "", nil, false
+	}
+	return source, m[:2], true
+}
+
+// ReplaceSourceHash updates the source comments (see
+// Options.SourceComments) recording oldSHA256, such as after the image
+// was embedded again from a changed source, to record newSHA256.
+func ReplaceSourceHash(content, oldSHA256, newSHA256 string) string {
+	return sourceCommentRegex.ReplaceAllStringFunc(content, func(match string) string {
+		return strings.Replace(match, " sha256:"+oldSHA256+" ", " sha256:"+newSHA256+" ", 1)
+	})
+}
@@ -0,0 +1,120 @@
+package markdown
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Section is one part of a document split by SplitByHeading.
+type Section struct {
+	// Title is the text of the heading that starts the section, "" for a
+	// document without matching headings.
+	Title   string
+	Content string
+}
+
+// SplitByHeading splits content before every ATX (# Title) or setext
+// (Title / ===) heading of the given level or higher, ignoring headings in
+// fenced code blocks. Text before the first such heading stays with the
+// first section. Concatenating the sections gives back content.
+func SplitByHeading(content string, level int) []Section {
+	codeBlocks := codeBlockRanges(content)
+	var starts []int
+	var titles []string
+	prevStart, prevBlank := -1, true
+	for pos := 0; pos < len(content); {
+		end := strings.IndexByte(content[pos:], '\n')
+		if end < 0 {
+			end = len(content)
+		} else {
+			end += pos + 1
+		}
+		line := strings.TrimRight(content[pos:end], "\r\n")
+		if !inRanges(codeBlocks, pos) {
+			if l, title := atxHeading(line); l > 0 && l <= level {
+				starts, titles = append(starts, pos), append(titles, title)
+			} else if l := setextLevel(line); l > 0 && l <= level && !prevBlank && prevStart >= 0 {
+				prev := strings.TrimRight(content[prevStart:pos], "\r\n")
+				if l, _ := atxHeading(prev); l == 0 {
+					starts, titles = append(starts, prevStart), append(titles, strings.TrimSpace(prev))
+				}
+			}
+		}
+		prevStart, prevBlank = pos, strings.TrimSpace(line) == ""
+		pos = end
+	}
+
+	if len(starts) == 0 {
+		return []Section{{Content: content}}
+	}
+	sections := make([]Section, len(starts))
+	for i, start := range starts {
+		end := len(content)
+		if i+1 < len(starts) {
+			end = starts[i+1]
+		}
+		if i == 0 {
+			start = 0
+		}
+		sections[i] = Section{Title: titles[i], Content: content[start:end]}
+	}
+	return sections
+}
+
+// atxHeading returns the level and text of an ATX heading line, or 0.
+func atxHeading(line string) (int, string) {
+	trimmed := strings.TrimLeft(line, " ")
+	if len(line)-len(trimmed) > 3 {
+		return 0, ""
+	}
+	n := 0
+	for n < len(trimmed) && trimmed[n] == '#' {
+		n++
+	}
+	if n == 0 || n > 6 || (n < len(trimmed) && trimmed[n] != ' ' && trimmed[n] != '\t') {
+		return 0, ""
+	}
+	title := strings.TrimSpace(trimmed[n:])
+	// Drop an optional closing sequence of #s.
+	if stripped := strings.TrimRight(title, "#"); stripped == "" || strings.HasSuffix(stripped, " ") {
+		title = strings.TrimSpace(stripped)
+	}
+	return n, title
+}
+
+// setextLevel returns 1 for a === underline, 2 for a --- underline, or 0.
+func setextLevel(line string) int {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || len(line)-len(strings.TrimLeft(line, " ")) > 3 {
+		return 0
+	}
+	switch strings.Trim(trimmed, string(trimmed[0])) {
+	case "":
+		if trimmed[0] == '=' {
+			return 1
+		}
+		if trimmed[0] == '-' {
+			return 2
+		}
+	}
+	return 0
+}
+
+// Slug turns a heading into a file-name-friendly form: lower case letters
+// and digits separated by single dashes.
+func Slug(title string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(title) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,50 @@
+package markdown_test
+
+import (
+	"markdown-images/markdown"
+	"strings"
+	"testing"
+)
+
+func TestSplitByHeading(t *testing.T) {
+	content := "Intro text\n\n# One\nbody ![a](a.png)\n## One.a\nmore\n" +
+		"```\n# not a heading\n```\n" +
+		"Two #\n===\ntext\n#Not a heading\n# Three ##\r\nend"
+
+	sections := markdown.SplitByHeading(content, 1)
+	var titles []string
+	var joined strings.Builder
+	for _, s := range sections {
+		titles = append(titles, s.Title)
+		joined.WriteString(s.Content)
+	}
+	if strings.Join(titles, "|") != "One|Two #|Three" {
+		t.Errorf("Unexpected titles %q", titles)
+	}
+	if joined.String() != content {
+		t.Errorf("Sections don't add up to the document")
+	}
+	if !strings.HasPrefix(sections[0].Content, "Intro text\n\n# One\n") || !strings.Contains(sections[0].Content, "## One.a") {
+		t.Errorf("Unexpected first section %q", sections[0].Content)
+	}
+
+	if got := len(markdown.SplitByHeading(content, 2)); got != 4 {
+		t.Errorf("Expected 4 sections at level 2, got %d", got)
+	}
+	if got := markdown.SplitByHeading("no headings", 1); len(got) != 1 || got[0].Title != "" {
+		t.Errorf("Expected a single untitled section, got %+v", got)
+	}
+}
+
+func TestSlug(t *testing.T) {
+	for title, want := range map[string]string{
+		"Getting Started":        "getting-started",
+		"  API: v2 (beta)!  ":    "api-v2-beta",
+		"Übersicht & Einführung": "übersicht-einführung",
+		"":                       "",
+	} {
+		if got := markdown.Slug(title); got != want {
+			t.Errorf("Slug(%q) = %q; want %q", title, got, want)
+		}
+	}
+}
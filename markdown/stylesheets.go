@@ -0,0 +1,228 @@
+package markdown
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// maxImportDepth bounds nested @import rules, which may also be circular.
+const maxImportDepth = 8
+
+var (
+	cssURLRegex    = regexp.MustCompile(`url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImportRegex = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"')\s;]+)["']?\s*\)?\s*([^;]*);`)
+	styleTagRegex  = regexp.MustCompile(`(?is)(<style\b[^>]*>)(.*?)(</style>)`)
+)
+
+// fontTypes maps font file extensions to their MIME types.
+var fontTypes = map[string]string{
+	".woff2": "font/woff2",
+	".woff":  "font/woff",
+	".ttf":   "font/ttf",
+	".otf":   "font/otf",
+	".eot":   "application/vnd.ms-fontobject",
+}
+
+// InlineStylesheets makes an HTML page, such as one from RenderHTML,
+// self-contained: every <link rel="stylesheet"> is replaced by a <style>
+// element holding the stylesheet, with its @import rules inlined, and the
+// images and fonts referenced with url(...) there or in the page's own
+// <style> elements are embedded as data URIs (fonts as selected by
+// Options.Fonts). Relative paths are resolved
+// against baseDir or the referencing stylesheet, as browsers do.
+//
+// Stylesheets and assets that can't be read are left as references; the
+// failures are returned, tagged with error codes.
+func (p *Processor) InlineStylesheets(page, baseDir string) (string, []error) {
+	var errs []error
+	page = styleTagRegex.ReplaceAllStringFunc(page, func(block string) string {
+		m := styleTagRegex.FindStringSubmatch(block)
+		return m[1] + p.embedCSSURLs(m[2], baseDir, &errs) + m[3]
+	})
+
+	var b strings.Builder
+	s := &scanner{content: page, closeFrom: -1}
+	last := 0
+	for i := strings.IndexByte(page, '<'); i >= 0; {
+		next := i + 1
+		if tag, ok := s.htmlTag(i); ok && tag.name == "link" && isStylesheetLink(&tag) {
+			next = tag.end
+			href, _ := tag.attr("href")
+			if css, err := p.loadStylesheet(html.UnescapeString(href.value), baseDir, 0, &errs); err != nil {
+				errs = append(errs, err)
+			} else {
+				b.WriteString(page[last:tag.start])
+				b.WriteString(styleElement(&tag, css))
+				last = tag.end
+			}
+		}
+		n := strings.IndexByte(page[next:], '<')
+		if n < 0 {
+			break
+		}
+		i = next + n
+	}
+	b.WriteString(page[last:])
+	return b.String(), errs
+}
+
+// isStylesheetLink reports whether a <link> tag loads a stylesheet.
+func isStylesheetLink(tag *htmlTag) bool {
+	rel, ok := tag.attr("rel")
+	if !ok {
+		return false
+	}
+	href, ok := tag.attr("href")
+	if !ok || href.value == "" || strings.HasPrefix(href.value, "data:") {
+		return false
+	}
+	for _, r := range strings.Fields(strings.ToLower(rel.value)) {
+		if r == "stylesheet" {
+			return true
+		}
+	}
+	return false
+}
+
+// styleElement returns a <style> element with css, keeping a media query.
+func styleElement(tag *htmlTag, css string) string {
+	open := "<style>"
+	if media, ok := tag.attr("media"); ok && media.value != "" {
+		open = fmt.Sprintf(`<style media="%s">`, media.value)
+	}
+	// A literal end tag in the stylesheet would close the element early.
+	css = strings.ReplaceAll(css, "</style", `<\/style`)
+	return open + "\n" + strings.TrimRight(css, "\n") + "\n</style>"
+}
+
+// loadStylesheet reads the stylesheet at ref, relative to baseDir, with its
+// imports inlined and its url(...) assets embedded.
+func (p *Processor) loadStylesheet(ref, baseDir string, depth int, errs *[]error) (string, error) {
+	data, err := p.readAsset(ref, baseDir)
+	if err != nil {
+		return "", err
+	}
+	dir := assetDir(ref, baseDir)
+	// Imported stylesheets embed their own assets, relative to themselves.
+	css := p.embedCSSURLs(string(data), dir, errs)
+	return cssImportRegex.ReplaceAllStringFunc(css, func(rule string) string {
+		m := cssImportRegex.FindStringSubmatch(rule)
+		if depth+1 >= maxImportDepth {
+			*errs = append(*errs, newError(CodeFileUnreadable, m[1], errors.New("@import nested too deeply")))
+			return rule
+		}
+		imported, err := p.loadStylesheet(m[1], dir, depth+1, errs)
+		if err != nil {
+			*errs = append(*errs, err)
+			return rule
+		}
+		imported = strings.TrimRight(imported, "\n")
+		if media := strings.TrimSpace(m[2]); media != "" {
+			return fmt.Sprintf("@media %s {\n%s\n}", media, imported)
+		}
+		return imported
+	}), nil
+}
+
+// embedCSSURLs replaces the url(...) references in css, relative to dir,
+// with data URIs. The URLs of @import rules are left for loadStylesheet.
+func (p *Processor) embedCSSURLs(css, dir string, errs *[]error) string {
+	if p.opts.Fonts == "" || p.opts.Fonts == FontsWOFF2 {
+		css = pruneFontSources(css)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range cssURLRegex.FindAllStringSubmatchIndex(css, -1) {
+		ref := css[max(m[2], m[4], m[6]):max(m[3], m[5], m[7])]
+		if ref == "" || strings.HasPrefix(ref, "data:") || strings.HasPrefix(ref, "#") ||
+			strings.HasSuffix(strings.TrimRight(css[:m[0]], " \t\r\n"), "@import") ||
+			(p.opts.Fonts == FontsNone && isFont(ref)) {
+			continue
+		}
+		data, err := p.readAsset(ref, dir)
+		if err != nil {
+			*errs = append(*errs, err)
+			continue
+		}
+		mimeType := assetType(ref, data)
+		if mimeType == "" {
+			*errs = append(*errs, newError(CodeUnsupportedFormat, ref, errors.New("not an image or font")))
+			continue
+		}
+		fragment := ""
+		if i := strings.IndexByte(ref, '#'); i >= 0 && mimeType == "image/svg+xml" {
+			fragment = ref[i:] // SVG sprites address their symbols by fragment.
+		}
+		b.WriteString(css[last:m[0]])
+		fmt.Fprintf(&b, `url("data:%s;base64,%s%s")`, mimeType, base64.StdEncoding.EncodeToString(data), fragment)
+		last = m[1]
+	}
+	b.WriteString(css[last:])
+	return b.String()
+}
+
+// readAsset reads a local or remote stylesheet asset. Query strings and
+// fragments, common on font URLs, are ignored for local files.
+func (p *Processor) readAsset(ref, dir string) ([]byte, error) {
+	if isURL(ref) {
+		return p.downloadImageContent(context.Background(), ref)
+	}
+	if isURL(dir) {
+		base, err := url.Parse(dir + "/")
+		if err != nil {
+			return nil, newError(CodeDownloadFailed, ref, err)
+		}
+		rel, err := url.Parse(ref)
+		if err != nil {
+			return nil, newError(CodeDownloadFailed, ref, err)
+		}
+		return p.downloadImageContent(context.Background(), base.ResolveReference(rel).String())
+	}
+	file := stripQuery(ref)
+	data, err := os.ReadFile(resolveLocalPath(dir, file))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, newError(CodeFileNotFound, ref, err)
+	} else if err != nil {
+		return nil, newError(CodeFileUnreadable, ref, err)
+	}
+	return data, nil
+}
+
+// assetDir is the directory relative references inside the stylesheet at
+// ref resolve against: a local directory or a URL without a trailing slash.
+func assetDir(ref, baseDir string) string {
+	switch {
+	case isURL(ref):
+		u, _ := url.Parse(ref)
+		u.Path, u.RawQuery, u.Fragment = path.Dir(u.Path), "", ""
+		return strings.TrimSuffix(u.String(), "/")
+	case isURL(baseDir):
+		return assetDir(strings.TrimSuffix(baseDir, "/")+"/"+ref, "")
+	}
+	return filepath.Join(baseDir, filepath.Dir(filepath.FromSlash(stripQuery(ref))))
+}
+
+// assetType returns the MIME type of an image or font asset, or "".
+func assetType(ref string, data []byte) string {
+	if mimeType := sniffImageType(data); strings.HasPrefix(mimeType, "image/") {
+		return mimeType
+	}
+	return fontTypes[strings.ToLower(path.Ext(stripQuery(ref)))]
+}
+
+// stripQuery removes a query string or fragment from a path.
+func stripQuery(ref string) string {
+	if i := strings.IndexAny(ref, "?#"); i >= 0 {
+		return ref[:i]
+	}
+	return ref
+}
@@ -0,0 +1,57 @@
+package markdown_test
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInlineStylesheets(t *testing.T) {
+	tempDir := t.TempDir()
+	var bg bytes.Buffer
+	if err := png.Encode(&bg, image.NewGray(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"css/site.css":      "@import \"print.css\" print;\nbody { background: url(../img/bg.png); }\n@font-face { src: url('fonts/a.woff2?v=3') format('woff2'); }\n.x { mask: url(#m); }\n",
+		"css/print.css":     "h1 { background: url(missing.png); }\n",
+		"css/fonts/a.woff2": "wOF2",
+		"img/bg.png":        bg.String(),
+	}
+	for name, content := range files {
+		file := filepath.Join(tempDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := "<head>\n<link rel=\"stylesheet\" href=\"css/site.css\" media=\"screen\">\n<link rel=\"icon\" href=\"favicon.ico\">\n" +
+		"<style>p { background: url(\"img/bg.png\") }</style>\n</head>"
+
+	inlined, errs := markdown.NewProcessor(markdown.Options{}).InlineStylesheets(page, tempDir)
+	if len(errs) != 1 || markdown.CodeOf(errs[0]) != markdown.CodeFileNotFound {
+		t.Errorf("Expected only the missing image to fail, got %v", errs)
+	}
+	for _, want := range []string{
+		"<style media=\"screen\">\n@media print {\nh1 { background: url(missing.png); }\n}\n",
+		`body { background: url("data:image/png;base64,`,
+		`src: url("data:font/woff2;base64,d09GMg==") format('woff2')`,
+		`.x { mask: url(#m); }`,
+		`<link rel="icon" href="favicon.ico">`,
+		`<style>p { background: url("data:image/png;base64,`,
+	} {
+		if !strings.Contains(inlined, want) {
+			t.Errorf("Expected %q in\n%s", want, inlined)
+		}
+	}
+	if strings.Contains(inlined, "site.css") {
+		t.Errorf("Expected the stylesheet link to be replaced:\n%s", inlined)
+	}
+}
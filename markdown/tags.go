@@ -0,0 +1,165 @@
+package markdown
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TagProfile is how images tagged with its name in their alt text are
+// encoded: ![diagram|lossless](x.png) is embedded with the lossless profile
+// and the alt text "diagram".
+type TagProfile struct {
+	// Format forces the encoded format, "png" or "jpeg", whatever the
+	// source's; empty keeps the usual choice. JPEG has no transparency, so
+	// transparent areas become white.
+	Format string
+	// Quality overrides the JPEG quality, unless a directive sets it.
+	Quality int
+	// Pipeline runs after the built-in resizing and Options.Pipelines.
+	Pipeline Pipeline
+	// Spec is the text the profile was parsed from, if any.
+	Spec string
+}
+
+// builtinTagProfiles are available without being defined: lossless for
+// diagrams and screenshots whose edges JPEG would blur, photo for pictures
+// that are much smaller as JPEG, and icon for small images with few colors.
+var builtinTagProfiles = map[string]TagProfile{
+	"lossless": {Format: "png", Spec: "format=png"},
+	"photo":    {Format: "jpeg", Spec: "format=jpeg"},
+	"icon": {Format: "png", Spec: "format=png | quantize=256", Pipeline: Pipeline{
+		Transforms: []Transform{must(quantizeTransform("256"))},
+		Spec:       "quantize=256",
+	}},
+}
+
+func must(t Transform, err error) Transform {
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// ParseTagProfile parses "name: step | step ...", where a step is
+// format=png, format=jpeg, quality=N or a transform as in a pipeline, e.g.
+// "sketch: format=png | grayscale | quantize=16".
+func ParseTagProfile(spec string) (string, TagProfile, error) {
+	name, steps, ok := strings.Cut(spec, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, "| \t") {
+		return "", TagProfile{}, fmt.Errorf("tag profile %q must look like name: step | step", spec)
+	}
+	profile := TagProfile{Spec: strings.TrimSpace(steps)}
+	var transforms []string
+	for _, step := range strings.Split(steps, "|") {
+		key, value, _ := strings.Cut(strings.TrimSpace(step), "=")
+		switch key {
+		case "format":
+			if value != "png" && value != "jpeg" {
+				return "", TagProfile{}, fmt.Errorf("tag profile %s: format must be png or jpeg, got %q", name, value)
+			}
+			profile.Format = value
+		case "quality":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > 100 {
+				return "", TagProfile{}, fmt.Errorf("tag profile %s: quality must be between 1 and 100, got %q", name, value)
+			}
+			profile.Quality = n
+		default:
+			transforms = append(transforms, strings.TrimSpace(step))
+		}
+	}
+	if len(transforms) > 0 {
+		// The steps have no pattern; a leading colon keeps a colon in a
+		// transform argument from being read as one.
+		pipeline, err := ParsePipeline(":" + strings.Join(transforms, " | "))
+		if err != nil {
+			return "", TagProfile{}, fmt.Errorf("tag profile %s: %w", name, err)
+		}
+		profile.Pipeline = pipeline
+	}
+	return name, profile, nil
+}
+
+// tagProfile returns the profile named by tag, from Options.TagProfiles or the
+// built-in ones.
+func (p *Processor) tagProfile(tag string) (TagProfile, bool) {
+	if profile, ok := p.opts.TagProfiles[tag]; ok {
+		return profile, true
+	}
+	profile, ok := builtinTagProfiles[tag]
+	return profile, ok
+}
+
+// splitTags removes the trailing |tag suffixes of an alt text that name a
+// profile, returning the rest of the alt text and the tags in the order
+// they are written. Other text after a | is part of the alt text.
+func (p *Processor) splitTags(alt string) (string, []string) {
+	var tags []string
+	for {
+		i := strings.LastIndexByte(alt, '|')
+		if i < 0 {
+			break
+		}
+		tag := strings.TrimSpace(alt[i+1:])
+		if _, ok := p.tagProfile(tag); !ok {
+			break
+		}
+		tags = append(tags, tag)
+		alt = strings.TrimRight(alt[:i], " ")
+	}
+	slices.Reverse(tags)
+	return alt, tags
+}
+
+// profiles returns the profiles of the tags of a directive, in order.
+func (p *Processor) profiles(d Directive) []TagProfile {
+	var profiles []TagProfile
+	for _, tag := range d.Tags {
+		if profile, ok := p.tagProfile(tag); ok {
+			profiles = append(profiles, profile)
+		}
+	}
+	return profiles
+}
+
+// profileKey describes the profiles of d for the disk cache.
+func (p *Processor) profileKey(d Directive) string {
+	var specs []string
+	for _, profile := range p.profiles(d) {
+		specs = append(specs, profile.Spec)
+	}
+	return strings.Join(specs, "\x00")
+}
+
+// applyProfiles runs the pipelines of the tags of d on img, and returns the
+// format they force, if any; the last tag wins.
+func (p *Processor) applyProfiles(d Directive, img image.Image) (image.Image, string, error) {
+	format := ""
+	for _, profile := range p.profiles(d) {
+		for _, t := range profile.Pipeline.Transforms {
+			var err error
+			if img, err = t.Apply(img); err != nil {
+				return nil, "", err
+			}
+		}
+		if profile.Format != "" {
+			format = profile.Format
+		}
+	}
+	return img, format, nil
+}
+
+// flatten draws img over white, for formats without transparency.
+func flatten(img image.Image) image.Image {
+	b := img.Bounds()
+	out := image.NewRGBA(b)
+	draw.Draw(out, b, image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(out, b, img, b.Min, draw.Over)
+	return out
+}
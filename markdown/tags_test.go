@@ -0,0 +1,86 @@
+package markdown_test
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestAltTextTags(t *testing.T) {
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "a.png", 8, 8)
+	var photo bytes.Buffer
+	img := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i * 7)
+	}
+	if err := jpeg.Encode(&photo, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(tempDir, "b.jpg"), photo.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, sketch, err := markdown.ParseTagProfile("sketch: format=png | grayscale")
+	if err != nil {
+		t.Fatal(err)
+	}
+	processor := markdown.NewProcessor(markdown.Options{TagProfiles: map[string]markdown.TagProfile{"sketch": sketch}})
+	for _, tc := range []struct {
+		name, input, wantAlt, wantType string
+		wantTags                       []string
+	}{
+		{"photo", "![Team|photo](a.png)", "![Team](", "image/jpeg", []string{"photo"}},
+		{"lossless", "![Diagram | lossless](b.jpg)", "![Diagram](", "image/png", []string{"lossless"}},
+		{"chained", `<img src="a.png" alt="x|icon|photo">`, "![x](", "image/jpeg", []string{"icon", "photo"}},
+		{"custom", "![s|sketch](b.jpg)", "![s](", "image/png", []string{"sketch"}},
+		{"not a tag", "![a|b](a.png)", "![a|b](", "image/png", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			result, err := processor.Process(tc.input, tempDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := result.Images[0]
+			if !res.Embedded || res.MIMEType != tc.wantType {
+				t.Errorf("Expected an embedded %s, got %+v", tc.wantType, res)
+			}
+			if !strings.Contains(result.Content, tc.wantAlt) {
+				t.Errorf("Expected %q in %.80q", tc.wantAlt, result.Content)
+			}
+			if !slices.Equal(res.Reference.Directive.Tags, tc.wantTags) {
+				t.Errorf("Tags = %q; want %q", res.Reference.Directive.Tags, tc.wantTags)
+			}
+		})
+	}
+
+	// The custom profile's grayscale step ran.
+	result, err := processor.Process("![s|sketch](b.jpg)", tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := result.Content[strings.Index(result.Content, "data:") : len(result.Content)-1]
+	_, data, _ := markdown.DecodeDataURI(uri)
+	decoded, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ColorModel() != color.GrayModel {
+		t.Errorf("Expected a grayscale PNG, got %T", decoded)
+	}
+
+	for _, spec := range []string{"no colon", ": format=png", "x: format=gif", "x: quality=0", "x: bogus"} {
+		if _, _, err := markdown.ParseTagProfile(spec); err == nil {
+			t.Errorf("ParseTagProfile(%q) should fail", spec)
+		}
+	}
+}
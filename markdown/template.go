@@ -0,0 +1,62 @@
+package markdown
+
+import (
+	"strings"
+	"text/template"
+)
+
+// ImageData is what Options.ImageTemplate is executed with for each
+// embedded image. Text fields are unescaped; templates producing HTML
+// should pass them through the html function.
+type ImageData struct {
+	// Src is the data URI of the embedded image.
+	Src   string
+	Alt   string
+	Title string
+	// Width and Height are the dimensions written for the image, zero if
+	// unknown.
+	Width  int
+	Height int
+	// Path is the image as referenced in the source document.
+	Path     string
+	MIMEType string
+	// Size is the size in bytes of the embedded image before base64 encoding.
+	Size int
+	// Markup is what would be written without a template, in the configured
+	// attribute style, so templates can wrap it rather than rebuild it.
+	Markup string
+	// Newline is the line ending of the document.
+	Newline string
+	// Number and Caption are the figure number and caption, such as
+	// "Figure 3: Throughput", when Options.FigureLabel numbers figures.
+	Number  int
+	Caption string
+}
+
+// executeImageTemplate renders an embedded image with the ImageTemplate.
+func (p *Processor) executeImageTemplate(ref ImageReference, res ImageResult, dataURI, markup, newline string, fig figure) (string, error) {
+	data := ImageData{
+		Src:      dataURI,
+		Alt:      htmlAlt(ref),
+		Title:    ref.Title,
+		Width:    res.Width,
+		Height:   res.Height,
+		Path:     ref.ImagePath,
+		MIMEType: res.MIMEType,
+		Size:     res.EncodedSize,
+		Markup:   markup,
+		Newline:  newline,
+		Number:   fig.number,
+		Caption:  fig.caption,
+	}
+	var b strings.Builder
+	if err := p.opts.ImageTemplate.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// ParseImageTemplate parses the text of an Options.ImageTemplate.
+func ParseImageTemplate(text string) (*template.Template, error) {
+	return template.New("image").Option("missingkey=error").Parse(text)
+}
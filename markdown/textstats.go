@@ -0,0 +1,49 @@
+package markdown
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// TextStats counts the prose of a markdown document.
+type TextStats struct {
+	Words    int
+	Headings int
+}
+
+// CountText counts the words and headings of content, as rendered: code,
+// HTML, link destinations and the alt text of images are not words.
+func CountText(content string) TextStats {
+	source := []byte(content)
+	doc := goldmark.New(goldmark.WithExtensions(extension.GFM)).Parser().Parse(text.NewReader(source))
+	var stats TextStats
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if !entering {
+			return ast.WalkContinue, nil
+		}
+		switch n := n.(type) {
+		case *ast.Heading:
+			stats.Headings++
+		case *ast.Image, *ast.CodeSpan:
+			return ast.WalkSkipChildren, nil
+		case *ast.Text:
+			for _, field := range strings.Fields(string(n.Segment.Value(source))) {
+				// Punctuation split off by markup, as in "[docs](x).", is no word.
+				if strings.IndexFunc(field, isWordRune) >= 0 {
+					stats.Words++
+				}
+			}
+		}
+		return ast.WalkContinue, nil
+	})
+	return stats
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
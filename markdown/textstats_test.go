@@ -0,0 +1,21 @@
+package markdown_test
+
+import (
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestCountText(t *testing.T) {
+	content := "# Getting started\n\n" +
+		"Install the *tool* and run it on [your docs](docs/index.md).\n\n" +
+		"![A diagram of the pipeline](diagram.png)\n\n" +
+		"## Flags\n\n" +
+		"```bash\ngo run main.go --debug doc.md\n```\n\n" +
+		"Use `--debug` for details.\n\n" +
+		"<div align=\"center\">html</div>\n"
+	got := markdown.CountText(content)
+	if want := (markdown.TextStats{Words: 15, Headings: 2}); got != want {
+		t.Errorf("Got %+v, want %+v", got, want)
+	}
+}
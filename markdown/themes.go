@@ -0,0 +1,31 @@
+package markdown
+
+import (
+	"embed"
+	"sort"
+	"strings"
+)
+
+//go:embed themes/*.css
+var themeFiles embed.FS
+
+// Theme returns the stylesheet of a built-in theme for RenderHTML: github,
+// dark or print.
+func Theme(name string) (string, bool) {
+	css, err := themeFiles.ReadFile("themes/" + name + ".css")
+	if err != nil {
+		return "", false
+	}
+	return string(css), true
+}
+
+// ThemeNames lists the built-in themes in alphabetical order.
+func ThemeNames() []string {
+	entries, _ := themeFiles.ReadDir("themes")
+	var names []string
+	for _, e := range entries {
+		names = append(names, strings.TrimSuffix(e.Name(), ".css"))
+	}
+	sort.Strings(names)
+	return names
+}
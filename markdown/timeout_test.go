@@ -0,0 +1,87 @@
+package markdown_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// slowServer answers only once the client gives up, or after ten seconds.
+func slowServer() *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+		http.NotFound(w, r)
+	}))
+}
+
+func TestImageTimeout(t *testing.T) {
+	server := slowServer()
+	defer server.Close()
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "local.png", 4, 4)
+
+	processor := markdown.NewProcessor(markdown.Options{ImageTimeout: 100 * time.Millisecond})
+	start := time.Now()
+	result, err := processor.Process(fmt.Sprintf("![slow](%s/slow.png) ![local](local.png)", server.URL), tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the slow image to be abandoned, took %s", elapsed)
+	}
+	slow := result.Images[0]
+	if markdown.CodeOf(slow.Err) != markdown.CodeTimeout || !errors.Is(slow.Err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout wrapping context.DeadlineExceeded, got %v", slow.Err)
+	}
+	if !result.Images[1].Embedded {
+		t.Errorf("Expected the local image to be embedded, got %v", result.Images[1].Err)
+	}
+}
+
+func TestDocumentTimeout(t *testing.T) {
+	server := slowServer()
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	content := fmt.Sprintf("![one](%s/one.png) ![two](%s/two.png)", server.URL, server.URL)
+	_, err := markdown.NewProcessor(markdown.Options{}).ProcessContext(ctx, content, t.TempDir())
+	if markdown.CodeOf(err) != markdown.CodeTimeout || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected the document to time out, got %v", err)
+	}
+}
+
+func TestProcessInterrupted(t *testing.T) {
+	server := slowServer()
+	defer server.Close()
+	tempDir := t.TempDir()
+	writeTestPNG(t, tempDir, "local.png", 4, 4)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	tail := " ![later](local.png)\n"
+	content := fmt.Sprintf("![first](local.png) ![slow](%s/slow.png)", server.URL) + tail
+	result, err := markdown.NewProcessor(markdown.Options{}).ProcessContext(ctx, content, tempDir)
+	if markdown.CodeOf(err) != markdown.CodeInterrupted || !errors.Is(err, context.Canceled) {
+		t.Fatalf("Expected an interruption, got %v", err)
+	}
+	if result == nil || len(result.Images) != 2 || !result.Images[0].Embedded {
+		t.Fatalf("Expected a partial result with the first image embedded, got %+v", result)
+	}
+	if markdown.CodeOf(result.Images[1].Err) != markdown.CodeInterrupted {
+		t.Errorf("Expected the in-flight image to be interrupted, got %v", result.Images[1].Err)
+	}
+	if !strings.HasPrefix(result.Content, "![first](data:image/png;base64,") || !strings.HasSuffix(result.Content, server.URL+"/slow.png)"+tail) {
+		t.Errorf("Expected the unprocessed images to stay references, got %q", result.Content)
+	}
+}
@@ -0,0 +1,260 @@
+package markdown
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Transform is one step of an image pipeline. Transforms run on decoded
+// raster images after the built-in resizing, in the order they are listed.
+// A Transform may be applied to several images at once.
+type Transform interface {
+	Apply(img image.Image) (image.Image, error)
+}
+
+// TransformFunc adapts a function to the Transform interface.
+type TransformFunc func(img image.Image) (image.Image, error)
+
+// Apply calls f(img).
+func (f TransformFunc) Apply(img image.Image) (image.Image, error) {
+	return f(img)
+}
+
+// TransformFactory builds a transform from its argument, the text after "="
+// in the pipeline ("" if there is none).
+type TransformFactory func(arg string) (Transform, error)
+
+var (
+	transformsMu sync.RWMutex
+	transforms   = map[string]TransformFactory{
+		"strip-metadata": noArg(func(img image.Image) (image.Image, error) {
+			// Decoding already drops EXIF, ICC and text chunks, so this
+			// only documents the intent (and forces a re-encode).
+			return img, nil
+		}),
+		"max-width":  dimensionArg(func(img image.Image, n int) image.Image { return resizeImage(img, 0, 0, n, 0) }),
+		"max-height": dimensionArg(func(img image.Image, n int) image.Image { return resizeImage(img, 0, 0, 0, n) }),
+		"quantize":   quantizeTransform,
+		"grayscale": noArg(func(img image.Image) (image.Image, error) {
+			gray := image.NewGray(img.Bounds())
+			draw.Draw(gray, gray.Bounds(), img, img.Bounds().Min, draw.Src)
+			return gray, nil
+		}),
+	}
+)
+
+// RegisterTransform makes a transform available to pipelines under name.
+// It replaces any transform registered under the same name.
+func RegisterTransform(name string, factory TransformFactory) {
+	transformsMu.Lock()
+	defer transformsMu.Unlock()
+	transforms[name] = factory
+}
+
+func noArg(f TransformFunc) TransformFactory {
+	return func(arg string) (Transform, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("takes no argument")
+		}
+		return f, nil
+	}
+}
+
+func dimensionArg(f func(img image.Image, n int) image.Image) TransformFactory {
+	return func(arg string) (Transform, error) {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("needs a positive number of pixels, got %q", arg)
+		}
+		return TransformFunc(func(img image.Image) (image.Image, error) { return f(img, n), nil }), nil
+	}
+}
+
+// Pipeline is an ordered list of transforms applied to the images whose
+// path matches Pattern.
+type Pipeline struct {
+	// Pattern is a path.Match pattern. Patterns without a slash are matched
+	// against the file name only; an empty pattern matches every image.
+	Pattern    string
+	Transforms []Transform
+	// Spec is the text the pipeline was parsed from.
+	Spec string
+}
+
+// ParsePipeline parses "pattern: transform | transform=arg | ...". The
+// pattern and colon are optional, e.g. "*.png: strip-metadata | max-width=1200
+// | quantize=128" or just "grayscale".
+func ParsePipeline(spec string) (Pipeline, error) {
+	p := Pipeline{Spec: spec}
+	steps := spec
+	// A colon separates the pattern, unless it is part of a URL pattern
+	// such as https://example.com/*.
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == ':' && !strings.HasPrefix(spec[i:], "://") {
+			p.Pattern, steps = strings.TrimSpace(spec[:i]), spec[i+1:]
+			break
+		}
+	}
+	if _, err := path.Match(p.Pattern, ""); err != nil {
+		return Pipeline{}, fmt.Errorf("transform pattern %q: %w", p.Pattern, err)
+	}
+
+	transformsMu.RLock()
+	defer transformsMu.RUnlock()
+	for _, step := range strings.Split(steps, "|") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(step), "=")
+		if name == "" {
+			return Pipeline{}, fmt.Errorf("transform pipeline %q has an empty step", spec)
+		}
+		factory, ok := transforms[name]
+		if !ok {
+			return Pipeline{}, fmt.Errorf("unknown transform %q (want %s)", name, transformNames())
+		}
+		t, err := factory(arg)
+		if err != nil {
+			return Pipeline{}, fmt.Errorf("transform %s: %w", name, err)
+		}
+		p.Transforms = append(p.Transforms, t)
+	}
+	return p, nil
+}
+
+// transformNames lists the registered transforms; the caller holds transformsMu.
+func transformNames() string {
+	var names []string
+	for name := range transforms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// Matches reports whether the pipeline applies to an image reference path.
+func (p Pipeline) Matches(imagePath string) bool {
+	if p.Pattern == "" {
+		return true
+	}
+	if u, err := parseURLPath(imagePath); err == nil {
+		imagePath = u
+	}
+	if !strings.Contains(p.Pattern, "/") {
+		imagePath = path.Base(imagePath)
+	}
+	ok, _ := path.Match(p.Pattern, imagePath)
+	return ok
+}
+
+// parseURLPath returns the path of a remote image URL, so that query strings
+// don't defeat patterns such as *.png.
+func parseURLPath(imagePath string) (string, error) {
+	if !isURL(imagePath) {
+		return "", fmt.Errorf("not a URL")
+	}
+	u, err := normalizeURL(imagePath)
+	if err != nil {
+		return "", err
+	}
+	if i := strings.IndexAny(u, "?#"); i >= 0 {
+		u = u[:i]
+	}
+	return u, nil
+}
+
+// applyPipelines runs every pipeline matching imagePath over img, in order.
+func (p *Processor) applyPipelines(imagePath string, img image.Image) (image.Image, error) {
+	for _, pipeline := range p.opts.Pipelines {
+		if !pipeline.Matches(imagePath) {
+			continue
+		}
+		for _, t := range pipeline.Transforms {
+			var err error
+			if img, err = t.Apply(img); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return img, nil
+}
+
+// quantizeTransform reduces an image to at most n colors with median cut
+// and Floyd-Steinberg dithering, which makes PNGs much smaller.
+func quantizeTransform(arg string) (Transform, error) {
+	n, err := strconv.Atoi(arg)
+	if err != nil || n < 2 || n > 256 {
+		return nil, fmt.Errorf("needs a number of colors between 2 and 256, got %q", arg)
+	}
+	return TransformFunc(func(img image.Image) (image.Image, error) {
+		palette := medianCut(img, n)
+		out := image.NewPaletted(img.Bounds(), palette)
+		draw.FloydSteinberg.Draw(out, out.Bounds(), img, img.Bounds().Min)
+		return out, nil
+	}), nil
+}
+
+// medianCut builds a palette of at most n colors for img.
+func medianCut(img image.Image, n int) color.Palette {
+	b := img.Bounds()
+	pixels := make([][4]uint8, 0, b.Dx()*b.Dy())
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			pixels = append(pixels, [4]uint8{c.R, c.G, c.B, c.A})
+		}
+	}
+
+	boxes := [][][4]uint8{pixels}
+	for len(boxes) < n {
+		// Split the box with the widest channel range at its median.
+		best, bestChannel, bestRange := -1, 0, 0
+		for i, box := range boxes {
+			if len(box) < 2 {
+				continue
+			}
+			for ch := 0; ch < 4; ch++ {
+				lo, hi := uint8(255), uint8(0)
+				for _, px := range box {
+					lo, hi = min(lo, px[ch]), max(hi, px[ch])
+				}
+				if int(hi)-int(lo) > bestRange {
+					best, bestChannel, bestRange = i, ch, int(hi)-int(lo)
+				}
+			}
+		}
+		if best < 0 {
+			break
+		}
+		box := boxes[best]
+		sort.Slice(box, func(i, j int) bool { return box[i][bestChannel] < box[j][bestChannel] })
+		mid := len(box) / 2
+		boxes[best] = box[:mid]
+		boxes = append(boxes, box[mid:])
+	}
+
+	palette := make(color.Palette, 0, len(boxes))
+	for _, box := range boxes {
+		if len(box) == 0 {
+			continue
+		}
+		var sum [4]int
+		for _, px := range box {
+			for ch := range sum {
+				sum[ch] += int(px[ch])
+			}
+		}
+		palette = append(palette, color.NRGBA{
+			R: uint8(sum[0] / len(box)), G: uint8(sum[1] / len(box)),
+			B: uint8(sum[2] / len(box)), A: uint8(sum[3] / len(box)),
+		})
+	}
+	if len(palette) == 0 {
+		palette = append(palette, color.Transparent)
+	}
+	return palette
+}
@@ -0,0 +1,114 @@
+package markdown_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+	"image/png"
+	"markdown-images/markdown"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+func TestParsePipeline(t *testing.T) {
+	testCases := []struct {
+		spec        string
+		wantPattern string
+		wantSteps   int
+		wantErr     bool
+	}{
+		{"*.png: strip-metadata | max-width=1200 | quantize=128", "*.png", 3, false},
+		{"grayscale", "", 1, false},
+		{"assets/*.jpg:max-height=50", "assets/*.jpg", 1, false},
+		{"https://example.com/*: grayscale", "https://example.com/*", 1, false},
+		{"*.png: sharpen", "", 0, true},
+		{"*.png: quantize=1000", "", 0, true},
+		{"*.png: max-width=wide", "", 0, true},
+		{"*.png: grayscale=1", "", 0, true},
+		{"*.png: grayscale ||", "", 0, true},
+		{"[: grayscale", "", 0, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.spec, func(t *testing.T) {
+			p, err := markdown.ParsePipeline(tc.spec)
+			if tc.wantErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", p)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParsePipeline failed: %v", err)
+			}
+			if p.Pattern != tc.wantPattern || len(p.Transforms) != tc.wantSteps {
+				t.Errorf("Got pattern %q with %d steps", p.Pattern, len(p.Transforms))
+			}
+		})
+	}
+}
+
+func TestPipelineMatches(t *testing.T) {
+	p, _ := markdown.ParsePipeline("*.png: grayscale")
+	for path, want := range map[string]bool{
+		"a.png":                          true,
+		"img/deep/a.png":                 true,
+		"a.jpg":                          false,
+		"https://example.com/a.png?v=2":  true,
+		"https://example.com/a.jpg#.png": false,
+	} {
+		if got := p.Matches(path); got != want {
+			t.Errorf("Matches(%q) = %v; want %v", path, got, want)
+		}
+	}
+}
+
+func TestPipelineTransforms(t *testing.T) {
+	tempDir := t.TempDir()
+	// A gradient with many distinct colors.
+	img := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.NRGBA{uint8(x * 4), uint8(y * 4), 128, 255})
+		}
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, img)
+	if err := os.WriteFile(filepath.Join(tempDir, "gradient.png"), buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var calls int
+	markdown.RegisterTransform("count", func(arg string) (markdown.Transform, error) {
+		return markdown.TransformFunc(func(img image.Image) (image.Image, error) {
+			calls++
+			return img, nil
+		}), nil
+	})
+	quantize, err := markdown.ParsePipeline("*.png: max-width=32 | quantize=8 | count")
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, _ := markdown.ParsePipeline("*.jpg: count")
+
+	processor := markdown.NewProcessor(markdown.Options{Pipelines: []markdown.Pipeline{quantize, skipped}})
+	result, err := processor.Process("![g](gradient.png)", tempDir)
+	if err != nil {
+		t.Fatalf("Process failed: %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected the custom transform to run once, got %d", calls)
+	}
+	payload := regexp.MustCompile(`base64,([^)]+)`).FindStringSubmatch(result.Content)
+	data, _ := base64.StdEncoding.DecodeString(payload[1])
+	out, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	paletted, ok := out.(*image.Paletted)
+	if !ok || len(paletted.Palette) > 8 || out.Bounds().Dx() != 32 {
+		t.Errorf("Expected a 32px wide image with at most 8 colors, got %T %v", out, out.Bounds())
+	}
+}
@@ -0,0 +1,39 @@
+package markdown
+
+import (
+	"regexp"
+	"strings"
+)
+
+// variableRegex matches a {{name}} placeholder. Names are lower case, so
+// that the {{include file.md}} directive and most template syntaxes of
+// other tools are left alone.
+var variableRegex = regexp.MustCompile(`\{\{\s*([a-z][a-z0-9_-]*)\s*\}\}`)
+
+// SubstituteVariables replaces the {{name}} placeholders in content that
+// have a value in vars, such as {{date}} or {{version}}. Placeholders in
+// code blocks and code spans, and those without a value, are kept as
+// written.
+func SubstituteVariables(content string, vars map[string]string) string {
+	codeBlocks := codeBlockRanges(content)
+	var b strings.Builder
+	last := 0
+	for _, m := range variableRegex.FindAllStringSubmatchIndex(content, -1) {
+		value, ok := vars[content[m[2]:m[3]]]
+		if !ok || inRanges(codeBlocks, m[0]) || inCodeSpan(content, m[0]) {
+			continue
+		}
+		b.WriteString(content[last:m[0]])
+		b.WriteString(value)
+		last = m[1]
+	}
+	b.WriteString(content[last:])
+	return b.String()
+}
+
+// inCodeSpan reports whether pos follows an odd number of backticks on its
+// line, which is where a single-line code span would put it.
+func inCodeSpan(content string, pos int) bool {
+	lineStart := strings.LastIndexByte(content[:pos], '\n') + 1
+	return strings.Count(content[lineStart:pos], "`")%2 == 1
+}
@@ -0,0 +1,16 @@
+package markdown_test
+
+import (
+	"testing"
+
+	"markdown-images/markdown"
+)
+
+func TestSubstituteVariables(t *testing.T) {
+	vars := map[string]string{"date": "2024-05-01", "version": "1.2.0"}
+	content := "v{{version}}, built {{ date }}.\n{{unknown}} {{Date}} {{include a.md}}\n`{{date}}` and `x` {{date}}\n```\n{{version}}\n```\n"
+	want := "v1.2.0, built 2024-05-01.\n{{unknown}} {{Date}} {{include a.md}}\n`{{date}}` and `x` 2024-05-01\n```\n{{version}}\n```\n"
+	if got := markdown.SubstituteVariables(content, vars); got != want {
+		t.Errorf("Got:\n%s\nwant:\n%s", got, want)
+	}
+}
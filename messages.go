@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+)
+
+// Messages printed by the command go through a message catalog, so that
+// documentation teams can run it in their own language. As with gettext,
+// the English format string is the message ID, and a catalog maps IDs to
+// translated format strings with the same verbs. Untranslated messages
+// stay in English, and error codes such as MI1001 are never translated, so
+// scripts should match on those. messages/template.json lists every ID.
+var messageCatalog struct {
+	sync.RWMutex
+	messages map[string]string
+}
+
+// statusOutput receives the progress messages written with printf: standard
+// output, or standard error when the output itself goes to standard output.
+var statusOutput io.Writer = os.Stdout
+
+// logLevel is the least severe level of message the command prints:
+// progress messages written with printf are at slog.LevelInfo and warnings
+// written with warnf at slog.LevelWarn. It also filters the records of the
+// library (see logger).
+var logLevel = new(slog.LevelVar)
+
+// logger returns the logger given to the library: its records, structured
+// as key=value pairs, go to standard error when logLevel lets them.
+func logger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+}
+
+// formatVerbRegex matches the verbs of a format string, and %%.
+var formatVerbRegex = regexp.MustCompile(`%(?:\[\d+\])?[-+# 0]*(?:\d+|\*)?(?:\.(?:\d+|\*))?[a-zA-Z%]`)
+
+// loadMessages reads a catalog from a JSON object mapping English messages
+// to translations; empty translations are ignored. A translation whose verbs
+// differ in number from its message's would garble the output, so it is an
+// error.
+func loadMessages(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errorf("reading --messages: %v", err)
+	}
+	var messages map[string]string
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, errorf("invalid --messages catalog %s: %v", path, err)
+	}
+	for id, translation := range messages {
+		if translation != "" && len(formatVerbRegex.FindAllString(id, -1)) != len(formatVerbRegex.FindAllString(translation, -1)) {
+			return nil, errorf("invalid --messages catalog %s: %q must use the same verbs as %q", path, translation, id)
+		}
+	}
+	return messages, nil
+}
+
+// setMessages makes messages, which may be nil for English, the catalog.
+func setMessages(messages map[string]string) {
+	messageCatalog.Lock()
+	defer messageCatalog.Unlock()
+	messageCatalog.messages = messages
+}
+
+// tr returns the translation of msg, or msg if it has none.
+func tr(msg string) string {
+	messageCatalog.RLock()
+	defer messageCatalog.RUnlock()
+	if translation, ok := messageCatalog.messages[msg]; ok && translation != "" {
+		return translation
+	}
+	return msg
+}
+
+// printf, fprintf, logf, fatalf and errorf are their fmt and log
+// counterparts with the format translated; printf is silenced by --quiet.
+// warnf is logf for warnings, which --log-level error silences.
+
+func printf(format string, args ...any) {
+	if logLevel.Level() > slog.LevelInfo {
+		return
+	}
+	fmt.Fprintf(statusOutput, tr(format), args...)
+}
+
+func fprintf(w io.Writer, format string, args ...any) {
+	fmt.Fprintf(w, tr(format), args...)
+}
+
+func logf(format string, args ...any) {
+	log.Printf(tr(format), args...)
+}
+
+func warnf(format string, args ...any) {
+	if logLevel.Level() > slog.LevelWarn {
+		return
+	}
+	log.Printf(tr(format), args...)
+}
+
+func fatalf(format string, args ...any) {
+	exitf(exitFailure, format, args...)
+}
+
+func errorf(format string, args ...any) error {
+	return fmt.Errorf(tr(format), args...)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"markdown-images/markdown"
+)
+
+// defaultMirrorMap is the name of the mapping file in --assets-dir.
+const defaultMirrorMap = "mirror.json"
+
+// loadMirrorMap reads a mapping from image URLs to local paths, relative to
+// the mapping file's directory. A missing file is an empty mapping.
+func loadMirrorMap(path string) (map[string]string, error) {
+	mapping := map[string]string{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return mapping, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &mapping); err != nil {
+		return nil, errorf("invalid mapping file %s: %v", path, err)
+	}
+	return mapping, nil
+}
+
+// mirrorImages downloads the remote images referenced by the input files
+// into o.assetsDir, points the documents at the local copies and records
+// each URL's copy in the mapping file. URLs already in the mapping are
+// downloaded again into the same file, so running it later re-syncs the
+// copies with their sources. Images that fail to download keep their URL.
+func (o *cliOptions) mirrorImages(ctx context.Context, processor *markdown.Processor) error {
+	mapPath := o.mirrorMap
+	if mapPath == "" {
+		mapPath = filepath.Join(o.assetsDir, defaultMirrorMap)
+	}
+	mapping, err := loadMirrorMap(mapPath)
+	if err != nil {
+		return err
+	}
+	mapDir := filepath.Dir(mapPath)
+
+	documents := map[string]string{}
+	urls := map[string]bool{}
+	for url := range mapping {
+		urls[url] = true
+	}
+	for _, file := range o.inputFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return &markdown.Error{Code: markdown.CodeInputUnreadable, Path: file, Err: err}
+		}
+		documents[file] = string(data)
+		for _, url := range markdown.RemoteImageURLs(string(data)) {
+			urls[url] = true
+		}
+	}
+	sorted := make([]string, 0, len(urls))
+	for url := range urls {
+		sorted = append(sorted, url)
+	}
+	sort.Strings(sorted)
+
+	// local holds the copy of each URL that can be used, as a path from the
+	// working directory.
+	local := map[string]string{}
+	for _, url := range sorted {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if o.dryRun {
+			printf("Dry run: would download %s\n", url)
+			continue
+		}
+		content, err := processor.Download(ctx, url)
+		if err != nil {
+			warnf("Warning: Could not download %s: %v", url, err)
+			if recorded, ok := mapping[url]; ok {
+				local[url] = filepath.Join(mapDir, filepath.FromSlash(recorded))
+			}
+			continue
+		}
+		path := filepath.Join(o.assetsDir, markdown.MirrorName(url, content))
+		if recorded, ok := mapping[url]; ok {
+			path = filepath.Join(mapDir, filepath.FromSlash(recorded))
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := writeFileAtomic(path, content); err != nil {
+			return err
+		}
+		local[url] = path
+		if rel, err := filepath.Rel(mapDir, path); err == nil {
+			mapping[url] = filepath.ToSlash(rel)
+		}
+	}
+	if o.dryRun {
+		return nil
+	}
+
+	updated := 0
+	for _, file := range o.inputFiles {
+		dir := o.documentDir(file)
+		content := markdown.RewriteImagePaths(documents[file], func(path string) (string, bool) {
+			copied, ok := local[path]
+			if !ok {
+				return "", false
+			}
+			rel, err := filepath.Rel(dir, copied)
+			if err != nil {
+				return "", false
+			}
+			return filepath.ToSlash(rel), true
+		})
+		if content == documents[file] {
+			continue
+		}
+		if err := writeFileAtomic(file, []byte(content)); err != nil {
+			return err
+		}
+		updated++
+	}
+
+	data, err := json.MarshalIndent(mapping, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(mapDir, 0755); err != nil {
+		return err
+	}
+	if err := writeFileAtomic(mapPath, append(data, '\n')); err != nil {
+		return err
+	}
+	printf("Mirrored %d images into %s and updated %d documents; mapping in %s\n", len(local), o.assetsDir, updated, mapPath)
+	return nil
+}
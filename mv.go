@@ -0,0 +1,83 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+
+	"markdown-images/markdown"
+)
+
+// movedDocument is a document whose references the mv subcommand rewrites.
+type movedDocument struct {
+	path              string
+	content, original []byte
+}
+
+// moveImage renames the image o.moveFrom to o.moveTo, or into it if it is a
+// directory, and rewrites every reference to it in the input files. All the
+// documents are rewritten in memory before anything changes on disk, and if
+// writing one fails, the image and the documents already written are put
+// back, so the tree is never left half updated.
+func (o *cliOptions) moveImage() error {
+	info, err := os.Stat(o.moveFrom)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return errorf("%s is not a file", o.moveFrom)
+	}
+	to := o.moveTo
+	if info, err := os.Stat(to); err == nil && info.IsDir() {
+		to = filepath.Join(to, filepath.Base(o.moveFrom))
+	}
+	if _, err := os.Lstat(to); err == nil {
+		return errorf("refusing to overwrite %s", to)
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	var docs []movedDocument
+	references := 0
+	for _, file := range o.inputFiles {
+		original, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		content, n := markdown.RenameImagePaths(string(original), o.documentDir(file), o.moveFrom, to)
+		if n == 0 {
+			continue
+		}
+		docs = append(docs, movedDocument{path: file, content: []byte(content), original: original})
+		references += n
+		if o.dryRun {
+			printf("Dry run: would update %d references in %s\n", n, file)
+		}
+	}
+	if o.dryRun {
+		printf("Dry run: would move %s -> %s\n", o.moveFrom, to)
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(o.moveFrom, to); err != nil {
+		return err
+	}
+	for i, doc := range docs {
+		if err := writeFileAtomic(doc.path, doc.content); err != nil {
+			for _, written := range docs[:i] {
+				if err := writeFileAtomic(written.path, written.original); err != nil {
+					warnf("Warning: Could not restore %s: %v", written.path, err)
+				}
+			}
+			if err := os.Rename(to, o.moveFrom); err != nil {
+				warnf("Warning: Could not move %s back: %v", to, err)
+			}
+			return errorf("updating %s: %v (changes were rolled back)", doc.path, err)
+		}
+	}
+	printf("Moved %s -> %s, updated %d references in %d documents\n", o.moveFrom, to, references, len(docs))
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// registerPDFCommand rasterizes PDFs referenced by <object>, <embed> or image
+// tags with an external command, such as
+// "pdftoppm -png -singlefile -f 1 -l 1 -r 150 -", which reads the PDF on
+// standard input and writes an image of its first page to standard output.
+// The command is killed when ctx is done.
+func registerPDFCommand(ctx context.Context, command string) error {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return errorf("--pdf-command is empty")
+	}
+	markdown.RegisterFormat("application/pdf", "%PDF-", func(r io.Reader) (image.Image, error) {
+		var stdout, stderr bytes.Buffer
+		cmd, err := tempCommand(ctx, args[0], args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = r, &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, fmt.Errorf("%s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		img, _, err := image.Decode(&stdout)
+		if err != nil {
+			return nil, errorf("%s output: %v", args[0], err)
+		}
+		return img, nil
+	}, nil)
+	return nil
+}
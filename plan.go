@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// planVersion is bumped when the plan format changes incompatibly.
+const planVersion = 1
+
+// plan describes what a run would do, for review before it is applied with
+// --apply. Paths are as given on the command line, so a plan must be applied
+// from the directory it was made in.
+type plan struct {
+	Version int `json:"version"`
+	// Args is the command line that produced the plan, without --plan and
+	// --dry-run; --apply re-runs it.
+	Args      []string       `json:"args"`
+	Documents []documentPlan `json:"documents"`
+}
+
+// documentPlan covers one output file.
+type documentPlan struct {
+	Inputs []string `json:"inputs"`
+	Output string   `json:"output"`
+	// SHA256 is the hash of the combined input, so a plan is never applied
+	// to a document that changed after it was reviewed.
+	SHA256 string      `json:"sha256"`
+	Images []imagePlan `json:"images"`
+}
+
+// imagePlan is the intended action for one image reference.
+type imagePlan struct {
+	Source string `json:"source"`
+	Line   int    `json:"line"`
+	// Action is "embed", "skip" or "fail". Changing "embed" to "skip" before
+	// applying leaves the image untouched.
+	Action        string `json:"action"`
+	Format        string `json:"format,omitempty"`
+	Width         int    `json:"width,omitempty"`
+	Height        int    `json:"height,omitempty"`
+	OriginalBytes int    `json:"original_bytes,omitempty"`
+	EmbeddedBytes int    `json:"embedded_bytes,omitempty"`
+	// SourceSHA256 is the hash of the image as planned; applying fails if
+	// the image changed since.
+	SourceSHA256 string `json:"source_sha256,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// planArgs strips the flags that only make sense while planning.
+func planArgs(args []string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		if !strings.HasPrefix(args[i], "-") {
+			kept = append(kept, args[i])
+			continue
+		}
+		switch name {
+		case "plan":
+			if !hasValue {
+				i++
+			}
+		case "dry-run":
+		default:
+			kept = append(kept, args[i])
+		}
+	}
+	return kept
+}
+
+func contentHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// add records the outcome of a dry run of one document.
+func (p *plan) add(inputs []string, output, content string, result *markdown.Result) {
+	doc := documentPlan{Inputs: inputs, Output: output, SHA256: contentHash(content), Images: []imagePlan{}}
+	for _, img := range result.Images {
+		ip := imagePlan{
+			Source: img.Reference.ImagePath,
+			Line:   strings.Count(content[:img.Reference.StartPos], "\n") + 1,
+		}
+		switch {
+		case img.Err != nil:
+			ip.Action, ip.Error = "fail", img.Err.Error()
+		case img.SkipReason != "":
+			ip.Action, ip.Reason = "skip", img.SkipReason
+		default:
+			ip.Action, ip.Format = "embed", img.MIMEType
+			ip.Width, ip.Height = img.Width, img.Height
+			ip.OriginalBytes, ip.EmbeddedBytes = img.OriginalSize, img.EncodedSize
+			ip.SourceSHA256 = img.SourceSHA256
+		}
+		doc.Images = append(doc.Images, ip)
+	}
+	p.Documents = append(p.Documents, doc)
+}
+
+// write saves the plan as indented JSON.
+func (p *plan) write(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
+
+// loadPlan reads a plan written by --plan.
+func loadPlan(path string) (*plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p plan
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if p.Version != planVersion {
+		return nil, errorf("%s: unsupported plan version %d", path, p.Version)
+	}
+	return &p, nil
+}
+
+// document returns the plan for the given inputs and output, checking that
+// their content is still what was reviewed.
+func (p *plan) document(inputs []string, output, content string) (*documentPlan, error) {
+	key := strings.Join(inputs, "\x00")
+	for i := range p.Documents {
+		doc := &p.Documents[i]
+		if strings.Join(doc.Inputs, "\x00") != key || doc.Output != output {
+			continue
+		}
+		if doc.SHA256 != contentHash(content) {
+			return nil, errorf("%s changed since the plan was made", strings.Join(inputs, ", "))
+		}
+		return doc, nil
+	}
+	return nil, errorf("%s -> %s is not in the plan", strings.Join(inputs, ", "), output)
+}
+
+// beforeEmbed applies the reviewed actions: images not planned for embedding
+// are left alone, and embedded images get the planned dimensions.
+func (doc *documentPlan) beforeEmbed(index int, ref *markdown.ImageReference) string {
+	if index >= len(doc.Images) || doc.Images[index].Source != ref.ImagePath {
+		return "not in the plan"
+	}
+	ip := doc.Images[index]
+	if ip.Action != "embed" {
+		return "skipped by plan"
+	}
+	ref.Width, ref.Height = ip.Width, ip.Height
+	return ""
+}
+
+// verify checks that every image planned for embedding was embedded from
+// unchanged source data in the planned format, so that nothing is written
+// unless the reviewed actions were carried out exactly.
+func (doc *documentPlan) verify(result *markdown.Result) error {
+	for i, img := range result.Images {
+		if i >= len(doc.Images) || doc.Images[i].Action != "embed" {
+			continue
+		}
+		ip := doc.Images[i]
+		switch {
+		case !img.Embedded:
+			return errorf("%s (line %d) could not be embedded: %v", ip.Source, ip.Line, img.Err)
+		case ip.SourceSHA256 != "" && img.SourceSHA256 != ip.SourceSHA256:
+			return errorf("%s (line %d) changed since the plan was made", ip.Source, ip.Line)
+		case img.MIMEType != ip.Format:
+			return errorf("%s (line %d) was embedded as %s, planned %s", ip.Source, ip.Line, img.MIMEType, ip.Format)
+		}
+	}
+	if len(result.Images) != len(doc.Images) {
+		return errorf("found %d images, the plan has %d", len(result.Images), len(doc.Images))
+	}
+	return nil
+}
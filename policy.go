@@ -0,0 +1,249 @@
+package main
+
+import (
+	"fmt"
+	"mime"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"markdown-images/markdown"
+)
+
+// policyFileNames are looked for like configFileNames. A policy codifies a
+// repository's documentation standards; check reports its violations, and
+// embed --strict fails on them.
+var policyFileNames = []string{".markdown-images-policy.yaml", ".markdown-images-policy.yml"}
+
+// policyRules are the rules a policy may set, with the parameter each
+// takes, if any.
+var policyRules = map[string]string{
+	"max-size":   "limit",
+	"https-only": "",
+	"alt-text":   "",
+	"formats":    "allow",
+}
+
+// policyRule is a rule of a policy and its level, "warn" or "error".
+type policyRule struct {
+	name  string
+	level string
+	// limit is the max-size in bytes of image data, before base64.
+	limit int64
+	// formats are the MIME types allowed by the formats rule.
+	formats []string
+}
+
+// policy is a parsed policy file.
+type policy struct {
+	rules []policyRule
+}
+
+// policyImage is what a policy judges of an image: size is -1 and mimeType
+// empty when unknown, as for remote images that are not downloaded.
+type policyImage struct {
+	ref      markdown.ImageReference
+	mimeType string
+	size     int64
+}
+
+// policyViolation is an image breaking a rule.
+type policyViolation struct {
+	rule    policyRule
+	message string
+}
+
+// loadPolicy reads a policy file: a YAML mapping of rule names to their
+// level, or to a mapping of their level and parameter, e.g.
+//
+//	max-size: {limit: 1M, level: error}
+//	https-only: error
+//	alt-text: warn
+//	formats: {allow: [png, jpeg, svg]}
+//
+// The level defaults to error.
+func loadPolicy(path string) (*policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, errorf("invalid policy file %s: %v", path, err)
+	}
+	pol := &policy{}
+	if len(doc.Content) == 0 {
+		return pol, nil
+	}
+	rules := doc.Content[0]
+	if rules.Kind != yaml.MappingNode {
+		return nil, errorf("invalid policy file %s: expected a mapping of rule names to settings", path)
+	}
+	for i := 0; i+1 < len(rules.Content); i += 2 {
+		rule, err := parsePolicyRule(rules.Content[i].Value, rules.Content[i+1])
+		if err != nil {
+			return nil, errorf("invalid policy file %s (line %d): %v", path, rules.Content[i].Line, err)
+		}
+		pol.rules = append(pol.rules, rule)
+	}
+	return pol, nil
+}
+
+func parsePolicyRule(name string, node *yaml.Node) (policyRule, error) {
+	param, ok := policyRules[name]
+	if !ok {
+		return policyRule{}, errorf("unknown rule %q (expected max-size, https-only, alt-text or formats)", name)
+	}
+	rule := policyRule{name: name, level: "error"}
+	settings := map[string]*yaml.Node{}
+	switch node.Kind {
+	case yaml.ScalarNode:
+		settings["level"] = node
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if key != "level" && key != param {
+				return policyRule{}, errorf("unknown setting %q of %s", key, name)
+			}
+			settings[key] = node.Content[i+1]
+		}
+	default:
+		return policyRule{}, errorf("%s must be a level or a mapping", name)
+	}
+	if level := settings["level"]; level != nil {
+		if level.Value != "warn" && level.Value != "error" {
+			return policyRule{}, errorf("invalid level %q of %s (expected warn or error)", level.Value, name)
+		}
+		rule.level = level.Value
+	}
+	value := settings[param]
+	if param != "" && value == nil {
+		return policyRule{}, errorf("%s requires %s", name, param)
+	}
+	switch name {
+	case "max-size":
+		n, err := markdown.ParseSize(value.Value)
+		if err != nil {
+			return policyRule{}, errorf("invalid limit of max-size: %v", err)
+		}
+		rule.limit = n
+	case "formats":
+		var formats []string
+		if err := value.Decode(&formats); err != nil {
+			return policyRule{}, errorf("allow of formats must be a list: %v", err)
+		}
+		for _, format := range formats {
+			rule.formats = append(rule.formats, formatMIMEType(format))
+		}
+	}
+	return rule, nil
+}
+
+// formatMIMEType accepts a MIME type or a short name such as png or svg.
+func formatMIMEType(format string) string {
+	format = strings.ToLower(strings.TrimSpace(format))
+	if strings.Contains(format, "/") {
+		return format
+	}
+	if t := mime.TypeByExtension("." + format); t != "" {
+		t, _, _ = strings.Cut(t, ";")
+		return t
+	}
+	return "image/" + format
+}
+
+// violations returns the rules img breaks. Rules that need what is
+// unknown about the image are not applied.
+func (pol *policy) violations(img policyImage) []policyViolation {
+	if pol == nil {
+		return nil
+	}
+	var found []policyViolation
+	for _, rule := range pol.rules {
+		var message string
+		switch rule.name {
+		case "max-size":
+			if img.size > rule.limit {
+				message = fmt.Sprintf(tr("%s exceeds the limit of %s"), markdown.FormatSize(img.size), markdown.FormatSize(rule.limit))
+			}
+		case "https-only":
+			if strings.HasPrefix(strings.ToLower(img.ref.ImagePath), "http://") {
+				message = tr("not served over https")
+			}
+		case "alt-text":
+			if (img.ref.Tag == "" || img.ref.Tag == "img") && !img.ref.Preview && strings.TrimSpace(img.ref.AltText) == "" {
+				message = tr("no alt text")
+			}
+		case "formats":
+			if img.mimeType != "" && !slices.Contains(rule.formats, img.mimeType) {
+				message = fmt.Sprintf(tr("%s is not an allowed format (%s)"), img.mimeType, strings.Join(rule.formats, ", "))
+			}
+		}
+		if message != "" {
+			found = append(found, policyViolation{rule: rule, message: message})
+		}
+	}
+	return found
+}
+
+// rejects reports whether img breaks a rule of level error.
+func (pol *policy) rejects(img policyImage) bool {
+	return slices.ContainsFunc(pol.violations(img), func(v policyViolation) bool { return v.rule.level == "error" })
+}
+
+// embeddedImage is what a policy judges of an embedded image: its encoded
+// size and format.
+func embeddedImage(img markdown.ImageResult) policyImage {
+	return policyImage{ref: img.Reference, mimeType: img.MIMEType, size: int64(img.EncodedSize)}
+}
+
+// referencedImage is what a policy judges of an image that is not embedded
+// yet: the format its data URI declares or its extension suggests, and the
+// size of its data or local file.
+func referencedImage(ref markdown.ImageReference, baseDir string) policyImage {
+	img := policyImage{ref: ref, size: -1}
+	if mimeType, data, ok := markdown.DecodeDataURI(ref.ImagePath); ok {
+		img.mimeType, img.size = mimeType, int64(len(data))
+		return img
+	}
+	name := ref.ImagePath
+	if u, err := url.Parse(name); err == nil {
+		name = u.Path
+	}
+	if t := mime.TypeByExtension(strings.ToLower(path.Ext(name))); t != "" {
+		img.mimeType, _, _ = strings.Cut(t, ";")
+	}
+	if file, ok := markdown.LocalPath(baseDir, ref.ImagePath); ok {
+		if info, err := os.Stat(file); err == nil {
+			img.size = info.Size()
+		}
+	}
+	return img
+}
+
+// enforcePolicy logs the violations of the embedded images of result, read
+// from file; tally counts the images breaking error rules as failed.
+func (o *cliOptions) enforcePolicy(file, content string, result *markdown.Result) {
+	for _, img := range result.Images {
+		if !img.Embedded {
+			continue
+		}
+		ref := img.Reference
+		line := strings.Count(content[:min(ref.StartPos, len(content))], "\n") + 1
+		for _, v := range o.policy.violations(embeddedImage(img)) {
+			warnf("Policy %s: %s:%d: %s: %s (%s)", v.rule.level, file, line, ref.ImagePath, v.message, v.rule.name)
+		}
+	}
+}
+
+// imageName is how reports name an image: data URIs are too long to show.
+func imageName(path string) string {
+	if strings.HasPrefix(path, "data:") {
+		return "embedded image"
+	}
+	return path
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// progressThreshold is how many images a document needs for --progress auto
+// to show its progress; shorter documents finish too soon to need it.
+const progressThreshold = 10
+
+// progressPrinter shows the --progress of each document on standard error:
+// a bar redrawn in place on a terminal, or a line per image otherwise, such
+// as in CI logs.
+type progressPrinter struct {
+	w        io.Writer
+	terminal bool
+	width    int
+	always   bool
+	// drawn is set while a bar is on the screen.
+	drawn bool
+}
+
+// progressFunc returns the Options.Progress of the --progress mode, or nil
+// for never.
+func (o *cliOptions) progressFunc() func(markdown.Progress) {
+	if o.progress == "never" {
+		return nil
+	}
+	pp := &progressPrinter{w: os.Stderr, terminal: isTerminal(os.Stderr), width: terminalWidth(), always: o.progress == "always"}
+	return pp.update
+}
+
+func (pp *progressPrinter) update(pr markdown.Progress) {
+	if logLevel.Level() > slog.LevelInfo || (pr.Total < progressThreshold && !pp.always) {
+		return
+	}
+	if !pp.terminal {
+		if pr.Image != "" {
+			fprintf(pp.w, "Image %d/%d (%s downloaded): %s\n", pr.Done+1, pr.Total, markdown.FormatSize(pr.Downloaded), pr.Image)
+		}
+		return
+	}
+	if pr.Image == "" {
+		if pp.drawn {
+			io.WriteString(pp.w, "\r\033[K")
+			pp.drawn = false
+		}
+		return
+	}
+	const barWidth = 20
+	filled := barWidth * pr.Done / max(pr.Total, 1)
+	line := fmt.Sprintf("[%s%s] %s: %s", strings.Repeat("#", filled), strings.Repeat("-", barWidth-filled),
+		fmt.Sprintf(tr("%d/%d images, %s downloaded"), pr.Done, pr.Total, markdown.FormatSize(pr.Downloaded)), pr.Image)
+	if runes := []rune(line); len(runes) >= pp.width {
+		// A line that wraps can't be redrawn in place.
+		line = string(runes[:pp.width-2]) + "…"
+	}
+	io.WriteString(pp.w, "\r\033[K"+line)
+	pp.drawn = true
+}
+
+// isTerminal reports whether f is a terminal that understands the escape
+// sequences used to redraw a line.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+// terminalWidth returns the width of the terminal as set in $COLUMNS, or 80.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 10 {
+		return n
+	}
+	return 80
+}
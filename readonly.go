@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// writableStem returns where the default output of file goes, before its
+// extension is replaced: beside file, or when its directory is read-only,
+// as on a CI cache or a container image, at the same path in the working
+// directory, relative to the directory argument file was found in. It
+// fails before anything is processed if the output can't be written there
+// either, or if file is to be replaced with --in-place.
+func (o *cliOptions) writableStem(file string) (string, error) {
+	dir := filepath.Dir(file)
+	if o.readOnly == nil {
+		o.readOnly, o.relocated = map[string]bool{}, map[string]string{}
+	}
+	readOnly, checked := o.readOnly[dir]
+	if !checked {
+		readOnly = !dirWritable(dir)
+		o.readOnly[dir] = readOnly
+	}
+	if !readOnly {
+		return file, nil
+	}
+	if o.inPlace {
+		return "", errorf("can't replace %s in place: %s is read-only (use --output-dir to write the outputs elsewhere)", file, dir)
+	}
+	stem := filepath.Base(file)
+	if root, ok := o.inputRoots[file]; ok {
+		if rel, err := filepath.Rel(root, file); err == nil {
+			stem = rel
+		}
+	}
+	if realPath(filepath.Dir(stem)) == realPath(dir) || !dirWritable(".") {
+		return "", errorf("%s is read-only and the output can't be written to the working directory instead: use --output or --output-dir to write it elsewhere", dir)
+	}
+	if other, ok := o.relocated[stem]; ok && other != file {
+		return "", errorf("%s and %s are in read-only directories and would both be written to %s in the working directory (use --output-dir to keep them apart)", other, file, stem)
+	}
+	o.relocated[stem] = file
+	if !checked {
+		warnf("Warning: %s is read-only; writing its outputs to the working directory instead", dir)
+	}
+	if !o.dryRun {
+		if err := os.MkdirAll(filepath.Dir(stem), 0755); err != nil {
+			return "", err
+		}
+	}
+	return stem, nil
+}
@@ -0,0 +1,13 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// dirWritable reports whether files can be created in dir, as far as its
+// permission bits tell on this platform. Errors are left for writing the
+// output to report.
+func dirWritable(dir string) bool {
+	info, err := os.Stat(dir)
+	return err != nil || info.Mode().Perm()&0200 != 0
+}
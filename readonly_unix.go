@@ -0,0 +1,18 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// dirWritable reports whether files can be created in dir, without creating
+// one, so that --dry-run writes nothing: access(2) also refuses read-only
+// mounts, of which permission bits say nothing. Errors other than a refusal
+// are left for writing the output to report.
+func dirWritable(dir string) bool {
+	err := unix.Access(dir, unix.W_OK)
+	return !errors.Is(err, unix.EACCES) && !errors.Is(err, unix.EPERM) && !errors.Is(err, unix.EROFS)
+}
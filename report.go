@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// reportVersion is bumped when the report format changes incompatibly.
+const reportVersion = 1
+
+// runReport is the --report file: what happened to every image reference
+// of every document, for tooling that audits the assets of a run.
+type runReport struct {
+	Version   int              `json:"version"`
+	Started   time.Time        `json:"started"`
+	Duration  float64          `json:"duration_seconds"`
+	Documents []reportDocument `json:"documents"`
+}
+
+// reportDocument is a processed document, or one that could not be
+// processed at all, with Error set.
+type reportDocument struct {
+	Files  []string      `json:"files"`
+	Output string        `json:"output,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Code   string        `json:"code,omitempty"`
+	Images []reportImage `json:"images"`
+}
+
+// reportImage is the outcome of one image reference.
+type reportImage struct {
+	// Reference is the path or URL as written, on the given line.
+	Reference string `json:"reference"`
+	Line      int    `json:"line"`
+	// Source is the file or URL it was loaded from, if it was.
+	Source        string `json:"source,omitempty"`
+	MIMEType      string `json:"mime_type,omitempty"`
+	OriginalBytes int    `json:"original_bytes,omitempty"`
+	EncodedBytes  int    `json:"encoded_bytes,omitempty"`
+	Embedded      bool   `json:"embedded"`
+	Placeholder   bool   `json:"placeholder,omitempty"`
+	SkipReason    string `json:"skip_reason,omitempty"`
+	Error         string `json:"error,omitempty"`
+	Code          string `json:"code,omitempty"`
+}
+
+// addDocument adds a processed document: files are its inputs, content
+// what was processed and output where it was written.
+func (r *runReport) addDocument(files []string, output, content string, result *markdown.Result) {
+	doc := reportDocument{Files: files, Output: output, Images: []reportImage{}}
+	for _, img := range result.Images {
+		ref := img.Reference
+		entry := reportImage{
+			Reference:     imageName(ref.ImagePath),
+			Line:          strings.Count(content[:min(ref.StartPos, len(content))], "\n") + 1,
+			Source:        img.Source,
+			MIMEType:      img.MIMEType,
+			OriginalBytes: img.OriginalSize,
+			EncodedBytes:  img.EncodedSize,
+			Embedded:      img.Embedded,
+			Placeholder:   img.Placeholder,
+			SkipReason:    img.SkipReason,
+		}
+		if img.Err != nil {
+			entry.Error, entry.Code = img.Err.Error(), string(markdown.CodeOf(img.Err))
+		}
+		doc.Images = append(doc.Images, entry)
+	}
+	r.Documents = append(r.Documents, doc)
+}
+
+// addError adds a document that could not be processed at all.
+func (r *runReport) addError(files []string, err error) {
+	r.Documents = append(r.Documents, reportDocument{Files: files, Error: err.Error(), Code: string(markdown.CodeOf(err)), Images: []reportImage{}})
+}
+
+// write writes the report to path, with the total time of the run.
+func (r *runReport) write(path string, elapsed time.Duration) error {
+	r.Version, r.Duration = reportVersion, elapsed.Seconds()
+	if r.Documents == nil {
+		r.Documents = []reportDocument{}
+	}
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"markdown-images/markdown"
+)
+
+// sourcesVersion is bumped when the sources file format changes incompatibly.
+const sourcesVersion = 1
+
+// sourcesSuffix names the file --record-sources writes beside an output.
+const sourcesSuffix = ".sources.json"
+
+// sourceRecord is where the images of an output came from, so that the
+// refresh subcommand can embed them again when their sources change.
+type sourceRecord struct {
+	Version int `json:"version"`
+	// Args is the command line that made the output; refresh encodes
+	// images with the same settings.
+	Args []string `json:"args"`
+	// BaseDir is the directory image paths are relative to, itself
+	// relative to the output's directory.
+	BaseDir string        `json:"base_dir"`
+	Images  []sourceImage `json:"images"`
+}
+
+// sourceImage is one embedded image and the reference it was made from.
+type sourceImage struct {
+	Path      string             `json:"path"`
+	Tag       string             `json:"tag,omitempty"`
+	Width     int                `json:"width,omitempty"`
+	Height    int                `json:"height,omitempty"`
+	Directive markdown.Directive `json:"directive"`
+	// SourceSHA256 is the hash of the source when it was embedded, and
+	// DataSHA256 that of the data URI in the output.
+	SourceSHA256 string `json:"source_sha256"`
+	DataSHA256   string `json:"data_sha256"`
+}
+
+// writeSources records the sources of the images embedded in outputFile.
+func (o *cliOptions) writeSources(outputFile, baseDir string, result *markdown.Result) error {
+	rel, err := filepath.Rel(filepath.Dir(outputFile), baseDir)
+	if err != nil {
+		if rel, err = filepath.Abs(baseDir); err != nil {
+			return err
+		}
+	}
+	record := sourceRecord{Version: sourcesVersion, Args: planArgs(o.args), BaseDir: filepath.ToSlash(rel), Images: []sourceImage{}}
+	for _, img := range result.Images {
+		if !img.Embedded {
+			continue
+		}
+		ref := img.Reference
+		record.Images = append(record.Images, sourceImage{
+			Path:         ref.ImagePath,
+			Tag:          ref.Tag,
+			Width:        ref.Width,
+			Height:       ref.Height,
+			Directive:    ref.Directive,
+			SourceSHA256: img.SourceSHA256,
+			DataSHA256:   img.DataURISHA256,
+		})
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(outputFile+sourcesSuffix, append(data, '\n'))
+}
+
+// loadSources reads the record written beside outputFile.
+func loadSources(outputFile string) (*sourceRecord, error) {
+	data, err := os.ReadFile(outputFile + sourcesSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errorf("no recorded sources for %s (embed it with --record-sources)", outputFile)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var record sourceRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, errorf("invalid sources file %s: %v", outputFile+sourcesSuffix, err)
+	}
+	if record.Version != sourcesVersion {
+		return nil, errorf("unsupported sources file version %d in %s", record.Version, outputFile+sourcesSuffix)
+	}
+	return &record, nil
+}
+
+// refreshOutputs embeds again, in each input file (an output written with
+// --record-sources), the images whose source changed since, with the
+// settings they were first embedded with, and replaces their data URIs.
+// Nothing else in the output changes. It returns how many files could not
+// be refreshed.
+func (o *cliOptions) refreshOutputs(ctx context.Context) int {
+	failed := 0
+	for _, file := range o.inputFiles {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := o.refreshFile(ctx, file); err != nil {
+			logf("Error: %v", err)
+			failed++
+		}
+	}
+	return failed
+}
+
+// refreshFile refreshes the images of one output.
+func (o *cliOptions) refreshFile(ctx context.Context, file string) error {
+	record, err := loadSources(file)
+	if err != nil {
+		return err
+	}
+	recorded, err := parseArgs(record.Args)
+	if err != nil {
+		return errorf("recorded arguments of %s: %v", file, err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return &markdown.Error{Code: markdown.CodeInputUnreadable, Path: file, Err: err}
+	}
+	content := string(data)
+	baseDir := filepath.Join(filepath.Dir(file), filepath.FromSlash(record.BaseDir))
+	processor := markdown.NewProcessor(recorded.processorOptions())
+
+	refreshed := 0
+	// replaced holds the hashes of the data URIs already replaced: an image
+	// referenced twice is replaced everywhere the first time.
+	replaced := map[string]bool{}
+	for i, img := range record.Images {
+		ref := markdown.ImageReference{ImagePath: img.Path, Tag: img.Tag, Width: img.Width, Height: img.Height, Directive: img.Directive}
+		dataURI, res := processor.EmbedImage(ctx, ref, baseDir)
+		switch {
+		case res.Err != nil:
+			warnf("Warning: Could not refresh %s in %s: %v", img.Path, file, res.Err)
+			continue
+		case res.SourceSHA256 == img.SourceSHA256:
+			continue
+		}
+		if !replaced[img.DataSHA256] {
+			var n int
+			if content, n = markdown.ReplaceDataURI(content, img.DataSHA256, dataURI); n == 0 {
+				warnf("Warning: %s is no longer embedded in %s", img.Path, file)
+				continue
+			}
+			content = markdown.ReplaceSourceHash(content, img.SourceSHA256, res.SourceSHA256)
+			replaced[img.DataSHA256] = true
+		}
+		record.Images[i].SourceSHA256 = res.SourceSHA256
+		record.Images[i].DataSHA256 = res.DataURISHA256
+		refreshed++
+		if o.dryRun {
+			printf("Dry run: would refresh %s in %s\n", img.Path, file)
+		}
+	}
+	if refreshed == 0 {
+		printf("Up to date: %s\n", file)
+		return nil
+	}
+	if o.dryRun {
+		return nil
+	}
+	if err := writeFileAtomic(file, []byte(content)); err != nil {
+		return &markdown.Error{Code: markdown.CodeOutputUnwritable, Path: file, Err: err}
+	}
+	data, err = json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(file+sourcesSuffix, append(data, '\n')); err != nil {
+		return err
+	}
+	printf("Refreshed %d of %d images in %s\n", refreshed, len(record.Images), file)
+	return nil
+}
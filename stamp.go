@@ -0,0 +1,64 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// stampPrefix starts the comment --stamp ends outputs with.
+const stampPrefix = "<!-- markdown-images inputs sha256:"
+
+// inputHash hashes what the output of content is made from: the document,
+// the options, the bytes of every image and the media references, and
+// whether each was embedded, plus a custom --theme stylesheet for HTML.
+// Error messages are left out, as they may hold timings or temporary paths
+// that would change the hash of the same output.
+func (o *cliOptions) inputHash(content string, result *markdown.Result) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%d\x00%s\x00", buildOptions(o.args, o.configFile), len(content), content)
+	for _, img := range result.Images {
+		fmt.Fprintf(h, "image %q %s %t\x00", img.Reference.ImagePath, img.SourceSHA256, img.Embedded)
+	}
+	for _, media := range result.Media {
+		fmt.Fprintf(h, "media %q %d %t\x00", media.Path, media.Size, media.Embedded)
+	}
+	if _, builtin := markdown.Theme(o.theme); o.format == "html" && !builtin && o.theme != "none" && !strings.Contains(o.theme, "://") {
+		if f, err := os.Open(o.theme); err == nil {
+			io.Copy(h, f)
+			f.Close()
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// stampOutput appends the stamp comment for hash to output, on a line of
+// its own. It is invisible in rendered markdown and HTML.
+func stampOutput(output, hash, newline string) string {
+	if output != "" && !strings.HasSuffix(output, "\n") {
+		output += newline
+	}
+	return output + stampPrefix + hash + " -->" + newline
+}
+
+// readStamp returns the hash stamped on the output file at path, or "".
+func readStamp(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	i := strings.LastIndex(string(data), stampPrefix)
+	if i < 0 {
+		return ""
+	}
+	hash, _, ok := strings.Cut(string(data[i+len(stampPrefix):]), " -->")
+	if !ok {
+		return ""
+	}
+	return hash
+}
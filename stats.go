@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// usageStats is the cumulative, local-only record kept in the --stats-file.
+// Nothing in it is ever sent over the network.
+type usageStats struct {
+	Runs               int   `json:"runs"`
+	DocumentsProcessed int   `json:"documents_processed"`
+	ImagesEmbedded     int   `json:"images_embedded"`
+	ImagesFailed       int   `json:"images_failed"`
+	OriginalBytes      int64 `json:"original_bytes"`
+	EmbeddedBytes      int64 `json:"embedded_bytes"`
+	BytesSaved         int64 `json:"bytes_saved"`
+	// FailuresByCode counts failed images per stable error code.
+	FailuresByCode map[markdown.ErrorCode]int `json:"failures_by_code,omitempty"`
+	FirstRun       time.Time                  `json:"first_run"`
+	LastRun        time.Time                  `json:"last_run"`
+}
+
+// add folds the outcome of one processed document into the totals.
+func (s *usageStats) add(result *markdown.Result) {
+	s.DocumentsProcessed++
+	for _, img := range result.Images {
+		if img.SkipReason != "" {
+			continue
+		}
+		if !img.Embedded {
+			s.ImagesFailed++
+			if code := markdown.CodeOf(img.Err); code != "" {
+				if s.FailuresByCode == nil {
+					s.FailuresByCode = make(map[markdown.ErrorCode]int)
+				}
+				s.FailuresByCode[code]++
+			}
+			continue
+		}
+		s.ImagesEmbedded++
+		s.OriginalBytes += int64(img.OriginalSize)
+		s.EmbeddedBytes += int64(img.EncodedSize)
+	}
+	s.BytesSaved = s.OriginalBytes - s.EmbeddedBytes
+}
+
+// recordStats adds the documents processed since it was last called to the
+// --stats-file as one run.
+func (o *cliOptions) recordStats() {
+	if o.statsFile == "" || len(o.usage) == 0 {
+		return
+	}
+	if err := recordUsageStats(o.statsFile, o.usage...); err != nil {
+		warnf("Warning: Could not update stats file %s: %v", o.statsFile, err)
+	}
+	o.usage = nil
+}
+
+// recordUsageStats loads the stats file at path (if any), adds one run
+// covering results and writes it back.
+func recordUsageStats(path string, results ...*markdown.Result) error {
+	stats := &usageStats{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, stats); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now().UTC()
+	if stats.FirstRun.IsZero() {
+		stats.FirstRun = now
+	}
+	stats.LastRun = now
+	stats.Runs++
+	for _, result := range results {
+		stats.add(result)
+	}
+
+	data, err = json.MarshalIndent(stats, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'))
+}
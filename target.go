@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"io"
+	"sort"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// targetProfile adapts the output to where the document will be viewed.
+type targetProfile struct {
+	attrStyle markdown.AttrStyle
+	// formats are the MIME types worth embedding; nil allows all.
+	formats      []string
+	maxEmbedSize int64
+	// dataURILimit and documentLimit are the sizes beyond which the viewer
+	// is known to fail: a warning is printed when the output exceeds them.
+	dataURILimit  int64
+	documentLimit int64
+}
+
+// targetProfiles are the --target presets. Explicit flags and environment
+// variables take precedence over a profile's settings.
+var targetProfiles = map[string]targetProfile{
+	// GitHub shows kramdown blocks as literal text and refuses to render
+	// very large markdown files, so dimensions go into HTML and each image
+	// is kept reasonably small.
+	"github": {attrStyle: markdown.AttrStyleHTML, maxEmbedSize: 512 << 10, dataURILimit: browserURLLimit, documentLimit: 5 << 20},
+	// VS Code's preview blocks SVG data URIs in untrusted workspaces.
+	"vscode": {attrStyle: markdown.AttrStyleHTML, formats: []string{"image/png", "image/jpeg", "image/gif", "image/x-icon"}, dataURILimit: browserURLLimit},
+	"pandoc": {attrStyle: markdown.AttrStylePandoc},
+	// Confluence's markdown import drops raw HTML and only accepts the
+	// common raster formats; pages are limited to about 5 MB of storage.
+	"confluence": {attrStyle: markdown.AttrStyleNone, formats: []string{"image/png", "image/jpeg", "image/gif"}, maxEmbedSize: 1 << 20, documentLimit: 5 << 20},
+}
+
+// browserURLLimit is Chromium's 2 MB URL limit, which also applies to data
+// URIs in some contexts (opening an image in a new tab, some previews).
+const browserURLLimit = 2 << 20
+
+// targetNames returns the known profile names, sorted.
+func targetNames() string {
+	var names []string
+	for name := range targetProfiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyTarget fills in the settings of the --target profile that were not
+// given explicitly. set holds the names of the flags that were.
+func (o *cliOptions) applyTarget(set map[string]bool) error {
+	if o.target == "" {
+		return nil
+	}
+	profile, ok := targetProfiles[strings.ToLower(o.target)]
+	if !ok {
+		return errorf("unknown target %q (want %s)", o.target, targetNames())
+	}
+	if !set["attr-style"] {
+		o.attrStyle = attrStyleValue(profile.attrStyle)
+	}
+	if !set["max-embed-size"] {
+		o.maxEmbedSize = sizeValue(profile.maxEmbedSize)
+	}
+	o.allowedFormats = profile.formats
+	o.profile = profile
+	return nil
+}
+
+// warnLimits prints a warning for every embedded image, and for the whole
+// output, that exceeds the limits of the selected target.
+func (o *cliOptions) warnLimits(w io.Writer, outputFile string, result *markdown.Result) {
+	if limit := o.profile.dataURILimit; limit > 0 {
+		for _, img := range result.Images {
+			if !img.Embedded {
+				continue
+			}
+			size := int64(len("data:"+img.MIMEType+";base64,") + base64.StdEncoding.EncodedLen(img.EncodedSize))
+			if size > limit {
+				fprintf(w, "Warning: data URI for %s is %s, over the %s limit of %s; consider --max-embed-size or a smaller --max-width\n",
+					img.Reference.ImagePath, markdown.FormatSize(size), o.target, markdown.FormatSize(limit))
+			}
+		}
+	}
+	if limit := o.profile.documentLimit; limit > 0 && int64(len(result.Content)) > limit {
+		fprintf(w, "Warning: %s is %s, over the %s limit of %s; consider splitting the document or --max-embed-size\n",
+			outputFile, markdown.FormatSize(int64(len(result.Content))), o.target, markdown.FormatSize(limit))
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "io/fs"
+
+// umask is the file mode creation mask, which this platform doesn't have.
+var umask fs.FileMode
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"io/fs"
+
+	"golang.org/x/sys/unix"
+)
+
+// umask is the process's file mode creation mask. Reading it means setting
+// it, so it is read once before any goroutine can create a file.
+var umask = func() fs.FileMode {
+	mask := unix.Umask(0)
+	unix.Umask(mask)
+	return fs.FileMode(mask)
+}()
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"maps"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDateFormat writes {{date}} as an ISO 8601 date.
+const defaultDateFormat = "2006-01-02"
+
+// variables returns the values of the {{name}} placeholders in a document
+// in dir: {{date}}, {{git-sha}} when dir is in a git repository, and the
+// --var values, which take precedence.
+func (o *cliOptions) variables(dir string) map[string]string {
+	vars := map[string]string{"date": buildTime().Format(o.dateFormat)}
+	if sha := gitSHA(dir); sha != "" {
+		vars["git-sha"] = sha
+	}
+	maps.Copy(vars, o.vars)
+	return vars
+}
+
+// buildTime is the time of the build: SOURCE_DATE_EPOCH if it is set, as
+// reproducible builds expect, or the current time.
+func buildTime() time.Time {
+	if epoch, err := strconv.ParseInt(os.Getenv("SOURCE_DATE_EPOCH"), 10, 64); err == nil {
+		return time.Unix(epoch, 0).UTC()
+	}
+	return time.Now()
+}
+
+// gitSHA returns the abbreviated commit checked out in dir, or "".
+func gitSHA(dir string) string {
+	cmd := exec.Command("git", "rev-parse", "--short", "HEAD")
+	cmd.Dir = dir
+	out, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
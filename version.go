@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// version is the release, set at build time with
+// -ldflags "-X main.version=v1.2.3". Otherwise the module version recorded
+// by go install is used, if any.
+var version = ""
+
+// versionInfo describes the build for --version: the version, the commit it
+// was built from, as the Go toolchain records it, and the toolchain and
+// platform.
+func versionInfo() string {
+	v, commit, built, modified := version, "", "", false
+	goVersion := "unknown"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		goVersion = info.GoVersion
+		if v == "" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+			v = info.Main.Version
+		}
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				commit = s.Value
+			case "vcs.time":
+				built = s.Value
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+	}
+	if v == "" {
+		v = "devel"
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "markdown-images %s\n", v)
+	if commit != "" {
+		if modified {
+			commit += " (modified)"
+		}
+		fmt.Fprintf(&b, "commit: %s\n", commit)
+	}
+	if built != "" {
+		fmt.Fprintf(&b, "commit time: %s\n", built)
+	}
+	fmt.Fprintf(&b, "go: %s %s/%s\n", goVersion, runtime.GOOS, runtime.GOARCH)
+	return b.String()
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+
+	"markdown-images/markdown"
+)
+
+// registerFFmpeg extracts video poster frames with ffmpeg, taking the frame
+// at the given offset from the start. It returns false if ffmpeg is not
+// installed, in which case videos keep their blank players. ffmpeg is killed
+// when ctx is done.
+func registerFFmpeg(ctx context.Context, at time.Duration) bool {
+	ffmpeg, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return false
+	}
+	decode := func(r io.Reader) (image.Image, error) {
+		// MP4 indexes are often at the end of the file, so ffmpeg needs a
+		// seekable input rather than a pipe.
+		tmp, err := createTemp("", "*.video")
+		if err != nil {
+			return nil, err
+		}
+		defer removeTemp(tmp.Name())
+		_, err = io.Copy(tmp, r)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd, err := tempCommand(ctx, ffmpeg, "-v", "error", "-ss", fmt.Sprintf("%.3f", at.Seconds()), "-i", tmp.Name(),
+			"-frames:v", "1", "-f", "image2pipe", "-vcodec", "png", "-")
+		if err != nil {
+			return nil, err
+		}
+		cmd.Stdout, cmd.Stderr = &stdout, &stderr
+		if err := cmd.Run(); err != nil {
+			return nil, errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		if stdout.Len() == 0 {
+			return nil, errorf("ffmpeg: no frame at %s", at)
+		}
+		img, _, err := image.Decode(&stdout)
+		return img, err
+	}
+	markdown.RegisterFormat("video/mp4", "", decode, nil)
+	markdown.RegisterFormat("video/webm", "", decode, nil)
+	return true
+}
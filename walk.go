@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"markdown-images/markdown"
+)
+
+// ignoreFileNames are read in every directory of a recursive walk. Both use
+// .gitignore syntax; .mdimagesignore is for paths that should stay in git but
+// not be embedded, such as drafts.
+var ignoreFileNames = []string{".gitignore", ".mdimagesignore"}
+
+// ignoreRule is one pattern line of an ignore file.
+type ignoreRule struct {
+	// base is the slash-separated directory of the ignore file, relative to
+	// the walk root ("" for the root itself).
+	base     string
+	pattern  string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// parseIgnoreRule parses a .gitignore line. Blank lines and comments yield false.
+func parseIgnoreRule(base, line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	rule := ignoreRule{base: base}
+	if strings.HasPrefix(line, "!") {
+		rule.negate, line = true, line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly, line = true, strings.TrimSuffix(line, "/")
+	}
+	// A slash anywhere but at the end anchors the pattern to the ignore
+	// file's directory; otherwise it matches a name at any depth.
+	if strings.Contains(line, "/") {
+		rule.anchored, line = true, strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = line
+	return rule, true
+}
+
+// matches reports whether the rule applies to rel, a slash-separated path
+// relative to the walk root.
+func (r ignoreRule) matches(rel string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if r.base != "" {
+		if !strings.HasPrefix(rel, r.base+"/") {
+			return false
+		}
+		rel = rel[len(r.base)+1:]
+	}
+	if !r.anchored {
+		return globMatch(r.pattern, path.Base(rel))
+	}
+	return globMatch(r.pattern, rel)
+}
+
+// globMatch matches a slash-separated name against a pattern in which "**"
+// stands for any number of path segments and other segments use path.Match.
+func globMatch(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], name[0]); err != nil || !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// ignoreRules are the rules collected so far during a walk. As in git, the
+// last matching rule decides, and rules from deeper directories come later.
+type ignoreRules []ignoreRule
+
+func (rules ignoreRules) ignored(rel string, isDir bool) bool {
+	ignored := false
+	for _, r := range rules {
+		if r.matches(rel, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// load appends the rules of the ignore files in dir, whose slash-separated
+// path relative to the walk root is rel.
+func (rules *ignoreRules) load(dir, rel string) error {
+	for _, name := range ignoreFileNames {
+		f, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if rule, ok := parseIgnoreRule(rel, scanner.Text()); ok {
+				*rules = append(*rules, rule)
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMarkdownFile reports whether a walked file should be processed. Earlier
+// outputs of this tool are never picked up again.
+func isMarkdownFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext != ".md" && ext != ".markdown" {
+		return false
+	}
+	return !isEmbeddedOutput(name)
+}
+
+// isEmbeddedOutput reports whether name is that of an output named after
+// its input, <input>_embedded or, for --variant linked, <input>_linked.
+func isEmbeddedOutput(name string) bool {
+	base := strings.TrimSuffix(name, filepath.Ext(name))
+	return strings.HasSuffix(base, "_"+markdown.VariantEmbedded) || strings.HasSuffix(base, "_"+markdown.VariantLinked)
+}
+
+// walkMarkdownFiles returns the markdown files below root in lexical order,
+// skipping .git, skipDir (if not empty) and everything matched by .gitignore
+// or .mdimagesignore. If globs are given, the files whose path relative to
+// root matches one of them are returned instead of the markdown files.
+func walkMarkdownFiles(root string, globs []string, skipDir string) ([]string, error) {
+	var rules ignoreRules
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			if rel == "." {
+				return rules.load(p, "")
+			}
+			if d.Name() == ".git" || rules.ignored(rel, true) || sameDir(p, skipDir) {
+				return filepath.SkipDir
+			}
+			return rules.load(p, rel)
+		}
+		if rules.ignored(rel, false) {
+			return nil
+		}
+		matched := isMarkdownFile(d.Name())
+		if len(globs) > 0 {
+			matched = matchesAny(globs, rel) && !isEmbeddedOutput(d.Name())
+		}
+		if matched {
+			files = append(files, p)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// matchesAny reports whether the slash-separated rel matches one of globs.
+func matchesAny(globs []string, rel string) bool {
+	for _, glob := range globs {
+		if globMatch(glob, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameDir reports whether the directories a and b are the same one.
+func sameDir(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+// expandInputs replaces directory arguments with the files below them that
+// match --glob, or the markdown files below them, and records the directory
+// each was found in for --output-dir. The --output-dir itself is never
+// walked. Plain files are kept as given, even if an ignore file matches them.
+func (o *cliOptions) expandInputs() error {
+	var files []string
+	roots := map[string]string{}
+	for _, arg := range o.inputFiles {
+		info, err := os.Stat(arg)
+		if err != nil || !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+		found, err := walkMarkdownFiles(arg, o.globs, o.outputDir)
+		if err != nil {
+			return err
+		}
+		for _, file := range found {
+			roots[file] = arg
+		}
+		files = append(files, found...)
+	}
+	o.inputFiles, o.inputRoots = files, roots
+	return nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"markdown-images/markdown"
+)
+
+// watchDelay is how long --watch waits for more changes before embedding
+// again, so that a burst of writes, such as an editor saving, runs it once.
+const watchDelay = 200 * time.Millisecond
+
+// watchState maps the files --watch monitors to the documents that depend
+// on them. A unit is the input files of one output: every input on its own,
+// or all of them with --concat.
+type watchState struct {
+	units map[string][]string
+	// deps maps each monitored file, as an absolute path, to the keys of
+	// the units made from it.
+	deps map[string]map[string]bool
+}
+
+func unitKey(files []string) string {
+	return strings.Join(files, "\n")
+}
+
+// track replaces the files the output of files depends on.
+func (w *watchState) track(files, deps []string) {
+	key := unitKey(files)
+	w.units[key] = files
+	for _, units := range w.deps {
+		delete(units, key)
+	}
+	for _, dep := range deps {
+		abs, err := filepath.Abs(dep)
+		if err != nil {
+			continue
+		}
+		if w.deps[abs] == nil {
+			w.deps[abs] = map[string]bool{}
+		}
+		w.deps[abs][key] = true
+	}
+}
+
+// watchInputs embeds the input files and then again every time one of them,
+// an included file or, with --watch-images, a local image they reference
+// changes, until ctx is canceled. Errors are reported and watching goes on,
+// so a document can be fixed while it is watched.
+func (o *cliOptions) watchInputs(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	o.watching = &watchState{units: map[string][]string{}, deps: map[string]map[string]bool{}}
+	var units [][]string
+	if o.concat {
+		units = [][]string{o.inputFiles}
+	} else {
+		for _, file := range o.inputFiles {
+			units = append(units, []string{file})
+		}
+	}
+	// Until a document is embedded, it only depends on itself.
+	for _, files := range units {
+		o.watching.track(files, files)
+	}
+	o.rebuild(ctx, units)
+
+	// Directories are watched rather than files, since editors often save
+	// by replacing a file, which ends a watch on the file itself.
+	dirs := map[string]bool{}
+	watchDirs := func() {
+		for path := range o.watching.deps {
+			dir := filepath.Dir(path)
+			if dirs[dir] {
+				continue
+			}
+			if err := watcher.Add(dir); err != nil {
+				warnf("Warning: Could not watch %s: %v", dir, err)
+				continue
+			}
+			dirs[dir] = true
+		}
+	}
+	watchDirs()
+	printf("Watching %d files for changes; press Ctrl-C to stop\n", len(o.watching.deps))
+
+	pending := map[string]bool{}
+	timer := time.NewTimer(watchDelay)
+	timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			for key := range o.watching.deps[filepath.Clean(event.Name)] {
+				pending[key] = true
+			}
+			if len(pending) > 0 {
+				timer.Reset(watchDelay)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			warnf("Warning: %v", err)
+		case <-timer.C:
+			keys := make([]string, 0, len(pending))
+			for key := range pending {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			units = units[:0]
+			for _, key := range keys {
+				units = append(units, o.watching.units[key])
+			}
+			clear(pending)
+			printf("\nChanged: embedding %d documents again\n", len(units))
+			o.rebuild(ctx, units)
+			watchDirs()
+		}
+	}
+}
+
+// rebuild embeds each unit, reporting failures. A new processor is used
+// every time, since the encoded images a processor keeps may have changed.
+func (o *cliOptions) rebuild(ctx context.Context, units [][]string) {
+	processor := markdown.NewProcessor(o.processorOptions())
+	for _, files := range units {
+		if ctx.Err() != nil {
+			break
+		}
+		if err := o.embed(ctx, processor, files); err != nil {
+			logf("Error: %v", err)
+		}
+	}
+	o.recordStats()
+}